@@ -0,0 +1,188 @@
+package pcert
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ACME (RFC 8555) objects which are shared by the server and the client.
+
+const (
+	ACMEStatusPending     = "pending"
+	ACMEStatusReady       = "ready"
+	ACMEStatusProcessing  = "processing"
+	ACMEStatusValid       = "valid"
+	ACMEStatusInvalid     = "invalid"
+	ACMEStatusDeactivated = "deactivated"
+	ACMEStatusRevoked     = "revoked"
+
+	ACMEChallengeHTTP01    = "http-01"
+	ACMEChallengeDNS01     = "dns-01"
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
+
+	// ACMETLSALPNProtocol is the ALPN protocol of tls-alpn-01 challenges
+	// (RFC 8737).
+	ACMETLSALPNProtocol = "acme-tls/1"
+
+	acmeErrorPrefix = "urn:ietf:params:acme:error:"
+	acmeContentType = "application/jose+json"
+)
+
+// ACMEDirectory contains the URLs of the ACME resources.
+type ACMEDirectory struct {
+	NewNonce   string             `json:"newNonce"`
+	NewAccount string             `json:"newAccount"`
+	NewOrder   string             `json:"newOrder"`
+	RevokeCert string             `json:"revokeCert"`
+	KeyChange  string             `json:"keyChange,omitempty"`
+	Meta       *ACMEDirectoryMeta `json:"meta,omitempty"`
+}
+
+type ACMEDirectoryMeta struct {
+	TermsOfService string `json:"termsOfService,omitempty"`
+	Website        string `json:"website,omitempty"`
+}
+
+type ACMEAccount struct {
+	Status               string   `json:"status,omitempty"`
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting,omitempty"`
+	Orders               string   `json:"orders,omitempty"`
+}
+
+type ACMEIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type ACMEOrder struct {
+	Status         string           `json:"status,omitempty"`
+	Expires        *time.Time       `json:"expires,omitempty"`
+	Identifiers    []ACMEIdentifier `json:"identifiers"`
+	NotBefore      *time.Time       `json:"notBefore,omitempty"`
+	NotAfter       *time.Time       `json:"notAfter,omitempty"`
+	Error          *ACMEProblem     `json:"error,omitempty"`
+	Authorizations []string         `json:"authorizations,omitempty"`
+	Finalize       string           `json:"finalize,omitempty"`
+	Certificate    string           `json:"certificate,omitempty"`
+}
+
+type ACMEAuthorization struct {
+	Identifier ACMEIdentifier  `json:"identifier"`
+	Status     string          `json:"status"`
+	Expires    *time.Time      `json:"expires,omitempty"`
+	Challenges []ACMEChallenge `json:"challenges"`
+	Wildcard   bool            `json:"wildcard,omitempty"`
+}
+
+type ACMEChallenge struct {
+	Type      string       `json:"type"`
+	URL       string       `json:"url"`
+	Status    string       `json:"status"`
+	Token     string       `json:"token"`
+	Validated *time.Time   `json:"validated,omitempty"`
+	Error     *ACMEProblem `json:"error,omitempty"`
+}
+
+// ACMEProblem is an ACME error (RFC 7807 problem document).
+type ACMEProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *ACMEProblem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// newACMEProblem returns a problem of an ACME error type (e.g. malformed).
+func newACMEProblem(typ string, status int, format string, args ...any) *ACMEProblem {
+	return &ACMEProblem{
+		Type:   acmeErrorPrefix + typ,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+// acmeJWS is a JWS in the flattened JSON serialization.
+type acmeJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// acmeJWSHeader is the protected header of an ACME request. Either JWK or
+// KID is set.
+type acmeJWSHeader struct {
+	Alg   string `json:"alg"`
+	Nonce string `json:"nonce"`
+	URL   string `json:"url"`
+	JWK   *JWK   `json:"jwk,omitempty"`
+	KID   string `json:"kid,omitempty"`
+}
+
+// signACMEJWS returns the request body of an ACME request. If payload is nil
+// the request is a POST-as-GET request with an empty payload.
+func signACMEJWS(key crypto.Signer, header acmeJWSHeader, payload []byte) ([]byte, error) {
+	alg, err := JWSAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	header.Alg = alg
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	jws := acmeJWS{
+		Protected: jwkEncoding.EncodeToString(protected),
+		Payload:   jwkEncoding.EncodeToString(payload),
+	}
+	sig, err := jwsSign(key, alg, []byte(jws.Protected+"."+jws.Payload))
+	if err != nil {
+		return nil, err
+	}
+	jws.Signature = jwkEncoding.EncodeToString(sig)
+	return json.Marshal(jws)
+}
+
+// ACMEKeyAuthorization returns the key authorization of a challenge token
+// for an account key.
+func ACMEKeyAuthorization(token string, accountKey *JWK) (string, error) {
+	thumbprint, err := accountKey.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
+
+// ACMEDNS01Value returns the value of the TXT record _acme-challenge.<domain>
+// for a key authorization.
+func ACMEDNS01Value(keyAuthorization string) string {
+	sum := sha256.Sum256([]byte(keyAuthorization))
+	return jwkEncoding.EncodeToString(sum[:])
+}
+
+// acmeProblemFromResponse returns the problem of an error response.
+func acmeProblemFromResponse(resp *http.Response, body []byte) error {
+	problem := &ACMEProblem{}
+	if json.Unmarshal(body, problem) != nil || problem.Type == "" {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, body)
+	}
+	if problem.Status == 0 {
+		problem.Status = resp.StatusCode
+	}
+	return problem
+}
+
+// IsACMEProblem reports whether err is an ACME problem of type typ (e.g.
+// badNonce).
+func IsACMEProblem(err error, typ string) bool {
+	var problem *ACMEProblem
+	return errors.As(err, &problem) && problem.Type == acmeErrorPrefix+typ
+}
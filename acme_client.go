@@ -0,0 +1,475 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	acmePollInterval    = time.Second
+	acmeMaxPollInterval = 10 * time.Second
+)
+
+// ACMESolver solves challenges of one type.
+type ACMESolver interface {
+	// Type returns the challenge type (e.g. http-01).
+	Type() string
+	// Present makes the key authorization available so that the server
+	// can validate the challenge.
+	Present(ctx context.Context, identifier ACMEIdentifier, token, keyAuthorization string) error
+	// CleanUp removes what Present has set up.
+	CleanUp(ctx context.Context, identifier ACMEIdentifier, token, keyAuthorization string) error
+}
+
+// ACMEClient is an ACME (RFC 8555) client.
+type ACMEClient struct {
+	// DirectoryURL is the URL of the directory of the ACME server.
+	DirectoryURL string
+	// Key is the account key.
+	Key crypto.Signer
+	// HTTPClient is used for all requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	directory *ACMEDirectory
+	kid       string
+	nonces    []string
+}
+
+// Directory returns the directory of the server.
+func (c *ACMEClient) Directory(ctx context.Context) (*ACMEDirectory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.directory != nil {
+		return c.directory, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DirectoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, acmeMaxRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get directory: %s", resp.Status)
+	}
+	directory := &ACMEDirectory{}
+	err = json.Unmarshal(body, directory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory: %w", err)
+	}
+	c.directory = directory
+	return directory, nil
+}
+
+// Register creates an account for the key or returns the existing account.
+// By registering the terms of service are agreed.
+func (c *ACMEClient) Register(ctx context.Context, contact []string) (*ACMEAccount, error) {
+	dir, err := c.Directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account := &ACMEAccount{}
+	resp, err := c.post(ctx, dir.NewAccount, &ACMEAccount{
+		Contact:              contact,
+		TermsOfServiceAgreed: true,
+	}, account)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.kid = resp.Header.Get("Location")
+	c.mu.Unlock()
+	if c.kid == "" {
+		return nil, errors.New("server returned no account URL")
+	}
+	return account, nil
+}
+
+// NewOrder creates an order for identifiers and returns the order and its
+// URL.
+func (c *ACMEClient) NewOrder(ctx context.Context, identifiers []ACMEIdentifier) (*ACMEOrder, string, error) {
+	dir, err := c.Directory(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	order := &ACMEOrder{}
+	resp, err := c.post(ctx, dir.NewOrder, &ACMEOrder{Identifiers: identifiers}, order)
+	if err != nil {
+		return nil, "", err
+	}
+	return order, resp.Header.Get("Location"), nil
+}
+
+// Order returns the order at url.
+func (c *ACMEClient) Order(ctx context.Context, url string) (*ACMEOrder, error) {
+	order := &ACMEOrder{}
+	_, err := c.post(ctx, url, nil, order)
+	return order, err
+}
+
+// Authorization returns the authorization at url.
+func (c *ACMEClient) Authorization(ctx context.Context, url string) (*ACMEAuthorization, error) {
+	authz := &ACMEAuthorization{}
+	_, err := c.post(ctx, url, nil, authz)
+	return authz, err
+}
+
+// Accept tells the server that a challenge is ready to be validated.
+func (c *ACMEClient) Accept(ctx context.Context, url string) (*ACMEChallenge, error) {
+	challenge := &ACMEChallenge{}
+	_, err := c.post(ctx, url, struct{}{}, challenge)
+	return challenge, err
+}
+
+// Finalize requests the certificate for a ready order with a DER encoded
+// CSR.
+func (c *ACMEClient) Finalize(ctx context.Context, finalizeURL string, csr []byte) (*ACMEOrder, error) {
+	order := &ACMEOrder{}
+	_, err := c.post(ctx, finalizeURL, map[string]string{"csr": jwkEncoding.EncodeToString(csr)}, order)
+	return order, err
+}
+
+// Certificate downloads the certificate chain at url.
+func (c *ACMEClient) Certificate(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	var body []byte
+	_, err := c.post(ctx, url, nil, &body)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParseAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("server returned no certificates")
+	}
+	return certs, nil
+}
+
+// Revoke revokes a certificate with the account key. reason is a reason
+// code of RFC 5280 (0 is unspecified).
+func (c *ACMEClient) Revoke(ctx context.Context, cert *x509.Certificate, reason int) error {
+	dir, err := c.Directory(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.post(ctx, dir.RevokeCert, map[string]any{
+		"certificate": jwkEncoding.EncodeToString(cert.Raw),
+		"reason":      reason,
+	}, nil)
+	return err
+}
+
+// KeyAuthorization returns the key authorization of a challenge token.
+func (c *ACMEClient) KeyAuthorization(token string) (string, error) {
+	jwk, err := NewJWK(c.Key.Public())
+	if err != nil {
+		return "", err
+	}
+	return ACMEKeyAuthorization(token, jwk)
+}
+
+// Issue obtains a certificate for the DNS names and IP addresses of a CSR.
+// The challenges are solved with solver. The account has to be registered
+// with Register before. It returns the certificate chain with the
+// certificate first.
+func (c *ACMEClient) Issue(ctx context.Context, csr *x509.CertificateRequest, solver ACMESolver) ([]*x509.Certificate, error) {
+	identifiers := []ACMEIdentifier{}
+	for _, name := range csr.DNSNames {
+		identifiers = append(identifiers, ACMEIdentifier{Type: "dns", Value: name})
+	}
+	for _, ip := range csr.IPAddresses {
+		identifiers = append(identifiers, ACMEIdentifier{Type: "ip", Value: ip.String()})
+	}
+	if len(identifiers) == 0 {
+		return nil, errors.New("CSR contains no DNS names or IP addresses")
+	}
+
+	order, orderURL, err := c.NewOrder(ctx, identifiers)
+	if err != nil {
+		return nil, err
+	}
+	for _, authzURL := range order.Authorizations {
+		err := c.authorize(ctx, authzURL, solver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = c.waitOrder(ctx, orderURL, ACMEStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != ACMEStatusReady {
+		return nil, fmt.Errorf("order is %s: %w", order.Status, acmeError(order.Error))
+	}
+	order, err = c.Finalize(ctx, order.Finalize, csr.Raw)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == ACMEStatusProcessing {
+		order, err = c.waitOrder(ctx, orderURL, ACMEStatusProcessing)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if order.Status != ACMEStatusValid {
+		return nil, fmt.Errorf("order is %s: %w", order.Status, acmeError(order.Error))
+	}
+	return c.Certificate(ctx, order.Certificate)
+}
+
+// authorize solves a challenge of an authorization unless it is already
+// valid.
+func (c *ACMEClient) authorize(ctx context.Context, url string, solver ACMESolver) error {
+	authz, err := c.Authorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == ACMEStatusValid {
+		return nil
+	}
+	var challenge *ACMEChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == solver.Type() {
+			challenge = &authz.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no %s challenge offered for %s", solver.Type(), authz.Identifier.Value)
+	}
+
+	// the token is used by solvers in file names and URLs
+	if !isACMEToken(challenge.Token) {
+		return fmt.Errorf("invalid %s challenge token '%s' for %s", solver.Type(), challenge.Token, authz.Identifier.Value)
+	}
+
+	// the identifier of wildcard authorizations contains no "*."
+	identifier := authz.Identifier
+	keyAuth, err := c.KeyAuthorization(challenge.Token)
+	if err != nil {
+		return err
+	}
+	err = solver.Present(ctx, identifier, challenge.Token, keyAuth)
+	if err != nil {
+		return fmt.Errorf("failed to present %s challenge for %s: %w", solver.Type(), identifier.Value, err)
+	}
+	defer func() {
+		_ = solver.CleanUp(ctx, identifier, challenge.Token, keyAuth)
+	}()
+
+	_, err = c.Accept(ctx, challenge.URL)
+	if err != nil {
+		return err
+	}
+	for interval := acmePollInterval; ; {
+		authz, err = c.Authorization(ctx, url)
+		if err != nil {
+			return err
+		}
+		switch authz.Status {
+		case ACMEStatusValid:
+			return nil
+		case ACMEStatusPending:
+		default:
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("authorization for %s is %s: %w", identifier.Value, authz.Status, ch.Error)
+				}
+			}
+			return fmt.Errorf("authorization for %s is %s", identifier.Value, authz.Status)
+		}
+		interval, err = acmeSleep(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isACMEToken reports whether token only contains characters of the
+// base64url alphabet (RFC 8555 section 8.1).
+func isACMEToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// waitOrder polls the order until its status is no longer status.
+func (c *ACMEClient) waitOrder(ctx context.Context, url, status string) (*ACMEOrder, error) {
+	for interval := acmePollInterval; ; {
+		order, err := c.Order(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if order.Status != status {
+			return order, nil
+		}
+		interval, err = acmeSleep(ctx, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// acmeSleep waits for interval and returns the next interval.
+func acmeSleep(ctx context.Context, interval time.Duration) (time.Duration, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(interval):
+	}
+	return min(2*interval, acmeMaxPollInterval), nil
+}
+
+func acmeError(problem *ACMEProblem) error {
+	if problem == nil {
+		return errors.New("no error returned by the server")
+	}
+	return problem
+}
+
+func (c *ACMEClient) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// post sends a signed request and decodes the response into out. If payload
+// is nil a POST-as-GET request is sent. If out is a *[]byte the raw body is
+// returned. Requests which fail with badNonce are retried once.
+func (c *ACMEClient) post(ctx context.Context, url string, payload any, out any) (*http.Response, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, body, err := c.postData(ctx, url, data)
+	if IsACMEProblem(err, "badNonce") {
+		resp, body, err = c.postData(ctx, url, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return resp, nil
+	}
+	if b, ok := out.(*[]byte); ok {
+		*b = body
+		return resp, nil
+	}
+	err = json.Unmarshal(body, out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+func (c *ACMEClient) postData(ctx context.Context, url string, data []byte) (*http.Response, []byte, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := acmeJWSHeader{
+		Nonce: nonce,
+		URL:   url,
+	}
+	c.mu.Lock()
+	header.KID = c.kid
+	c.mu.Unlock()
+	if header.KID == "" {
+		header.JWK, err = NewJWK(c.Key.Public())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	body, err := signACMEJWS(c.Key, header, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", acmeContentType)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	c.addNonce(resp)
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, acmeMaxRequestSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, acmeProblemFromResponse(resp, respBody)
+	}
+	return resp, respBody, nil
+}
+
+func (c *ACMEClient) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if len(c.nonces) > 0 {
+		nonce := c.nonces[len(c.nonces)-1]
+		c.nonces = c.nonces[:len(c.nonces)-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.Directory(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("no nonce returned by %s: %s", dir.NewNonce, resp.Status)
+	}
+	return nonce, nil
+}
+
+func (c *ACMEClient) addNonce(resp *http.Response) {
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return
+	}
+	c.mu.Lock()
+	c.nonces = append(c.nonces, nonce)
+	c.mu.Unlock()
+}
@@ -0,0 +1,184 @@
+package pcert
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type acmeTestSolver struct {
+	typ      string
+	mu       sync.Mutex
+	values   map[string]string
+	cleanups int
+}
+
+func (s *acmeTestSolver) Type() string { return s.typ }
+
+func (s *acmeTestSolver) Present(ctx context.Context, id ACMEIdentifier, token, keyAuth string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.typ == ACMEChallengeDNS01 {
+		s.values["_acme-challenge."+id.Value] = ACMEDNS01Value(keyAuth)
+	} else {
+		s.values[token] = keyAuth
+	}
+	return nil
+}
+
+func (s *acmeTestSolver) CleanUp(ctx context.Context, id ACMEIdentifier, token, keyAuth string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanups++
+	return nil
+}
+
+func (s *acmeTestSolver) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func TestACMEClient(t *testing.T) {
+	httpSolver := &acmeTestSolver{typ: ACMEChallengeHTTP01, values: map[string]string{}}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, httpSolver.get(strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")))
+	}))
+	defer httpServer.Close()
+	_, httpPort, _ := net.SplitHostPort(httpServer.Listener.Addr().String())
+
+	dnsSolver := &acmeTestSolver{typ: ACMEChallengeDNS01, values: map[string]string{}}
+	server := &ACMEServer{
+		Validity: 24 * time.Hour,
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			return []string{dnsSolver.get(name)}, nil
+		},
+	}
+	server.HTTPPort, _ = strconv.Atoi(httpPort)
+	tc := newACMETestServer(t, server)
+
+	key, _, err := GenerateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ACMEClient{
+		DirectoryURL: tc.directoryURL,
+		Key:          key.(crypto.Signer),
+		HTTPClient:   tc.client,
+	}
+	ctx := context.Background()
+	account, err := client.Register(ctx, []string{"mailto:admin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != ACMEStatusValid {
+		t.Errorf("account is %s", account.Status)
+	}
+
+	// http-01 with an IP address
+	csrDER, _, err := CreateRequestWithKeyOptions(&x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := client.Issue(ctx, csr, httpSolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || !chain[1].Equal(server.CACert) {
+		t.Fatalf("unexpected chain: %d certificates", len(chain))
+	}
+	if len(chain[0].IPAddresses) != 1 || !chain[0].IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("unexpected IP addresses: %v", chain[0].IPAddresses)
+	}
+	if httpSolver.cleanups != 1 {
+		t.Errorf("cleanups: got=%d want=1", httpSolver.cleanups)
+	}
+
+	// dns-01 with a wildcard name
+	csr, _ = newACMETestCSR(t, "", "*.example.com", "example.com")
+	chain, err = client.Issue(ctx, csr, dnsSolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chain[0].DNSNames, ",") != "*.example.com,example.com" {
+		t.Errorf("unexpected DNS names: %v", chain[0].DNSNames)
+	}
+
+	err = client.Revoke(ctx, chain[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsRevoked(chain[0]) {
+		t.Error("certificate not revoked")
+	}
+
+	// the challenge fails if the solver does not present the right value
+	failingSolver := &acmeTestSolver{typ: ACMEChallengeDNS01, values: map[string]string{}}
+	csr, _ = newACMETestCSR(t, "", "fail.example.com")
+	_, err = client.Issue(ctx, csr, failingSolver)
+	if err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Errorf("expected invalid authorization, got %v", err)
+	}
+
+	_, err = client.Issue(ctx, csr, &acmeTestSolver{typ: "unknown-01"})
+	if err == nil || !strings.Contains(err.Error(), "no unknown-01 challenge") {
+		t.Errorf("expected error for unknown challenge type, got %v", err)
+	}
+}
+
+func TestACMEClient_invalidToken(t *testing.T) {
+	server := &ACMEServer{Validity: time.Hour}
+	newACMETestServer(t, server)
+	// a malicious server sends a token which is a path
+	tokenRegexp := regexp.MustCompile(`"token":\s*"[^"]*"`)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, r)
+		for key, values := range rec.Header() {
+			if key != "Content-Length" {
+				w.Header()[key] = values
+			}
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(tokenRegexp.ReplaceAll(rec.Body.Bytes(), []byte(`"token": "../../etc/passwd"`)))
+	}))
+	defer ts.Close()
+
+	key, _, err := GenerateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ACMEClient{
+		DirectoryURL: ts.URL + "/directory",
+		Key:          key.(crypto.Signer),
+		HTTPClient:   ts.Client(),
+	}
+	ctx := context.Background()
+	_, err = client.Register(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver := &acmeTestSolver{typ: ACMEChallengeHTTP01, values: map[string]string{}}
+	csr, _ := newACMETestCSR(t, "", "www.example.com")
+	_, err = client.Issue(ctx, csr, solver)
+	if err == nil || !strings.Contains(err.Error(), "invalid http-01 challenge token") {
+		t.Errorf("expected error for invalid token, got %v", err)
+	}
+	if len(solver.values) != 0 {
+		t.Errorf("solver was called with invalid token: %v", solver.values)
+	}
+}
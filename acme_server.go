@@ -0,0 +1,1144 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultACMEValidity is the validity of certificates issued by the
+	// ACMEServer if no validity is set.
+	DefaultACMEValidity = 90 * 24 * time.Hour
+
+	acmeOrderLifetime     = 7 * 24 * time.Hour
+	acmeValidationTimeout = 10 * time.Second
+	acmeMaxRequestSize    = 1 << 20
+	acmeMaxIdentifiers    = 100
+	acmeMaxNonces         = 10000
+)
+
+// ACMEServer is an ACME (RFC 8555) server which issues certificates signed
+// by a CA. It supports accounts, orders, the challenge types http-01, dns-01
+// and tls-alpn-01 and the revocation of certificates. Account key rollover,
+// external account binding and pre-authorization are not supported.
+//
+// The ACMEServer is intended for development and test environments. All
+// state is kept in memory and is lost if the server is stopped.
+type ACMEServer struct {
+	// CACert and CAKey are used to sign the certificates.
+	CACert *x509.Certificate
+	CAKey  any
+	// Chain contains certificates which are appended to issued
+	// certificates after CACert (e.g. further intermediates).
+	Chain []*x509.Certificate
+
+	// Validity of the issued certificates. Defaults to
+	// DefaultACMEValidity. Orders which request a longer validity are
+	// rejected.
+	Validity time.Duration
+
+	// SkipValidation marks all challenges as valid without validating
+	// them.
+	SkipValidation bool
+	// HTTPPort is the port on which http-01 challenges are validated.
+	// Defaults to 80.
+	HTTPPort int
+	// TLSPort is the port on which tls-alpn-01 challenges are validated.
+	// Defaults to 443.
+	TLSPort int
+	// LookupTXT is used to validate dns-01 challenges. Defaults to
+	// net.DefaultResolver.LookupTXT.
+	LookupTXT func(ctx context.Context, name string) ([]string, error)
+
+	// BaseURL is the URL under which the server is reachable (e.g.
+	// https://acme.example.com/acme). If it is not set, it is derived from
+	// the scheme and host of the requests.
+	BaseURL string
+
+	once  sync.Once
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	state *acmeState
+}
+
+type acmeState struct {
+	nonces     map[string]bool
+	nonceOrder []string
+	accounts   map[string]*acmeAccount
+	orders     map[string]*acmeOrder
+	authzs     map[string]*acmeAuthz
+	challenges map[string]*acmeChallenge
+	certs      map[string]*acmeCert
+}
+
+type acmeAccount struct {
+	id         string
+	key        *JWK
+	thumbprint string
+	status     string
+	contact    []string
+	orders     []string
+}
+
+type acmeOrder struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []ACMEIdentifier
+	notBefore   time.Time
+	notAfter    time.Time
+	authzs      []string
+	certID      string
+	err         *ACMEProblem
+}
+
+type acmeAuthz struct {
+	id         string
+	accountID  string
+	identifier ACMEIdentifier
+	status     string
+	expires    time.Time
+	wildcard   bool
+	challenges []string
+}
+
+type acmeChallenge struct {
+	id        string
+	authzID   string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *ACMEProblem
+}
+
+type acmeCert struct {
+	id        string
+	accountID string
+	cert      *x509.Certificate
+	revoked   bool
+}
+
+// acmeRequest is a verified JWS request.
+type acmeRequest struct {
+	payload []byte
+	// account is set for requests which are signed with the key of an
+	// account (kid)
+	account *acmeAccount
+	// jwk is set for requests which contain the key (jwk)
+	jwk *JWK
+}
+
+func (s *ACMEServer) init() {
+	s.state = &acmeState{
+		nonces:     map[string]bool{},
+		accounts:   map[string]*acmeAccount{},
+		orders:     map[string]*acmeOrder{},
+		authzs:     map[string]*acmeAuthz{},
+		challenges: map[string]*acmeChallenge{},
+		certs:      map[string]*acmeCert{},
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /directory", s.handleDirectory)
+	s.mux.HandleFunc("HEAD /new-nonce", s.handleNewNonce)
+	s.mux.HandleFunc("GET /new-nonce", s.handleNewNonce)
+	s.mux.HandleFunc("POST /new-account", s.handleNewAccount)
+	s.mux.HandleFunc("POST /account/{id}", s.handleAccount)
+	s.mux.HandleFunc("POST /account/{id}/orders", s.handleAccountOrders)
+	s.mux.HandleFunc("POST /new-order", s.handleNewOrder)
+	s.mux.HandleFunc("POST /order/{id}", s.handleOrder)
+	s.mux.HandleFunc("POST /order/{id}/finalize", s.handleFinalize)
+	s.mux.HandleFunc("POST /authz/{id}", s.handleAuthz)
+	s.mux.HandleFunc("POST /chall/{id}", s.handleChallenge)
+	s.mux.HandleFunc("POST /cert/{id}", s.handleCertificate)
+	s.mux.HandleFunc("POST /revoke-cert", s.handleRevokeCert)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *ACMEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(s.init)
+	// every response contains a fresh nonce (RFC 8555 section 6.5)
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"index\"", s.url(r, "/directory")))
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *ACMEServer) baseURL(r *http.Request) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *ACMEServer) url(r *http.Request, path string) string {
+	return s.baseURL(r) + path
+}
+
+func (s *ACMEServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	writeACMEJSON(w, http.StatusOK, &ACMEDirectory{
+		NewNonce:   s.url(r, "/new-nonce"),
+		NewAccount: s.url(r, "/new-account"),
+		NewOrder:   s.url(r, "/new-order"),
+		RevokeCert: s.url(r, "/revoke-cert"),
+	})
+}
+
+func (s *ACMEServer) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *ACMEServer) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, true)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	if req.account != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "new accounts have to be requested with a jwk"))
+		return
+	}
+	account := &ACMEAccount{}
+	err := json.Unmarshal(req.payload, account)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid account: %s", err))
+		return
+	}
+	thumbprint, err := req.jwk.Thumbprint()
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("badPublicKey", http.StatusBadRequest, "%s", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.state.accounts {
+		if existing.thumbprint == thumbprint {
+			w.Header().Set("Location", s.url(r, "/account/"+existing.id))
+			writeACMEJSON(w, http.StatusOK, s.accountObject(r, existing))
+			return
+		}
+	}
+	if account.OnlyReturnExisting {
+		writeACMEProblem(w, newACMEProblem("accountDoesNotExist", http.StatusBadRequest, "no account exists with the provided key"))
+		return
+	}
+	for _, contact := range account.Contact {
+		if !strings.HasPrefix(contact, "mailto:") {
+			writeACMEProblem(w, newACMEProblem("unsupportedContact", http.StatusBadRequest, "unsupported contact '%s'", contact))
+			return
+		}
+	}
+	a := &acmeAccount{
+		id:         randomACMEID(),
+		key:        req.jwk.Public(),
+		thumbprint: thumbprint,
+		status:     ACMEStatusValid,
+		contact:    account.Contact,
+	}
+	s.state.accounts[a.id] = a
+	w.Header().Set("Location", s.url(r, "/account/"+a.id))
+	writeACMEJSON(w, http.StatusCreated, s.accountObject(r, a))
+}
+
+// handleAccount returns, updates or deactivates an account.
+func (s *ACMEServer) handleAccount(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	if req.account.id != r.PathValue("id") {
+		writeACMEProblem(w, newACMEProblem("unauthorized", http.StatusForbidden, "account does not match the key"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(req.payload) > 0 {
+		update := &ACMEAccount{}
+		err := json.Unmarshal(req.payload, update)
+		if err != nil {
+			writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid account: %s", err))
+			return
+		}
+		if update.Contact != nil {
+			req.account.contact = update.Contact
+		}
+		if update.Status == ACMEStatusDeactivated {
+			req.account.status = ACMEStatusDeactivated
+		} else if update.Status != "" && update.Status != req.account.status {
+			writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid account status '%s'", update.Status))
+			return
+		}
+	}
+	writeACMEJSON(w, http.StatusOK, s.accountObject(r, req.account))
+}
+
+func (s *ACMEServer) handleAccountOrders(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	if req.account.id != r.PathValue("id") {
+		writeACMEProblem(w, newACMEProblem("unauthorized", http.StatusForbidden, "account does not match the key"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orders := []string{}
+	for _, id := range req.account.orders {
+		orders = append(orders, s.url(r, "/order/"+id))
+	}
+	writeACMEJSON(w, http.StatusOK, map[string][]string{"orders": orders})
+}
+
+func (s *ACMEServer) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	order := &ACMEOrder{}
+	err := json.Unmarshal(req.payload, order)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid order: %s", err))
+		return
+	}
+	if len(order.Identifiers) == 0 || len(order.Identifiers) > acmeMaxIdentifiers {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "an order requires between 1 and %d identifiers", acmeMaxIdentifiers))
+		return
+	}
+	for i, id := range order.Identifiers {
+		id, problem := normalizeACMEIdentifier(id)
+		if problem != nil {
+			writeACMEProblem(w, problem)
+			return
+		}
+		order.Identifiers[i] = id
+	}
+
+	now := time.Now()
+	o := &acmeOrder{
+		id:          randomACMEID(),
+		accountID:   req.account.id,
+		status:      ACMEStatusPending,
+		expires:     now.Add(acmeOrderLifetime),
+		identifiers: order.Identifiers,
+	}
+	if order.NotBefore != nil {
+		o.notBefore = *order.NotBefore
+	}
+	if order.NotAfter != nil {
+		o.notAfter = *order.NotAfter
+	}
+	if !o.notBefore.IsZero() && !o.notAfter.IsZero() && !o.notBefore.Before(o.notAfter) {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "notBefore has to be before notAfter"))
+		return
+	}
+	notBefore := o.notBefore
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	if !o.notAfter.IsZero() && o.notAfter.Sub(notBefore) > s.validity() {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "the requested validity exceeds the maximum validity of %s", s.validity()))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range o.identifiers {
+		a := &acmeAuthz{
+			id:         randomACMEID(),
+			accountID:  req.account.id,
+			identifier: id,
+			status:     ACMEStatusPending,
+			expires:    o.expires,
+		}
+		types := []string{ACMEChallengeHTTP01, ACMEChallengeDNS01, ACMEChallengeTLSALPN01}
+		switch {
+		case strings.HasPrefix(id.Value, "*."):
+			// wildcards can only be validated with dns-01 (RFC 8555 section 7.1.3)
+			a.identifier.Value = strings.TrimPrefix(id.Value, "*.")
+			a.wildcard = true
+			types = []string{ACMEChallengeDNS01}
+		case id.Type == "ip":
+			types = []string{ACMEChallengeHTTP01}
+		}
+		token := randomACMEToken()
+		for _, typ := range types {
+			c := &acmeChallenge{
+				id:      randomACMEID(),
+				authzID: a.id,
+				typ:     typ,
+				token:   token,
+				status:  ACMEStatusPending,
+			}
+			s.state.challenges[c.id] = c
+			a.challenges = append(a.challenges, c.id)
+		}
+		s.state.authzs[a.id] = a
+		o.authzs = append(o.authzs, a.id)
+	}
+	s.state.orders[o.id] = o
+	req.account.orders = append(req.account.orders, o.id)
+
+	w.Header().Set("Location", s.url(r, "/order/"+o.id))
+	writeACMEJSON(w, http.StatusCreated, s.orderObject(r, o))
+}
+
+func (s *ACMEServer) handleOrder(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.state.orders[r.PathValue("id")]
+	if !ok || o.accountID != req.account.id {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "order not found"))
+		return
+	}
+	s.updateOrder(o)
+	writeACMEJSON(w, http.StatusOK, s.orderObject(r, o))
+}
+
+func (s *ACMEServer) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.state.authzs[r.PathValue("id")]
+	if !ok || a.accountID != req.account.id {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "authorization not found"))
+		return
+	}
+	if len(req.payload) > 0 {
+		update := &ACMEAuthorization{}
+		err := json.Unmarshal(req.payload, update)
+		if err != nil || update.Status != ACMEStatusDeactivated {
+			writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "authorizations can only be deactivated"))
+			return
+		}
+		a.status = ACMEStatusDeactivated
+	}
+	writeACMEJSON(w, http.StatusOK, s.authzObject(r, a))
+}
+
+// handleChallenge returns a challenge or validates it if the payload is an
+// empty object. The validation is performed synchronously, so the response
+// already contains the result.
+func (s *ACMEServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.state.challenges[r.PathValue("id")]
+	var a *acmeAuthz
+	if ok {
+		a = s.state.authzs[c.authzID]
+	}
+	if !ok || a.accountID != req.account.id {
+		s.mu.Unlock()
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "challenge not found"))
+		return
+	}
+	// a POST-as-GET request returns the challenge
+	if len(req.payload) == 0 || c.status != ACMEStatusPending || a.status != ACMEStatusPending {
+		defer s.mu.Unlock()
+		s.writeChallenge(w, r, c, a)
+		return
+	}
+	c.status = ACMEStatusProcessing
+	identifier, typ, token := a.identifier, c.typ, c.token
+	s.mu.Unlock()
+
+	var err error
+	if !s.SkipValidation {
+		keyAuth, _ := ACMEKeyAuthorization(token, req.account.key)
+		ctx, cancel := context.WithTimeout(r.Context(), acmeValidationTimeout)
+		err = s.validate(ctx, typ, identifier, token, keyAuth)
+		cancel()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		problem := &ACMEProblem{}
+		if !errors.As(err, &problem) {
+			problem = newACMEProblem("incorrectResponse", http.StatusForbidden, "%s", err)
+		}
+		c.status = ACMEStatusInvalid
+		c.err = problem
+		a.status = ACMEStatusInvalid
+	} else {
+		c.status = ACMEStatusValid
+		c.validated = time.Now()
+		a.status = ACMEStatusValid
+	}
+	s.writeChallenge(w, r, c, a)
+}
+
+func (s *ACMEServer) writeChallenge(w http.ResponseWriter, r *http.Request, c *acmeChallenge, a *acmeAuthz) {
+	w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"up\"", s.url(r, "/authz/"+a.id)))
+	writeACMEJSON(w, http.StatusOK, s.challengeObject(r, c))
+}
+
+func (s *ACMEServer) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	finalize := struct {
+		CSR string `json:"csr"`
+	}{}
+	err := json.Unmarshal(req.payload, &finalize)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid finalize request: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.state.orders[r.PathValue("id")]
+	if !ok || o.accountID != req.account.id {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "order not found"))
+		return
+	}
+	s.updateOrder(o)
+	if o.status != ACMEStatusReady {
+		writeACMEProblem(w, newACMEProblem("orderNotReady", http.StatusForbidden, "order is %s", o.status))
+		return
+	}
+
+	der, err := jwkEncoding.DecodeString(finalize.CSR)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("badCSR", http.StatusBadRequest, "invalid CSR encoding: %s", err))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err == nil {
+		err = checkACMECSR(csr, o.identifiers)
+	}
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("badCSR", http.StatusBadRequest, "%s", err))
+		return
+	}
+
+	cert, err := s.issue(csr, o)
+	if err != nil {
+		o.status = ACMEStatusInvalid
+		o.err = newACMEProblem("serverInternal", http.StatusInternalServerError, "failed to issue certificate: %s", err)
+		writeACMEProblem(w, o.err)
+		return
+	}
+	c := &acmeCert{
+		id:        randomACMEID(),
+		accountID: o.accountID,
+		cert:      cert,
+	}
+	s.state.certs[c.id] = c
+	o.certID = c.id
+	o.status = ACMEStatusValid
+
+	w.Header().Set("Location", s.url(r, "/order/"+o.id))
+	writeACMEJSON(w, http.StatusOK, s.orderObject(r, o))
+}
+
+// validity returns the maximum validity of the issued certificates.
+func (s *ACMEServer) validity() time.Duration {
+	if s.Validity == 0 {
+		return DefaultACMEValidity
+	}
+	return s.Validity
+}
+
+// issue signs a certificate for the identifiers of the order. Only the
+// public key and the common name (which has to be one of the identifiers)
+// are used from the CSR. The validity requested in the order is limited to
+// the validity of the server and the validity of the CA certificate.
+func (s *ACMEServer) issue(csr *x509.CertificateRequest, o *acmeOrder) (*x509.Certificate, error) {
+	opts := &CertificateOptions{
+		ProfileServer: true,
+	}
+	opts.NotBefore = o.notBefore
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = time.Now()
+	}
+	opts.NotAfter = opts.NotBefore.Add(s.validity())
+	if !o.notAfter.IsZero() && o.notAfter.Before(opts.NotAfter) {
+		opts.NotAfter = o.notAfter
+	}
+	if opts.NotAfter.After(s.CACert.NotAfter) {
+		opts.NotAfter = s.CACert.NotAfter
+	}
+	opts.Subject = pkix.Name{CommonName: csr.Subject.CommonName}
+	dnsNames := []string{}
+	ips := []net.IP{}
+	for _, id := range o.identifiers {
+		if id.Type == "ip" {
+			ips = append(ips, net.ParseIP(id.Value))
+			continue
+		}
+		dnsNames = append(dnsNames, id.Value)
+		if opts.Subject.CommonName == "" {
+			opts.Subject.CommonName = id.Value
+		}
+	}
+	cert := NewCertificate(opts)
+	// the server profile adds the common name to the DNS names. the names
+	// are set explicitly so that applyCSR does not copy names of the CSR.
+	cert.DNSNames = dnsNames
+	cert.IPAddresses = ips
+	cert.EmailAddresses = []string{}
+	cert.URIs = []*url.URL{}
+
+	der, err := CreateCertificateWithCSR(csr, cert, s.CACert, s.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func (s *ACMEServer) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, false)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.state.certs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok || c.accountID != req.account.id {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "certificate not found"))
+		return
+	}
+	chain := Encode(c.cert.Raw)
+	for _, cert := range append([]*x509.Certificate{s.CACert}, s.Chain...) {
+		chain = append(chain, Encode(cert.Raw)...)
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(chain)
+}
+
+// handleRevokeCert revokes a certificate. The request has to be signed by
+// the account which requested the certificate or by the key of the
+// certificate.
+func (s *ACMEServer) handleRevokeCert(w http.ResponseWriter, r *http.Request) {
+	req, problem := s.parseRequest(r, true)
+	if problem != nil {
+		writeACMEProblem(w, problem)
+		return
+	}
+	revocation := struct {
+		Certificate string `json:"certificate"`
+		Reason      *int   `json:"reason"`
+	}{}
+	err := json.Unmarshal(req.payload, &revocation)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid revocation request: %s", err))
+		return
+	}
+	// reason codes of RFC 5280 section 5.3.1. 7 is not used.
+	if revocation.Reason != nil && (*revocation.Reason < 0 || *revocation.Reason > 10 || *revocation.Reason == 7) {
+		writeACMEProblem(w, newACMEProblem("badRevocationReason", http.StatusBadRequest, "invalid reason %d", *revocation.Reason))
+		return
+	}
+	der, err := jwkEncoding.DecodeString(revocation.Certificate)
+	if err != nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusBadRequest, "invalid certificate encoding: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var c *acmeCert
+	for _, cert := range s.state.certs {
+		if bytes.Equal(cert.cert.Raw, der) {
+			c = cert
+			break
+		}
+	}
+	if c == nil {
+		writeACMEProblem(w, newACMEProblem("malformed", http.StatusNotFound, "certificate was not issued by this server"))
+		return
+	}
+
+	authorized := false
+	if req.account != nil {
+		authorized = req.account.id == c.accountID
+	} else {
+		keyJWK, err := NewJWK(c.cert.PublicKey)
+		if err == nil {
+			certThumbprint, _ := keyJWK.Thumbprint()
+			reqThumbprint, _ := req.jwk.Thumbprint()
+			authorized = certThumbprint != "" && certThumbprint == reqThumbprint
+		}
+	}
+	if !authorized {
+		writeACMEProblem(w, newACMEProblem("unauthorized", http.StatusForbidden, "not authorized to revoke the certificate"))
+		return
+	}
+	if c.revoked {
+		writeACMEProblem(w, newACMEProblem("alreadyRevoked", http.StatusBadRequest, "certificate is already revoked"))
+		return
+	}
+	c.revoked = true
+	w.WriteHeader(http.StatusOK)
+}
+
+// IsRevoked reports whether cert was issued by the server and has been
+// revoked.
+func (s *ACMEServer) IsRevoked(cert *x509.Certificate) bool {
+	s.once.Do(s.init)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.state.certs {
+		if c.cert.Equal(cert) {
+			return c.revoked
+		}
+	}
+	return false
+}
+
+// parseRequest verifies the JWS of a POST request. If jwkAllowed is true the
+// request can contain the key (jwk) or reference an account (kid), otherwise
+// only kid is allowed.
+func (s *ACMEServer) parseRequest(r *http.Request, jwkAllowed bool) (*acmeRequest, *ACMEProblem) {
+	if ct := r.Header.Get("Content-Type"); ct != acmeContentType {
+		return nil, newACMEProblem("malformed", http.StatusUnsupportedMediaType, "invalid content type '%s'", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, acmeMaxRequestSize))
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "failed to read request: %s", err)
+	}
+	jws := &acmeJWS{}
+	err = json.Unmarshal(body, jws)
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "invalid JWS: %s", err)
+	}
+	protected, err := jwkEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "invalid protected header encoding")
+	}
+	header := &acmeJWSHeader{}
+	err = json.Unmarshal(protected, header)
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "invalid protected header: %s", err)
+	}
+	payload, err := jwkEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "invalid payload encoding")
+	}
+	sig, err := jwkEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "invalid signature encoding")
+	}
+
+	if !s.useNonce(header.Nonce) {
+		return nil, newACMEProblem("badNonce", http.StatusBadRequest, "invalid nonce")
+	}
+	if header.URL != s.url(r, r.URL.Path) {
+		return nil, newACMEProblem("unauthorized", http.StatusUnauthorized, "url '%s' in header does not match request", header.URL)
+	}
+
+	req := &acmeRequest{
+		payload: payload,
+	}
+	switch {
+	case header.JWK != nil && header.KID != "":
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "jwk and kid are mutually exclusive")
+	case header.JWK != nil:
+		if !jwkAllowed {
+			return nil, newACMEProblem("malformed", http.StatusBadRequest, "request has to be signed with kid")
+		}
+		if header.JWK.IsPrivate() {
+			return nil, newACMEProblem("badPublicKey", http.StatusBadRequest, "jwk contains a private key")
+		}
+		req.jwk = header.JWK
+	case header.KID != "":
+		prefix := s.url(r, "/account/")
+		id, ok := strings.CutPrefix(header.KID, prefix)
+		s.mu.Lock()
+		account := s.state.accounts[id]
+		var status string
+		if account != nil {
+			status = account.status
+		}
+		s.mu.Unlock()
+		if !ok || account == nil {
+			return nil, newACMEProblem("accountDoesNotExist", http.StatusBadRequest, "account '%s' does not exist", header.KID)
+		}
+		if status != ACMEStatusValid {
+			return nil, newACMEProblem("unauthorized", http.StatusUnauthorized, "account is %s", status)
+		}
+		req.account = account
+		req.jwk = account.key
+	default:
+		return nil, newACMEProblem("malformed", http.StatusBadRequest, "jwk or kid is required")
+	}
+
+	pub, err := req.jwk.Key()
+	if err != nil {
+		return nil, newACMEProblem("badPublicKey", http.StatusBadRequest, "%s", err)
+	}
+	err = jwsVerify(pub, header.Alg, []byte(jws.Protected+"."+jws.Payload), sig)
+	if err != nil {
+		return nil, newACMEProblem("badSignatureAlgorithm", http.StatusBadRequest, "invalid signature: %s", err)
+	}
+	return req, nil
+}
+
+func (s *ACMEServer) newNonce() string {
+	nonce := randomACMEToken()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.nonces[nonce] = true
+	s.state.nonceOrder = append(s.state.nonceOrder, nonce)
+	// forget the oldest nonces so that unused nonces do not accumulate
+	if len(s.state.nonceOrder) > acmeMaxNonces {
+		for _, old := range s.state.nonceOrder[:len(s.state.nonceOrder)-acmeMaxNonces] {
+			delete(s.state.nonces, old)
+		}
+		s.state.nonceOrder = slices.Clone(s.state.nonceOrder[len(s.state.nonceOrder)-acmeMaxNonces:])
+	}
+	return nonce
+}
+
+func (s *ACMEServer) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.nonces[nonce] {
+		return false
+	}
+	delete(s.state.nonces, nonce)
+	return true
+}
+
+// updateOrder updates the status of a pending order based on the status of
+// its authorizations.
+func (s *ACMEServer) updateOrder(o *acmeOrder) {
+	if o.status != ACMEStatusPending {
+		return
+	}
+	if time.Now().After(o.expires) {
+		o.status = ACMEStatusInvalid
+		o.err = newACMEProblem("malformed", http.StatusForbidden, "order expired")
+		return
+	}
+	ready := true
+	for _, id := range o.authzs {
+		a := s.state.authzs[id]
+		switch a.status {
+		case ACMEStatusValid:
+		case ACMEStatusPending:
+			ready = false
+		default:
+			o.status = ACMEStatusInvalid
+			o.err = newACMEProblem("unauthorized", http.StatusForbidden, "authorization for %s is %s", a.identifier.Value, a.status)
+			return
+		}
+	}
+	if ready {
+		o.status = ACMEStatusReady
+	}
+}
+
+func (s *ACMEServer) accountObject(r *http.Request, a *acmeAccount) *ACMEAccount {
+	return &ACMEAccount{
+		Status:  a.status,
+		Contact: a.contact,
+		Orders:  s.url(r, "/account/"+a.id+"/orders"),
+	}
+}
+
+func (s *ACMEServer) orderObject(r *http.Request, o *acmeOrder) *ACMEOrder {
+	order := &ACMEOrder{
+		Status:      o.status,
+		Expires:     &o.expires,
+		Identifiers: o.identifiers,
+		Error:       o.err,
+		Finalize:    s.url(r, "/order/"+o.id+"/finalize"),
+	}
+	if !o.notBefore.IsZero() {
+		order.NotBefore = &o.notBefore
+	}
+	if !o.notAfter.IsZero() {
+		order.NotAfter = &o.notAfter
+	}
+	for _, id := range o.authzs {
+		order.Authorizations = append(order.Authorizations, s.url(r, "/authz/"+id))
+	}
+	if o.certID != "" {
+		order.Certificate = s.url(r, "/cert/"+o.certID)
+	}
+	return order
+}
+
+func (s *ACMEServer) authzObject(r *http.Request, a *acmeAuthz) *ACMEAuthorization {
+	authz := &ACMEAuthorization{
+		Identifier: a.identifier,
+		Status:     a.status,
+		Expires:    &a.expires,
+		Challenges: []ACMEChallenge{},
+		Wildcard:   a.wildcard,
+	}
+	for _, id := range a.challenges {
+		authz.Challenges = append(authz.Challenges, *s.challengeObject(r, s.state.challenges[id]))
+	}
+	return authz
+}
+
+func (s *ACMEServer) challengeObject(r *http.Request, c *acmeChallenge) *ACMEChallenge {
+	challenge := &ACMEChallenge{
+		Type:   c.typ,
+		URL:    s.url(r, "/chall/"+c.id),
+		Status: c.status,
+		Token:  c.token,
+		Error:  c.err,
+	}
+	if !c.validated.IsZero() {
+		challenge.Validated = &c.validated
+	}
+	return challenge
+}
+
+// validate validates a challenge for an identifier.
+func (s *ACMEServer) validate(ctx context.Context, typ string, id ACMEIdentifier, token, keyAuth string) error {
+	switch typ {
+	case ACMEChallengeHTTP01:
+		return s.validateHTTP01(ctx, id, token, keyAuth)
+	case ACMEChallengeDNS01:
+		return s.validateDNS01(ctx, id, keyAuth)
+	case ACMEChallengeTLSALPN01:
+		return s.validateTLSALPN01(ctx, id, keyAuth)
+	default:
+		return fmt.Errorf("unsupported challenge type '%s'", typ)
+	}
+}
+
+func (s *ACMEServer) validateHTTP01(ctx context.Context, id ACMEIdentifier, token, keyAuth string) error {
+	port := s.HTTPPort
+	if port == 0 {
+		port = 80
+	}
+	u := "http://" + net.JoinHostPort(id.Value, strconv.Itoa(port)) + "/.well-known/acme-challenge/" + token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return newACMEProblem("connection", http.StatusForbidden, "%s", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return newACMEProblem("connection", http.StatusForbidden, "%s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	if got := strings.TrimSpace(string(body)); got != keyAuth {
+		return fmt.Errorf("%s returned '%s' instead of the key authorization", u, got)
+	}
+	return nil
+}
+
+func (s *ACMEServer) validateDNS01(ctx context.Context, id ACMEIdentifier, keyAuth string) error {
+	lookupTXT := s.LookupTXT
+	if lookupTXT == nil {
+		lookupTXT = net.DefaultResolver.LookupTXT
+	}
+	name := "_acme-challenge." + id.Value
+	records, err := lookupTXT(ctx, name)
+	if err != nil {
+		return newACMEProblem("dns", http.StatusForbidden, "%s", err)
+	}
+	expected := ACMEDNS01Value(keyAuth)
+	if !slices.Contains(records, expected) {
+		return fmt.Errorf("no TXT record %s with the value %s found", name, expected)
+	}
+	return nil
+}
+
+func (s *ACMEServer) validateTLSALPN01(ctx context.Context, id ACMEIdentifier, keyAuth string) error {
+	port := s.TLSPort
+	if port == 0 {
+		port = 443
+	}
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName:         id.Value,
+			NextProtos:         []string{ACMETLSALPNProtocol},
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(id.Value, strconv.Itoa(port)))
+	if err != nil {
+		return newACMEProblem("connection", http.StatusForbidden, "%s", err)
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != ACMETLSALPNProtocol {
+		return fmt.Errorf("protocol %s was not negotiated", ACMETLSALPNProtocol)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != id.Value || len(cert.IPAddresses)+len(cert.EmailAddresses)+len(cert.URIs) > 0 {
+		return fmt.Errorf("certificate has to contain exactly the DNS name %s", id.Value)
+	}
+	expected := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(Extensions["ACMEIdentifier"]) {
+			continue
+		}
+		var value []byte
+		_, err := asn1.Unmarshal(ext.Value, &value)
+		if !ext.Critical || err != nil || !bytes.Equal(value, expected[:]) {
+			return errors.New("invalid acmeIdentifier extension")
+		}
+		return nil
+	}
+	return errors.New("certificate has no acmeIdentifier extension")
+}
+
+// normalizeACMEIdentifier checks the syntax of an identifier and converts
+// DNS names to lower case.
+func normalizeACMEIdentifier(id ACMEIdentifier) (ACMEIdentifier, *ACMEProblem) {
+	switch id.Type {
+	case "dns":
+		id.Value = strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		name := strings.TrimPrefix(id.Value, "*.")
+		if !isDNSName(name) {
+			return id, newACMEProblem("rejectedIdentifier", http.StatusBadRequest, "invalid DNS name '%s'", id.Value)
+		}
+	case "ip":
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, newACMEProblem("rejectedIdentifier", http.StatusBadRequest, "invalid IP address '%s'", id.Value)
+		}
+		id.Value = ip.String()
+	default:
+		return id, newACMEProblem("unsupportedIdentifier", http.StatusBadRequest, "unsupported identifier type '%s'", id.Type)
+	}
+	return id, nil
+}
+
+func isDNSName(name string) bool {
+	if len(name) == 0 || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkACMECSR checks that the names of the CSR match the identifiers of
+// the order.
+func checkACMECSR(csr *x509.CertificateRequest, identifiers []ACMEIdentifier) error {
+	expected := map[string]bool{}
+	for _, id := range identifiers {
+		expected[id.Type+":"+id.Value] = true
+	}
+	got := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		got["dns:"+strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		got["ip:"+ip.String()] = true
+	}
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return errors.New("CSR must only contain DNS names and IP addresses")
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" && !expected["dns:"+cn] && !expected["ip:"+cn] {
+		return fmt.Errorf("common name '%s' is not an identifier of the order", csr.Subject.CommonName)
+	}
+	for name := range expected {
+		if !got[name] {
+			return fmt.Errorf("CSR does not contain %s", name)
+		}
+	}
+	for name := range got {
+		if !expected[name] {
+			return fmt.Errorf("%s is not an identifier of the order", name)
+		}
+	}
+	return nil
+}
+
+func writeACMEJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeACMEProblem(w http.ResponseWriter, p *ACMEProblem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func randomACMEID() string {
+	return randomACMEString(12)
+}
+
+func randomACMEToken() string {
+	return randomACMEString(32)
+}
+
+func randomACMEString(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		// reading randomness failed
+		panic(err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,574 @@
+package pcert
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acmeTestClient sends signed requests to an ACMEServer.
+type acmeTestClient struct {
+	t            *testing.T
+	client       *http.Client
+	directoryURL string
+	directory    ACMEDirectory
+	key          crypto.Signer
+	jwk          *JWK
+	kid          string
+}
+
+func newACMETestServer(t *testing.T, server *ACMEServer) *acmeTestClient {
+	t.Helper()
+	if server.CACert == nil {
+		caDER, caKey, err := CreateCertificate(NewCertificate(&CertificateOptions{
+			ProfileCA:   true,
+			Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "ACME CA"}},
+		}), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.CACert, err = x509.ParseCertificate(caDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.CAKey = caKey
+	}
+	ts := httptest.NewTLSServer(server)
+	t.Cleanup(ts.Close)
+	return newACMETestClient(t, ts.Client(), ts.URL+"/directory")
+}
+
+func newACMETestClient(t *testing.T, client *http.Client, directoryURL string) *acmeTestClient {
+	t.Helper()
+	key, _, err := GenerateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, _ := NewJWK(key.(crypto.Signer).Public())
+	c := &acmeTestClient{
+		t:            t,
+		client:       client,
+		directoryURL: directoryURL,
+		key:          key.(crypto.Signer),
+		jwk:          jwk,
+	}
+	resp, err := client.Get(directoryURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&c.directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (c *acmeTestClient) nonce() string {
+	resp, err := c.client.Head(c.directory.NewNonce)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Replay-Nonce")
+}
+
+// post sends a signed request and decodes the response into out. If
+// payload is nil a POST-as-GET request is sent.
+func (c *acmeTestClient) post(url string, payload any, out any) (*http.Response, error) {
+	c.t.Helper()
+	return c.postWithKey(c.key, url, payload, out)
+}
+
+func (c *acmeTestClient) postWithKey(key crypto.Signer, url string, payload any, out any) (*http.Response, error) {
+	c.t.Helper()
+	header := acmeJWSHeader{
+		Nonce: c.nonce(),
+		URL:   url,
+	}
+	if c.kid != "" && key == c.key {
+		header.KID = c.kid
+	} else {
+		header.JWK, _ = NewJWK(key.Public())
+	}
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	body, err := signACMEJWS(key, header, data)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp, err := c.client.Post(url, acmeContentType, strings.NewReader(string(body)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return resp, acmeProblemFromResponse(resp, respBody)
+	}
+	if out != nil {
+		if b, ok := out.(*[]byte); ok {
+			*b = respBody
+		} else if err := json.Unmarshal(respBody, out); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *acmeTestClient) newAccount() {
+	c.t.Helper()
+	resp, err := c.post(c.directory.NewAccount, &ACMEAccount{TermsOfServiceAgreed: true, Contact: []string{"mailto:admin@example.com"}}, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		c.t.Fatalf("unexpected status %s", resp.Status)
+	}
+	c.kid = resp.Header.Get("Location")
+}
+
+// order creates an order and solves its challenges of type typ.
+func (c *acmeTestClient) order(typ string, solve func(token, keyAuth string), identifiers ...ACMEIdentifier) (*ACMEOrder, string) {
+	c.t.Helper()
+	return c.orderRequest(&ACMEOrder{Identifiers: identifiers}, typ, solve)
+}
+
+// orderRequest creates the order req and solves its challenges of type typ.
+func (c *acmeTestClient) orderRequest(req *ACMEOrder, typ string, solve func(token, keyAuth string)) (*ACMEOrder, string) {
+	c.t.Helper()
+	order := &ACMEOrder{}
+	resp, err := c.post(c.directory.NewOrder, req, order)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, authzURL := range order.Authorizations {
+		authz := &ACMEAuthorization{}
+		_, err := c.post(authzURL, nil, authz)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		for _, challenge := range authz.Challenges {
+			if challenge.Type != typ {
+				continue
+			}
+			keyAuth, _ := ACMEKeyAuthorization(challenge.Token, c.jwk)
+			solve(challenge.Token, keyAuth)
+			result := &ACMEChallenge{}
+			_, err = c.post(challenge.URL, struct{}{}, result)
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			if result.Status != ACMEStatusValid {
+				c.t.Fatalf("challenge %s is %s: %v", typ, result.Status, result.Error)
+			}
+		}
+	}
+	_, err = c.post(orderURL, nil, order)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return order, orderURL
+}
+
+func (c *acmeTestClient) finalize(order *ACMEOrder, csr *x509.CertificateRequest) (*ACMEOrder, error) {
+	c.t.Helper()
+	err := csr.CheckSignature()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	result := &ACMEOrder{}
+	_, err = c.post(order.Finalize, map[string]string{"csr": jwkEncoding.EncodeToString(csr.Raw)}, result)
+	return result, err
+}
+
+func newACMETestCSR(t *testing.T, cn string, dnsNames ...string) (*x509.CertificateRequest, any) {
+	t.Helper()
+	csrPEM, key, err := CreateRequest(&x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr, key
+}
+
+func TestACMEServer(t *testing.T) {
+	// http-01 challenge server
+	challenges := map[string]string{}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, challenges[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")])
+	}))
+	defer httpServer.Close()
+	_, httpPort, _ := net.SplitHostPort(httpServer.Listener.Addr().String())
+
+	txtRecords := map[string][]string{}
+	server := &ACMEServer{
+		Validity: 24 * time.Hour,
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			return txtRecords[name], nil
+		},
+	}
+	server.HTTPPort, _ = strconv.Atoi(httpPort)
+	c := newACMETestServer(t, server)
+	c.newAccount()
+
+	// the existing account is returned for the same key
+	kid := c.kid
+	c.kid = ""
+	resp, err := c.post(c.directory.NewAccount, &ACMEAccount{OnlyReturnExisting: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != kid {
+		t.Errorf("existing account not returned: %s %s", resp.Status, resp.Header.Get("Location"))
+	}
+	c.kid = kid
+
+	// http-01 with an IP address and dns-01 with a wildcard name
+	order, orderURL := c.order(ACMEChallengeHTTP01, func(token, keyAuth string) {
+		challenges[token] = keyAuth
+	}, ACMEIdentifier{Type: "ip", Value: "127.0.0.1"})
+	if order.Status != ACMEStatusReady {
+		t.Fatalf("order is %s", order.Status)
+	}
+
+	csr, _ := newACMETestCSR(t, "", "other.example.com")
+	_, err = c.finalize(order, csr)
+	if !IsACMEProblem(err, "badCSR") {
+		t.Errorf("expected badCSR for wrong names, got %v", err)
+	}
+
+	csrPEM, _, err := CreateRequest(&x509.CertificateRequest{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, _ = ParseCSR(csrPEM)
+	order, err = c.finalize(order, csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != ACMEStatusValid || order.Certificate == "" {
+		t.Fatalf("unexpected order after finalize: %+v", order)
+	}
+	var chainPEM []byte
+	_, err = c.post(order.Certificate, nil, &chainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ParseAll(chainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || !chain[1].Equal(server.CACert) {
+		t.Fatalf("unexpected chain: %d certificates", len(chain))
+	}
+	cert := chain[0]
+	if err := cert.CheckSignatureFrom(server.CACert); err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.IPv4(127, 0, 0, 1)) || len(cert.DNSNames) != 0 {
+		t.Errorf("unexpected names: %v %v", cert.IPAddresses, cert.DNSNames)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 24*time.Hour {
+		t.Errorf("unexpected validity %s", validity)
+	}
+	if len(cert.ExtKeyUsage) == 0 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("server profile not applied: %v", cert.ExtKeyUsage)
+	}
+
+	// the order is listed on the account
+	orders := struct {
+		Orders []string `json:"orders"`
+	}{}
+	account := &ACMEAccount{}
+	_, err = c.post(c.kid, nil, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.post(account.Orders, nil, &orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders.Orders) != 1 || orders.Orders[0] != orderURL {
+		t.Errorf("unexpected orders: %v", orders.Orders)
+	}
+
+	// revocation by the account
+	revoke := map[string]any{"certificate": jwkEncoding.EncodeToString(cert.Raw)}
+	_, err = c.post(c.directory.RevokeCert, revoke, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsRevoked(cert) {
+		t.Error("certificate not revoked")
+	}
+	_, err = c.post(c.directory.RevokeCert, revoke, nil)
+	if !IsACMEProblem(err, "alreadyRevoked") {
+		t.Errorf("expected alreadyRevoked, got %v", err)
+	}
+
+	order, _ = c.order(ACMEChallengeDNS01, func(token, keyAuth string) {
+		txtRecords["_acme-challenge.example.com"] = []string{"other", ACMEDNS01Value(keyAuth)}
+	}, ACMEIdentifier{Type: "dns", Value: "*.Example.com"}, ACMEIdentifier{Type: "dns", Value: "example.com"})
+	if order.Status != ACMEStatusReady {
+		t.Fatalf("order is %s", order.Status)
+	}
+	csr, certKey := newACMETestCSR(t, "example.com", "example.com", "*.example.com")
+	order, err = c.finalize(order, csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.post(order.Certificate, nil, &chainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = Parse(chainPEM)
+
+	// revocation by another account is not allowed but with the key of the
+	// certificate
+	other := newACMETestClient(t, c.client, c.directoryURL)
+	other.newAccount()
+	revoke = map[string]any{"certificate": jwkEncoding.EncodeToString(cert.Raw), "reason": 4}
+	_, err = other.post(other.directory.RevokeCert, revoke, nil)
+	if !IsACMEProblem(err, "unauthorized") {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+	_, err = other.postWithKey(certKey.(crypto.Signer), other.directory.RevokeCert, revoke, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACMEServer_tlsalpn01(t *testing.T) {
+	var keyAuth string
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		NextProtos: []string{ACMETLSALPNProtocol},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			sum := sha256.Sum256([]byte(keyAuth))
+			value, _ := asn1.Marshal(sum[:])
+			key, pub, err := GenerateKey(KeyOptions{})
+			if err != nil {
+				return nil, err
+			}
+			der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				DNSNames:     []string{hello.ServerName},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+				ExtraExtensions: []pkix.Extension{
+					{Id: Extensions["ACMEIdentifier"], Critical: true, Value: value},
+				},
+			}, &x509.Certificate{SerialNumber: big.NewInt(1)}, pub, key)
+			if err != nil {
+				return nil, err
+			}
+			return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	server := &ACMEServer{}
+	server.TLSPort, _ = strconv.Atoi(port)
+	c := newACMETestServer(t, server)
+	c.newAccount()
+	order, _ := c.order(ACMEChallengeTLSALPN01, func(token, ka string) {
+		keyAuth = ka
+	}, ACMEIdentifier{Type: "dns", Value: "localhost"})
+	if order.Status != ACMEStatusReady {
+		t.Fatalf("order is %s", order.Status)
+	}
+}
+
+func TestACMEServer_errors(t *testing.T) {
+	server := &ACMEServer{SkipValidation: true}
+	c := newACMETestServer(t, server)
+
+	// requests without account
+	_, err := c.post(c.directory.NewOrder, &ACMEOrder{Identifiers: []ACMEIdentifier{{Type: "dns", Value: "example.com"}}}, nil)
+	if !IsACMEProblem(err, "malformed") {
+		t.Errorf("expected malformed for request without account, got %v", err)
+	}
+	_, err = c.post(c.directory.NewAccount, &ACMEAccount{OnlyReturnExisting: true}, nil)
+	if !IsACMEProblem(err, "accountDoesNotExist") {
+		t.Errorf("expected accountDoesNotExist, got %v", err)
+	}
+	c.newAccount()
+
+	// a nonce can only be used once
+	header := acmeJWSHeader{Nonce: c.nonce(), URL: c.directory.NewOrder, KID: c.kid}
+	body, _ := signACMEJWS(c.key, header, []byte(`{"identifiers":[{"type":"dns","value":"example.com"}]}`))
+	for i, expected := range []int{http.StatusCreated, http.StatusBadRequest} {
+		resp, err := c.client.Post(c.directory.NewOrder, acmeContentType, strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("request %d: got status %d want %d", i, resp.StatusCode, expected)
+		}
+	}
+
+	for _, id := range []ACMEIdentifier{
+		{Type: "dns", Value: "-invalid.example.com"},
+		{Type: "dns", Value: "*.*.example.com"},
+		{Type: "ip", Value: "1.2.3"},
+		{Type: "email", Value: "admin@example.com"},
+	} {
+		_, err = c.post(c.directory.NewOrder, &ACMEOrder{Identifiers: []ACMEIdentifier{id}}, nil)
+		if err == nil {
+			t.Errorf("expected error for identifier %v", id)
+		}
+	}
+
+	// finalize before the challenges are solved
+	order := &ACMEOrder{}
+	_, err = c.post(c.directory.NewOrder, &ACMEOrder{Identifiers: []ACMEIdentifier{{Type: "dns", Value: "example.com"}}}, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, _ := newACMETestCSR(t, "", "example.com")
+	_, err = c.finalize(order, csr)
+	if !IsACMEProblem(err, "orderNotReady") {
+		t.Errorf("expected orderNotReady, got %v", err)
+	}
+
+	// with SkipValidation the challenges are valid without a response
+	order, _ = c.order(ACMEChallengeHTTP01, func(string, string) {}, ACMEIdentifier{Type: "dns", Value: "example.com"})
+	if order.Status != ACMEStatusReady {
+		t.Fatalf("order is %s", order.Status)
+	}
+
+	// the CN has to be an identifier
+	csr, _ = newACMETestCSR(t, "other.example.com", "example.com")
+	_, err = c.finalize(order, csr)
+	if !IsACMEProblem(err, "badCSR") {
+		t.Errorf("expected badCSR, got %v", err)
+	}
+
+	// deactivated accounts can no longer be used
+	_, err = c.post(c.kid, &ACMEAccount{Status: ACMEStatusDeactivated}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.post(c.directory.NewOrder, &ACMEOrder{Identifiers: []ACMEIdentifier{{Type: "dns", Value: "example.com"}}}, nil)
+	if !IsACMEProblem(err, "unauthorized") {
+		t.Errorf("expected unauthorized for deactivated account, got %v", err)
+	}
+}
+
+func TestACMEServer_validity(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCertificate(&CertificateOptions{
+		ProfileCA:   true,
+		Expiry:      10 * 24 * time.Hour,
+		Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "ACME CA"}},
+	}), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ACMEServer{
+		CACert:         caCert,
+		CAKey:          caKey,
+		Validity:       30 * 24 * time.Hour,
+		SkipValidation: true,
+	}
+	c := newACMETestServer(t, server)
+	c.newAccount()
+	identifiers := []ACMEIdentifier{{Type: "dns", Value: "example.com"}}
+	issue := func(req *ACMEOrder) *x509.Certificate {
+		t.Helper()
+		order, orderURL := c.orderRequest(req, ACMEChallengeHTTP01, func(string, string) {})
+		csr, _ := newACMETestCSR(t, "", "example.com")
+		_, err := c.finalize(order, csr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.post(orderURL, nil, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chainPEM []byte
+		_, err = c.post(order.Certificate, nil, &chainPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := Parse(chainPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	// the validity is limited by the CA certificate
+	cert := issue(&ACMEOrder{Identifiers: identifiers})
+	if !cert.NotAfter.Equal(caCert.NotAfter) {
+		t.Errorf("certificate expires after the CA: %s > %s", cert.NotAfter, caCert.NotAfter)
+	}
+
+	// a shorter validity of the order is used
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert = issue(&ACMEOrder{Identifiers: identifiers, NotAfter: &notAfter})
+	if !cert.NotAfter.Equal(notAfter) {
+		t.Errorf("unexpected notAfter: got %s want %s", cert.NotAfter, notAfter)
+	}
+
+	// a longer validity than the server validity is rejected
+	notBefore := time.Now()
+	farNotAfter := notBefore.Add(1000 * 24 * time.Hour)
+	longNotAfter := notBefore.Add(31 * 24 * time.Hour)
+	for _, req := range []*ACMEOrder{
+		{Identifiers: identifiers, NotAfter: &farNotAfter},
+		{Identifiers: identifiers, NotBefore: &notBefore, NotAfter: &longNotAfter},
+	} {
+		_, err = c.post(c.directory.NewOrder, req, nil)
+		if !IsACMEProblem(err, "malformed") {
+			t.Errorf("expected malformed for validity %s, got %v", req.NotAfter, err)
+		}
+	}
+}
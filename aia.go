@@ -0,0 +1,214 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultIssuerFetchDepth is the maximum number of issuers which are
+	// fetched to complete a chain if IssuerFetcher.MaxDepth is not set.
+	DefaultIssuerFetchDepth = 5
+
+	// DefaultIssuerFetchTimeout is the timeout for a single HTTP request
+	// if IssuerFetcher.Timeout is not set.
+	DefaultIssuerFetchTimeout = time.Second * 10
+
+	// maxIssuerResponseSize limits the size of a CA Issuers response.
+	maxIssuerResponseSize = 1 << 20
+)
+
+// IssuerFetcher fetches issuer certificates from the CA Issuers URLs of the
+// Authority Information Access extension (see IssuingCertificateURL in
+// x509.Certificate). Responses can be DER or PEM encoded certificates or a
+// PKCS#7 certs-only bundle.
+type IssuerFetcher struct {
+	// Client is used to fetch the issuers. If nil http.DefaultClient is used.
+	Client *http.Client
+
+	// CacheDir is a directory where fetched responses are cached. If empty
+	// no caching takes place.
+	CacheDir string
+
+	// MaxDepth limits the number of issuers fetched by CompleteChain. If
+	// zero DefaultIssuerFetchDepth is used.
+	MaxDepth int
+
+	// Timeout for a single request. If zero DefaultIssuerFetchTimeout is used.
+	Timeout time.Duration
+}
+
+// FetchIssuer returns the issuer of cert by trying all its CA Issuers URLs.
+// The first certificate which has signed cert is returned.
+func (f *IssuerFetcher) FetchIssuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	if len(cert.IssuingCertificateURL) == 0 {
+		return nil, fmt.Errorf("certificate '%s' has no issuing certificate URL", cert.Subject)
+	}
+
+	var errs []error
+	for _, url := range cert.IssuingCertificateURL {
+		candidates, err := f.fetch(ctx, url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, candidate := range candidates {
+			if cert.CheckSignatureFrom(candidate) == nil {
+				return candidate, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("no certificate from '%s' has signed '%s'", url, cert.Subject))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// CompleteChain appends the missing issuers to chain by following the CA
+// Issuers URLs starting from the last certificate in the chain. It stops if it
+// reaches a self-signed certificate, a certificate without a CA Issuers URL or
+// if MaxDepth is reached. The first element of chain is expected to be the
+// leaf certificate.
+func (f *IssuerFetcher) CompleteChain(ctx context.Context, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("empty chain")
+	}
+
+	maxDepth := f.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultIssuerFetchDepth
+	}
+
+	for i := 0; i < maxDepth; i++ {
+		last := chain[len(chain)-1]
+		if IsSelfSigned(last) || len(last.IssuingCertificateURL) == 0 {
+			return chain, nil
+		}
+
+		issuer, err := f.FetchIssuer(ctx, last)
+		if err != nil {
+			return chain, err
+		}
+
+		for _, c := range chain {
+			if c.Equal(issuer) {
+				return chain, fmt.Errorf("issuer loop detected at '%s'", issuer.Subject)
+			}
+		}
+		chain = append(chain, issuer)
+	}
+	return chain, nil
+}
+
+func (f *IssuerFetcher) fetch(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	cacheFile := f.cacheFile(url)
+	if cacheFile != "" {
+		data, err := os.ReadFile(cacheFile)
+		if err == nil {
+			certs, err := parseIssuerResponse(data)
+			if err == nil {
+				return certs, nil
+			}
+		}
+	}
+
+	data, err := f.download(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := parseIssuerResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from '%s': %w", url, err)
+	}
+
+	// caching is best-effort. a failure to write the cache (e.g. read-only
+	// home directory) must not fail the fetch.
+	if cacheFile != "" {
+		err = os.MkdirAll(f.CacheDir, 0o755)
+		if err == nil {
+			_ = os.WriteFile(cacheFile, data, 0o644)
+		}
+	}
+	return certs, nil
+}
+
+func (f *IssuerFetcher) download(ctx context.Context, url string) ([]byte, error) {
+	timeout := f.Timeout
+	if timeout == 0 {
+		timeout = DefaultIssuerFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch '%s': %s", url, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxIssuerResponseSize))
+}
+
+func (f *IssuerFetcher) cacheFile(url string) string {
+	if f.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// parseIssuerResponse parses the body of a CA Issuers response which is
+// either a DER or PEM encoded certificate or a PKCS#7 bundle.
+func parseIssuerResponse(data []byte) ([]*x509.Certificate, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		certs, err := ParseAll(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no CERTIFICATE found in PEM data")
+		}
+		return certs, nil
+	}
+
+	certs, err := x509.ParseCertificates(data)
+	if err == nil {
+		return certs, nil
+	}
+
+	certs, p7err := ParsePKCS7Certificates(data)
+	if p7err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	return nil, fmt.Errorf("data is neither a DER certificate (%w) nor a PKCS#7 bundle", err)
+}
+
+// IsSelfSigned returns true if the issuer of cert is its subject and cert is
+// signed by its own key.
+func IsSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
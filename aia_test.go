@@ -0,0 +1,218 @@
+package pcert
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPKI is a root CA, an intermediate CA and a leaf certificate. The
+// intermediate and the leaf point with their CA Issuers URL to a local HTTP
+// server which serves the intermediate DER encoded and the root as PKCS#7
+// bundle.
+type testPKI struct {
+	server       *httptest.Server
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	leaf         *x509.Certificate
+	requests     int
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	pki := &testPKI{}
+	files := map[string][]byte{}
+	pki.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pki.requests++
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(pki.server.Close)
+
+	create := func(name string, ca bool, issuerURL string, signCert *x509.Certificate, signKey crypto.PrivateKey) (*x509.Certificate, crypto.PrivateKey) {
+		tmpl := NewCertificate(&CertificateOptions{
+			ProfileCA: ca,
+			Certificate: x509.Certificate{
+				Subject: pkix.Name{CommonName: name},
+			},
+		})
+		if issuerURL != "" {
+			tmpl.IssuingCertificateURL = []string{pki.server.URL + issuerURL}
+		}
+		der, key, err := CreateCertificate(tmpl, signCert, signKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	var rootKey, intermediateKey crypto.PrivateKey
+	pki.root, rootKey = create("Root", true, "", nil, nil)
+	pki.intermediate, intermediateKey = create("Intermediate", true, "/root.p7c", pki.root, rootKey)
+	pki.leaf, _ = create("Leaf", false, "/intermediate.der", pki.intermediate, intermediateKey)
+
+	files["/root.p7c"] = testPKCS7(t, pki.root)
+	files["/intermediate.der"] = pki.intermediate.Raw
+	return pki
+}
+
+func testPKCS7(t *testing.T, certs ...*x509.Certificate) []byte {
+	t.Helper()
+	der, err := EncodePKCS7Certificates(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestIssuerFetcher_CompleteChain(t *testing.T) {
+	pki := newTestPKI(t)
+	f := &IssuerFetcher{}
+
+	chain, err := f.CompleteChain(context.Background(), []*x509.Certificate{pki.leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*x509.Certificate{pki.leaf, pki.intermediate, pki.root}
+	if len(chain) != len(want) {
+		t.Fatalf("wrong chain length: got=%d want=%d", len(chain), len(want))
+	}
+	for i := range want {
+		if !chain[i].Equal(want[i]) {
+			t.Errorf("wrong certificate at position %d: got=%s want=%s", i, chain[i].Subject, want[i].Subject)
+		}
+	}
+}
+
+func TestIssuerFetcher_CompleteChain_maxDepth(t *testing.T) {
+	pki := newTestPKI(t)
+	f := &IssuerFetcher{
+		MaxDepth: 1,
+	}
+
+	chain, err := f.CompleteChain(context.Background(), []*x509.Certificate{pki.leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chain) != 2 {
+		t.Fatalf("wrong chain length: got=%d want=%d", len(chain), 2)
+	}
+}
+
+func TestIssuerFetcher_cache(t *testing.T) {
+	pki := newTestPKI(t)
+	f := &IssuerFetcher{
+		CacheDir: t.TempDir(),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := f.CompleteChain(context.Background(), []*x509.Certificate{pki.leaf})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pki.requests != 2 {
+		t.Errorf("issuers not served from cache: got=%d requests want=%d", pki.requests, 2)
+	}
+
+	entries, err := os.ReadDir(f.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("wrong number of cache entries: got=%d want=%d", len(entries), 2)
+	}
+}
+
+func TestIssuerFetcher_cache_notWritable(t *testing.T) {
+	pki := newTestPKI(t)
+	cacheParent := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(cacheParent, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &IssuerFetcher{
+		// cache dir below a regular file can never be created
+		CacheDir: filepath.Join(cacheParent, "cache"),
+	}
+
+	chain, err := f.CompleteChain(context.Background(), []*x509.Certificate{pki.leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("wrong chain length: got=%d want=%d", len(chain), 3)
+	}
+}
+
+func TestIssuerFetcher_FetchIssuer_notFound(t *testing.T) {
+	pki := newTestPKI(t)
+	f := &IssuerFetcher{}
+
+	cert := *pki.leaf
+	cert.IssuingCertificateURL = []string{pki.server.URL + "/missing"}
+
+	_, err := f.FetchIssuer(context.Background(), &cert)
+	if err == nil {
+		t.Fatal("no error for missing issuer")
+	}
+}
+
+func TestIsSelfSigned(t *testing.T) {
+	pki := newTestPKI(t)
+	if !IsSelfSigned(pki.root) {
+		t.Error("root is not self-signed")
+	}
+	if IsSelfSigned(pki.leaf) {
+		t.Error("leaf is self-signed")
+	}
+
+	// a certificate signed by its own key for another issuer name
+	key, pub, err := GenerateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, NewClientCertificate("client"), NewCACertificate("Other"), pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) != nil {
+		t.Fatal("certificate is not signed by its own key")
+	}
+	if IsSelfSigned(cert) {
+		t.Error("certificate with other issuer is self-signed")
+	}
+}
+
+func TestParsePKCS7Certificates(t *testing.T) {
+	pki := newTestPKI(t)
+	certs, err := ParsePKCS7Certificates(testPKCS7(t, pki.leaf, pki.intermediate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(pki.leaf) || !certs[1].Equal(pki.intermediate) {
+		t.Fatalf("wrong certificates parsed from PKCS#7: %v", certs)
+	}
+}
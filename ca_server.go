@@ -0,0 +1,710 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCAServerValidity is the validity of certificates issued by the
+	// CAServer if neither the request nor the server set a validity.
+	DefaultCAServerValidity = 90 * 24 * time.Hour
+
+	// HMACAuthScheme is the scheme of the Authorization header of requests
+	// signed with SignHMACRequest.
+	HMACAuthScheme = "PCERT-HMAC-SHA256"
+
+	hmacMaxClockSkew      = 5 * time.Minute
+	caServerMaxBodySize   = 1 << 20
+	caServerProfileServer = "server"
+	caServerProfileClient = "client"
+)
+
+// CAServerProfiles are the profiles which can be requested from a CAServer.
+var CAServerProfiles = []string{caServerProfileServer, caServerProfileClient}
+
+// CAPolicy restricts the certificates a client of the CAServer can obtain.
+type CAPolicy struct {
+	// AllowedNames are patterns (see path.Match) for the common name and
+	// all subject alternative names of the certificates. A '*' also
+	// matches dots, so '*.example.com' matches all subdomains and '*'
+	// matches all names. If empty, no names are allowed.
+	AllowedNames []string
+	// Profiles are the allowed profiles (see CAServerProfiles). If empty,
+	// all profiles are allowed.
+	Profiles []string
+	// MaxValidity is the maximum validity of the certificates. If zero,
+	// the validity is not restricted.
+	MaxValidity time.Duration
+	// Admin allows to list and revoke all certificates and not only the
+	// ones of the client itself.
+	Admin bool
+}
+
+func (p *CAPolicy) allowsName(name string) bool {
+	for _, pattern := range p.AllowedNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CAPolicy) allowsProfile(profile string) bool {
+	return len(p.Profiles) == 0 || slices.Contains(p.Profiles, profile)
+}
+
+// CAIdentity is an authenticated client of the CAServer.
+type CAIdentity struct {
+	// Name identifies the client. It is stored as owner of the issued
+	// certificates.
+	Name   string
+	Policy CAPolicy
+	// Certificate is the client certificate if the client authenticated
+	// with a certificate.
+	Certificate *x509.Certificate
+}
+
+// CAAuthenticator authenticates requests to the CAServer. Authenticate
+// returns nil and no error if the request contains no credentials for the
+// authenticator. body is the request body.
+type CAAuthenticator interface {
+	Authenticate(r *http.Request, body []byte) (*CAIdentity, error)
+}
+
+// BearerTokenAuthenticator authenticates requests with static bearer tokens
+// in the Authorization header.
+type BearerTokenAuthenticator struct {
+	// Tokens maps the tokens to the identities.
+	Tokens map[string]*CAIdentity
+}
+
+func (a *BearerTokenAuthenticator) Authenticate(r *http.Request, _ []byte) (*CAIdentity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+	var identity *CAIdentity
+	for t, id := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			identity = id
+		}
+	}
+	if identity == nil {
+		return nil, errors.New("invalid token")
+	}
+	return identity, nil
+}
+
+// HMACKey is a shared secret of a client for the HMACAuthenticator.
+type HMACKey struct {
+	Secret   []byte
+	Identity *CAIdentity
+}
+
+// HMACAuthenticator authenticates requests signed with SignHMACRequest.
+// Requests are accepted if their timestamp differs by at most five minutes
+// from the time of the server. Replayed requests within this period are not
+// detected.
+type HMACAuthenticator struct {
+	// Keys maps the key IDs to the keys.
+	Keys map[string]HMACKey
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request, body []byte) (*CAIdentity, error) {
+	params, ok := strings.CutPrefix(r.Header.Get("Authorization"), HMACAuthScheme+" ")
+	if !ok {
+		return nil, nil
+	}
+	var keyID, timestamp, signature string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "KeyId":
+			keyID = value
+		case "Timestamp":
+			timestamp = value
+		case "Signature":
+			signature = value
+		}
+	}
+	key, ok := a.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key '%s'", keyID)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp '%s'", timestamp)
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > hmacMaxClockSkew || d < -hmacMaxClockSkew {
+		return nil, errors.New("timestamp is out of range")
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+	if !hmac.Equal(sig, hmacRequestSignature(key.Secret, r.Method, r.RequestURI, timestamp, body)) {
+		return nil, errors.New("invalid signature")
+	}
+	return key.Identity, nil
+}
+
+// SignHMACRequest sets the Authorization header of r for the
+// HMACAuthenticator. The signature covers the method, the request URI, the
+// current time and the body.
+func SignHMACRequest(r *http.Request, keyID string, secret []byte) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := hmacRequestSignature(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s,Timestamp=%s,Signature=%x", HMACAuthScheme, keyID, timestamp, signature))
+	return nil
+}
+
+func hmacRequestSignature(secret []byte, method, requestURI, timestamp string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", method, requestURI, timestamp, bodyHash)
+	return mac.Sum(nil)
+}
+
+// ClientCertificateAuthenticator authenticates requests with TLS client
+// certificates. The identity is selected by the common name of the
+// certificate. The CAServer rejects client certificates issued by its own
+// CA, as clients could otherwise obtain a certificate with the common name
+// of another client.
+type ClientCertificateAuthenticator struct {
+	// Roots are used to verify the client certificates. They must not
+	// contain the CA of the CAServer.
+	Roots *x509.CertPool
+	// Policies maps common names to the policies of the clients.
+	Policies map[string]CAPolicy
+	// DefaultPolicy is used for common names which are not in Policies.
+	// If nil, such clients are rejected.
+	DefaultPolicy *CAPolicy
+}
+
+func (a *ClientCertificateAuthenticator) Authenticate(r *http.Request, _ []byte) (*CAIdentity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, err
+	}
+	name := cert.Subject.CommonName
+	policy, ok := a.Policies[name]
+	if !ok {
+		if a.DefaultPolicy == nil {
+			return nil, fmt.Errorf("no policy for client '%s'", name)
+		}
+		policy = *a.DefaultPolicy
+	}
+	return &CAIdentity{
+		Name:        name,
+		Policy:      policy,
+		Certificate: cert,
+	}, nil
+}
+
+// CASignRequest is the request to sign a CSR.
+type CASignRequest struct {
+	// CSR in PEM format.
+	CSR     string `json:"csr"`
+	Profile string `json:"profile"`
+	// Validity is a duration (e.g. 720h). If empty, the default of the
+	// server is used.
+	Validity string `json:"validity,omitempty"`
+}
+
+// CAIssueRequest is the request to create a key and a certificate.
+type CAIssueRequest struct {
+	CommonName     string   `json:"common_name,omitempty"`
+	DNSNames       []string `json:"dns_names,omitempty"`
+	IPAddresses    []string `json:"ip_addresses,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	Profile        string   `json:"profile"`
+	Validity       string   `json:"validity,omitempty"`
+	// KeyAlgorithm is RSA, ECDSA or Ed25519. Defaults to ECDSA.
+	KeyAlgorithm string `json:"key_algorithm,omitempty"`
+	KeySize      int    `json:"key_size,omitempty"`
+}
+
+// CACertificateResponse is the response to a sign or issue request. The
+// certificates and the key are in PEM format. Key is only set for issue
+// requests.
+type CACertificateResponse struct {
+	SerialNumber string `json:"serial_number"`
+	Certificate  string `json:"certificate"`
+	Chain        string `json:"chain"`
+	Key          string `json:"key,omitempty"`
+}
+
+// CARevokeRequest is the request to revoke a certificate. SerialNumber is
+// hex encoded. Reason is a reason code of RFC 5280 section 5.3.1.
+type CARevokeRequest struct {
+	SerialNumber string `json:"serial_number"`
+	Reason       int    `json:"reason,omitempty"`
+}
+
+// CACertificateInfo describes a certificate issued by the CAServer.
+type CACertificateInfo struct {
+	SerialNumber string     `json:"serial_number"`
+	Subject      string     `json:"subject"`
+	Names        []string   `json:"names"`
+	NotBefore    time.Time  `json:"not_before"`
+	NotAfter     time.Time  `json:"not_after"`
+	Owner        string     `json:"owner"`
+	Revoked      bool       `json:"revoked"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	Reason       int        `json:"reason,omitempty"`
+}
+
+// CAServer is a JSON/HTTP API to issue certificates with a CA. It offers the
+// following endpoints:
+//
+//	GET  /ca            CA certificate and chain in PEM format
+//	POST /sign          sign a CSR (CASignRequest)
+//	POST /issue         create a key and a certificate (CAIssueRequest)
+//	POST /revoke        revoke a certificate (CARevokeRequest)
+//	GET  /certificates  list the issued certificates (CACertificateInfo)
+//
+// The handlers are available as methods to mount them individually. All
+// endpoints except /ca require authentication by one of the Authenticators.
+// The policy of the authenticated client restricts which certificates it
+// can obtain. Clients can list and revoke their own certificates.
+//
+// The issued certificates and revocations are kept in memory and are lost if
+// the server is stopped.
+type CAServer struct {
+	// CACert and CAKey are used to sign the certificates.
+	CACert *x509.Certificate
+	CAKey  any
+	// Chain contains certificates which are returned after CACert (e.g.
+	// the root of an intermediate CACert).
+	Chain []*x509.Certificate
+
+	// Validity of the certificates if the request sets no validity.
+	// Defaults to DefaultCAServerValidity. It is limited by the
+	// MaxValidity of the policy.
+	Validity time.Duration
+
+	// Authenticators are tried in order. The first one which returns an
+	// identity or an error is used.
+	Authenticators []CAAuthenticator
+
+	once  sync.Once
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	certs []*caServerCert
+}
+
+type caServerCert struct {
+	cert      *x509.Certificate
+	owner     string
+	revokedAt time.Time
+	reason    int
+}
+
+// ServeHTTP implements http.Handler.
+func (s *CAServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("GET /ca", s.HandleCA)
+		s.mux.HandleFunc("POST /sign", s.HandleSign)
+		s.mux.HandleFunc("POST /issue", s.HandleIssue)
+		s.mux.HandleFunc("POST /revoke", s.HandleRevoke)
+		s.mux.HandleFunc("GET /certificates", s.HandleList)
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+// HandleCA writes the CA certificate and the chain in PEM format.
+func (s *CAServer) HandleCA(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(s.chainPEM())
+}
+
+// HandleSign signs the CSR of a CASignRequest.
+func (s *CAServer) HandleSign(w http.ResponseWriter, r *http.Request) {
+	identity, body, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	req := &CASignRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "invalid request: %s", err)
+		return
+	}
+	csr, err := ParseCSR([]byte(req.CSR))
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "invalid CSR: %s", err)
+		return
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "invalid CSR signature: %s", err)
+		return
+	}
+	opts, status, err := s.certificateOptions(identity, req.Profile, req.Validity)
+	if err != nil {
+		writeCAServerError(w, status, "%s", err)
+		return
+	}
+	opts.Subject = csr.Subject
+	opts.DNSNames = slices.Clone(csr.DNSNames)
+	opts.IPAddresses = csr.IPAddresses
+	opts.EmailAddresses = csr.EmailAddresses
+	opts.URIs = csr.URIs
+	err = s.checkNames(identity, opts)
+	if err != nil {
+		writeCAServerError(w, http.StatusForbidden, "%s", err)
+		return
+	}
+	cert, err := issueCertificateForCSR(csr, *opts, s.CACert, s.CAKey)
+	if err != nil {
+		writeCAServerError(w, http.StatusInternalServerError, "failed to sign certificate: %s", err)
+		return
+	}
+	s.writeCertificate(w, identity, cert, nil)
+}
+
+// HandleIssue creates a key and a certificate for a CAIssueRequest.
+func (s *CAServer) HandleIssue(w http.ResponseWriter, r *http.Request) {
+	identity, body, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	req := &CAIssueRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "invalid request: %s", err)
+		return
+	}
+	keyOpts := KeyOptions{Size: req.KeySize}
+	if req.KeyAlgorithm != "" {
+		for _, alg := range PublicKeyAlgorithms {
+			if strings.EqualFold(alg.String(), req.KeyAlgorithm) {
+				keyOpts.Algorithm = alg
+			}
+		}
+		if keyOpts.Algorithm == x509.UnknownPublicKeyAlgorithm {
+			writeCAServerError(w, http.StatusBadRequest, "unknown key algorithm '%s'", req.KeyAlgorithm)
+			return
+		}
+	}
+	opts, status, err := s.certificateOptions(identity, req.Profile, req.Validity)
+	if err != nil {
+		writeCAServerError(w, status, "%s", err)
+		return
+	}
+	opts.Subject = pkix.Name{CommonName: req.CommonName}
+	opts.DNSNames = slices.Clone(req.DNSNames)
+	opts.EmailAddresses = req.EmailAddresses
+	for _, ipStr := range req.IPAddresses {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			writeCAServerError(w, http.StatusBadRequest, "invalid IP address '%s'", ipStr)
+			return
+		}
+		opts.IPAddresses = append(opts.IPAddresses, ip)
+	}
+	for _, uri := range req.URIs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			writeCAServerError(w, http.StatusBadRequest, "invalid URI '%s'", uri)
+			return
+		}
+		opts.URIs = append(opts.URIs, u)
+	}
+	err = s.checkNames(identity, opts)
+	if err != nil {
+		writeCAServerError(w, http.StatusForbidden, "%s", err)
+		return
+	}
+	der, key, err := CreateCertificateWithKeyOptions(NewCertificate(opts), keyOpts, s.CACert, s.CAKey)
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "failed to create certificate: %s", err)
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		writeCAServerError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		writeCAServerError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	s.writeCertificate(w, identity, cert, keyPEM)
+}
+
+// HandleRevoke revokes a certificate of a CARevokeRequest.
+func (s *CAServer) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	identity, body, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	req := &CARevokeRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "invalid request: %s", err)
+		return
+	}
+	// reason codes of RFC 5280 section 5.3.1. 7 is not used.
+	if req.Reason < 0 || req.Reason > 10 || req.Reason == 7 {
+		writeCAServerError(w, http.StatusBadRequest, "invalid reason %d", req.Reason)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var c *caServerCert
+	for _, cert := range s.certs {
+		if hex.EncodeToString(cert.cert.SerialNumber.Bytes()) == strings.ToLower(req.SerialNumber) {
+			c = cert
+			break
+		}
+	}
+	if c == nil || (c.owner != identity.Name && !identity.Policy.Admin) {
+		writeCAServerError(w, http.StatusNotFound, "certificate '%s' not found", req.SerialNumber)
+		return
+	}
+	if !c.revokedAt.IsZero() {
+		writeCAServerError(w, http.StatusConflict, "certificate '%s' is already revoked", req.SerialNumber)
+		return
+	}
+	c.revokedAt = time.Now()
+	c.reason = req.Reason
+	writeCAServerJSON(w, http.StatusOK, c.info())
+}
+
+// HandleList writes the certificates of the client. Admins get all
+// certificates.
+func (s *CAServer) HandleList(w http.ResponseWriter, r *http.Request) {
+	identity, _, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := []CACertificateInfo{}
+	for _, c := range s.certs {
+		if c.owner == identity.Name || identity.Policy.Admin {
+			infos = append(infos, c.info())
+		}
+	}
+	writeCAServerJSON(w, http.StatusOK, infos)
+}
+
+// IsRevoked reports whether cert was issued by the server and has been
+// revoked.
+func (s *CAServer) IsRevoked(cert *x509.Certificate) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.certs {
+		if c.cert.Equal(cert) {
+			return !c.revokedAt.IsZero()
+		}
+	}
+	return false
+}
+
+// authenticate reads the body and authenticates the request. If it fails,
+// an error is written and ok is false.
+func (s *CAServer) authenticate(w http.ResponseWriter, r *http.Request) (identity *CAIdentity, body []byte, ok bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, caServerMaxBodySize))
+	if err != nil {
+		writeCAServerError(w, http.StatusBadRequest, "failed to read request: %s", err)
+		return nil, nil, false
+	}
+	for _, a := range s.Authenticators {
+		identity, err = a.Authenticate(r, body)
+		if err != nil {
+			writeCAServerError(w, http.StatusUnauthorized, "authentication failed: %s", err)
+			return nil, nil, false
+		}
+		if identity != nil {
+			break
+		}
+	}
+	if identity == nil {
+		writeCAServerError(w, http.StatusUnauthorized, "authentication required")
+		return nil, nil, false
+	}
+	if identity.Certificate != nil && s.issuedClientCertificate(r) {
+		writeCAServerError(w, http.StatusUnauthorized, "authentication failed: client certificates issued by this CA are not accepted")
+		return nil, nil, false
+	}
+	return identity, body, true
+}
+
+// issuedClientCertificate reports whether a certificate of the TLS client
+// chain of r is the CA certificate or is signed by it.
+func (s *CAServer) issuedClientCertificate(r *http.Request) bool {
+	if r.TLS == nil {
+		return false
+	}
+	for _, cert := range r.TLS.PeerCertificates {
+		if cert.Equal(s.CACert) || bytes.Equal(cert.RawSubjectPublicKeyInfo, s.CACert.RawSubjectPublicKeyInfo) || cert.CheckSignatureFrom(s.CACert) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateOptions returns the options for a profile and a validity if
+// they are allowed by the policy of identity. On error the HTTP status is
+// returned.
+func (s *CAServer) certificateOptions(identity *CAIdentity, profile, validity string) (*CertificateOptions, int, error) {
+	if !slices.Contains(CAServerProfiles, profile) {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid profile '%s'. valid profiles are %s", profile, strings.Join(CAServerProfiles, ", "))
+	}
+	if !identity.Policy.allowsProfile(profile) {
+		return nil, http.StatusForbidden, fmt.Errorf("profile '%s' is not allowed", profile)
+	}
+	maxValidity := identity.Policy.MaxValidity
+	expiry := s.Validity
+	if expiry == 0 {
+		expiry = DefaultCAServerValidity
+	}
+	if maxValidity > 0 && expiry > maxValidity {
+		expiry = maxValidity
+	}
+	if validity != "" {
+		d, err := time.ParseDuration(validity)
+		if err != nil || d <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid validity '%s'", validity)
+		}
+		if maxValidity > 0 && d > maxValidity {
+			return nil, http.StatusForbidden, fmt.Errorf("validity %s exceeds the maximum of %s", d, maxValidity)
+		}
+		expiry = d
+	}
+	return &CertificateOptions{
+		Expiry:        expiry,
+		ProfileServer: profile == caServerProfileServer,
+		ProfileClient: profile == caServerProfileClient,
+	}, 0, nil
+}
+
+// checkNames checks the names of opts against the policy of identity. For
+// the server profile the common name is set to the first DNS name if it is
+// empty, as the profile adds the common name to the DNS names.
+func (s *CAServer) checkNames(identity *CAIdentity, opts *CertificateOptions) error {
+	if opts.ProfileServer && opts.Subject.CommonName == "" {
+		if len(opts.DNSNames) == 0 {
+			return errors.New("the server profile requires a common name or a DNS name")
+		}
+		opts.Subject.CommonName = opts.DNSNames[0]
+	}
+	names := certificateNames(&opts.Certificate)
+	if len(names) == 0 {
+		return errors.New("no names requested")
+	}
+	for _, name := range names {
+		if !identity.Policy.allowsName(name) {
+			return fmt.Errorf("name '%s' is not allowed", name)
+		}
+	}
+	return nil
+}
+
+// certificateNames returns the common name and the subject alternative
+// names of cert.
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, ipStrings(cert.IPAddresses)...)
+	names = append(names, cert.EmailAddresses...)
+	names = append(names, uriStrings(cert.URIs)...)
+	return names
+}
+
+func (s *CAServer) chainPEM() []byte {
+	chain := Encode(s.CACert.Raw)
+	for _, cert := range s.Chain {
+		chain = append(chain, Encode(cert.Raw)...)
+	}
+	return chain
+}
+
+func (s *CAServer) writeCertificate(w http.ResponseWriter, identity *CAIdentity, cert *x509.Certificate, keyPEM []byte) {
+	s.mu.Lock()
+	s.certs = append(s.certs, &caServerCert{cert: cert, owner: identity.Name})
+	s.mu.Unlock()
+	writeCAServerJSON(w, http.StatusOK, &CACertificateResponse{
+		SerialNumber: hex.EncodeToString(cert.SerialNumber.Bytes()),
+		Certificate:  string(Encode(cert.Raw)),
+		Chain:        string(s.chainPEM()),
+		Key:          string(keyPEM),
+	})
+}
+
+func (c *caServerCert) info() CACertificateInfo {
+	info := CACertificateInfo{
+		SerialNumber: hex.EncodeToString(c.cert.SerialNumber.Bytes()),
+		Subject:      c.cert.Subject.String(),
+		Names:        certificateNames(c.cert),
+		NotBefore:    c.cert.NotBefore,
+		NotAfter:     c.cert.NotAfter,
+		Owner:        c.owner,
+		Revoked:      !c.revokedAt.IsZero(),
+		Reason:       c.reason,
+	}
+	if info.Revoked {
+		revokedAt := c.revokedAt
+		info.RevokedAt = &revokedAt
+	}
+	return info
+}
+
+func writeCAServerJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeCAServerError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeCAServerJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,262 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCAServer(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCACertificate("API CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCADER, clientCAKey, err := CreateCertificate(NewCACertificate("Client CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCACert, err := x509.ParseCertificate(clientCADER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(clientCACert)
+
+	ci := &CAIdentity{Name: "ci", Policy: CAPolicy{
+		AllowedNames: []string{"*.ci.example.com"},
+		Profiles:     []string{"server"},
+		MaxValidity:  30 * 24 * time.Hour,
+	}}
+	admin := &CAIdentity{Name: "admin", Policy: CAPolicy{AllowedNames: []string{"*"}, Admin: true}}
+	hmacSecret := []byte("hmac secret")
+	server := &CAServer{
+		CACert: caCert,
+		CAKey:  caKey,
+		Authenticators: []CAAuthenticator{
+			&BearerTokenAuthenticator{Tokens: map[string]*CAIdentity{"ci-token": ci, "admin-token": admin}},
+			&HMACAuthenticator{Keys: map[string]HMACKey{"deploy": {Secret: hmacSecret, Identity: &CAIdentity{
+				Name:   "deploy",
+				Policy: CAPolicy{AllowedNames: []string{"deploy-*"}},
+			}}}},
+			&ClientCertificateAuthenticator{
+				Roots:         roots,
+				DefaultPolicy: &CAPolicy{AllowedNames: []string{"*.mtls.example.com"}},
+			},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/pki/", http.StripPrefix("/pki", server))
+	ts := httptest.NewUnstartedServer(mux)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	do := func(t *testing.T, client *http.Client, method, path string, body any, auth func(*http.Request)) (int, []byte) {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, ts.URL+"/pki"+path, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf := &bytes.Buffer{}
+		_, _ = buf.ReadFrom(resp.Body)
+		return resp.StatusCode, buf.Bytes()
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	client := ts.Client()
+
+	status, body := do(t, client, "GET", "/ca", nil, nil)
+	chain, err := ParseAll(body)
+	if status != http.StatusOK || err != nil || len(chain) != 1 || !chain[0].Equal(caCert) {
+		t.Fatalf("unexpected CA response: %d %s", status, body)
+	}
+
+	// sign a CSR with a bearer token
+	csrDER, _, err := CreateRequestWithKeyOptions(&x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "a.ci.example.com"},
+		DNSNames: []string{"b.ci.example.com"},
+	}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signReq := &CASignRequest{CSR: string(EncodeCSR(csrDER)), Profile: "server"}
+	status, body = do(t, client, "POST", "/sign", signReq, bearer("ci-token"))
+	if status != http.StatusOK {
+		t.Fatalf("sign failed: %d %s", status, body)
+	}
+	signResp := &CACertificateResponse{}
+	if err := json.Unmarshal(body, signResp); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := Parse([]byte(signResp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Error(err)
+	}
+	if len(cert.DNSNames) != 2 || cert.NotAfter.Sub(cert.NotBefore) != 30*24*time.Hour {
+		t.Errorf("unexpected certificate: %v %s", cert.DNSNames, cert.NotAfter.Sub(cert.NotBefore))
+	}
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		req    any
+		auth   func(*http.Request)
+		status int
+		err    string
+	}{
+		{"no authentication", "/sign", signReq, nil, http.StatusUnauthorized, "authentication required"},
+		{"invalid token", "/sign", signReq, bearer("invalid"), http.StatusUnauthorized, "invalid token"},
+		{"name not allowed", "/issue", &CAIssueRequest{CommonName: "www.example.com", Profile: "server"}, bearer("ci-token"), http.StatusForbidden, "not allowed"},
+		{"profile not allowed", "/sign", &CASignRequest{CSR: signReq.CSR, Profile: "client"}, bearer("ci-token"), http.StatusForbidden, "profile 'client' is not allowed"},
+		{"invalid profile", "/sign", &CASignRequest{CSR: signReq.CSR, Profile: "ca"}, bearer("ci-token"), http.StatusBadRequest, "invalid profile"},
+		{"validity exceeded", "/sign", &CASignRequest{CSR: signReq.CSR, Profile: "server", Validity: "1000h"}, bearer("ci-token"), http.StatusForbidden, "exceeds the maximum"},
+		{"tampered HMAC", "/issue", &CAIssueRequest{CommonName: "deploy-1", Profile: "client"}, func(r *http.Request) {
+			_ = SignHMACRequest(r, "deploy", []byte("wrong"))
+		}, http.StatusUnauthorized, "invalid signature"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := do(t, client, "POST", test.path, test.req, test.auth)
+			if status != test.status || !strings.Contains(string(body), test.err) {
+				t.Errorf("expected %d %s, got %d %s", test.status, test.err, status, body)
+			}
+		})
+	}
+
+	// issue a key and certificate with an HMAC signed request
+	issueReq := &CAIssueRequest{CommonName: "deploy-1", Profile: "client", KeyAlgorithm: "ed25519", Validity: "1h"}
+	status, body = do(t, client, "POST", "/issue", issueReq, func(r *http.Request) {
+		if err := SignHMACRequest(r, "deploy", hmacSecret); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if status != http.StatusOK {
+		t.Fatalf("issue failed: %d %s", status, body)
+	}
+	issueResp := &CACertificateResponse{}
+	if err := json.Unmarshal(body, issueResp); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey([]byte(issueResp.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := Parse([]byte(issueResp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatchesCertificate(key, issued) || issued.PublicKeyAlgorithm != x509.Ed25519 {
+		t.Error("key does not match certificate")
+	}
+
+	// the certificate of ci is only visible for ci and the admin
+	list := func(token string) []CACertificateInfo {
+		t.Helper()
+		status, body := do(t, client, "GET", "/certificates", nil, bearer(token))
+		if status != http.StatusOK {
+			t.Fatalf("list failed: %d %s", status, body)
+		}
+		infos := []CACertificateInfo{}
+		if err := json.Unmarshal(body, &infos); err != nil {
+			t.Fatal(err)
+		}
+		return infos
+	}
+	if infos := list("ci-token"); len(infos) != 1 || infos[0].SerialNumber != signResp.SerialNumber || infos[0].Owner != "ci" {
+		t.Errorf("unexpected certificates of ci: %+v", infos)
+	}
+	if infos := list("admin-token"); len(infos) != 2 {
+		t.Errorf("unexpected certificates of admin: %d", len(infos))
+	}
+
+	// ci can not revoke the certificate of deploy
+	status, _ = do(t, client, "POST", "/revoke", &CARevokeRequest{SerialNumber: issueResp.SerialNumber}, bearer("ci-token"))
+	if status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", status)
+	}
+	status, body = do(t, client, "POST", "/revoke", &CARevokeRequest{SerialNumber: signResp.SerialNumber, Reason: 4}, bearer("ci-token"))
+	if status != http.StatusOK {
+		t.Fatalf("revoke failed: %d %s", status, body)
+	}
+	if !server.IsRevoked(cert) {
+		t.Error("certificate is not revoked")
+	}
+	if infos := list("ci-token"); !infos[0].Revoked || infos[0].Reason != 4 || infos[0].RevokedAt == nil {
+		t.Errorf("unexpected revocation info: %+v", infos[0])
+	}
+	status, _ = do(t, client, "POST", "/revoke", &CARevokeRequest{SerialNumber: signResp.SerialNumber}, bearer("ci-token"))
+	if status != http.StatusConflict {
+		t.Errorf("expected 409, got %d", status)
+	}
+
+	// authenticate with a client certificate of the client CA
+	mtlsClient := func(certDER []byte, key any) *http.Client {
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{certDER},
+			PrivateKey:  key,
+		}}
+		return &http.Client{Transport: transport}
+	}
+	mtlsDER, mtlsKey, err := CreateCertificate(NewClientCertificate("host.mtls.example.com"), clientCACert, clientCAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = do(t, mtlsClient(mtlsDER, mtlsKey), "POST", "/issue", &CAIssueRequest{DNSNames: []string{"host.mtls.example.com"}, Profile: "server"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("issue with client certificate failed: %d %s", status, body)
+	}
+
+	// a client certificate issued by the server itself must not
+	// authenticate, even if its common name matches a client
+	status, body = do(t, client, "POST", "/issue", &CAIssueRequest{CommonName: "host.mtls.example.com", Profile: "client"}, bearer("admin-token"))
+	if status != http.StatusOK {
+		t.Fatalf("issue failed: %d %s", status, body)
+	}
+	issueResp = &CACertificateResponse{}
+	if err := json.Unmarshal(body, issueResp); err != nil {
+		t.Fatal(err)
+	}
+	issued, err = Parse([]byte(issueResp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = ParseKey([]byte(issueResp.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Authenticators[2].(*ClientCertificateAuthenticator).Roots.AddCert(caCert)
+	status, body = do(t, mtlsClient(issued.Raw, key), "GET", "/certificates", nil, nil)
+	if status != http.StatusUnauthorized || !strings.Contains(string(body), "issued by this CA") {
+		t.Errorf("expected client certificate of the server CA to be rejected, got %d %s", status, body)
+	}
+}
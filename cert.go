@@ -5,11 +5,13 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"reflect"
+	"slices"
 	"time"
 )
 
@@ -84,6 +86,40 @@ func CreateCertificateWithCSR(csr *x509.CertificateRequest, cert, signCert *x509
 	return x509.CreateCertificate(rand.Reader, cert, signCert, csr.PublicKey, signKey)
 }
 
+// issueCertificateForCSR creates a certificate for csr with opts. The
+// subject and the subject alternative names are taken from the CSR if they
+// are not set in opts. Unlike applyCSR the names are applied before the
+// profiles, as the server profile adds the common name to the DNS names.
+func issueCertificateForCSR(csr *x509.CertificateRequest, opts CertificateOptions, signCert *x509.Certificate, signKey any) (*x509.Certificate, error) {
+	opts.SerialNumber = nil
+	opts.ExtKeyUsage = slices.Clone(opts.ExtKeyUsage)
+	opts.DNSNames = slices.Clone(opts.DNSNames)
+	if reflect.DeepEqual(opts.Subject, pkix.Name{}) {
+		opts.Subject = csr.Subject
+	}
+	if opts.DNSNames == nil {
+		opts.DNSNames = slices.Clone(csr.DNSNames)
+	}
+	if opts.IPAddresses == nil {
+		opts.IPAddresses = csr.IPAddresses
+	}
+	if opts.EmailAddresses == nil {
+		opts.EmailAddresses = csr.EmailAddresses
+	}
+	if opts.URIs == nil {
+		opts.URIs = csr.URIs
+	}
+	// the signature algorithm is chosen based on the CA key and not the one
+	// of the CSR
+	csrCopy := *csr
+	csrCopy.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+	der, err := CreateCertificateWithCSR(&csrCopy, NewCertificate(&opts), signCert, signKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
 // apply values of CSR to certificate
 func applyCSR(csr *x509.CertificateRequest, cert *x509.Certificate) {
 	cert.Signature = csr.Signature
@@ -143,6 +179,8 @@ func generateSerial() (*big.Int, error) {
 // CertificateOptions. Further it sets certain defaults if they were not set explicitly:
 // - Expiration one year from now
 // - Random serial number
+// - A critical extended key usage extension if TimeStamping is the only
+// extended key usage (RFC 3161 section 2.3)
 func NewCertificate(opts *CertificateOptions) *x509.Certificate {
 	if opts == nil {
 		opts = &CertificateOptions{}
@@ -168,6 +206,11 @@ func NewCertificate(opts *CertificateOptions) *x509.Certificate {
 	if opts.ProfileClient {
 		SetClientProfile(&opts.Certificate)
 	}
+	if opts.ProfileSPIFFE {
+		SetSPIFFEProfile(&opts.Certificate)
+	}
+
+	setTimeStampingCritical(&opts.Certificate)
 
 	if opts.SerialNumber == nil {
 		var err error
@@ -180,6 +223,25 @@ func NewCertificate(opts *CertificateOptions) *x509.Certificate {
 	return &opts.Certificate
 }
 
+// setTimeStampingCritical marks the extended key usage extension as critical
+// if TimeStamping is the only extended key usage, as RFC 3161 requires it for
+// TSA certificates. The x509 package always creates a non-critical
+// extension.
+func setTimeStampingCritical(cert *x509.Certificate) {
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping || len(cert.UnknownExtKeyUsage) != 0 {
+		return
+	}
+	oid := Extensions["ExtendedKeyUsage"]
+	if slices.ContainsFunc(cert.ExtraExtensions, func(ext pkix.Extension) bool { return ext.Id.Equal(oid) }) {
+		return
+	}
+	value, err := asn1.Marshal([]asn1.ObjectIdentifier{extKeyUsageOIDs["TimeStamping"]})
+	if err != nil {
+		panic(err.Error())
+	}
+	cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{Id: oid, Critical: true, Value: value})
+}
+
 // CertificateOptions represents all options which can be set using
 // CreateCertificate (see Go docs of it). Further it offers Expiry to set a
 // validity duration instead of absolute times.
@@ -188,6 +250,9 @@ type CertificateOptions struct {
 	ProfileServer bool
 	ProfileClient bool
 	ProfileCA     bool
+	// ProfileSPIFFE sets the characteristics of an X509-SVID based on the
+	// SPIFFE ID in URIs (see SetSPIFFEProfile).
+	ProfileSPIFFE bool
 
 	x509.Certificate
 }
@@ -0,0 +1,543 @@
+package pcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CertificateJSONVersion is the version of the JSON schema of
+// CertificateJSON. It is increased on every incompatible change.
+const CertificateJSONVersion = 1
+
+// CertificateJSON is the JSON representation of a certificate. Identifiers
+// and fingerprints are hex encoded, signatures and extension values are
+// base64 encoded and times are in RFC 3339 format. Lists are always present
+// (empty if not set), optional objects are omitted if the certificate does
+// not contain the corresponding extension.
+type CertificateJSON struct {
+	SchemaVersion int `json:"schema_version"`
+
+	Version            int           `json:"version"`
+	SerialNumber       string        `json:"serial_number"`
+	Subject            NameJSON      `json:"subject"`
+	Issuer             NameJSON      `json:"issuer"`
+	NotBefore          time.Time     `json:"not_before"`
+	NotAfter           time.Time     `json:"not_after"`
+	PublicKey          PublicKeyJSON `json:"public_key"`
+	SignatureAlgorithm string        `json:"signature_algorithm"`
+	Signature          string        `json:"signature"`
+
+	KeyUsage              []string                `json:"key_usage"`
+	ExtendedKeyUsage      []string                `json:"extended_key_usage"`
+	BasicConstraints      *BasicConstraintsJSON   `json:"basic_constraints,omitempty"`
+	SubjectKeyID          string                  `json:"subject_key_id"`
+	AuthorityKeyID        string                  `json:"authority_key_id"`
+	SubjectAltNames       SubjectAltNamesJSON     `json:"san"`
+	NameConstraints       *NameConstraintsJSON    `json:"name_constraints,omitempty"`
+	Policies              []string                `json:"policies"`
+	AuthorityInfoAccess   AuthorityInfoAccessJSON `json:"authority_info_access"`
+	CRLDistributionPoints []string                `json:"crl_distribution_points"`
+	Extensions            []ExtensionJSON         `json:"extensions"`
+	Fingerprints          CertificateFingerprints `json:"fingerprints"`
+}
+
+// NameJSON is the JSON representation of a distinguished name. String is the
+// RFC 2253 representation of the whole name and is ignored by
+// CertificateFromJSON. Extra contains the attributes without a dedicated
+// field (e.g. emailAddress or domainComponent).
+type NameJSON struct {
+	String             string   `json:"string"`
+	CommonName         string   `json:"common_name,omitempty"`
+	SerialNumber       string   `json:"serial_number,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	StreetAddress      []string `json:"street_address,omitempty"`
+	PostalCode         []string `json:"postal_code,omitempty"`
+
+	Extra []AttributeJSON `json:"extra,omitempty"`
+}
+
+// AttributeJSON is an attribute of a distinguished name. Name is empty for
+// unknown attribute types and is ignored by CertificateFromJSON. Only
+// attributes with a string value are represented.
+type AttributeJSON struct {
+	OID   string `json:"oid"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+// PublicKeyJSON describes the public key of a certificate. Size is the size
+// in bits for RSA and ECDSA keys.
+type PublicKeyJSON struct {
+	Algorithm string `json:"algorithm"`
+	Size      int    `json:"size,omitempty"`
+	Curve     string `json:"curve,omitempty"`
+}
+
+// BasicConstraintsJSON is the JSON representation of the basic constraints
+// extension. MaxPathLen is omitted if there is no path length constraint.
+type BasicConstraintsJSON struct {
+	IsCA       bool `json:"is_ca"`
+	MaxPathLen *int `json:"max_path_len,omitempty"`
+}
+
+// SubjectAltNamesJSON contains the subject alternative names.
+type SubjectAltNamesJSON struct {
+	DNS   []string `json:"dns"`
+	IP    []string `json:"ip"`
+	Email []string `json:"email"`
+	URI   []string `json:"uri"`
+}
+
+// NewSubjectAltNamesJSON returns the JSON representation of the subject
+// alternative names of a certificate or a certificate request.
+func NewSubjectAltNamesJSON(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) SubjectAltNamesJSON {
+	return SubjectAltNamesJSON{
+		DNS:   nonNil(dnsNames),
+		IP:    ipStrings(ips),
+		Email: nonNil(emails),
+		URI:   uriStrings(uris),
+	}
+}
+
+// NameConstraintsJSON is the JSON representation of the name constraints
+// extension. IP ranges are in CIDR notation.
+type NameConstraintsJSON struct {
+	Critical       bool     `json:"critical"`
+	PermittedDNS   []string `json:"permitted_dns"`
+	ExcludedDNS    []string `json:"excluded_dns"`
+	PermittedIP    []string `json:"permitted_ip"`
+	ExcludedIP     []string `json:"excluded_ip"`
+	PermittedEmail []string `json:"permitted_email"`
+	ExcludedEmail  []string `json:"excluded_email"`
+	PermittedURI   []string `json:"permitted_uri"`
+	ExcludedURI    []string `json:"excluded_uri"`
+}
+
+// AuthorityInfoAccessJSON contains the URLs of the authority information
+// access extension.
+type AuthorityInfoAccessJSON struct {
+	OCSP               []string `json:"ocsp"`
+	IssuingCertificate []string `json:"issuing_certificate"`
+}
+
+// ExtensionJSON is a raw extension. Name is empty for unknown extensions.
+type ExtensionJSON struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	Value    string `json:"value"`
+}
+
+// CertificateFingerprints contains the hex encoded fingerprints of the DER
+// encoded certificate, the base64 encoded SHA-256 hash of the public key
+// (pin-sha256) and the OpenSSL hash of the subject.
+type CertificateFingerprints struct {
+	SHA1        string `json:"sha1"`
+	SHA256      string `json:"sha256"`
+	PinSHA256   string `json:"pin_sha256"`
+	SubjectHash string `json:"subject_hash"`
+}
+
+// NewCertificateFingerprints returns the fingerprints of cert.
+func NewCertificateFingerprints(cert *x509.Certificate) CertificateFingerprints {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	// the subject has been parsed already so computing the hash cannot fail
+	subjectHash, _ := SubjectHash(cert)
+	return CertificateFingerprints{
+		SHA1:        hex.EncodeToString(sha1Sum[:]),
+		SHA256:      hex.EncodeToString(sha256Sum[:]),
+		PinSHA256:   spkiPin(cert.RawSubjectPublicKeyInfo),
+		SubjectHash: subjectHash,
+	}
+}
+
+// NewCertificateJSON returns the JSON representation of cert.
+func NewCertificateJSON(cert *x509.Certificate) *CertificateJSON {
+	c := &CertificateJSON{
+		SchemaVersion:      CertificateJSONVersion,
+		Version:            cert.Version,
+		SerialNumber:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		Subject:            newNameJSON(cert.Subject),
+		Issuer:             newNameJSON(cert.Issuer),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		PublicKey:          newPublicKeyJSON(cert),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		Signature:          base64.StdEncoding.EncodeToString(cert.Signature),
+		KeyUsage:           KeyUsageToStringSlice(cert.KeyUsage),
+		ExtendedKeyUsage:   ExtKeyUsageToStringSlice(cert.ExtKeyUsage),
+		SubjectKeyID:       hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyID:     hex.EncodeToString(cert.AuthorityKeyId),
+		SubjectAltNames:    NewSubjectAltNamesJSON(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs),
+		Policies:           oidStrings(cert.PolicyIdentifiers),
+		AuthorityInfoAccess: AuthorityInfoAccessJSON{
+			OCSP:               nonNil(cert.OCSPServer),
+			IssuingCertificate: nonNil(cert.IssuingCertificateURL),
+		},
+		CRLDistributionPoints: nonNil(cert.CRLDistributionPoints),
+		Extensions:            []ExtensionJSON{},
+	}
+
+	for _, oid := range cert.UnknownExtKeyUsage {
+		c.ExtendedKeyUsage = append(c.ExtendedKeyUsage, oid.String())
+	}
+
+	if cert.BasicConstraintsValid {
+		c.BasicConstraints = &BasicConstraintsJSON{
+			IsCA: cert.IsCA,
+		}
+		if cert.MaxPathLen > 0 || (cert.MaxPathLen == 0 && cert.MaxPathLenZero) {
+			maxPathLen := cert.MaxPathLen
+			c.BasicConstraints.MaxPathLen = &maxPathLen
+		}
+	}
+
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(Extensions["NameConstraints"]) {
+			c.NameConstraints = &NameConstraintsJSON{
+				Critical:       cert.PermittedDNSDomainsCritical,
+				PermittedDNS:   nonNil(cert.PermittedDNSDomains),
+				ExcludedDNS:    nonNil(cert.ExcludedDNSDomains),
+				PermittedIP:    ipNetStrings(cert.PermittedIPRanges),
+				ExcludedIP:     ipNetStrings(cert.ExcludedIPRanges),
+				PermittedEmail: nonNil(cert.PermittedEmailAddresses),
+				ExcludedEmail:  nonNil(cert.ExcludedEmailAddresses),
+				PermittedURI:   nonNil(cert.PermittedURIDomains),
+				ExcludedURI:    nonNil(cert.ExcludedURIDomains),
+			}
+		}
+		c.Extensions = append(c.Extensions, ExtensionJSON{
+			OID:      ext.Id.String(),
+			Name:     ExtensionName(ext.Id),
+			Critical: ext.Critical,
+			Value:    base64.StdEncoding.EncodeToString(ext.Value),
+		})
+	}
+
+	c.Fingerprints = NewCertificateFingerprints(cert)
+	return c
+}
+
+func newNameJSON(name pkix.Name) NameJSON {
+	return NameJSON{
+		String:             name.String(),
+		CommonName:         name.CommonName,
+		SerialNumber:       name.SerialNumber,
+		Country:            name.Country,
+		Organization:       name.Organization,
+		OrganizationalUnit: name.OrganizationalUnit,
+		Locality:           name.Locality,
+		Province:           name.Province,
+		StreetAddress:      name.StreetAddress,
+		PostalCode:         name.PostalCode,
+		Extra:              extraAttributes(name),
+	}
+}
+
+// nameAttributes are the attribute types of the fields of pkix.Name.
+var nameAttributes = map[string]bool{
+	"2.5.4.3":  true,
+	"2.5.4.5":  true,
+	"2.5.4.6":  true,
+	"2.5.4.7":  true,
+	"2.5.4.8":  true,
+	"2.5.4.9":  true,
+	"2.5.4.10": true,
+	"2.5.4.11": true,
+	"2.5.4.17": true,
+}
+
+// extraAttributes returns the attributes of name which have no field in
+// pkix.Name.
+func extraAttributes(name pkix.Name) []AttributeJSON {
+	var extra []AttributeJSON
+	for _, attr := range name.Names {
+		value, ok := attr.Value.(string)
+		if !ok || nameAttributes[attr.Type.String()] {
+			continue
+		}
+		extra = append(extra, AttributeJSON{
+			OID:   attr.Type.String(),
+			Name:  OIDName(attr.Type),
+			Value: value,
+		})
+	}
+	return extra
+}
+
+func newPublicKeyJSON(cert *x509.Certificate) PublicKeyJSON {
+	pk := PublicKeyJSON{
+		Algorithm: cert.PublicKeyAlgorithm.String(),
+	}
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		pk.Size = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		pk.Size = key.Params().BitSize
+		pk.Curve = key.Params().Name
+	case ed25519.PublicKey:
+		pk.Curve = "Ed25519"
+	}
+	return pk
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := []string{}
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+func ipNetStrings(ipNets []*net.IPNet) []string {
+	out := []string{}
+	for _, ipNet := range ipNets {
+		out = append(out, ipNet.String())
+	}
+	return out
+}
+
+func uriStrings(uris []*url.URL) []string {
+	out := []string{}
+	for _, uri := range uris {
+		out = append(out, uri.String())
+	}
+	return out
+}
+
+func oidStrings(oids []asn1.ObjectIdentifier) []string {
+	out := []string{}
+	for _, oid := range oids {
+		out = append(out, oid.String())
+	}
+	return out
+}
+
+// templateExtensions are the extensions which are set by x509.CreateCertificate
+// based on the fields of the template or which only make sense in the
+// original certificate. They are not copied to ExtraExtensions by
+// CertificateFromJSON.
+var templateExtensions = []string{
+	"SubjectKeyId",
+	"KeyUsage",
+	"SubjectAltName",
+	"BasicConstraints",
+	"NameConstraints",
+	"CRLDistributionPoints",
+	"CertificatePolicies",
+	"AuthorityKeyId",
+	"ExtendedKeyUsage",
+	"AuthorityInfoAccess",
+	"SCTList",
+	"CTPoison",
+}
+
+// CertificateFromJSON parses a JSON encoded certificate (see CertificateJSON)
+// and returns a certificate which can be used as template for
+// x509.CreateCertificate. All fields are optional. The version, issuer,
+// public key, signature and fingerprints are ignored since they are set when
+// the certificate is signed. Extensions which are not represented by a
+// dedicated field are copied to ExtraExtensions.
+func CertificateFromJSON(data []byte) (*x509.Certificate, error) {
+	c := &CertificateJSON{}
+	err := json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate JSON: %w", err)
+	}
+	return c.Template()
+}
+
+// Template returns a certificate template based on c. See
+// CertificateFromJSON.
+func (c *CertificateJSON) Template() (*x509.Certificate, error) {
+	if c.SchemaVersion > CertificateJSONVersion {
+		return nil, fmt.Errorf("unsupported schema version %d", c.SchemaVersion)
+	}
+
+	subject, err := c.Subject.name()
+	if err != nil {
+		return nil, err
+	}
+	cert := &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             c.NotBefore,
+		NotAfter:              c.NotAfter,
+		DNSNames:              c.SubjectAltNames.DNS,
+		EmailAddresses:        c.SubjectAltNames.Email,
+		OCSPServer:            c.AuthorityInfoAccess.OCSP,
+		IssuingCertificateURL: c.AuthorityInfoAccess.IssuingCertificate,
+		CRLDistributionPoints: c.CRLDistributionPoints,
+	}
+
+	if c.SerialNumber != "" {
+		serial, ok := new(big.Int).SetString(strings.ReplaceAll(c.SerialNumber, ":", ""), 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number '%s'", c.SerialNumber)
+		}
+		cert.SerialNumber = serial
+	}
+
+	for _, name := range c.KeyUsage {
+		usage, ok := KeyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown key usage '%s'", name)
+		}
+		cert.KeyUsage |= usage
+	}
+
+	for _, name := range c.ExtendedKeyUsage {
+		if usage, ok := ExtKeyUsages[name]; ok {
+			cert.ExtKeyUsage = append(cert.ExtKeyUsage, usage)
+			continue
+		}
+		oid, err := ParseOID(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown extended key usage '%s'", name)
+		}
+		cert.UnknownExtKeyUsage = append(cert.UnknownExtKeyUsage, oid)
+	}
+
+	if c.BasicConstraints != nil {
+		cert.BasicConstraintsValid = true
+		cert.IsCA = c.BasicConstraints.IsCA
+		cert.MaxPathLen = -1
+		if c.BasicConstraints.MaxPathLen != nil {
+			cert.MaxPathLen = *c.BasicConstraints.MaxPathLen
+			cert.MaxPathLenZero = cert.MaxPathLen == 0
+		}
+	}
+
+	if c.SubjectKeyID != "" {
+		cert.SubjectKeyId, err = hex.DecodeString(c.SubjectKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject key id: %w", err)
+		}
+	}
+
+	for _, ipStr := range c.SubjectAltNames.IP {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", ipStr)
+		}
+		cert.IPAddresses = append(cert.IPAddresses, ip)
+	}
+
+	for _, uriStr := range c.SubjectAltNames.URI {
+		uri, err := url.Parse(uriStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URI '%s': %w", uriStr, err)
+		}
+		cert.URIs = append(cert.URIs, uri)
+	}
+
+	if nc := c.NameConstraints; nc != nil {
+		cert.PermittedDNSDomainsCritical = nc.Critical
+		cert.PermittedDNSDomains = nc.PermittedDNS
+		cert.ExcludedDNSDomains = nc.ExcludedDNS
+		cert.PermittedEmailAddresses = nc.PermittedEmail
+		cert.ExcludedEmailAddresses = nc.ExcludedEmail
+		cert.PermittedURIDomains = nc.PermittedURI
+		cert.ExcludedURIDomains = nc.ExcludedURI
+		cert.PermittedIPRanges, err = parseCIDRs(nc.PermittedIP)
+		if err != nil {
+			return nil, err
+		}
+		cert.ExcludedIPRanges, err = parseCIDRs(nc.ExcludedIP)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, policy := range c.Policies {
+		oid, err := ParseOID(policy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy '%s': %w", policy, err)
+		}
+		cert.PolicyIdentifiers = append(cert.PolicyIdentifiers, oid)
+	}
+
+extensions:
+	for _, ext := range c.Extensions {
+		oid, err := ParseOID(ext.OID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension: %w", err)
+		}
+		for _, name := range templateExtensions {
+			if Extensions[name].Equal(oid) {
+				continue extensions
+			}
+		}
+		value, err := base64.StdEncoding.DecodeString(ext.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of extension %s: %w", ext.OID, err)
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: ext.Critical,
+			Value:    value,
+		})
+	}
+
+	return cert, nil
+}
+
+func (n NameJSON) name() (pkix.Name, error) {
+	name := pkix.Name{
+		CommonName:         n.CommonName,
+		SerialNumber:       n.SerialNumber,
+		Country:            n.Country,
+		Organization:       n.Organization,
+		OrganizationalUnit: n.OrganizationalUnit,
+		Locality:           n.Locality,
+		Province:           n.Province,
+		StreetAddress:      n.StreetAddress,
+		PostalCode:         n.PostalCode,
+	}
+	for _, attr := range n.Extra {
+		oid, err := ParseOID(attr.OID)
+		if err != nil {
+			return name, fmt.Errorf("invalid name attribute: %w", err)
+		}
+		name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oid, Value: attr.Value})
+	}
+	return name, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
@@ -0,0 +1,224 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJSONCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	uri, _ := url.Parse("spiffe://example.com/server")
+	_, permittedIP, _ := net.ParseCIDR("10.0.0.0/8")
+	template := NewCertificate(&CertificateOptions{
+		Certificate: x509.Certificate{
+			SerialNumber: big.NewInt(0xabcdef),
+			Subject: pkix.Name{
+				CommonName:   "My CA",
+				Organization: []string{"Example"},
+			},
+			NotBefore:                   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:                    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:                 []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			UnknownExtKeyUsage:          []asn1.ObjectIdentifier{{1, 2, 3, 4}},
+			BasicConstraintsValid:       true,
+			IsCA:                        true,
+			MaxPathLen:                  0,
+			MaxPathLenZero:              true,
+			DNSNames:                    []string{"ca.example.com"},
+			IPAddresses:                 []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::1")},
+			EmailAddresses:              []string{"ca@example.com"},
+			URIs:                        []*url.URL{uri},
+			PermittedDNSDomainsCritical: true,
+			PermittedDNSDomains:         []string{"example.com"},
+			PermittedIPRanges:           []*net.IPNet{permittedIP},
+			PolicyIdentifiers:           []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 1}},
+			OCSPServer:                  []string{"http://ocsp.example.com"},
+			IssuingCertificateURL:       []string{"http://ca.example.com/ca.crt"},
+			CRLDistributionPoints:       []string{"http://ca.example.com/ca.crl"},
+			ExtraExtensions: []pkix.Extension{
+				{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5}, Value: []byte{0x05, 0x00}},
+			},
+		},
+	})
+	certDER, _, err := CreateCertificate(template, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNewCertificateJSON(t *testing.T) {
+	cert := newTestJSONCertificate(t)
+	c := NewCertificateJSON(cert)
+
+	if c.SchemaVersion != CertificateJSONVersion {
+		t.Errorf("schema version: got=%d want=%d", c.SchemaVersion, CertificateJSONVersion)
+	}
+	if c.SerialNumber != "abcdef" {
+		t.Errorf("serial: got=%s want=abcdef", c.SerialNumber)
+	}
+	if c.Subject.String != "CN=My CA,O=Example" || c.Subject.CommonName != "My CA" {
+		t.Errorf("unexpected subject %#v", c.Subject)
+	}
+	if want := []string{"ClientAuth", "ServerAuth", "1.2.3.4"}; !reflect.DeepEqual(c.ExtendedKeyUsage, want) {
+		t.Errorf("extended key usage: got=%v want=%v", c.ExtendedKeyUsage, want)
+	}
+	if c.BasicConstraints == nil || !c.BasicConstraints.IsCA || c.BasicConstraints.MaxPathLen == nil || *c.BasicConstraints.MaxPathLen != 0 {
+		t.Errorf("unexpected basic constraints %#v", c.BasicConstraints)
+	}
+	if want := []string{"10.0.0.1", "::1"}; !reflect.DeepEqual(c.SubjectAltNames.IP, want) {
+		t.Errorf("ip: got=%v want=%v", c.SubjectAltNames.IP, want)
+	}
+	if want := []string{"spiffe://example.com/server"}; !reflect.DeepEqual(c.SubjectAltNames.URI, want) {
+		t.Errorf("uri: got=%v want=%v", c.SubjectAltNames.URI, want)
+	}
+	if c.NameConstraints == nil || !reflect.DeepEqual(c.NameConstraints.PermittedIP, []string{"10.0.0.0/8"}) {
+		t.Errorf("unexpected name constraints %#v", c.NameConstraints)
+	}
+	if want := []string{"2.23.140.1.2.1"}; !reflect.DeepEqual(c.Policies, want) {
+		t.Errorf("policies: got=%v want=%v", c.Policies, want)
+	}
+	if len(c.Fingerprints.SHA1) != 40 || len(c.Fingerprints.SHA256) != 64 || len(c.Fingerprints.PinSHA256) != 44 || len(c.Fingerprints.SubjectHash) != 8 {
+		t.Errorf("unexpected fingerprints %#v", c.Fingerprints)
+	}
+
+	names := map[string]string{}
+	for _, ext := range c.Extensions {
+		names[ext.OID] = ext.Name
+	}
+	if names["2.5.29.15"] != "KeyUsage" || names["2.5.29.14"] != "SubjectKeyId" {
+		t.Errorf("unexpected extension names %v", names)
+	}
+	if name, ok := names["1.2.3.4.5"]; !ok || name != "" {
+		t.Errorf("unknown extension missing or named: %v", names)
+	}
+
+	out, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "null") {
+		t.Errorf("JSON contains null values: %s", out)
+	}
+}
+
+func TestCertificateFromJSON(t *testing.T) {
+	cert := newTestJSONCertificate(t)
+	data, err := json.Marshal(NewCertificateJSON(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := CertificateFromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, _, err := CreateCertificate(template, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything besides the key dependent fields has to match
+	got := NewCertificateJSON(newCert)
+	want := NewCertificateJSON(cert)
+	got.Signature, want.Signature = "", ""
+	got.Fingerprints, want.Fingerprints = CertificateFingerprints{}, CertificateFingerprints{}
+	got.SubjectKeyID, want.SubjectKeyID = "", ""
+	for _, c := range []*CertificateJSON{got, want} {
+		extensions := []ExtensionJSON{}
+		for _, ext := range c.Extensions {
+			if ext.Name != "SubjectKeyId" {
+				ext.Value = ""
+				extensions = append(extensions, ext)
+			}
+		}
+		c.Extensions = extensions
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("certificate does not match template\ngot:\n%s\nwant:\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestCertificateFromJSON_extraNames(t *testing.T) {
+	email := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	dc := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+	template := NewCertificate(&CertificateOptions{
+		Certificate: x509.Certificate{
+			Subject: pkix.Name{
+				CommonName: "My CA",
+				ExtraNames: []pkix.AttributeTypeAndValue{
+					{Type: email, Value: "ca@example.com"},
+					{Type: dc, Value: "example"},
+				},
+			},
+		},
+	})
+	certDER, _, err := CreateCertificate(template, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCertificateJSON(cert)
+	want := []AttributeJSON{
+		{OID: email.String(), Name: OIDName(email), Value: "ca@example.com"},
+		{OID: dc.String(), Name: OIDName(dc), Value: "example"},
+	}
+	if !reflect.DeepEqual(c.Subject.Extra, want) {
+		t.Errorf("extra: got=%v want=%v", c.Subject.Extra, want)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTemplate, err := CertificateFromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(newTemplate.Subject.ExtraNames, template.Subject.ExtraNames) {
+		t.Errorf("extra names: got=%v want=%v", newTemplate.Subject.ExtraNames, template.Subject.ExtraNames)
+	}
+}
+
+func TestCertificateFromJSON_invalid(t *testing.T) {
+	for _, input := range []string{
+		`{"schema_version": 2}`,
+		`{"serial_number": "xyz"}`,
+		`{"key_usage": ["Foo"]}`,
+		`{"extended_key_usage": ["Foo"]}`,
+		`{"san": {"ip": ["1.2.3"]}}`,
+		`{"policies": ["1"]}`,
+		`{"name_constraints": {"permitted_ip": ["10.0.0.1"]}}`,
+		`{"subject": {"extra": [{"oid": "email", "value": "ca@example.com"}]}}`,
+		`[]`,
+	} {
+		_, err := CertificateFromJSON([]byte(input))
+		if err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
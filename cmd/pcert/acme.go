@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newACMECmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "acme",
+		Short: "ACME (RFC 8555) server and client",
+	}
+	cmd.AddCommand(
+		newACMEServeCmd(),
+		newACMEIssueCmd(),
+	)
+	return cmd
+}
+
+type acmeServeOptions struct {
+	Listen    string
+	SignCert  string
+	SignKey   string
+	TLSCert   string
+	TLSKey    string
+	Hostnames []string
+	DNSServer string
+	Server    pcert.ACMEServer
+}
+
+func newACMEServeCmd() *cobra.Command {
+	opts := &acmeServeOptions{
+		Listen:    ":14000",
+		SignCert:  "ca.crt",
+		Hostnames: []string{"localhost"},
+		Server: pcert.ACMEServer{
+			Validity: pcert.DefaultACMEValidity,
+			HTTPPort: 80,
+			TLSPort:  443,
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run an ACME server which issues certificates with a local CA",
+		Long: `Run an ACME (RFC 8555) server which issues certificates signed by a CA
+created with 'pcert create --ca'. Clients like certbot, lego, Caddy or
+cert-manager can use it to obtain certificates in development and test
+environments.
+
+The challenge types http-01, dns-01 and tls-alpn-01 are supported. With
+--skip-validation all challenges are valid without validating them. All state
+is kept in memory and is lost when the server stops.
+
+The directory is served under /directory over HTTPS. If no --tls-cert is set
+a server certificate for --hostname is issued by the CA, so clients which
+trust the CA trust the server as well.`,
+		Example: `  # issue certificates with ca.crt and ca.key
+  pcert acme serve
+
+  # accept all challenges and use a different CA
+  pcert acme serve --skip-validation --sign-cert ./ca/intermediate.crt
+
+  # use with lego
+  lego --server https://localhost:14000/directory --email admin@example.com --domains test.local --http run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tlsCert, err := opts.load()
+			if err != nil {
+				return err
+			}
+
+			server := &http.Server{
+				Addr:    opts.Listen,
+				Handler: &opts.Server,
+				TLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{*tlsCert},
+				},
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = server.Shutdown(shutdownCtx)
+			}()
+
+			printServerURL(cmd.ErrOrStderr(), "ACME directory", "https", opts.Listen, opts.Hostnames, "/directory")
+			err = server.ListenAndServeTLS("", "")
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the server listens.")
+	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "CA certificate used to sign the certificates. Further certificates in the file are appended to the issued chains.")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key of the CA. If not set the key file relative to --sign-cert is used.")
+	cmd.Flags().StringVar(&opts.TLSCert, "tls-cert", opts.TLSCert, "Server certificate. If not set a certificate for --hostname is issued by the CA.")
+	cmd.Flags().StringVar(&opts.TLSKey, "tls-key", opts.TLSKey, "Key of the server certificate. If not set the key file relative to --tls-cert is used.")
+	cmd.Flags().StringSliceVar(&opts.Hostnames, "hostname", opts.Hostnames, "Names of the server certificate if --tls-cert is not set.")
+	cmd.Flags().BoolVar(&opts.Server.SkipValidation, "skip-validation", opts.Server.SkipValidation, "Mark all challenges as valid without validating them.")
+	cmd.Flags().IntVar(&opts.Server.HTTPPort, "http-port", opts.Server.HTTPPort, "Port on which http-01 challenges are validated.")
+	cmd.Flags().IntVar(&opts.Server.TLSPort, "tls-port", opts.Server.TLSPort, "Port on which tls-alpn-01 challenges are validated.")
+	cmd.Flags().StringVar(&opts.DNSServer, "dns-server", opts.DNSServer, "DNS server (host:port) used to validate dns-01 challenges. If not set the system resolver is used.")
+	cmd.Flags().Var(newDurationValue(&opts.Server.Validity), "validity", "Validity of the issued certificates (e.g. 90d).")
+	return cmd
+}
+
+// load reads the CA and returns the server certificate.
+func (o *acmeServeOptions) load() (*tls.Certificate, error) {
+	ca, err := loadSigningCA(o.SignCert, o.SignKey)
+	if err != nil {
+		return nil, err
+	}
+	o.Server.CACert = ca.Cert
+	o.Server.CAKey = ca.Key
+	o.Server.Chain = ca.Chain
+
+	if o.DNSServer != "" {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, o.DNSServer)
+			},
+		}
+		o.Server.LookupTXT = resolver.LookupTXT
+	}
+
+	return ca.serverCertificate(o.TLSCert, o.TLSKey, o.Hostnames)
+}
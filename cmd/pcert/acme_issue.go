@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+type acmeIssueOptions struct {
+	Cert       string
+	Key        string
+	Chain      string
+	Directory  string
+	AccountKey string
+	Contacts   []string
+	CAs        []string
+	HTTPListen string
+	Webroot    string
+	DNSHook    string
+	Timeout    time.Duration
+	OutFormat  string
+
+	KeyOptions         pcert.KeyOptions
+	CertificateRequest x509.CertificateRequest
+}
+
+func newACMEIssueCmd() *cobra.Command {
+	opts := &acmeIssueOptions{
+		HTTPListen: ":80",
+		Timeout:    5 * time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "issue [CERT-OUT [KEY-OUT]]",
+		Short: "Obtain a certificate from an ACME server",
+		Long: `Obtain a certificate from an ACME (RFC 8555) server like Let's Encrypt,
+Pebble or 'pcert acme serve'. A key and CSR for the names set with --dns and
+--ip are created like with 'pcert request' and the order is driven until the
+certificate is issued.
+
+The challenges are solved as follows:
+  - with --dns-hook the dns-01 challenge is used. The hook is called as
+    'HOOK present FQDN VALUE' before the challenge is validated and as
+    'HOOK cleanup FQDN VALUE' afterwards. It has to create the TXT record FQDN
+    with VALUE.
+  - with --webroot the http-01 challenge is used and the key authorizations
+    are written to WEBROOT/.well-known/acme-challenge/.
+  - otherwise the http-01 challenge is used and the key authorizations are
+    served by a built-in server on --http-listen.
+
+The account key is read from --account-key. If the file does not exist a new
+key is created and stored there. If CERT-OUT and KEY-OUT are specified the
+certificate and key are stored in the respective files. If only CERT-OUT is
+specified the key is stored in the same directory in a file ending with .key.
+The issuer certificates are appended to the certificate unless --chain is
+set.`,
+		Example: `  # obtain a certificate using the built-in http-01 server
+  pcert acme issue --directory https://acme-v02.api.letsencrypt.org/directory --dns www.example.com tls.crt
+
+  # use a local 'pcert acme serve' and a webroot of a running web server
+  pcert acme issue --directory https://localhost:14000/directory --ca ca.crt --webroot /var/www --dns test.local tls.crt
+
+  # obtain a wildcard certificate with a dns-01 hook
+  pcert acme issue --directory https://localhost:14000/directory --dns-hook ./dns-hook.sh --dns '*.example.com' tls.crt`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 && args[0] != "-" {
+				opts.Cert = args[0]
+				opts.Key = getKeyRelativeToFile(args[0])
+			}
+			if len(args) == 2 {
+				opts.Cert = args[0]
+				opts.Key = args[1]
+			}
+			if opts.Directory == "" {
+				return errors.New("--directory is required")
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), opts.Timeout)
+			defer cancel()
+			certs, privateKey, err := opts.issue(ctx, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+
+			chain := certs
+			if opts.Chain != "" {
+				chain = certs[:1]
+			}
+			certOut, err := encodeCertificates(opts.OutFormat, chain...)
+			if err != nil {
+				return err
+			}
+			keyPEM, err := pcert.EncodeKey(privateKey)
+			if err != nil {
+				return err
+			}
+			err = writeStdoutOrFile(opts.Cert, certOut, 0o644, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			err = writeStdoutOrFile(opts.Key, keyPEM, 0o600, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			if opts.Chain != "" {
+				chainOut, err := encodeCertificates(opts.OutFormat, certs[1:]...)
+				if err != nil {
+					return err
+				}
+				return writeStdoutOrFile(opts.Chain, chainOut, 0o644, cmd.OutOrStdout())
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.Directory, "directory", opts.Directory, "URL of the ACME directory.")
+	cmd.Flags().StringVar(&opts.AccountKey, "account-key", opts.AccountKey, "File of the account key. It is created if it does not exist. Defaults to a file per ACME server in the user configuration directory.")
+	cmd.Flags().StringSliceVar(&opts.Contacts, "contact", opts.Contacts, "Contact of the account (e.g. admin@example.com).")
+	cmd.Flags().StringSliceVar(&opts.CAs, "ca", opts.CAs, "Additional CA certificates to trust the ACME server.")
+	cmd.Flags().StringVar(&opts.HTTPListen, "http-listen", opts.HTTPListen, "Address of the built-in server which solves http-01 challenges.")
+	cmd.Flags().StringVar(&opts.Webroot, "webroot", opts.Webroot, "Solve http-01 challenges by writing files to the document root of a running web server.")
+	cmd.Flags().StringVar(&opts.DNSHook, "dns-hook", opts.DNSHook, "Program which creates and removes TXT records to solve dns-01 challenges.")
+	cmd.Flags().StringVar(&opts.Chain, "chain", opts.Chain, "Write the issuer certificates to this file instead of appending them to the certificate.")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", opts.Timeout, "Maximum duration to obtain the certificate.")
+	cmd.Flags().Var(newOutFormatValue(&opts.OutFormat), "out-format", "Format of the certificate output (pem, der or p7b). The key is always PEM encoded.")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
+	cmd.MarkFlagsMutuallyExclusive("webroot", "dns-hook")
+	registerRequestFlags(cmd, &opts.CertificateRequest)
+	registerKeyFlags(cmd, &opts.KeyOptions)
+	return cmd
+}
+
+// issue obtains the certificate chain and returns it together with the key
+// of the certificate.
+func (o *acmeIssueOptions) issue(ctx context.Context, stderr io.Writer) ([]*x509.Certificate, any, error) {
+	if len(o.CertificateRequest.DNSNames) == 0 && len(o.CertificateRequest.IPAddresses) == 0 {
+		return nil, nil, errors.New("at least one --dns or --ip is required")
+	}
+	accountKey, err := o.loadAccountKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	httpClient, err := o.httpClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := &pcert.ACMEClient{
+		DirectoryURL: o.Directory,
+		Key:          accountKey,
+		HTTPClient:   httpClient,
+	}
+
+	contacts := []string{}
+	for _, contact := range o.Contacts {
+		if !strings.Contains(contact, ":") {
+			contact = "mailto:" + contact
+		}
+		contacts = append(contacts, contact)
+	}
+	_, err = client.Register(ctx, contacts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to register account: %w", err)
+	}
+
+	csrDER, privateKey, err := pcert.CreateRequestWithKeyOptions(&o.CertificateRequest, o.KeyOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var solver pcert.ACMESolver
+	switch {
+	case o.DNSHook != "":
+		solver = &acmeDNSHookSolver{Command: o.DNSHook, Output: stderr}
+	case o.Webroot != "":
+		solver = &acmeHTTPSolver{Webroot: o.Webroot}
+	default:
+		solver = &acmeHTTPSolver{Listen: o.HTTPListen}
+	}
+	certs, err := client.Issue(ctx, csr, solver)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs, privateKey, nil
+}
+
+// loadAccountKey reads the account key or creates it if it does not exist.
+func (o *acmeIssueOptions) loadAccountKey() (crypto.Signer, error) {
+	if o.AccountKey == "" {
+		u, err := url.Parse(o.Directory)
+		if err != nil {
+			return nil, err
+		}
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine location of the account key. use --account-key: %w", err)
+		}
+		host := strings.ReplaceAll(u.Host, ":", "_")
+		o.AccountKey = filepath.Join(configDir, "pcert", "acme", host, "account.key")
+	}
+
+	data, err := os.ReadFile(o.AccountKey)
+	if errors.Is(err, os.ErrNotExist) {
+		key, _, err := pcert.GenerateKey(pcert.KeyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := pcert.EncodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(filepath.Dir(o.AccountKey), 0o700)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(o.AccountKey, keyPEM, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return key.(crypto.Signer), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := pcert.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account key '%s': %w", o.AccountKey, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported account key '%s'", o.AccountKey)
+	}
+	return signer, nil
+}
+
+func (o *acmeIssueOptions) httpClient() (*http.Client, error) {
+	if len(o.CAs) == 0 {
+		return http.DefaultClient, nil
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	for _, file := range o.CAs {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := pcert.ParseAll(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA '%s': %w", file, err)
+		}
+		for _, cert := range certs {
+			roots.AddCert(cert)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	return &http.Client{Transport: transport}, nil
+}
+
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// acmeHTTPSolver solves http-01 challenges. If Webroot is set the key
+// authorizations are written to files below it. Otherwise they are served
+// on Listen while challenges are pending.
+type acmeHTTPSolver struct {
+	Listen  string
+	Webroot string
+
+	mu       sync.Mutex
+	tokens   map[string]string
+	server   *http.Server
+	listener net.Listener
+}
+
+func (s *acmeHTTPSolver) Type() string {
+	return pcert.ACMEChallengeHTTP01
+}
+
+func (s *acmeHTTPSolver) Present(ctx context.Context, id pcert.ACMEIdentifier, token, keyAuth string) error {
+	if s.Webroot != "" {
+		dir := filepath.Join(s.Webroot, filepath.FromSlash(acmeChallengePath))
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, token), []byte(keyAuth), 0o644)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = map[string]string{}
+	}
+	s.tokens[token] = keyAuth
+	if s.server != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.Listen)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+	return nil
+}
+
+func (s *acmeHTTPSolver) CleanUp(ctx context.Context, id pcert.ACMEIdentifier, token, keyAuth string) error {
+	if s.Webroot != "" {
+		return os.Remove(filepath.Join(s.Webroot, filepath.FromSlash(acmeChallengePath), token))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	if len(s.tokens) > 0 || s.server == nil {
+		return nil
+	}
+	err := s.server.Close()
+	s.server = nil
+	s.listener = nil
+	return err
+}
+
+func (s *acmeHTTPSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, acmeChallengePath)
+	s.mu.Lock()
+	keyAuth, found := s.tokens[token]
+	s.mu.Unlock()
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.WriteString(w, keyAuth)
+}
+
+// acmeDNSHookSolver solves dns-01 challenges by calling Command to create and
+// remove the TXT records.
+type acmeDNSHookSolver struct {
+	Command string
+	Output  io.Writer
+}
+
+func (s *acmeDNSHookSolver) Type() string {
+	return pcert.ACMEChallengeDNS01
+}
+
+func (s *acmeDNSHookSolver) Present(ctx context.Context, id pcert.ACMEIdentifier, token, keyAuth string) error {
+	return s.run(ctx, "present", id, keyAuth)
+}
+
+func (s *acmeDNSHookSolver) CleanUp(ctx context.Context, id pcert.ACMEIdentifier, token, keyAuth string) error {
+	return s.run(ctx, "cleanup", id, keyAuth)
+}
+
+func (s *acmeDNSHookSolver) run(ctx context.Context, action string, id pcert.ACMEIdentifier, keyAuth string) error {
+	fqdn := "_acme-challenge." + strings.TrimPrefix(id.Value, "*.") + "."
+	cmd := exec.CommandContext(ctx, s.Command, action, fqdn, pcert.ACMEDNS01Value(keyAuth))
+	cmd.Stdout = s.Output
+	cmd.Stderr = s.Output
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("dns hook '%s %s %s' failed: %w", s.Command, action, fqdn, err)
+	}
+	return nil
+}
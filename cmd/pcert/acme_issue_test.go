@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+// newACMEIssueTestServer starts an ACME server and returns its directory
+// URL and a file with the CA of its TLS certificate.
+func newACMEIssueTestServer(t *testing.T, server *pcert.ACMEServer) (string, string) {
+	t.Helper()
+	ca := newTestCA(t, "ACME CA", nil)
+	server.CACert = ca.cert
+	server.CAKey = ca.key
+	ts := httptest.NewTLSServer(server)
+	t.Cleanup(ts.Close)
+	caFile := filepath.Join(t.TempDir(), "acme-server.crt")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.URL + "/directory", caFile
+}
+
+func readTestCertificates(t *testing.T, file string) []*x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pcert.ParseAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func Test_acmeIssue_webroot(t *testing.T) {
+	dir := t.TempDir()
+	webroot := filepath.Join(dir, "www")
+	httpServer := httptest.NewServer(http.FileServer(http.Dir(webroot)))
+	defer httpServer.Close()
+	_, httpPort, _ := net.SplitHostPort(httpServer.Listener.Addr().String())
+
+	server := &pcert.ACMEServer{Validity: pcert.DefaultACMEValidity}
+	server.HTTPPort, _ = strconv.Atoi(httpPort)
+	directory, caFile := newACMEIssueTestServer(t, server)
+
+	accountKey := filepath.Join(dir, "acme", "account.key")
+	certFile := filepath.Join(dir, "tls.crt")
+	args := []string{"acme", "issue", "--directory", directory, "--ca", caFile, "--account-key", accountKey, "--webroot", webroot, "--ip", "127.0.0.1", certFile}
+	_, stderr, err := runCmd(args, nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+
+	certs := readTestCertificates(t, certFile)
+	if len(certs) != 2 || !certs[1].Equal(server.CACert) {
+		t.Fatalf("expected certificate and CA, got %d certificates", len(certs))
+	}
+	if len(certs[0].IPAddresses) != 1 || certs[0].IPAddresses[0].String() != "127.0.0.1" {
+		t.Errorf("unexpected IP addresses: %v", certs[0].IPAddresses)
+	}
+	keyData, err := os.ReadFile(filepath.Join(dir, "tls.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := pcert.ParseKey(keyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pcert.KeyMatchesCertificate(key, certs[0]) {
+		t.Error("key does not match certificate")
+	}
+	entries, err := os.ReadDir(filepath.Join(webroot, ".well-known", "acme-challenge"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("challenge files not removed: %v %v", entries, err)
+	}
+
+	// the account key is created once and reused
+	accountKeyData, err := os.ReadFile(accountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stderr, err = runCmd(args, nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	newAccountKeyData, err := os.ReadFile(accountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(accountKeyData) != string(newAccountKeyData) {
+		t.Error("account key was replaced")
+	}
+
+	_, _, err = runCmd([]string{"acme", "issue", "--directory", directory, "--ca", caFile, "--account-key", accountKey}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "--dns or --ip") {
+		t.Errorf("expected error without names, got %v", err)
+	}
+}
+
+func Test_acmeIssue_dnsHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook is a shell script")
+	}
+	dir := t.TempDir()
+	records := filepath.Join(dir, "records")
+	hook := filepath.Join(dir, "hook.sh")
+	err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$1 $2 $3\" >> "+records+"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &pcert.ACMEServer{
+		Validity: pcert.DefaultACMEValidity,
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			file, err := os.Open(records)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			values := []string{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) == 3 && fields[0] == "present" && fields[1] == name+"." {
+					values = append(values, fields[2])
+				}
+			}
+			return values, nil
+		},
+	}
+	directory, caFile := newACMEIssueTestServer(t, server)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	chainFile := filepath.Join(dir, "chain.crt")
+	args := []string{"acme", "issue", "--directory", directory, "--ca", caFile, "--account-key", filepath.Join(dir, "account.key"), "--dns-hook", hook, "--dns", "*.example.com", "--chain", chainFile, certFile}
+	_, stderr, err := runCmd(args, nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	certs := readTestCertificates(t, certFile)
+	if len(certs) != 1 || certs[0].DNSNames[0] != "*.example.com" {
+		t.Fatalf("unexpected certificate file: %d certificates", len(certs))
+	}
+	chain := readTestCertificates(t, chainFile)
+	if len(chain) != 1 || !chain[0].Equal(server.CACert) {
+		t.Errorf("unexpected chain file: %d certificates", len(chain))
+	}
+	calls, err := os.ReadFile(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(calls), "cleanup _acme-challenge.example.com.") {
+		t.Errorf("hook not called for cleanup: %s", calls)
+	}
+}
+
+func Test_acmeHTTPSolver(t *testing.T) {
+	solver := &acmeHTTPSolver{Listen: "127.0.0.1:0"}
+	id := pcert.ACMEIdentifier{Type: "dns", Value: "localhost"}
+	ctx := context.Background()
+	err := solver.Present(ctx, id, "token1", "token1.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = solver.Present(ctx, id, "token2", "token2.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := "http://" + solver.listener.Addr().String() + acmeChallengePath
+
+	get := func(token string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(baseURL + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, body := get("token1"); code != http.StatusOK || body != "token1.thumbprint" {
+		t.Errorf("unexpected response: %d %s", code, body)
+	}
+	if code, _ := get("other"); code != http.StatusNotFound {
+		t.Errorf("expected not found for unknown token, got %d", code)
+	}
+
+	_ = solver.CleanUp(ctx, id, "token1", "")
+	if code, _ := get("token1"); code != http.StatusNotFound {
+		t.Errorf("expected not found after cleanup, got %d", code)
+	}
+	_ = solver.CleanUp(ctx, id, "token2", "")
+	_, err = http.Get(baseURL + "token2")
+	if err == nil {
+		t.Error("server still running after last cleanup")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_acmeServe(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+
+	opts := &acmeServeOptions{
+		SignCert:  caFile,
+		Hostnames: []string{"localhost", "127.0.0.1"},
+	}
+	tlsCert, err := opts.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(&opts.Server)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	// the server certificate is trusted with the CA
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(server.URL + "/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	directory := &pcert.ACMEDirectory{}
+	err = json.NewDecoder(resp.Body).Decode(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if directory.NewOrder != server.URL+"/new-order" {
+		t.Errorf("unexpected directory: %+v", directory)
+	}
+
+	other := newTestCA(t, "Other", nil)
+	otherFile := writeTestCert(t, dir, "other", other)
+	opts = &acmeServeOptions{
+		SignCert: caFile,
+		SignKey:  strings.TrimSuffix(otherFile, certFileSuffix) + keyFileSuffix,
+	}
+	_, err = opts.load()
+	if err == nil || !strings.Contains(err.Error(), "does not belong") {
+		t.Errorf("expected error for wrong key, got %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+// asn1MaxValueBytes limits the number of bytes of binary values which are
+// printed as hex unless --full is set.
+const asn1MaxValueBytes = 32
+
+// asn1Node is an ASN.1 element. Offsets are relative to the start of the
+// DER input.
+type asn1Node struct {
+	Offset      int
+	HeaderLen   int
+	Length      int
+	Class       int
+	Tag         int
+	Constructed bool
+	// Content is the content of the element without header.
+	Content []byte
+	// Encapsulated is true if the children of a primitive BIT STRING or
+	// OCTET STRING were decoded from its content.
+	Encapsulated bool
+	Children     []*asn1Node
+}
+
+type asn1ParseOptions struct {
+	Offset       int
+	Encapsulated bool
+	Full         bool
+}
+
+func newASN1ParseCmd() *cobra.Command {
+	opts := &asn1ParseOptions{
+		Offset:       -1,
+		Encapsulated: true,
+	}
+	cmd := &cobra.Command{
+		Use:   "asn1parse [FILE]",
+		Short: "Print the ASN.1 structure of DER or PEM input",
+		Long: `Print the ASN.1 structure of certificates, CSRs, CRLs, keys or any other
+DER encoded data. The input can be PEM, DER or base64 encoded. If no file is
+provided the input is read from STDIN.
+
+Each line shows the offset, the depth, the header length, the content length,
+whether the element is constructed (cons) or primitive (prim), the tag and the
+decoded value of primitive elements. Object identifiers are shown with their
+name if they are known.
+
+OCTET STRINGs and BIT STRINGs which contain DER encoded data (e.g. extension
+values and RSA public keys) are decoded as well. Their elements are marked
+as encapsulated (enc). With --offset only the element at the given offset and
+its children are printed. If the input consists of several PEM blocks,
+offsets are relative to the start of each block.`,
+		Example: `  # print the structure of a certificate
+  pcert asn1parse tls.crt
+
+  # print the extensions of a certificate
+  pcert asn1parse --offset 381 tls.crt`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := "-"
+			if len(args) == 1 {
+				file = args[0]
+			}
+			data, err := readStdinOrFile(file, &stdinKeeper{stdin: cmd.InOrStdin()})
+			if err != nil {
+				return err
+			}
+
+			blocks, err := readASN1Input(data)
+			if err != nil {
+				return err
+			}
+
+			for i, block := range blocks {
+				if len(blocks) > 1 {
+					if i > 0 {
+						fmt.Fprintln(cmd.OutOrStdout())
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "# %s\n", block.Type)
+				}
+				nodes, err := parseASN1(block.Bytes, 0, opts.Encapsulated)
+				if err != nil {
+					return err
+				}
+				if opts.Offset >= 0 {
+					node := findASN1Node(nodes, opts.Offset)
+					if node == nil {
+						return fmt.Errorf("no element starts at offset %d", opts.Offset)
+					}
+					nodes = []*asn1Node{node}
+				}
+				for _, node := range nodes {
+					printASN1Node(cmd.OutOrStdout(), node, 0, opts)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&opts.Offset, "offset", opts.Offset, "Only print the element which starts at the given offset and its children.")
+	cmd.Flags().BoolVar(&opts.Encapsulated, "encapsulated", opts.Encapsulated, "Decode DER encoded data in OCTET STRINGs and BIT STRINGs.")
+	cmd.Flags().BoolVar(&opts.Full, "full", opts.Full, fmt.Sprintf("Print binary values completely and not just the first %d bytes.", asn1MaxValueBytes))
+	return cmd
+}
+
+// readASN1Input returns the DER data of all PEM blocks of data or the data
+// itself if it is DER or base64 encoded.
+func readASN1Input(data []byte) ([]*pem.Block, error) {
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		var blocks []*pem.Block
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			blocks = append(blocks, block)
+		}
+		if len(blocks) == 0 {
+			return nil, errors.New("invalid PEM input")
+		}
+		return blocks, nil
+	}
+
+	if len(data) > 0 && data[0] == 0x30 {
+		return []*pem.Block{{Type: "DER", Bytes: data}}, nil
+	}
+	stripped := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, data)
+	der, err := base64.StdEncoding.DecodeString(string(stripped))
+	if err != nil || len(der) == 0 {
+		return nil, errors.New("input is neither PEM, DER nor base64 encoded")
+	}
+	return []*pem.Block{{Type: "DER", Bytes: der}}, nil
+}
+
+// parseASN1 parses all elements of data. offset is the position of data in
+// the whole input.
+func parseASN1(data []byte, offset int, encapsulated bool) ([]*asn1Node, error) {
+	var nodes []*asn1Node
+	for pos := 0; pos < len(data); {
+		node, err := parseASN1Node(data[pos:], offset+pos, encapsulated)
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, node)
+		pos += node.HeaderLen + node.Length
+	}
+	return nodes, nil
+}
+
+func parseASN1Node(data []byte, offset int, encapsulated bool) (*asn1Node, error) {
+	errTruncated := fmt.Errorf("truncated element at offset %d", offset)
+	if len(data) < 2 {
+		return nil, errTruncated
+	}
+	node := &asn1Node{
+		Offset:      offset,
+		Class:       int(data[0] >> 6),
+		Constructed: data[0]&0x20 != 0,
+		Tag:         int(data[0] & 0x1f),
+	}
+	pos := 1
+
+	// high tag number form
+	if node.Tag == 0x1f {
+		node.Tag = 0
+		for {
+			if pos >= len(data) || node.Tag > 1<<24 {
+				return nil, errTruncated
+			}
+			b := data[pos]
+			pos++
+			node.Tag = node.Tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if pos >= len(data) {
+		return nil, errTruncated
+	}
+	indefinite := false
+	switch b := data[pos]; {
+	case b < 0x80:
+		node.Length = int(b)
+		pos++
+	case b == 0x80:
+		if !node.Constructed {
+			return nil, fmt.Errorf("indefinite length of primitive element at offset %d", offset)
+		}
+		indefinite = true
+		pos++
+	default:
+		n := int(b & 0x7f)
+		pos++
+		if n > 4 || pos+n > len(data) {
+			return nil, fmt.Errorf("invalid length at offset %d", offset)
+		}
+		for _, lb := range data[pos : pos+n] {
+			node.Length = node.Length<<8 | int(lb)
+		}
+		pos += n
+	}
+	node.HeaderLen = pos
+
+	if indefinite {
+		// children until the end-of-contents octets
+		for {
+			if pos+2 > len(data) {
+				return nil, errTruncated
+			}
+			if data[pos] == 0 && data[pos+1] == 0 {
+				pos += 2
+				break
+			}
+			child, err := parseASN1Node(data[pos:], offset+pos, encapsulated)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			pos += child.HeaderLen + child.Length
+		}
+		node.Length = pos - node.HeaderLen
+		node.Content = data[node.HeaderLen:pos]
+		return node, nil
+	}
+
+	if node.Length > len(data)-pos {
+		return nil, errTruncated
+	}
+	node.Content = data[pos : pos+node.Length]
+
+	if node.Constructed {
+		children, err := parseASN1(node.Content, offset+pos, encapsulated)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = children
+		return node, nil
+	}
+
+	if encapsulated && node.Class == asn1.ClassUniversal {
+		switch node.Tag {
+		case asn1.TagOctetString:
+			node.Children = parseEncapsulated(node.Content, offset+pos)
+		case asn1.TagBitString:
+			if len(node.Content) > 1 && node.Content[0] == 0 {
+				node.Children = parseEncapsulated(node.Content[1:], offset+pos+1)
+			}
+		}
+		node.Encapsulated = len(node.Children) > 0
+	}
+	return node, nil
+}
+
+// parseEncapsulated returns the elements of data if data consists of
+// constructed DER elements only. Otherwise nil is returned.
+func parseEncapsulated(data []byte, offset int) []*asn1Node {
+	if len(data) < 2 || data[0]&0x20 == 0 {
+		return nil
+	}
+	nodes, err := parseASN1(data, offset, true)
+	if err != nil {
+		return nil
+	}
+	for _, node := range nodes {
+		if !node.Constructed {
+			return nil
+		}
+	}
+	return nodes
+}
+
+func findASN1Node(nodes []*asn1Node, offset int) *asn1Node {
+	for _, node := range nodes {
+		if node.Offset == offset {
+			return node
+		}
+		if found := findASN1Node(node.Children, offset); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func printASN1Node(w io.Writer, node *asn1Node, depth int, opts *asn1ParseOptions) {
+	kind := "prim"
+	if node.Constructed {
+		kind = "cons"
+	}
+	if node.Encapsulated {
+		kind = "enc "
+	}
+	line := fmt.Sprintf("%5d:d=%-2d hl=%d l=%4d %s: %s%s", node.Offset, depth, node.HeaderLen, node.Length, kind, strings.Repeat(" ", depth), asn1TagName(node))
+	if !node.Constructed && !node.Encapsulated {
+		if value := asn1Value(node, opts.Full); value != "" {
+			line += " " + value
+		}
+	}
+	fmt.Fprintln(w, line)
+	for _, child := range node.Children {
+		printASN1Node(w, child, depth+1, opts)
+	}
+}
+
+var asn1UniversalTags = map[int]string{
+	0:                       "EOC",
+	asn1.TagBoolean:         "BOOLEAN",
+	asn1.TagInteger:         "INTEGER",
+	asn1.TagBitString:       "BIT STRING",
+	asn1.TagOctetString:     "OCTET STRING",
+	asn1.TagNull:            "NULL",
+	asn1.TagOID:             "OBJECT",
+	asn1.TagEnum:            "ENUMERATED",
+	asn1.TagUTF8String:      "UTF8STRING",
+	asn1.TagSequence:        "SEQUENCE",
+	asn1.TagSet:             "SET",
+	asn1.TagNumericString:   "NUMERICSTRING",
+	asn1.TagPrintableString: "PRINTABLESTRING",
+	asn1.TagT61String:       "T61STRING",
+	asn1.TagIA5String:       "IA5STRING",
+	asn1.TagUTCTime:         "UTCTIME",
+	asn1.TagGeneralizedTime: "GENERALIZEDTIME",
+	26:                      "VISIBLESTRING",
+	27:                      "GENERALSTRING",
+	28:                      "UNIVERSALSTRING",
+	asn1.TagBMPString:       "BMPSTRING",
+}
+
+func asn1TagName(node *asn1Node) string {
+	switch node.Class {
+	case asn1.ClassUniversal:
+		if name, ok := asn1UniversalTags[node.Tag]; ok {
+			return name
+		}
+		return fmt.Sprintf("[UNIVERSAL %d]", node.Tag)
+	case asn1.ClassApplication:
+		return fmt.Sprintf("[APPLICATION %d]", node.Tag)
+	case asn1.ClassContextSpecific:
+		return fmt.Sprintf("[%d]", node.Tag)
+	default:
+		return fmt.Sprintf("[PRIVATE %d]", node.Tag)
+	}
+}
+
+// asn1Value returns the decoded value of a primitive element.
+func asn1Value(node *asn1Node, full bool) string {
+	content := node.Content
+	if node.Class != asn1.ClassUniversal {
+		if isPrintable(content) {
+			return fmt.Sprintf("%q", content)
+		}
+		return asn1Hex(content, full)
+	}
+
+	switch node.Tag {
+	case asn1.TagBoolean:
+		if len(content) == 1 && content[0] != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	case asn1.TagInteger, asn1.TagEnum:
+		if len(content) == 0 {
+			return ""
+		}
+		n := new(big.Int).SetBytes(content)
+		// two's complement
+		if content[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(content)*8)))
+		}
+		if n.IsInt64() {
+			return n.String()
+		}
+		return "0x" + asn1Hex(content, full)
+	case asn1.TagOID:
+		var oid asn1.ObjectIdentifier
+		if len(content) > 127 {
+			return asn1Hex(content, full)
+		}
+		_, err := asn1.Unmarshal(append([]byte{asn1.TagOID, byte(len(content))}, content...), &oid)
+		if err != nil {
+			return asn1Hex(content, full)
+		}
+		if name := pcert.OIDName(oid); name != "" {
+			return fmt.Sprintf("%s (%s)", oid, name)
+		}
+		return oid.String()
+	case asn1.TagNull:
+		return ""
+	case asn1.TagUTF8String, asn1.TagNumericString, asn1.TagPrintableString, asn1.TagT61String, asn1.TagIA5String, 26, 27:
+		return fmt.Sprintf("%q", content)
+	case asn1.TagBMPString:
+		if len(content)%2 != 0 {
+			return asn1Hex(content, full)
+		}
+		chars := make([]uint16, len(content)/2)
+		for i := range chars {
+			chars[i] = uint16(content[2*i])<<8 | uint16(content[2*i+1])
+		}
+		return fmt.Sprintf("%q", string(utf16.Decode(chars)))
+	case asn1.TagUTCTime, asn1.TagGeneralizedTime:
+		layout := "060102150405Z0700"
+		if node.Tag == asn1.TagGeneralizedTime {
+			layout = "20060102150405Z0700"
+		}
+		t, err := time.Parse(layout, string(content))
+		if err != nil {
+			return fmt.Sprintf("%q", content)
+		}
+		return fmt.Sprintf("%q (%s)", content, t.UTC().Format(time.RFC3339))
+	case asn1.TagBitString:
+		if len(content) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("unused bits %d: %s", content[0], asn1Hex(content[1:], full))
+	default:
+		return asn1Hex(content, full)
+	}
+}
+
+func asn1Hex(data []byte, full bool) string {
+	if full || len(data) <= asn1MaxValueBytes {
+		return hex.EncodeToString(data)
+	}
+	return fmt.Sprintf("%s... (%d bytes)", hex.EncodeToString(data[:asn1MaxValueBytes]), len(data))
+}
+
+func isPrintable(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
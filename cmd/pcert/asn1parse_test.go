@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_asn1parse(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	certPEM := pcert.Encode(ca.cert.Raw)
+
+	stdout, _, err := runCmd([]string{"asn1parse"}, bytes.NewReader(certPEM), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"    0:d=0  hl=4 l=",
+		"cons:  SEQUENCE",
+		"prim:    INTEGER 2",
+		"OBJECT 2.5.4.3 (commonName)",
+		`PRINTABLESTRING "Root"`,
+		"OBJECT 2.5.29.19 (BasicConstraints)",
+		"enc :      OCTET STRING",
+		"BOOLEAN TRUE",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("'%s' not found in output:\n%s", expected, stdout)
+		}
+	}
+
+	// base64 and DER input result in the same output
+	for _, input := range [][]byte{ca.cert.Raw, []byte(base64.StdEncoding.EncodeToString(ca.cert.Raw))} {
+		out, _, err := runCmd([]string{"asn1parse"}, bytes.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != stdout.String() {
+			t.Errorf("output differs from PEM input:\n%s", out)
+		}
+	}
+
+	// sub-tree of the TBSCertificate
+	stdout, _, err = runCmd([]string{"asn1parse", "--offset", "4"}, bytes.NewReader(certPEM), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(stdout.String(), "\n")
+	// the signature algorithm appears only once since the signature
+	// itself is not part of the TBSCertificate
+	if !strings.HasPrefix(lines[0], "    4:d=0") || strings.Count(stdout.String(), "ecdsa-with-SHA256") != 1 {
+		t.Errorf("unexpected sub-tree:\n%s", stdout)
+	}
+
+	_, _, err = runCmd([]string{"asn1parse", "--offset", "5"}, bytes.NewReader(certPEM), nil)
+	if err == nil || !strings.Contains(err.Error(), "offset 5") {
+		t.Errorf("expected error for invalid offset, got %v", err)
+	}
+
+	_, _, err = runCmd([]string{"asn1parse"}, bytes.NewReader(ca.cert.Raw[:100]), nil)
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("expected error for truncated input, got %v", err)
+	}
+}
+
+func Test_asn1Value(t *testing.T) {
+	tests := []struct {
+		der      []byte
+		expected string
+	}{
+		{[]byte{0x02, 0x01, 0xff}, "-1"},
+		{[]byte{0x02, 0x02, 0x00, 0x80}, "128"},
+		{[]byte{0x01, 0x01, 0x00}, "FALSE"},
+		{[]byte{0x17, 0x0d, '2', '4', '0', '1', '0', '1', '0', '0', '0', '0', '0', '0', 'Z'}, `"240101000000Z" (2024-01-01T00:00:00Z)`},
+		{[]byte{0x1e, 0x04, 0x00, 'h', 0x00, 'i'}, `"hi"`},
+		{[]byte{0x82, 0x03, 'a', '.', 'b'}, `"a.b"`},
+		{[]byte{0x06, 0x03, 0x55, 0x1d, 0x0f}, "2.5.29.15 (KeyUsage)"},
+	}
+	for _, test := range tests {
+		nodes, err := parseASN1(test.der, 0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := asn1Value(nodes[0], false)
+		if got != test.expected {
+			t.Errorf("%x: got=%s want=%s", test.der, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/dvob/pcert"
+)
+
+// signingCA is a CA used by the servers to issue certificates.
+type signingCA struct {
+	Cert *x509.Certificate
+	Key  any
+	// Chain contains the further certificates of the CA file.
+	Chain []*x509.Certificate
+}
+
+// loadSigningCA reads the CA certificate and its key. If keyFile is empty
+// the key file relative to certFile is used. Further certificates in
+// certFile are returned as chain.
+func loadSigningCA(certFile, keyFile string) (*signingCA, error) {
+	if keyFile == "" {
+		keyFile = getKeyRelativeToFile(certFile)
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := pcert.ParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in '%s'", certFile)
+	}
+	data, err = os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pcert.ParseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if !pcert.KeyMatchesCertificate(key, certs[0]) {
+		return nil, fmt.Errorf("key '%s' does not belong to '%s'", keyFile, certFile)
+	}
+	return &signingCA{
+		Cert:  certs[0],
+		Key:   key,
+		Chain: certs[1:],
+	}, nil
+}
+
+// serverCertificate returns the TLS certificate of a server. If certFile is
+// set, the certificate and key are read from the files. Otherwise a
+// certificate for hostnames is issued by the CA.
+func (ca *signingCA) serverCertificate(certFile, keyFile string, hostnames []string) (*tls.Certificate, error) {
+	if certFile != "" {
+		if keyFile == "" {
+			keyFile = getKeyRelativeToFile(certFile)
+		}
+		tlsCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		return &tlsCert, err
+	}
+
+	if len(hostnames) == 0 {
+		return nil, errors.New("at least one --hostname is required")
+	}
+	certOpts := &pcert.CertificateOptions{
+		ProfileServer: true,
+		Certificate: x509.Certificate{
+			Subject:  pkix.Name{CommonName: hostnames[0]},
+			DNSNames: []string{},
+		},
+	}
+	for _, name := range hostnames {
+		if ip := net.ParseIP(name); ip != nil {
+			certOpts.IPAddresses = append(certOpts.IPAddresses, ip)
+		} else {
+			certOpts.DNSNames = append(certOpts.DNSNames, name)
+		}
+	}
+	der, key, err := pcert.CreateCertificate(pcert.NewCertificate(certOpts), ca.Cert, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	tlsCert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+	}
+	for _, cert := range ca.Chain {
+		tlsCert.Certificate = append(tlsCert.Certificate, cert.Raw)
+	}
+	return tlsCert, nil
+}
+
+// printServerURL prints the URL of a server listening on listen.
+func printServerURL(w io.Writer, description, scheme, listen string, hostnames []string, path string) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+		if len(hostnames) > 0 {
+			host = hostnames[0]
+		}
+	}
+	fmt.Fprintf(w, "%s: %s://%s%s\n", description, scheme, net.JoinHostPort(host, port), path)
+}
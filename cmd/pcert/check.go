@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+// maxCheckFileSize is the maximum size of files which are read when
+// directories are checked. Larger files are skipped.
+const maxCheckFileSize = 1 << 20
+
+// checkStatus is a status with the exit code of a Nagios plugin.
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case checkOK:
+		return "OK"
+	case checkWarning:
+		return "WARNING"
+	case checkCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (s checkStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+type checkOptions struct {
+	Warn time.Duration
+	Crit time.Duration
+	// Now is the time used to check the validity.
+	Now time.Time
+}
+
+type checkResult struct {
+	Path     string      `json:"path"`
+	Index    int         `json:"index"`
+	Subject  string      `json:"subject,omitempty"`
+	Issuer   string      `json:"issuer,omitempty"`
+	Serial   string      `json:"serial,omitempty"`
+	NotAfter *time.Time  `json:"not_after,omitempty"`
+	DaysLeft int         `json:"days_left"`
+	Status   checkStatus `json:"status"`
+	Messages []string    `json:"messages"`
+}
+
+func (r *checkResult) add(status checkStatus, format string, args ...any) {
+	if status > r.Status {
+		r.Status = status
+	}
+	r.Messages = append(r.Messages, fmt.Sprintf(format, args...))
+}
+
+type checkReport struct {
+	Status       checkStatus   `json:"status"`
+	Summary      string        `json:"summary"`
+	Certificates []checkResult `json:"certificates"`
+}
+
+func newCheckCmd() *cobra.Command {
+	var (
+		opts = &checkOptions{
+			Warn: 30 * 24 * time.Hour,
+			Crit: 7 * 24 * time.Hour,
+		}
+		format = "text"
+	)
+	cmd := &cobra.Command{
+		Use:   "check <FILE|DIR>...",
+		Short: "Check the remaining validity of certificates",
+		Long: `Check the remaining validity of all certificates in the given files and
+directories. Directories are searched recursively. Files which do not contain
+certificates are skipped. Bundles are supported and every certificate in them
+is checked.
+
+A certificate is critical if it expires within --crit, is expired or is not
+yet valid. It is a warning if it expires within --warn or if a certificate in
+the same file which issued it expires before it.
+
+The command follows the conventions of Nagios plugins: the first line is a
+summary and the exit code is 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN)
+if a file could not be read or no certificates were found.`,
+		Example: `  # check all certificates in /etc/ssl
+  pcert check --warn 30d --crit 7d /etc/ssl
+
+  # check a certificate chain and print the result as JSON
+  pcert check --format json fullchain.pem`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format '%s'. valid formats are text and json", format)
+			}
+			if opts.Crit > opts.Warn {
+				return fmt.Errorf("--crit must not be greater than --warn")
+			}
+			opts.Now = time.Now()
+
+			report := checkPaths(args, opts)
+			var err error
+			if format == "json" {
+				err = printCheckJSON(cmd.OutOrStdout(), report)
+			} else {
+				err = printCheckText(cmd.OutOrStdout(), report)
+			}
+			if err != nil {
+				return err
+			}
+			if report.Status != checkOK {
+				return &exitCodeError{
+					code: int(report.Status),
+					err:  fmt.Errorf("check failed with status %s", report.Status),
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Var(newDurationValue(&opts.Warn), "warn", "Warn if a certificate expires within this duration (e.g. 30d).")
+	cmd.Flags().Var(newDurationValue(&opts.Crit), "crit", "Critical if a certificate expires within this duration (e.g. 7d).")
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Output format. Valid formats are text and json.")
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveDefault
+	})
+	return cmd
+}
+
+// checkPaths checks all certificates in paths.
+func checkPaths(paths []string, opts *checkOptions) *checkReport {
+	report := &checkReport{
+		Certificates: []checkResult{},
+	}
+	for _, path := range paths {
+		walkCertificateFiles(path, func(file string, certs []*x509.Certificate, err error) {
+			if err != nil {
+				report.add(checkErrorResult(file, err))
+				return
+			}
+			report.add(checkCertificates(file, certs, opts)...)
+		})
+	}
+
+	counts := map[checkStatus]int{}
+	for _, result := range report.Certificates {
+		counts[result.Status]++
+	}
+	if len(report.Certificates) == 0 {
+		report.Status = checkUnknown
+		report.Summary = "no certificates found"
+		return report
+	}
+	report.Summary = fmt.Sprintf("%d checked, %d critical, %d warning, %d ok", len(report.Certificates)-counts[checkUnknown], counts[checkCritical], counts[checkWarning], counts[checkOK])
+	if counts[checkUnknown] > 0 {
+		report.Summary += fmt.Sprintf(", %d errors", counts[checkUnknown])
+	}
+	return report
+}
+
+func (r *checkReport) add(results ...checkResult) {
+	for _, result := range results {
+		if result.Status > r.Status {
+			r.Status = result.Status
+		}
+		r.Certificates = append(r.Certificates, result)
+	}
+}
+
+func checkErrorResult(path string, err error) checkResult {
+	return checkResult{
+		Path:     path,
+		Status:   checkUnknown,
+		Messages: []string{err.Error()},
+	}
+}
+
+// walkCertificateFiles calls fn for every file in path which contains
+// certificates. Directories are searched recursively and files which do not
+// contain certificates or are larger than maxCheckFileSize are skipped. If
+// path is not a directory, fn is called with an error if the file cannot be
+// read or contains no certificates.
+func walkCertificateFiles(path string, fn func(file string, certs []*x509.Certificate, err error)) {
+	info, err := os.Stat(path)
+	if err != nil {
+		fn(path, nil, err)
+		return
+	}
+	if !info.IsDir() {
+		certs, err := readCertificateFile(path)
+		if err == nil && len(certs) == 0 {
+			err = fmt.Errorf("no certificates found")
+		}
+		fn(path, certs, err)
+		return
+	}
+
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fn(file, nil, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxCheckFileSize {
+			return nil
+		}
+		// files without certificates are expected in directories
+		certs, err := readCertificateFile(file)
+		if err == nil && len(certs) > 0 {
+			fn(file, certs, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		fn(path, nil, err)
+	}
+}
+
+func readCertificateFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return pcert.ParseAll(data)
+}
+
+// checkCertificates checks all certificates of a file. Certificates which
+// are issued by another certificate of the same file are checked against
+// the expiry of their issuer.
+func checkCertificates(path string, certs []*x509.Certificate, opts *checkOptions) []checkResult {
+	results := []checkResult{}
+	for i, cert := range certs {
+		result := checkCertificate(cert, opts)
+		result.Path = path
+		result.Index = i
+		for _, issuer := range certs {
+			if issuer == cert || !isIssuer(issuer, cert) {
+				continue
+			}
+			if issuer.NotAfter.Before(cert.NotAfter) {
+				result.add(checkWarning, "issuer %s expires before the certificate on %s", issuer.Subject, issuer.NotAfter.Format(time.RFC3339))
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func checkCertificate(cert *x509.Certificate, opts *checkOptions) checkResult {
+	remaining := cert.NotAfter.Sub(opts.Now)
+	result := checkResult{
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		Serial:   encodeSerial(cert.SerialNumber),
+		NotAfter: &cert.NotAfter,
+		DaysLeft: int(remaining.Hours() / 24),
+		Messages: []string{},
+	}
+	switch {
+	case opts.Now.Before(cert.NotBefore):
+		result.add(checkCritical, "not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	case remaining <= 0:
+		result.add(checkCritical, "expired %s ago", formatDuration(-remaining.Truncate(time.Hour)))
+	case remaining < opts.Crit:
+		result.add(checkCritical, "expires in %s", formatDuration(remaining.Truncate(time.Hour)))
+	case remaining < opts.Warn:
+		result.add(checkWarning, "expires in %s", formatDuration(remaining.Truncate(time.Hour)))
+	}
+	return result
+}
+
+// isIssuer reports whether issuer signed cert.
+func isIssuer(issuer, cert *x509.Certificate) bool {
+	return string(issuer.RawSubject) == string(cert.RawIssuer) && cert.CheckSignatureFrom(issuer) == nil
+}
+
+func printCheckText(w io.Writer, report *checkReport) error {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s - %s\n", report.Status, report.Summary)
+	for _, r := range report.Certificates {
+		if r.Status == checkOK {
+			continue
+		}
+		location := r.Path
+		if r.Subject != "" {
+			location = fmt.Sprintf("%s[%d] %s", r.Path, r.Index, r.Subject)
+		}
+		fmt.Fprintf(sb, "%s %s: %s\n", r.Status, location, strings.Join(r.Messages, ", "))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func printCheckJSON(w io.Writer, report *checkReport) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
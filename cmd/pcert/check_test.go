@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+func newTestCertValidity(t *testing.T, name string, notBefore, notAfter time.Time, ca bool, signer *testCert) *testCert {
+	t.Helper()
+	return newTestCert(t, &pcert.CertificateOptions{
+		ProfileCA: ca,
+		Certificate: x509.Certificate{
+			Subject:   pkix.Name{CommonName: name},
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+		},
+	}, signer)
+}
+
+func Test_check(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	dir := t.TempDir()
+
+	ca := newTestCertValidity(t, "Root", now.Add(-day), now.Add(10*365*day), true, nil)
+	_ = writeTestCert(t, dir, "ok", newTestCertValidity(t, "ok", now.Add(-day), now.Add(365*day), false, ca))
+
+	check := func(args ...string) (string, int) {
+		stdout := &bytes.Buffer{}
+		code := run(append([]string{"check"}, args...), nil, stdout, &bytes.Buffer{}, func(string) (string, bool) { return "", false })
+		return stdout.String(), code
+	}
+
+	// the key files in the directory are skipped
+	out, code := check(dir)
+	if code != 0 || !strings.HasPrefix(out, "OK - 1 checked") {
+		t.Errorf("ok: got exit code %d:\n%s", code, out)
+	}
+
+	warnFile := writeTestCert(t, dir, "warn", newTestCertValidity(t, "warn", now.Add(-day), now.Add(20*day), false, ca))
+	out, code = check(dir)
+	if code != int(checkWarning) || !strings.Contains(out, "WARNING "+warnFile+"[0] CN=warn: expires in 19d") {
+		t.Errorf("warn: got exit code %d:\n%s", code, out)
+	}
+	out, code = check("--warn", "10d", warnFile)
+	if code != 0 {
+		t.Errorf("warn threshold: got exit code %d:\n%s", code, out)
+	}
+
+	_ = writeTestCert(t, dir, "future", newTestCertValidity(t, "future", now.Add(day), now.Add(365*day), false, ca))
+	out, code = check(dir)
+	if code != int(checkCritical) || !strings.Contains(out, "CN=future: not valid before") {
+		t.Errorf("not yet valid: got exit code %d:\n%s", code, out)
+	}
+
+	// intermediate which expires before the leaf
+	intermediate := newTestCertValidity(t, "Intermediate", now.Add(-day), now.Add(100*day), true, ca)
+	leaf := newTestCertValidity(t, "leaf", now.Add(-day), now.Add(200*day), false, intermediate)
+	chainFile := filepath.Join(t.TempDir(), "chain.pem")
+	err := os.WriteFile(chainFile, append(pcert.Encode(leaf.cert.Raw), pcert.Encode(intermediate.cert.Raw)...), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, code = check("--format", "json", chainFile)
+	if code != int(checkWarning) {
+		t.Errorf("chain: got exit code %d:\n%s", code, out)
+	}
+	report := struct {
+		Status       string `json:"status"`
+		Certificates []struct {
+			Subject  string   `json:"subject"`
+			Status   string   `json:"status"`
+			Messages []string `json:"messages"`
+		} `json:"certificates"`
+	}{}
+	err = json.Unmarshal([]byte(out), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "WARNING" || len(report.Certificates) != 2 || report.Certificates[0].Status != "WARNING" || report.Certificates[1].Status != "OK" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Certificates) > 0 && !strings.Contains(strings.Join(report.Certificates[0].Messages, ","), "issuer CN=Intermediate expires before") {
+		t.Errorf("missing issuer message: %+v", report.Certificates[0])
+	}
+
+	out, code = check(filepath.Join(dir, "ok.key"))
+	if code != int(checkUnknown) || !strings.HasPrefix(out, "UNKNOWN") {
+		t.Errorf("no certificates: got exit code %d:\n%s", code, out)
+	}
+
+	_, code = check("--warn", "1d", "--crit", "2d", dir)
+	if code != 1 {
+		t.Errorf("invalid thresholds: got exit code %d want 1", code)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newCMSCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cms",
+		Short: "Sign and verify files with detached CMS/PKCS#7 signatures",
+	}
+	cmd.AddCommand(
+		newCMSSignCmd(),
+		newCMSVerifyCmd(),
+	)
+	return cmd
+}
+
+type cmsSignOptions struct {
+	Cert   string
+	Key    string
+	Chain  []string
+	Output string
+}
+
+func newCMSSignCmd() *cobra.Command {
+	opts := &cmsSignOptions{}
+	cmd := &cobra.Command{
+		Use:   "sign FILE",
+		Short: "Create a detached CMS signature of a file",
+		Long: `Creates a detached CMS SignedData structure (DER) of FILE which is written to
+FILE.p7s or to the file set with --output. The signature contains the signing
+time, the certificate of the signer and the certificates set with --chain.
+Certificates and keys created with pcert (RSA, ECDSA and Ed25519) can be used,
+for example with the extended key usage CodeSigning or EmailProtection.`,
+		Example: `  pcert create signer.crt --sign-cert ca.crt --ext-key-usage CodeSigning
+  pcert cms sign --cert signer.crt app.tar.gz
+  openssl cms -verify -binary -inform DER -in app.tar.gz.p7s -content app.tar.gz -CAfile ca.crt -purpose any`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Cert == "" {
+				return errors.New("--cert is required")
+			}
+			signer, err := loadSigningCA(opts.Cert, opts.Key)
+			if err != nil {
+				return err
+			}
+			chain := signer.Chain
+			for _, file := range opts.Chain {
+				certs, err := readCertificateFile(file)
+				if err != nil {
+					return err
+				}
+				chain = append(chain, certs...)
+			}
+			content, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			signature, err := pcert.SignCMS(content, signer.Cert, signer.Key, chain, true)
+			if err != nil {
+				return err
+			}
+			output := opts.Output
+			if output == "" {
+				output = args[0] + ".p7s"
+			}
+			return writeStdoutOrFile(output, signature, 0o644, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVar(&opts.Cert, "cert", opts.Cert, "Certificate of the signer. Further certificates in the file are added to the signature.")
+	cmd.Flags().StringVar(&opts.Key, "key", opts.Key, "Key of the signer. If not set the key file relative to --cert is used.")
+	cmd.Flags().StringSliceVar(&opts.Chain, "chain", opts.Chain, "File with intermediate certificates which are added to the signature.")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", opts.Output, "Output file of the signature. Use '-' for stdout. Defaults to FILE.p7s.")
+	return cmd
+}
+
+type cmsVerifyOptions struct {
+	CACerts     []string
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+func newCMSVerifyCmd() *cobra.Command {
+	opts := &cmsVerifyOptions{}
+	cmd := &cobra.Command{
+		Use:   "verify FILE [SIGNATURE]",
+		Short: "Verify a detached CMS signature of a file",
+		Long: `Verifies the detached CMS signature (DER or PEM) of FILE read from SIGNATURE.
+If SIGNATURE is not set FILE.p7s is used. Use '-' to read the signature from
+stdin. Signatures which contain the content themselves are rejected. The
+certificates of the signers have to be issued by one of the CAs set with
+--ca. Certificates contained in the signature are used as intermediates. If
+--ext-key-usage is set the certificates of the signers have to be valid for
+these extended key usages.`,
+		Example: `  pcert cms verify --ca ca.crt --ext-key-usage CodeSigning app.tar.gz`,
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(opts.CACerts) == 0 {
+				return errors.New("--ca is required")
+			}
+			roots := x509.NewCertPool()
+			for _, file := range opts.CACerts {
+				certs, err := readCertificateFile(file)
+				if err != nil {
+					return err
+				}
+				for _, cert := range certs {
+					roots.AddCert(cert)
+				}
+			}
+			file := args[0] + ".p7s"
+			if len(args) == 2 {
+				file = args[1]
+			}
+			signature, err := readStdinOrFile(file, &stdinKeeper{stdin: cmd.InOrStdin()})
+			if err != nil {
+				return err
+			}
+			embedded, err := pcert.CMSContent(signature)
+			if err != nil {
+				return err
+			}
+			if embedded != nil {
+				return errors.New("signature is not detached: it contains its own content")
+			}
+			content, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			signatures, err := pcert.VerifyCMS(signature, content, x509.VerifyOptions{
+				Roots:     roots,
+				KeyUsages: opts.ExtKeyUsage,
+			})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			for _, s := range signatures {
+				fmt.Fprintf(out, "signer: %s\n", s.Certificate.Subject)
+				if !s.SigningTime.IsZero() {
+					fmt.Fprintf(out, "signing time: %s\n", s.SigningTime.Format(time.RFC3339))
+				}
+				chain := []string{}
+				for _, cert := range s.Chains[0] {
+					chain = append(chain, cert.Subject.String())
+				}
+				fmt.Fprintf(out, "chain: %s\n", strings.Join(chain, " -> "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&opts.CACerts, "ca", opts.CACerts, "File with CA certificates to verify the signers.")
+	cmd.Flags().Var(newExtKeyUsageValue(&opts.ExtKeyUsage), "ext-key-usage", "Extended key usages which the signers have to be valid for. See 'pcert list' for available extended key usages.")
+	_ = cmd.RegisterFlagCompletionFunc("ext-key-usage", extKeyUsageCompletionFunc)
+	return cmd
+}
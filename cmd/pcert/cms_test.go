@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_cms(t *testing.T) {
+	dir := t.TempDir()
+	root := newTestCA(t, "Root", nil)
+	intermediate := newTestCA(t, "Intermediate", root)
+	rootFile := writeTestCert(t, dir, "root", root)
+	intermediateFile := writeTestCert(t, dir, "intermediate", intermediate)
+	signerFile := writeTestCert(t, dir, "signer", newTestCert(t, &pcert.CertificateOptions{
+		Certificate: x509.Certificate{
+			Subject:     pkix.Name{CommonName: "Signer"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		},
+	}, intermediate))
+
+	file := filepath.Join(dir, "app.tar.gz")
+	err := os.WriteFile(file, []byte("app"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"cms", "sign", "--cert", signerFile, "--chain", intermediateFile, file}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runCmd([]string{"cms", "verify", "--ca", rootFile, "--ext-key-usage", "CodeSigning", file}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "chain: CN=Signer -> CN=Intermediate -> CN=Root") {
+		t.Errorf("unexpected output: %s", stdout)
+	}
+
+	signature, err := os.ReadFile(file + ".p7s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"cms", "verify", "--ca", rootFile, file, "-"}, strings.NewReader(string(signature)), nil)
+	if err != nil {
+		t.Errorf("verify from stdin: %s", err)
+	}
+
+	_, _, err = runCmd([]string{"cms", "verify", "--ca", rootFile, "--ext-key-usage", "EmailProtection", file}, nil, nil)
+	if err == nil {
+		t.Error("expected error for wrong extended key usage")
+	}
+
+	err = os.WriteFile(file, []byte("modified app"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"cms", "verify", "--ca", rootFile, file}, nil, nil)
+	if err == nil {
+		t.Error("expected error for modified file")
+	}
+
+	// a signature which contains its own content must not verify any file
+	signer, err := loadSigningCA(signerFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, err := pcert.SignCMS([]byte("harmless"), signer.Cert, signer.Key, []*x509.Certificate{intermediate.cert}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"malware", "harmless"} {
+		err = os.WriteFile(file, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = runCmd([]string{"cms", "verify", "--ca", rootFile, file, "-"}, strings.NewReader(string(attached)), nil)
+		if err == nil || !strings.Contains(err.Error(), "not detached") {
+			t.Errorf("expected error for attached signature, got %v", err)
+		}
+	}
+}
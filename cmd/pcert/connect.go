@@ -1,47 +1,306 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
 
+	"github.com/dvob/pcert"
 	"github.com/spf13/cobra"
 )
 
+// defaultConnectTimeout limits the time to connect and to perform the
+// handshake if no other timeout is set.
+const defaultConnectTimeout = time.Second * 30
+
+type connectOptions struct {
+	// TLSConfig is the configuration for the TLS client. If ServerName is
+	// empty, the host part of the address is used.
+	TLSConfig *tls.Config
+
+	// StartTLS is the protocol which is used to upgrade a plain text
+	// connection to TLS. If empty, TLS is spoken right from the start.
+	StartTLS string
+
+	// Timeout limits the time to connect and to perform the handshake.
+	Timeout time.Duration
+
+	// Proxy is the URL of a HTTP CONNECT or SOCKS5 proxy.
+	Proxy string
+
+	// CACerts are files with CA certificates to verify the server
+	// certificate.
+	CACerts []string
+
+	// ClientCert and ClientKey are the files of the client certificate
+	// and key used for mutual TLS.
+	ClientCert string
+	ClientKey  string
+}
+
+func newConnectOptions() *connectOptions {
+	return &connectOptions{
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: false,
+		},
+		Timeout: defaultConnectTimeout,
+	}
+}
+
+// loadFiles reads the CA certificates and the client certificate into the
+// TLSConfig.
+func (o *connectOptions) loadFiles() error {
+	if len(o.CACerts) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range o.CACerts {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			certs, err := pcert.ParseAll(data)
+			if err != nil {
+				return err
+			}
+			if len(certs) == 0 {
+				return fmt.Errorf("no certificates found in '%s'", file)
+			}
+			for _, cert := range certs {
+				pool.AddCert(cert)
+			}
+		}
+		o.TLSConfig.RootCAs = pool
+	}
+
+	if o.ClientCert != "" {
+		if o.ClientKey == "" {
+			o.ClientKey = getKeyRelativeToFile(o.ClientCert)
+		}
+		data, err := os.ReadFile(o.ClientCert)
+		if err != nil {
+			return err
+		}
+		certs, err := pcert.ParseAll(data)
+		if err != nil {
+			return err
+		}
+		if len(certs) == 0 {
+			return fmt.Errorf("no certificates found in '%s'", o.ClientCert)
+		}
+		key, err := pcert.LoadKey(o.ClientKey)
+		if err != nil {
+			return err
+		}
+		clientCert := tls.Certificate{
+			PrivateKey: key,
+			Leaf:       certs[0],
+		}
+		for _, cert := range certs {
+			clientCert.Certificate = append(clientCert.Certificate, cert.Raw)
+		}
+		o.TLSConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return nil
+}
+
+// dialTLS connects to addr and performs the TLS handshake.
+func dialTLS(ctx context.Context, addr string, opts *connectOptions) (*tls.Conn, error) {
+	tlsConfig := opts.TLSConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var (
+		dialer = &net.Dialer{}
+		conn   net.Conn
+		err    error
+	)
+	if opts.Proxy != "" {
+		var proxyURL *url.URL
+		proxyURL, err = url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		conn, err = dialProxy(ctx, dialer, proxyURL, addr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.StartTLS != "" {
+		// without a timeout we still limit the plain text part
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(startTLSTimeout)
+		}
+		_ = conn.SetDeadline(deadline)
+		err = startTLS(conn, opts.StartTLS, tlsConfig.ServerName)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Time{})
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
 func newConnectCmd() *cobra.Command {
 	var (
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: false,
+		opts          = newConnectOptions()
+		all           bool
+		fetchIssuers  bool
+		report        bool
+		format        = "pem"
+		outFormat     = outFormatPEM
+		issuerFetcher = &pcert.IssuerFetcher{
+			CacheDir: defaultIssuerCacheDir(),
+			MaxDepth: pcert.DefaultIssuerFetchDepth,
+			Timeout:  pcert.DefaultIssuerFetchTimeout,
 		}
-		all bool
 	)
 	cmd := &cobra.Command{
 		Use:   "connect <host:port>",
 		Short: "Connect to a host via TLS and print its server certificate",
-		Long:  `Connect to a host via TLS.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Connect to a host via TLS.
+
+With --starttls the connection is started in plain text and upgraded to TLS
+using the mechanism of the respective protocol (e.g. STARTTLS for SMTP or
+SSLRequest for PostgreSQL).
+
+With --fetch-issuers the chain presented by the server is completed by
+following the CA Issuers URLs (Authority Information Access) of the
+certificates. This implies --all.
+
+With --report a summary of the handshake is printed before the certificates:
+negotiated version, cipher suite and ALPN protocol, session resumption, the
+stapled OCSP response, SCTs, the acceptable CAs if the server requests a
+client certificate and the result of the chain verification. With --format json
+the report and the certificates are printed as one JSON document.`,
+		Example: `  # print certificate of a web server
+  pcert connect example.com:443
+
+  # print certificate chain of a mail relay
+  pcert connect --all --starttls smtp mail.example.com:25
+
+  # connect to a server which requires a client certificate
+  pcert connect --ca ca.crt --cert client.crt internal.example.com:8443
+
+  # show details about the handshake
+  pcert connect --report --format text example.com:443`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			host := args[0]
 
-			conn, err := tls.Dial("tcp", host, tlsConfig)
+			printer, err := certPrinter(format)
 			if err != nil {
 				return err
 			}
-			conn.Close()
+			if outFormat != outFormatPEM && (format != "pem" || report) {
+				return fmt.Errorf("--out-format %s can only be used with --format pem and without --report", outFormat)
+			}
 
-			state := conn.ConnectionState()
+			err = opts.loadFiles()
+			if err != nil {
+				return err
+			}
 
-			certs := state.PeerCertificates
+			var certs []*x509.Certificate
+			var tlsReport *tlsReport
+			if report {
+				tlsReport, err = newTLSReport(cmd.Context(), host, opts)
+				if err != nil {
+					return err
+				}
+				certs = tlsReport.Certificates
+			} else {
+				conn, err := dialTLS(cmd.Context(), host, opts)
+				if err != nil {
+					return err
+				}
+				conn.Close()
+				certs = conn.ConnectionState().PeerCertificates
+			}
 
-			if !all {
+			// if the chain cannot be completed we still print
+			// the certificates we got and report the error
+			// afterwards
+			var fetchErr error
+			if fetchIssuers {
+				certs, fetchErr = issuerFetcher.CompleteChain(cmd.Context(), certs)
+			} else if !all {
 				certs = certs[0:1]
 			}
 
-			for _, cert := range certs {
-				printPEM(cert)
+			switch {
+			case report && format == "json":
+				tlsReport.Certificates = certs
+				printReportJSON(cmd.OutOrStdout(), tlsReport)
+			case format == "pem":
+				if report {
+					printReportText(cmd.OutOrStdout(), tlsReport)
+				}
+				out, err := encodeCertificates(outFormat, certs...)
+				if err != nil {
+					return err
+				}
+				_, err = cmd.OutOrStdout().Write(out)
+				if err != nil {
+					return err
+				}
+			default:
+				if report {
+					printReportText(cmd.OutOrStdout(), tlsReport)
+				}
+				for _, cert := range certs {
+					printer(cmd.OutOrStdout(), cert)
+				}
+			}
+
+			if fetchErr != nil {
+				return fmt.Errorf("failed to complete chain: %w", fetchErr)
 			}
 			return nil
 		},
 	}
-	cmd.Flags().BoolVar(&tlsConfig.InsecureSkipVerify, "insecure", tlsConfig.InsecureSkipVerify, "Ignore certificate validation errors during the connect.")
+	registerConnectFlags(cmd, opts)
 	cmd.Flags().BoolVar(&all, "all", all, "Print all certificates presented by the server and not just the server certificate (leaf).")
+	cmd.Flags().BoolVar(&fetchIssuers, "fetch-issuers", fetchIssuers, "Complete the chain presented by the server by fetching missing issuers from their CA Issuers URLs.")
+	cmd.Flags().BoolVar(&report, "report", report, "Print a report about the handshake, the stapled OCSP response, SCTs and the verification of the chain.")
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Format in which to print the certificates and the report. Valid formats are pem, text and json.")
+	cmd.Flags().Var(newOutFormatValue(&outFormat), "out-format", "Encoding of the certificates with --format pem (pem, der or p7b).")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
+	bindIssuerFetcherFlags(cmd.Flags(), issuerFetcher)
 	return cmd
 }
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+func (tc *testCert) tlsCertificate(chain ...*x509.Certificate) tls.Certificate {
+	tlsCert := tls.Certificate{
+		Certificate: [][]byte{tc.cert.Raw},
+		PrivateKey:  tc.key,
+		Leaf:        tc.cert,
+	}
+	for _, c := range chain {
+		tlsCert.Certificate = append(tlsCert.Certificate, c.Raw)
+	}
+	return tlsCert
+}
+
+func newTestCert(t *testing.T, opts *pcert.CertificateOptions, signer *testCert) *testCert {
+	t.Helper()
+	tmpl := pcert.NewCertificate(opts)
+	var (
+		signCert *x509.Certificate
+		signKey  crypto.PrivateKey
+	)
+	if signer != nil {
+		signCert = signer.cert
+		signKey = signer.key
+	}
+	der, key, err := pcert.CreateCertificate(tmpl, signCert, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string, signer *testCert) *testCert {
+	t.Helper()
+	return newTestCert(t, &pcert.CertificateOptions{
+		ProfileCA: true,
+		Certificate: x509.Certificate{
+			Subject: pkix.Name{CommonName: name},
+		},
+	}, signer)
+}
+
+func newTestServerCert(t *testing.T, name string, signer *testCert) *testCert {
+	t.Helper()
+	return newTestCert(t, &pcert.CertificateOptions{
+		ProfileServer: true,
+		Certificate: x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			DNSNames:    []string{name},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		},
+	}, signer)
+}
+
+// newTLSServer starts a TLS server which presents the certificate chain
+// tlsCert. The address of the server is returned.
+func newTLSServer(t *testing.T, tlsCert tls.Certificate) string {
+	t.Helper()
+	return newTLSServerWithConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+	})
+}
+
+func newTLSServerWithConfig(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func Test_connect(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	addr := newTLSServer(t, server.tlsCertificate(ca.cert))
+
+	stdout, _, err := runCmd([]string{"connect", "--insecure", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pcert.ParseAll(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(server.cert) {
+		t.Fatalf("server certificate not printed: %s", stdout)
+	}
+
+	stdout, _, err = runCmd([]string{"connect", "--insecure", "--all", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err = pcert.ParseAll(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("wrong number of certificates: got=%d want=%d", len(certs), 2)
+	}
+}
+
+func Test_connect_out_format(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	addr := newTLSServer(t, server.tlsCertificate(ca.cert))
+
+	stdout, _, err := runCmd([]string{"connect", "--insecure", "--all", "--out-format", "p7b", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pcert.ParsePKCS7Certificates(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(server.cert) || !certs[1].Equal(ca.cert) {
+		t.Fatalf("wrong certificates in PKCS#7 output")
+	}
+
+	_, _, err = runCmd([]string{"connect", "--insecure", "--format", "text", "--out-format", "der", addr}, nil, nil)
+	if err == nil {
+		t.Fatal("--out-format der with --format text did not fail")
+	}
+}
+
+func Test_connect_fetch_issuers(t *testing.T) {
+	files := map[string][]byte{}
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer aiaServer.Close()
+
+	root := newTestCA(t, "Root", nil)
+	intermediate := newTestCert(t, &pcert.CertificateOptions{
+		ProfileCA: true,
+		Certificate: x509.Certificate{
+			Subject:               pkix.Name{CommonName: "Intermediate"},
+			IssuingCertificateURL: []string{aiaServer.URL + "/root.crt"},
+		},
+	}, root)
+	server := newTestCert(t, &pcert.CertificateOptions{
+		ProfileServer: true,
+		Certificate: x509.Certificate{
+			Subject:               pkix.Name{CommonName: "localhost"},
+			IssuingCertificateURL: []string{aiaServer.URL + "/intermediate.crt"},
+		},
+	}, intermediate)
+	files["/root.crt"] = pcert.Encode(root.cert.Raw)
+	files["/intermediate.crt"] = intermediate.cert.Raw
+
+	// server sends leaf only
+	addr := newTLSServer(t, server.tlsCertificate())
+
+	stdout, _, err := runCmd([]string{"connect", "--insecure", "--fetch-issuers", "--issuer-cache", "", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pcert.ParseAll(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"localhost", "Intermediate", "Root"}
+	got := []string{}
+	for _, c := range certs {
+		got = append(got, c.Subject.CommonName)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("chain not completed: got=%v want=%v", got, want)
+	}
+}
+
+func Test_connect_fetch_issuers_partial(t *testing.T) {
+	aiaServer := httptest.NewServer(http.NotFoundHandler())
+	defer aiaServer.Close()
+
+	root := newTestCA(t, "Root", nil)
+	intermediate := newTestCert(t, &pcert.CertificateOptions{
+		ProfileCA: true,
+		Certificate: x509.Certificate{
+			Subject:               pkix.Name{CommonName: "Intermediate"},
+			IssuingCertificateURL: []string{aiaServer.URL + "/root.crt"},
+		},
+	}, root)
+	server := newTestServerCert(t, "localhost", intermediate)
+
+	// server sends leaf and intermediate, root is not available
+	addr := newTLSServer(t, server.tlsCertificate(intermediate.cert))
+
+	stdout, _, err := runCmd([]string{"connect", "--insecure", "--fetch-issuers", "--issuer-cache", "", addr}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to complete chain") {
+		t.Fatalf("expected error for incomplete chain: %v", err)
+	}
+	certs, err := pcert.ParseAll(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("partial chain not printed: got=%d certificates want=%d", len(certs), 2)
+	}
+}
+
+// writeTestCert writes the certificate and key of tc to dir and returns the
+// path of the certificate.
+func writeTestCert(t *testing.T, dir, name string, tc *testCert) string {
+	t.Helper()
+	certFile := filepath.Join(dir, name+certFileSuffix)
+	err := os.WriteFile(certFile, pcert.Encode(tc.cert.Raw), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := pcert.EncodeKey(tc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+keyFileSuffix), keyPEM, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile
+}
+
+func Test_connect_ca_and_servername(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+	server := newTestCert(t, &pcert.CertificateOptions{
+		ProfileServer: true,
+		Certificate: x509.Certificate{
+			Subject:  pkix.Name{CommonName: "internal.example.com"},
+			DNSNames: []string{"internal.example.com"},
+		},
+	}, ca)
+	addr := newTLSServer(t, server.tlsCertificate())
+
+	_, _, err := runCmd([]string{"connect", "--ca", caFile, addr}, nil, nil)
+	if err == nil {
+		t.Fatal("verification did not fail for wrong server name")
+	}
+
+	_, _, err = runCmd([]string{"connect", "--ca", caFile, "--servername", "internal.example.com", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_connect_client_cert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	client := newTestCert(t, &pcert.CertificateOptions{
+		ProfileClient: true,
+		Certificate: x509.Certificate{
+			Subject: pkix.Name{CommonName: "client"},
+		},
+	}, ca)
+	clientFile := writeTestCert(t, dir, "client", client)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	// With TLS 1.3 the client handshake completes before the server
+	// rejects a missing client certificate. Use TLS 1.2 to see the
+	// failure during the handshake.
+	addr := newTLSServerWithConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	_, _, err := runCmd([]string{"connect", "--insecure", addr}, nil, nil)
+	if err == nil {
+		t.Fatal("connect without client certificate did not fail")
+	}
+
+	_, _, err = runCmd([]string{"connect", "--insecure", "--cert", clientFile, addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_connect_versions_and_alpn(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	addr := newTLSServerWithConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		MaxVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	})
+
+	_, _, err := runCmd([]string{"connect", "--insecure", "--min-version", "1.3", addr}, nil, nil)
+	if err == nil {
+		t.Fatal("connect with TLS 1.3 to TLS 1.2 server did not fail")
+	}
+
+	_, _, err = runCmd([]string{"connect", "--insecure", "--alpn", "foo", addr}, nil, nil)
+	if err == nil {
+		t.Fatal("connect with unsupported ALPN protocol did not fail")
+	}
+
+	_, _, err = runCmd([]string{"connect", "--insecure", "--max-version", "1.2", "--alpn", "h2", "--cipher", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = runCmd([]string{"connect", "--insecure", "--cipher", "TLS_FOO", addr}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown cipher suite") {
+		t.Fatalf("expected unknown cipher suite error: %v", err)
+	}
+}
+
+func Test_connect_timeout(t *testing.T) {
+	// server which accepts connections but never answers the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	start := time.Now()
+	_, _, err = runCmd([]string{"connect", "--timeout", "200ms", l.Addr().String()}, nil, nil)
+	if err == nil {
+		t.Fatal("connect did not time out")
+	}
+	if time.Since(start) > time.Second*5 {
+		t.Fatalf("timeout not applied")
+	}
+}
+
+// newHTTPProxy starts a HTTP proxy which only supports CONNECT.
+func newHTTPProxy(t *testing.T) string {
+	t.Helper()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT supported", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		pipe(conn, upstream)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy.URL
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy without authentication.
+func newSOCKS5Proxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 262)
+				// greeting
+				_, err := io.ReadFull(conn, buf[:3])
+				if err != nil {
+					conn.Close()
+					return
+				}
+				_, _ = conn.Write([]byte{5, 0})
+				// request with IPv4 address
+				_, err = io.ReadFull(conn, buf[:10])
+				if err != nil || buf[3] != 1 {
+					conn.Close()
+					return
+				}
+				addr := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(buf[8])<<8|int(buf[9])))
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return "socks5://" + l.Addr().String()
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+func Test_connect_proxy(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	addr := newTLSServer(t, server.tlsCertificate())
+
+	for _, proxy := range []string{newHTTPProxy(t), newSOCKS5Proxy(t)} {
+		stdout, _, err := runCmd([]string{"connect", "--insecure", "--proxy", proxy, addr}, nil, nil)
+		if err != nil {
+			t.Fatalf("proxy %s: %s", proxy, err)
+		}
+		cert, err := pcert.Parse(stdout.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(server.cert) {
+			t.Fatalf("proxy %s: wrong certificate", proxy)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+var convertFormats = []string{"pem", "der", "p7b", "pkcs8", "pkcs1", "sec1", "p12", "jwk"}
+
+type convertOptions struct {
+	// To is the output format.
+	To string
+	// Output is the output file. Empty or - writes to stdout.
+	Output string
+	// Password is used to decrypt and encrypt PKCS#12 files.
+	Password string
+
+	// Split is a directory to which each certificate is written to a
+	// separate file.
+	Split string
+	// SplitName is the naming scheme for files created with Split.
+	// Either subject or fingerprint.
+	SplitName string
+}
+
+// convertInput contains all certificates and keys read from the input.
+type convertInput struct {
+	certs []*x509.Certificate
+	keys  []any
+}
+
+func newConvertCmd() *cobra.Command {
+	opts := &convertOptions{
+		To:        "pem",
+		SplitName: "subject",
+	}
+	cmd := &cobra.Command{
+		Use:   "convert [INPUT...]",
+		Short: "Convert certificates and keys between formats",
+		Long: `Convert certificates and keys between formats. The format of the input is
+detected automatically (PEM, DER, base64, PKCS#7, PKCS#12 and JWK). If no INPUT
+is specified the input is read from STDIN.
+
+If multiple inputs are specified, they are merged. Duplicate certificates
+(same fingerprint) and keys are removed.
+
+Output formats:
+  pem    certificates and PKCS#8 keys PEM encoded
+  der    DER encoded certificates (concatenated) or a single PKCS#8 key
+  p7b    certificates in a PKCS#7 bundle
+  pkcs8  keys in PKCS#8 PEM encoding (PRIVATE KEY)
+  pkcs1  RSA keys in PKCS#1 PEM encoding (RSA PRIVATE KEY)
+  sec1   EC keys in SEC 1 PEM encoding (EC PRIVATE KEY)
+  p12    certificates and key in a PKCS#12 file encrypted with --password
+  jwk    a single key as JSON Web Key. Certificates are added as x5c
+
+With --split every certificate is written to its own file in the given
+directory. The files are named by the common name of the subject or by the
+SHA-256 fingerprint (--split-name).`,
+		Example: `  # DER to PEM
+  pcert convert server.der
+
+  # certificate and key to PKCS#12
+  pcert convert --to p12 --password secret -o server.p12 server.crt server.key
+
+  # PKCS#12 to PEM
+  pcert convert --password secret server.p12
+
+  # merge bundles and split them into one file per certificate
+  pcert convert --split certs/ bundle1.pem bundle2.p7b`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"-"}
+			}
+
+			stdin := &stdinKeeper{
+				stdin: cmd.InOrStdin(),
+			}
+			input := &convertInput{}
+			for _, arg := range args {
+				data, err := readStdinOrFile(arg, stdin)
+				if err != nil {
+					return err
+				}
+				err = input.read(data, opts.Password)
+				if err != nil {
+					if isFile(arg) {
+						return fmt.Errorf("%s: %w", arg, err)
+					}
+					return err
+				}
+			}
+
+			if opts.Split != "" {
+				return splitCertificates(input, opts)
+			}
+
+			out, err := input.encode(opts.To, opts.Password)
+			if err != nil {
+				return err
+			}
+			mode := os.FileMode(0o644)
+			if len(input.keys) > 0 {
+				mode = 0o600
+			}
+			return writeStdoutOrFile(opts.Output, out, mode, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVarP(&opts.To, "to", "t", opts.To, "Output format. Valid formats are "+strings.Join(convertFormats, ", ")+".")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", opts.Output, "Output file. If not set the output is written to STDOUT.")
+	cmd.Flags().StringVar(&opts.Password, "password", opts.Password, "Password to decrypt and encrypt PKCS#12 files.")
+	cmd.Flags().StringVar(&opts.Split, "split", opts.Split, "Write each certificate into a separate file in this directory. Only pem and der are supported as output format.")
+	cmd.Flags().StringVar(&opts.SplitName, "split-name", opts.SplitName, "Naming of the files created with --split. Either subject or fingerprint.")
+	_ = cmd.RegisterFlagCompletionFunc("to", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return convertFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = cmd.RegisterFlagCompletionFunc("split-name", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"subject", "fingerprint"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	return cmd
+}
+
+// read adds the certificates and keys of data to the input. Duplicates are
+// skipped.
+func (in *convertInput) read(data []byte, password string) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return errors.New("empty input")
+	case bytes.Contains(trimmed, []byte("-----BEGIN ")):
+		certs, err := pcert.ParseAll(data)
+		if err != nil {
+			return err
+		}
+		in.addCerts(certs...)
+		found := len(certs) > 0
+		rest := data
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				continue
+			}
+			key, err := pcert.ParseKey(pem.EncodeToMemory(block))
+			if err != nil {
+				return err
+			}
+			in.addKey(key)
+			found = true
+		}
+		if !found {
+			return errors.New("no certificates or private keys found in PEM data")
+		}
+	case trimmed[0] == '{':
+		jwk, err := pcert.ParseJWK(trimmed)
+		if err != nil {
+			return err
+		}
+		if !jwk.IsPrivate() {
+			return errors.New("JWK contains no private key")
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			return err
+		}
+		in.addKey(key)
+		for _, x5c := range jwk.X5c {
+			der, err := base64.StdEncoding.DecodeString(x5c)
+			if err != nil {
+				return fmt.Errorf("invalid x5c in JWK: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			in.addCerts(cert)
+		}
+	default:
+		if certs, err := pcert.ParseAll(data); err == nil && len(certs) > 0 {
+			in.addCerts(certs...)
+			return nil
+		}
+		if key, err := pcert.ParseKey(data); err == nil {
+			in.addKey(key)
+			return nil
+		}
+		key, certs, err := pcert.DecodePKCS12(data, password)
+		if err != nil {
+			return fmt.Errorf("unknown input format: %w", err)
+		}
+		if key != nil {
+			in.addKey(key)
+		}
+		in.addCerts(certs...)
+	}
+	return nil
+}
+
+func (in *convertInput) addCerts(certs ...*x509.Certificate) {
+	for _, cert := range certs {
+		duplicate := false
+		for _, existing := range in.certs {
+			if existing.Equal(cert) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			in.certs = append(in.certs, cert)
+		}
+	}
+}
+
+func (in *convertInput) addKey(key any) {
+	for _, existing := range in.keys {
+		if k, ok := existing.(interface{ Equal(x any) bool }); ok && k.Equal(key) {
+			return
+		}
+	}
+	in.keys = append(in.keys, key)
+}
+
+// chainForKey returns the certificates with the certificate which belongs to
+// key first.
+func (in *convertInput) chainForKey(key any) []*x509.Certificate {
+	chain := append([]*x509.Certificate{}, in.certs...)
+	for i, cert := range chain {
+		if pcert.KeyMatchesCertificate(key, cert) {
+			chain[0], chain[i] = chain[i], chain[0]
+			break
+		}
+	}
+	return chain
+}
+
+func (in *convertInput) encode(format, password string) ([]byte, error) {
+	switch format {
+	case "pem":
+		out, err := encodeCertificates(outFormatPEM, in.certs...)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range in.keys {
+			keyPEM, err := pcert.EncodeKey(key)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, keyPEM...)
+		}
+		return out, nil
+	case "der":
+		switch {
+		case len(in.keys) == 0:
+			return encodeCertificates(outFormatDER, in.certs...)
+		case len(in.certs) == 0 && len(in.keys) == 1:
+			return x509.MarshalPKCS8PrivateKey(in.keys[0])
+		default:
+			return nil, errors.New("der output can contain either certificates or a single key")
+		}
+	case "p7b":
+		if len(in.keys) > 0 {
+			return nil, errors.New("p7b output can not contain keys")
+		}
+		return encodeCertificates(outFormatP7B, in.certs...)
+	case "pkcs8", "pkcs1", "sec1":
+		return in.encodeKeys(format)
+	case "p12":
+		if len(in.keys) > 1 {
+			return nil, errors.New("p12 output can contain only one key")
+		}
+		var key any
+		if len(in.keys) == 1 {
+			key = in.keys[0]
+		}
+		return pcert.EncodePKCS12(key, in.chainForKey(key), password)
+	case "jwk":
+		if len(in.keys) != 1 {
+			return nil, fmt.Errorf("jwk output requires exactly one key but input contains %d", len(in.keys))
+		}
+		jwk, err := pcert.NewJWK(in.keys[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range in.chainForKey(in.keys[0]) {
+			jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		out, err := json.MarshalIndent(jwk, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s'. valid formats are %s", format, strings.Join(convertFormats, ", "))
+	}
+}
+
+func (in *convertInput) encodeKeys(format string) ([]byte, error) {
+	if len(in.certs) > 0 {
+		return nil, fmt.Errorf("%s output can only contain keys but input contains certificates", format)
+	}
+	if len(in.keys) == 0 {
+		return nil, errors.New("input contains no keys")
+	}
+	var out []byte
+	for _, key := range in.keys {
+		var (
+			keyPEM []byte
+			err    error
+		)
+		switch format {
+		case "pkcs1":
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("pkcs1 only supports RSA keys but got %T", key)
+			}
+			keyPEM = pcert.EncodeKeyPKCS1(rsaKey)
+		case "sec1":
+			ecKey, ok := key.(*ecdsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("sec1 only supports EC keys but got %T", key)
+			}
+			keyPEM, err = pcert.EncodeKeySEC1(ecKey)
+		default:
+			keyPEM, err = pcert.EncodeKey(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, keyPEM...)
+	}
+	return out, nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// certFingerprint returns the hex encoded SHA-256 fingerprint of cert.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func splitCertificates(input *convertInput, opts *convertOptions) error {
+	if len(input.keys) > 0 {
+		return errors.New("--split only supports certificates but input contains keys")
+	}
+	if opts.To != "pem" && opts.To != "der" {
+		return fmt.Errorf("--split only supports the formats pem and der")
+	}
+	if opts.SplitName != "subject" && opts.SplitName != "fingerprint" {
+		return fmt.Errorf("unknown --split-name '%s'. valid values are subject and fingerprint", opts.SplitName)
+	}
+
+	err := os.MkdirAll(opts.Split, 0o755)
+	if err != nil {
+		return err
+	}
+
+	ext := certFileSuffix
+	if opts.To == "der" {
+		ext = ".der"
+	}
+	used := map[string]bool{}
+	for _, cert := range input.certs {
+		name := certFingerprint(cert)
+		if opts.SplitName == "subject" {
+			cn := strings.Trim(unsafeFileNameChars.ReplaceAllString(cert.Subject.CommonName, "_"), "._")
+			if cn != "" {
+				name = cn
+				if used[name] {
+					name += "_" + certFingerprint(cert)[:8]
+				}
+			}
+		}
+		used[name] = true
+
+		data, err := encodeCertificates(opts.To, cert)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(opts.Split, name+ext), data, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -36,6 +36,10 @@ type createOptions struct {
 
 	// KeyOptions are the key settings.
 	KeyOptions pcert.KeyOptions
+
+	// OutFormat is the format in which the certificate is written (pem,
+	// der or p7b). The key is always written PEM encoded.
+	OutFormat string
 }
 
 func getKeyRelativeToFile(certPath string) string {
@@ -97,6 +101,10 @@ with .key.
 				}
 			}
 
+			err := checkSPIFFEFlags(cmd.Flags())
+			if err != nil {
+				return err
+			}
 			certTemplate := pcert.NewCertificate(&opts.CertificateOptions)
 
 			privateKey, publicKey, err := pcert.GenerateKey(opts.KeyOptions)
@@ -139,13 +147,26 @@ with .key.
 				return err
 			}
 
-			certPEM := pcert.Encode(certDER)
+			cert, err := x509.ParseCertificate(certDER)
+			if err != nil {
+				return err
+			}
+			if opts.CertificateOptions.ProfileSPIFFE {
+				_, err = pcert.CheckX509SVID(cert)
+				if err != nil {
+					return err
+				}
+			}
+			certOut, err := encodeCertificates(opts.OutFormat, cert)
+			if err != nil {
+				return err
+			}
 			keyPEM, err := pcert.EncodeKey(privateKey)
 			if err != nil {
 				return err
 			}
 
-			err = writeStdoutOrFile(opts.Cert, certPEM, 0o644, cmd.OutOrStdout())
+			err = writeStdoutOrFile(opts.Cert, certOut, 0o644, cmd.OutOrStdout())
 			if err != nil {
 				return err
 			}
@@ -161,6 +182,9 @@ with .key.
 	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "Certificate used to sign. If not specified a self-signed certificate is created")
 	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key used to sign. If not specified but --sign-cert is specified we use the key file relative to the certificate specified with --sign-cert.")
 
+	cmd.Flags().Var(newOutFormatValue(&opts.OutFormat), "out-format", "Format of the certificate output (pem, der or p7b). The key is always PEM encoded.")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
+
 	registerCertFlags(cmd, &opts.CertificateOptions)
 	registerKeyFlags(cmd, &opts.KeyOptions)
 	return cmd
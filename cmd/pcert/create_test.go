@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -237,3 +239,39 @@ func Test_create_output_parameter(t *testing.T) {
 		t.Errorf("could not load key: %s", err)
 	}
 }
+
+func Test_create_out_format(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{"der", "p7b"} {
+		certFile := filepath.Join(dir, format+".crt")
+		_, _, err := runCmd([]string{"create", "--out-format", format, certFile}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			t.Errorf("%s: certificate is PEM encoded", format)
+		}
+
+		// input is detected automatically
+		stdout, _, err := runCmd([]string{"show", certFile}, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !strings.Contains(stdout.String(), "subject:") {
+			t.Errorf("%s: unexpected show output: %s", format, stdout)
+		}
+		_, _, err = runCmd([]string{"create", "--sign-cert", certFile}, nil, nil)
+		if err != nil {
+			t.Errorf("%s: could not sign with certificate: %s", format, err)
+		}
+	}
+
+	_, _, err := runCmd([]string{"create", "--out-format", "jks"}, nil, nil)
+	if err == nil {
+		t.Error("invalid output format did not fail")
+	}
+}
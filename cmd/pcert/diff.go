@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+// diffSecurityExitCode is the exit code of the diff command if security
+// relevant fields differ.
+const diffSecurityExitCode = 2
+
+// certDifference is a difference of a field of two certificates. For single
+// value fields Old and New are set, for lists Added and Removed.
+type certDifference struct {
+	Field            string   `json:"field"`
+	Old              string   `json:"old,omitempty"`
+	New              string   `json:"new,omitempty"`
+	Added            []string `json:"added,omitempty"`
+	Removed          []string `json:"removed,omitempty"`
+	SecurityRelevant bool     `json:"security_relevant"`
+}
+
+type certDiffResult struct {
+	SecurityRelevant bool             `json:"security_relevant"`
+	Differences      []certDifference `json:"differences"`
+}
+
+func newDiffCmd() *cobra.Command {
+	var (
+		opts   = newConnectOptions()
+		format = "text"
+	)
+	cmd := &cobra.Command{
+		Use:   "diff <FILE|host:port> <FILE|host:port>",
+		Short: "Compare two certificates field by field",
+		Long: `Compare two certificates field by field and print the differences. An
+argument is either a file (or - for STDIN) or the address of an endpoint whose
+server certificate is compared. For endpoints all options of connect can be
+used.
+
+Differences in subject, issuer, key algorithm and size, signature algorithm,
+key usage, extended key usage, basic constraints, SANs, name constraints,
+policies, critical extensions and values of critical extensions are security
+relevant. They are marked with ! in the text output. Differences in serial,
+validity, key, key identifiers and URLs are expected on renewal and are not.
+Certificates are only reported as identical if they are byte-equal.
+
+The exit code is 0 if no security relevant fields differ, 2 if they differ and
+1 on errors.`,
+		Example: `  # compare a renewed certificate with the old one
+  pcert diff old.crt new.crt
+
+  # compare a local certificate with the one a server presents
+  pcert diff tls.crt example.com:443`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format '%s'. valid formats are text and json", format)
+			}
+
+			err := opts.loadFiles()
+			if err != nil {
+				return err
+			}
+
+			stdin := &stdinKeeper{stdin: cmd.InOrStdin()}
+			var certs [2]*x509.Certificate
+			for i, arg := range args {
+				certs[i], err = loadDiffCertificate(cmd, arg, stdin, opts)
+				if err != nil {
+					return err
+				}
+			}
+
+			result := diffCertificates(certs[0], certs[1])
+			if format == "json" {
+				err = printDiffJSON(cmd.OutOrStdout(), result)
+			} else {
+				err = printDiffText(cmd.OutOrStdout(), result)
+			}
+			if err != nil {
+				return err
+			}
+
+			if result.SecurityRelevant {
+				return &exitCodeError{
+					code: diffSecurityExitCode,
+					err:  errors.New("security relevant fields differ"),
+				}
+			}
+			return nil
+		},
+	}
+	registerConnectFlags(cmd, opts)
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Output format. Valid formats are text and json.")
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveDefault
+	})
+	return cmd
+}
+
+// loadDiffCertificate returns the first certificate of a file or the server
+// certificate of an endpoint.
+func loadDiffCertificate(cmd *cobra.Command, arg string, stdin *stdinKeeper, opts *connectOptions) (*x509.Certificate, error) {
+	if arg == "-" || fileExists(arg) {
+		data, err := readStdinOrFile(arg, stdin)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := pcert.ParseAll(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificates found in '%s'", arg)
+		}
+		return certs[0], nil
+	}
+
+	if _, _, err := net.SplitHostPort(arg); err != nil {
+		return nil, fmt.Errorf("'%s' is neither a file nor an address", arg)
+	}
+	conn, err := dialTLS(cmd.Context(), arg, opts)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+type certDiffer struct {
+	result certDiffResult
+}
+
+func (d *certDiffer) value(field, oldValue, newValue string, security bool) {
+	if oldValue == newValue {
+		return
+	}
+	d.add(certDifference{
+		Field:            field,
+		Old:              oldValue,
+		New:              newValue,
+		SecurityRelevant: security,
+	})
+}
+
+func (d *certDiffer) list(field string, oldValues, newValues []string, security bool) {
+	added, removed := diffLists(oldValues, newValues)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	d.add(certDifference{
+		Field:            field,
+		Added:            added,
+		Removed:          removed,
+		SecurityRelevant: security,
+	})
+}
+
+func (d *certDiffer) add(diff certDifference) {
+	d.result.Differences = append(d.result.Differences, diff)
+	d.result.SecurityRelevant = d.result.SecurityRelevant || diff.SecurityRelevant
+}
+
+// diffLists returns the values which are only in newValues (added) and only
+// in oldValues (removed).
+func diffLists(oldValues, newValues []string) (added, removed []string) {
+	contains := func(values []string, value string) bool {
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	for _, value := range newValues {
+		if !contains(oldValues, value) {
+			added = append(added, value)
+		}
+	}
+	for _, value := range oldValues {
+		if !contains(newValues, value) {
+			removed = append(removed, value)
+		}
+	}
+	return added, removed
+}
+
+func diffCertificates(oldCert, newCert *x509.Certificate) certDiffResult {
+	o := pcert.NewCertificateJSON(oldCert)
+	n := pcert.NewCertificateJSON(newCert)
+	d := &certDiffer{
+		result: certDiffResult{
+			Differences: []certDifference{},
+		},
+	}
+
+	d.value("subject", o.Subject.String, n.Subject.String, true)
+	d.value("issuer", o.Issuer.String, n.Issuer.String, true)
+	d.value("serial_number", o.SerialNumber, n.SerialNumber, false)
+	d.value("not_before", o.NotBefore.Format(time.RFC3339), n.NotBefore.Format(time.RFC3339), false)
+	d.value("not_after", o.NotAfter.Format(time.RFC3339), n.NotAfter.Format(time.RFC3339), false)
+	d.value("validity", oldCert.NotAfter.Sub(oldCert.NotBefore).String(), newCert.NotAfter.Sub(newCert.NotBefore).String(), false)
+
+	d.value("public_key.algorithm", o.PublicKey.Algorithm, n.PublicKey.Algorithm, true)
+	d.value("public_key.size", keySizeString(o.PublicKey.Size), keySizeString(n.PublicKey.Size), true)
+	d.value("public_key.curve", o.PublicKey.Curve, n.PublicKey.Curve, true)
+	d.value("public_key.pin_sha256", o.Fingerprints.PinSHA256, n.Fingerprints.PinSHA256, false)
+	d.value("signature_algorithm", o.SignatureAlgorithm, n.SignatureAlgorithm, true)
+
+	d.list("key_usage", o.KeyUsage, n.KeyUsage, true)
+	d.list("extended_key_usage", o.ExtendedKeyUsage, n.ExtendedKeyUsage, true)
+	d.value("basic_constraints", basicConstraintsString(o.BasicConstraints), basicConstraintsString(n.BasicConstraints), true)
+
+	d.list("san.dns", o.SubjectAltNames.DNS, n.SubjectAltNames.DNS, true)
+	d.list("san.ip", o.SubjectAltNames.IP, n.SubjectAltNames.IP, true)
+	d.list("san.email", o.SubjectAltNames.Email, n.SubjectAltNames.Email, true)
+	d.list("san.uri", o.SubjectAltNames.URI, n.SubjectAltNames.URI, true)
+
+	oldNC, newNC := nameConstraintsOrEmpty(o.NameConstraints), nameConstraintsOrEmpty(n.NameConstraints)
+	d.value("name_constraints.critical", strconv.FormatBool(oldNC.Critical), strconv.FormatBool(newNC.Critical), true)
+	d.list("name_constraints.permitted_dns", oldNC.PermittedDNS, newNC.PermittedDNS, true)
+	d.list("name_constraints.excluded_dns", oldNC.ExcludedDNS, newNC.ExcludedDNS, true)
+	d.list("name_constraints.permitted_ip", oldNC.PermittedIP, newNC.PermittedIP, true)
+	d.list("name_constraints.excluded_ip", oldNC.ExcludedIP, newNC.ExcludedIP, true)
+	d.list("name_constraints.permitted_email", oldNC.PermittedEmail, newNC.PermittedEmail, true)
+	d.list("name_constraints.excluded_email", oldNC.ExcludedEmail, newNC.ExcludedEmail, true)
+	d.list("name_constraints.permitted_uri", oldNC.PermittedURI, newNC.PermittedURI, true)
+	d.list("name_constraints.excluded_uri", oldNC.ExcludedURI, newNC.ExcludedURI, true)
+
+	d.list("policies", o.Policies, n.Policies, true)
+	d.value("subject_key_id", o.SubjectKeyID, n.SubjectKeyID, false)
+	d.value("authority_key_id", o.AuthorityKeyID, n.AuthorityKeyID, false)
+	d.list("authority_info_access.ocsp", o.AuthorityInfoAccess.OCSP, n.AuthorityInfoAccess.OCSP, false)
+	d.list("authority_info_access.issuing_certificate", o.AuthorityInfoAccess.IssuingCertificate, n.AuthorityInfoAccess.IssuingCertificate, false)
+	d.list("crl_distribution_points", o.CRLDistributionPoints, n.CRLDistributionPoints, false)
+
+	// adding or removing critical extensions or changing the criticality
+	// is security relevant
+	oldExts, newExts := extensionStrings(o.Extensions), extensionStrings(n.Extensions)
+	added, removed := diffLists(oldExts, newExts)
+	if len(added) > 0 || len(removed) > 0 {
+		critical := false
+		for _, ext := range append(added, removed...) {
+			critical = critical || strings.HasSuffix(ext, "critical)")
+		}
+		d.add(certDifference{
+			Field:            "extensions",
+			Added:            added,
+			Removed:          removed,
+			SecurityRelevant: critical,
+		})
+	}
+	d.extensionValues(o.Extensions, n.Extensions)
+
+	// fields which are not compared above (e.g. the encoding of names) or
+	// the signature can still differ
+	if len(d.result.Differences) == 0 && !bytes.Equal(oldCert.Raw, newCert.Raw) {
+		if bytes.Equal(oldCert.RawTBSCertificate, newCert.RawTBSCertificate) {
+			d.value("signature", o.Signature, n.Signature, false)
+		} else {
+			d.value("fingerprint_sha256", o.Fingerprints.SHA256, n.Fingerprints.SHA256, true)
+		}
+	}
+
+	return d.result
+}
+
+// extensionFields maps the OIDs of the extensions which are compared by
+// their fields to the prefix of the fields and whether they are security
+// relevant.
+var extensionFields = map[string]struct {
+	field    string
+	security bool
+}{
+	"2.5.29.14":         {"subject_key_id", false},
+	"2.5.29.15":         {"key_usage", true},
+	"2.5.29.17":         {"san.", true},
+	"2.5.29.19":         {"basic_constraints", true},
+	"2.5.29.30":         {"name_constraints.", true},
+	"2.5.29.31":         {"crl_distribution_points", false},
+	"2.5.29.32":         {"policies", true},
+	"2.5.29.35":         {"authority_key_id", false},
+	"2.5.29.37":         {"extended_key_usage", true},
+	"1.3.6.1.5.5.7.1.1": {"authority_info_access.", false},
+}
+
+// extensionValues compares the values of the extensions which are in both
+// certificates. Changed values of extensions which are compared by their
+// fields are only listed if no difference of the fields was found, since not
+// all parts of these extensions are represented in the fields.
+func (d *certDiffer) extensionValues(oldExts, newExts []pcert.ExtensionJSON) {
+	newValues := map[string]pcert.ExtensionJSON{}
+	for _, ext := range newExts {
+		newValues[ext.OID] = ext
+	}
+	for _, oldExt := range oldExts {
+		newExt, ok := newValues[oldExt.OID]
+		if !ok || oldExt.Value == newExt.Value {
+			continue
+		}
+		security := oldExt.Critical || newExt.Critical
+		if known, ok := extensionFields[oldExt.OID]; ok {
+			if d.hasField(known.field) {
+				continue
+			}
+			security = security || known.security
+		}
+		d.value("extensions."+oldExt.OID, oldExt.Value, newExt.Value, security)
+	}
+}
+
+func (d *certDiffer) hasField(prefix string) bool {
+	for _, diff := range d.result.Differences {
+		if strings.HasPrefix(diff.Field, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func keySizeString(size int) string {
+	if size == 0 {
+		return ""
+	}
+	return strconv.Itoa(size)
+}
+
+func basicConstraintsString(bc *pcert.BasicConstraintsJSON) string {
+	if bc == nil {
+		return ""
+	}
+	s := "CA:" + strconv.FormatBool(bc.IsCA)
+	if bc.MaxPathLen != nil {
+		s += " pathLen:" + strconv.Itoa(*bc.MaxPathLen)
+	}
+	return s
+}
+
+func nameConstraintsOrEmpty(nc *pcert.NameConstraintsJSON) *pcert.NameConstraintsJSON {
+	if nc == nil {
+		return &pcert.NameConstraintsJSON{}
+	}
+	return nc
+}
+
+// extensionStrings returns the extensions in the form "OID (name, critical)".
+func extensionStrings(extensions []pcert.ExtensionJSON) []string {
+	out := []string{}
+	for _, ext := range extensions {
+		attrs := []string{}
+		if ext.Name != "" {
+			attrs = append(attrs, ext.Name)
+		}
+		if ext.Critical {
+			attrs = append(attrs, "critical")
+		}
+		s := ext.OID
+		if len(attrs) > 0 {
+			s += " (" + strings.Join(attrs, ", ") + ")"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func printDiffText(w io.Writer, result certDiffResult) error {
+	if len(result.Differences) == 0 {
+		_, err := fmt.Fprintln(w, "certificates are identical")
+		return err
+	}
+	sb := &strings.Builder{}
+	for _, diff := range result.Differences {
+		marker := " "
+		if diff.SecurityRelevant {
+			marker = "!"
+		}
+		if diff.Added == nil && diff.Removed == nil {
+			fmt.Fprintf(sb, "%s %s: %s -> %s\n", marker, diff.Field, diffValue(diff.Old), diffValue(diff.New))
+			continue
+		}
+		for _, value := range diff.Removed {
+			fmt.Fprintf(sb, "%s %s: - %s\n", marker, diff.Field, value)
+		}
+		for _, value := range diff.Added {
+			fmt.Fprintf(sb, "%s %s: + %s\n", marker, diff.Field, value)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func diffValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func printDiffJSON(w io.Writer, result certDiffResult) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_diff(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	dir := t.TempDir()
+	caFile := writeTestCert(t, dir, "ca", ca)
+	old := newTestServerCert(t, "localhost", ca)
+	oldFile := writeTestCert(t, dir, "old", old)
+	renewedFile := writeTestCert(t, dir, "renewed", newTestServerCert(t, "localhost", ca))
+	changed := newTestCert(t, &pcert.CertificateOptions{
+		ProfileServer: true,
+		Certificate: x509.Certificate{
+			Subject:  pkix.Name{CommonName: "localhost"},
+			DNSNames: []string{"localhost", "www.example.com"},
+		},
+	}, ca)
+	changedFile := writeTestCert(t, dir, "changed", changed)
+
+	diff := func(args ...string) (string, int) {
+		stdout := &bytes.Buffer{}
+		code := run(append([]string{"diff"}, args...), nil, stdout, &bytes.Buffer{}, func(string) (string, bool) { return "", false })
+		return stdout.String(), code
+	}
+
+	// a renewal only changes fields which are not security relevant
+	out, code := diff(oldFile, renewedFile)
+	if code != 0 {
+		t.Errorf("renewal: got exit code %d want 0:\n%s", code, out)
+	}
+	for _, expected := range []string{"  serial_number: ", "  public_key.pin_sha256: "} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("renewal: '%s' not found in output:\n%s", expected, out)
+		}
+	}
+
+	out, code = diff(oldFile, changedFile)
+	if code != diffSecurityExitCode {
+		t.Errorf("changed SANs: got exit code %d want %d:\n%s", code, diffSecurityExitCode, out)
+	}
+	for _, expected := range []string{"! san.dns: + www.example.com", "! san.ip: - 127.0.0.1"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("changed SANs: '%s' not found in output:\n%s", expected, out)
+		}
+	}
+
+	out, code = diff("--format", "json", oldFile, changedFile)
+	if code != diffSecurityExitCode {
+		t.Errorf("json: got exit code %d want %d", code, diffSecurityExitCode)
+	}
+	result := certDiffResult{}
+	err := json.Unmarshal([]byte(out), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.SecurityRelevant || len(result.Differences) == 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// local file against an endpoint
+	addr := newTLSServer(t, old.tlsCertificate())
+	out, code = diff("--ca", caFile, oldFile, addr)
+	if code != 0 || !strings.Contains(out, "certificates are identical") {
+		t.Errorf("endpoint: got exit code %d:\n%s", code, out)
+	}
+
+	_, code = diff(oldFile, filepath.Join(dir, "missing.crt"))
+	if code != 1 {
+		t.Errorf("missing file: got exit code %d want 1", code)
+	}
+
+	// certificates with the same key which only differ in the value of an
+	// extension or in the signature
+	resign := func(name string, value []byte, critical bool) string {
+		tmpl := *old.cert
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: critical, Value: value}}
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, ca.cert, old.cert.PublicKey, ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeTestCert(t, dir, name, &testCert{cert: cert, key: old.key})
+	}
+	extA := resign("ext-a", []byte{0x04, 0x01, 'a'}, false)
+	out, code = diff(extA, resign("ext-b", []byte{0x04, 0x01, 'b'}, false))
+	if code != 0 || !strings.Contains(out, "  extensions.1.2.3.4: ") {
+		t.Errorf("changed extension value: got exit code %d:\n%s", code, out)
+	}
+	out, code = diff(resign("critical-a", []byte{0x04, 0x01, 'a'}, true), resign("critical-b", []byte{0x04, 0x01, 'b'}, true))
+	if code != diffSecurityExitCode || !strings.Contains(out, "! extensions.1.2.3.4: ") {
+		t.Errorf("changed critical extension value: got exit code %d:\n%s", code, out)
+	}
+	out, code = diff(extA, resign("ext-a2", []byte{0x04, 0x01, 'a'}, false))
+	if code != 0 || !strings.Contains(out, "  signature: ") {
+		t.Errorf("changed signature: got exit code %d:\n%s", code, out)
+	}
+}
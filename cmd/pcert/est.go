@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newESTCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "est",
+		Short: "EST (RFC 7030) server and client",
+	}
+	cmd.AddCommand(
+		newESTServeCmd(),
+		newESTEnrollCmd(),
+		newESTCACertsCmd(),
+	)
+	return cmd
+}
+
+type estServeOptions struct {
+	Listen    string
+	SignCert  string
+	SignKey   string
+	TLSCert   string
+	TLSKey    string
+	Hostnames []string
+	ClientCAs []string
+	Users     []string
+	Server    pcert.ESTServer
+}
+
+func newESTServeCmd() *cobra.Command {
+	opts := &estServeOptions{
+		Listen:    ":8443",
+		SignCert:  "ca.crt",
+		Hostnames: []string{"localhost"},
+		Server: pcert.ESTServer{
+			CertificateOptions: pcert.CertificateOptions{
+				ProfileClient: true,
+			},
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run an EST server which signs CSRs with a local CA",
+		Long: `Run an EST (RFC 7030) server which signs CSRs with a CA created with
+'pcert create --ca'. The operations cacerts, simpleenroll, simplereenroll and
+csrattrs are served under /.well-known/est over HTTPS.
+
+Clients authenticate with a client certificate issued by the CA (or by a CA
+set with --client-ca) or with HTTP basic authentication for the users set
+with --user. Re-enrollment requires a client certificate with the same subject
+and subject alternative names as the CSR.
+
+The certificates are created like with 'pcert sign'. The subject and names are
+taken from the CSR. If no --tls-cert is set a server certificate for
+--hostname is issued by the CA.`,
+		Example: `  # enroll with basic authentication or certificates issued by ca.crt
+  pcert est serve --user device:secret
+
+  # issue server certificates valid for 30 days
+  pcert est serve --server --client=false --expiry 30d`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tlsConfig, err := opts.load()
+			if err != nil {
+				return err
+			}
+			server := &http.Server{
+				Addr:              opts.Listen,
+				Handler:           &opts.Server,
+				TLSConfig:         tlsConfig,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = server.Shutdown(shutdownCtx)
+			}()
+
+			printServerURL(cmd.ErrOrStderr(), "EST server", "https", opts.Listen, opts.Hostnames, pcert.ESTPathPrefix)
+			err = server.ListenAndServeTLS("", "")
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the server listens.")
+	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "CA certificate used to sign the certificates. Further certificates in the file are returned by cacerts.")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key of the CA. If not set the key file relative to --sign-cert is used.")
+	cmd.Flags().StringVar(&opts.TLSCert, "tls-cert", opts.TLSCert, "Server certificate. If not set a certificate for --hostname is issued by the CA.")
+	cmd.Flags().StringVar(&opts.TLSKey, "tls-key", opts.TLSKey, "Key of the server certificate. If not set the key file relative to --tls-cert is used.")
+	cmd.Flags().StringSliceVar(&opts.Hostnames, "hostname", opts.Hostnames, "Names of the server certificate if --tls-cert is not set.")
+	cmd.Flags().StringSliceVar(&opts.ClientCAs, "client-ca", opts.ClientCAs, "Additional CA certificates to verify client certificates. Certificates of the signing CA are always accepted.")
+	cmd.Flags().StringSliceVar(&opts.Users, "user", opts.Users, "User for basic authentication in the form NAME:PASSWORD.")
+	cmd.Flags().BoolVar(&opts.Server.CertificateOptions.ProfileServer, "server", opts.Server.CertificateOptions.ProfileServer, "Issue certificates with settings typical for a server certificate.")
+	cmd.Flags().BoolVar(&opts.Server.CertificateOptions.ProfileClient, "client", opts.Server.CertificateOptions.ProfileClient, "Issue certificates with settings typical for a client certificate.")
+	cmd.Flags().Var(newDurationValue(&opts.Server.CertificateOptions.Expiry), "expiry", "Validity period of the issued certificates.")
+	return cmd
+}
+
+// load reads the CA and the users and returns the TLS configuration of the
+// server.
+func (o *estServeOptions) load() (*tls.Config, error) {
+	ca, err := loadSigningCA(o.SignCert, o.SignKey)
+	if err != nil {
+		return nil, err
+	}
+	o.Server.CACert = ca.Cert
+	o.Server.CAKey = ca.Key
+	o.Server.Chain = ca.Chain
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Cert)
+	for _, cert := range ca.Chain {
+		clientCAs.AddCert(cert)
+	}
+	for _, file := range o.ClientCAs {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := pcert.ParseAll(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificates found in '%s'", file)
+		}
+		for _, cert := range certs {
+			clientCAs.AddCert(cert)
+		}
+	}
+
+	users := map[string]string{}
+	for _, user := range o.Users {
+		name, password, ok := strings.Cut(user, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid user '%s'. format is NAME:PASSWORD", user)
+		}
+		users[name] = password
+	}
+	if len(users) > 0 {
+		o.Server.BasicAuth = func(username, password string) bool {
+			expected, ok := users[username]
+			return ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+		}
+	}
+
+	tlsCert, err := ca.serverCertificate(o.TLSCert, o.TLSKey, o.Hostnames)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*tlsCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}, nil
+}
+
+type estClientOptions struct {
+	Server   string
+	Username string
+	Password string
+	Connect  *connectOptions
+}
+
+func newESTClientOptions() *estClientOptions {
+	return &estClientOptions{
+		Connect: newConnectOptions(),
+	}
+}
+
+func registerESTClientFlags(cmd *cobra.Command, opts *estClientOptions) {
+	cmd.Flags().StringVar(&opts.Server, "server", opts.Server, "URL of the EST server (e.g. https://est.example.com:8443). If the URL has no path /.well-known/est is used.")
+	cmd.Flags().StringVar(&opts.Username, "username", opts.Username, "Username for basic authentication.")
+	cmd.Flags().StringVar(&opts.Password, "password", opts.Password, "Password for basic authentication.")
+	cmd.Flags().StringSliceVar(&opts.Connect.CACerts, "ca", opts.Connect.CACerts, "File with CA certificates to verify the server certificate. If not set the system trust store is used.")
+	cmd.Flags().BoolVar(&opts.Connect.TLSConfig.InsecureSkipVerify, "insecure", opts.Connect.TLSConfig.InsecureSkipVerify, "Do not verify the server certificate (e.g. to bootstrap the CA certificates).")
+	cmd.Flags().StringVar(&opts.Connect.ClientCert, "cert", opts.Connect.ClientCert, "Client certificate for the authentication.")
+	cmd.Flags().StringVar(&opts.Connect.ClientKey, "key", opts.Connect.ClientKey, "Key of the client certificate. If not specified but --cert is specified we use the key file relative to the certificate specified with --cert.")
+	cmd.Flags().DurationVar(&opts.Connect.Timeout, "timeout", opts.Connect.Timeout, "Timeout of the requests.")
+}
+
+func (o *estClientOptions) client() (*pcert.ESTClient, error) {
+	if o.Server == "" {
+		return nil, errors.New("--server is required")
+	}
+	err := o.Connect.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = o.Connect.TLSConfig
+	return &pcert.ESTClient{
+		URL:      o.Server,
+		Username: o.Username,
+		Password: o.Password,
+		HTTPClient: &http.Client{
+			Transport: transport,
+			Timeout:   o.Connect.Timeout,
+		},
+	}, nil
+}
+
+type estEnrollOptions struct {
+	Cert      string
+	Key       string
+	Reenroll  bool
+	OutFormat string
+	Client    *estClientOptions
+
+	KeyOptions         pcert.KeyOptions
+	CertificateRequest x509.CertificateRequest
+}
+
+func newESTEnrollCmd() *cobra.Command {
+	opts := &estEnrollOptions{
+		Client: newESTClientOptions(),
+	}
+	cmd := &cobra.Command{
+		Use:   "enroll [CERT-OUT [KEY-OUT]]",
+		Short: "Obtain a certificate from an EST server",
+		Long: `Creates a key and CSR like 'pcert request' and sends the CSR to an EST
+(RFC 7030) server. If CERT-OUT and KEY-OUT are specified the certificate and
+key are stored in the respective files. If only CERT-OUT is specified the key
+is stored in the same directory in a file ending with .key.
+
+With --reenroll an existing certificate set with --cert is renewed with a new
+key. The subject and names of the CSR are taken from the certificate if they
+are not set.`,
+		Example: `  # enroll with basic authentication
+  pcert est enroll --server https://est.example.com:8443 --ca ca.crt --username device --password secret --subject /CN=device-1 device.crt
+
+  # renew the certificate
+  pcert est enroll --server https://est.example.com:8443 --ca ca.crt --cert device.crt --reenroll device.crt`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 && args[0] != "-" {
+				opts.Cert = args[0]
+				opts.Key = getKeyRelativeToFile(args[0])
+			}
+			if len(args) == 2 {
+				opts.Cert = args[0]
+				opts.Key = args[1]
+			}
+			client, err := opts.Client.client()
+			if err != nil {
+				return err
+			}
+
+			csr := &opts.CertificateRequest
+			if opts.Reenroll {
+				tlsCerts := opts.Client.Connect.TLSConfig.Certificates
+				if len(tlsCerts) == 0 {
+					return errors.New("--reenroll requires --cert")
+				}
+				copyCertificateNames(csr, tlsCerts[0].Leaf)
+			}
+			csrDER, privateKey, err := pcert.CreateRequestWithKeyOptions(csr, opts.KeyOptions)
+			if err != nil {
+				return err
+			}
+
+			var cert *x509.Certificate
+			if opts.Reenroll {
+				cert, err = client.Reenroll(cmd.Context(), csrDER)
+			} else {
+				cert, err = client.Enroll(cmd.Context(), csrDER)
+			}
+			if err != nil {
+				return err
+			}
+
+			certOut, err := encodeCertificates(opts.OutFormat, cert)
+			if err != nil {
+				return err
+			}
+			keyPEM, err := pcert.EncodeKey(privateKey)
+			if err != nil {
+				return err
+			}
+			err = writeStdoutOrFile(opts.Cert, certOut, 0o644, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			return writeStdoutOrFile(opts.Key, keyPEM, 0o600, cmd.OutOrStdout())
+		},
+	}
+	registerESTClientFlags(cmd, opts.Client)
+	cmd.Flags().BoolVar(&opts.Reenroll, "reenroll", opts.Reenroll, "Renew the certificate set with --cert.")
+	cmd.Flags().Var(newOutFormatValue(&opts.OutFormat), "out-format", "Format of the certificate output (pem, der or p7b). The key is always PEM encoded.")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
+	registerRequestFlags(cmd, &opts.CertificateRequest)
+	registerKeyFlags(cmd, &opts.KeyOptions)
+	return cmd
+}
+
+// copyCertificateNames sets the subject and the subject alternative names of
+// cert in csr if none of them is set.
+func copyCertificateNames(csr *x509.CertificateRequest, cert *x509.Certificate) {
+	if csr.Subject.String() != "" || len(csr.DNSNames) > 0 || len(csr.EmailAddresses) > 0 || len(csr.IPAddresses) > 0 || len(csr.URIs) > 0 {
+		return
+	}
+	csr.Subject = cert.Subject
+	csr.DNSNames = cert.DNSNames
+	csr.EmailAddresses = cert.EmailAddresses
+	csr.IPAddresses = cert.IPAddresses
+	csr.URIs = cert.URIs
+}
+
+func newESTCACertsCmd() *cobra.Command {
+	var (
+		opts      = newESTClientOptions()
+		outFormat string
+	)
+	cmd := &cobra.Command{
+		Use:   "cacerts [CERT-OUT]",
+		Short: "Get the CA certificates of an EST server",
+		Example: `  # bootstrap the CA certificates without verifying the server
+  pcert est cacerts --server https://est.example.com:8443 --insecure ca.crt`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.client()
+			if err != nil {
+				return err
+			}
+			certs, err := client.CACerts(cmd.Context())
+			if err != nil {
+				return err
+			}
+			out, err := encodeCertificates(outFormat, certs...)
+			if err != nil {
+				return err
+			}
+			var file string
+			if len(args) > 0 {
+				file = args[0]
+			}
+			return writeStdoutOrFile(file, out, 0o644, cmd.OutOrStdout())
+		},
+	}
+	registerESTClientFlags(cmd, opts)
+	cmd.Flags().Var(newOutFormatValue(&outFormat), "out-format", "Format of the certificate output (pem, der or p7b).")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
+	return cmd
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_est(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "EST CA", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+
+	opts := &estServeOptions{
+		SignCert:  caFile,
+		Hostnames: []string{"127.0.0.1"},
+		Users:     []string{"device:secret"},
+	}
+	opts.Server.CertificateOptions.ProfileClient = true
+	tlsConfig, err := opts.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(&opts.Server)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	cacertsFile := filepath.Join(dir, "cacerts.crt")
+	_, stderr, err := runCmd([]string{"est", "cacerts", "--server", server.URL, "--insecure", cacertsFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	cacerts := readTestCertificates(t, cacertsFile)
+	if len(cacerts) != 1 || !cacerts[0].Equal(ca.cert) {
+		t.Fatalf("unexpected CA certificates: %d", len(cacerts))
+	}
+
+	certFile := filepath.Join(dir, "device.crt")
+	args := []string{"est", "enroll", "--server", server.URL, "--ca", cacertsFile, "--subject", "/CN=device-1", "--dns", "device-1.example.com", certFile}
+	_, _, err = runCmd(args, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 without authentication, got %v", err)
+	}
+	_, stderr, err = runCmd(append(args, "--username", "device", "--password", "secret"), nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	cert := readTestCertificates(t, certFile)[0]
+	if err := cert.CheckSignatureFrom(ca.cert); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "device-1" || len(cert.DNSNames) != 1 {
+		t.Errorf("unexpected names: %s %v", cert.Subject, cert.DNSNames)
+	}
+
+	// renew the certificate with the certificate for authentication
+	renewedFile := filepath.Join(dir, "renewed.crt")
+	_, stderr, err = runCmd([]string{"est", "enroll", "--server", server.URL, "--ca", cacertsFile, "--cert", certFile, "--reenroll", renewedFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	renewed := readTestCertificates(t, renewedFile)[0]
+	if renewed.Subject.String() != cert.Subject.String() || renewed.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Errorf("unexpected renewed certificate: %s %s", renewed.Subject, renewed.SerialNumber)
+	}
+
+	_, _, err = runCmd([]string{"est", "enroll", "--server", server.URL, "--ca", cacertsFile, "--reenroll"}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "requires --cert") {
+		t.Errorf("expected error without --cert, got %v", err)
+	}
+
+	opts = &estServeOptions{SignCert: caFile, Users: []string{"nopassword"}}
+	_, err = opts.load()
+	if err == nil || !strings.Contains(err.Error(), "invalid user") {
+		t.Errorf("expected error for invalid user, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultExporterListen   = ":9793"
+	defaultExporterInterval = 5 * time.Minute
+)
+
+type exporterOptions struct {
+	Listen      string
+	MetricsPath string
+	Paths       []string
+	Targets     []string
+	Interval    time.Duration
+	Workers     int
+	Connect     *connectOptions
+}
+
+func newExporterCmd() *cobra.Command {
+	opts := &exporterOptions{
+		Listen:      defaultExporterListen,
+		MetricsPath: "/metrics",
+		Interval:    defaultExporterInterval,
+		Workers:     defaultScanWorkers,
+		Connect:     newConnectOptions(),
+	}
+	opts.Connect.Timeout = defaultScanTimeout
+	cmd := &cobra.Command{
+		Use:   "exporter",
+		Short: "Expose the expiry of certificates as Prometheus metrics",
+		Long: `Periodically scan certificate files and TLS endpoints and expose the
+result as Prometheus metrics.
+
+Files and directories are read like with the check command: directories are
+searched recursively and every certificate of a bundle is exported. Targets
+are scanned like with the scan command and all options of connect are
+supported. The server certificates are verified after the handshake, so
+endpoints with invalid certificates are exported as well.
+
+The following metrics are exported. Certificate metrics have the labels
+path, target, subject, issuer and serial:
+
+  pcert_cert_not_before_seconds    start of the validity as Unix time
+  pcert_cert_not_after_seconds     end of the validity as Unix time
+  pcert_cert_verify_ok             1 if the chain of a target verifies
+  pcert_target_up                  1 if the connection to a target succeeded
+  pcert_file_up                    1 if a file or directory could be read
+  pcert_scan_timestamp_seconds     time of the last scan
+  pcert_scan_duration_seconds      duration of the last scan`,
+		Example: `  # export the certificates in /etc/ssl and of an endpoint
+  pcert exporter --path /etc/ssl --target example.com:443
+
+  # scan every minute on a different port
+  pcert exporter --listen 127.0.0.1:9100 --interval 1m --path /etc/ssl`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(opts.Paths) == 0 && len(opts.Targets) == 0 {
+				return fmt.Errorf("at least one --path or --target is required")
+			}
+			if opts.Interval <= 0 {
+				return fmt.Errorf("interval must be positive")
+			}
+			if opts.Workers < 1 {
+				return fmt.Errorf("workers must be at least 1")
+			}
+			err := opts.Connect.loadFiles()
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			return runExporter(ctx, opts, cmd.ErrOrStderr())
+		},
+	}
+	registerConnectFlags(cmd, opts.Connect)
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the metrics are served.")
+	cmd.Flags().StringVar(&opts.MetricsPath, "metrics-path", opts.MetricsPath, "HTTP path under which the metrics are served.")
+	cmd.Flags().StringArrayVar(&opts.Paths, "path", nil, "Certificate file or directory to export. Can be repeated.")
+	cmd.Flags().StringArrayVar(&opts.Targets, "target", nil, "Endpoint (host:port) whose certificate is exported. Can be repeated.")
+	cmd.Flags().DurationVar(&opts.Interval, "interval", opts.Interval, "Interval in which files and targets are scanned.")
+	cmd.Flags().IntVarP(&opts.Workers, "workers", "w", opts.Workers, "Number of targets which are scanned concurrently.")
+	return cmd
+}
+
+// runExporter serves the metrics until ctx is done.
+func runExporter(ctx context.Context, opts *exporterOptions, log io.Writer) error {
+	e := &exporter{opts: opts}
+	e.collect(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle(opts.MetricsPath, e)
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.collect(ctx)
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(log, "serving metrics on %s%s\n", opts.Listen, opts.MetricsPath)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// exporter scans files and targets and serves the result of the last scan.
+type exporter struct {
+	opts *exporterOptions
+
+	mu      sync.RWMutex
+	metrics []byte
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	metrics := e.metrics
+	e.mu.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(metrics)
+}
+
+// collect scans all files and targets and stores the metrics.
+func (e *exporter) collect(ctx context.Context) {
+	start := time.Now()
+	set := newMetricSet()
+
+	for _, path := range e.opts.Paths {
+		up := 1.0
+		walkCertificateFiles(path, func(file string, certs []*x509.Certificate, err error) {
+			if err != nil {
+				up = 0
+				return
+			}
+			for _, cert := range certs {
+				set.addCertificate(cert, file, "")
+			}
+		})
+		set.add("pcert_file_up", metricLabels{{"path", path}}, up)
+	}
+
+	if len(e.opts.Targets) > 0 {
+		for _, result := range scan(ctx, e.opts.Targets, e.opts.Connect, e.opts.Workers, nil) {
+			if result.Error != "" {
+				set.add("pcert_target_up", metricLabels{{"target", result.Target}}, 0)
+				continue
+			}
+			set.add("pcert_target_up", metricLabels{{"target", result.Target}}, 1)
+			labels := set.addCertificate(result.Leaf, "", result.Target)
+			set.add("pcert_cert_verify_ok", labels, boolValue(result.Verification.OK))
+		}
+	}
+
+	set.add("pcert_scan_timestamp_seconds", nil, float64(start.Unix()))
+	set.add("pcert_scan_duration_seconds", nil, time.Since(start).Seconds())
+
+	buf := &bytes.Buffer{}
+	_ = set.write(buf)
+	e.mu.Lock()
+	e.metrics = buf.Bytes()
+	e.mu.Unlock()
+}
+
+var metricHelp = map[string]string{
+	"pcert_cert_not_before_seconds": "Start of the validity of the certificate as Unix time.",
+	"pcert_cert_not_after_seconds":  "End of the validity of the certificate as Unix time.",
+	"pcert_cert_verify_ok":          "Whether the certificate chain presented by the target could be verified.",
+	"pcert_target_up":               "Whether the connection to the target succeeded.",
+	"pcert_file_up":                 "Whether the file or directory could be read.",
+	"pcert_scan_timestamp_seconds":  "Time of the last scan as Unix time.",
+	"pcert_scan_duration_seconds":   "Duration of the last scan in seconds.",
+}
+
+type metricLabels [][2]string
+
+type metricSample struct {
+	labels metricLabels
+	value  float64
+}
+
+// metricSet collects gauges and writes them in the Prometheus text
+// exposition format.
+type metricSet struct {
+	samples map[string][]metricSample
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		samples: map[string][]metricSample{},
+	}
+}
+
+func (s *metricSet) add(name string, labels metricLabels, value float64) {
+	s.samples[name] = append(s.samples[name], metricSample{labels: labels, value: value})
+}
+
+// addCertificate adds the validity metrics of cert and returns its labels.
+func (s *metricSet) addCertificate(cert *x509.Certificate, path, target string) metricLabels {
+	labels := metricLabels{
+		{"path", path},
+		{"target", target},
+		{"subject", cert.Subject.String()},
+		{"issuer", cert.Issuer.String()},
+		{"serial", encodeSerial(cert.SerialNumber)},
+	}
+	s.add("pcert_cert_not_before_seconds", labels, float64(cert.NotBefore.Unix()))
+	s.add("pcert_cert_not_after_seconds", labels, float64(cert.NotAfter.Unix()))
+	return labels
+}
+
+func (s *metricSet) write(w io.Writer) error {
+	names := make([]string, 0, len(s.samples))
+	for name := range s.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(sb, "# HELP %s %s\n", name, metricHelp[name])
+		fmt.Fprintf(sb, "# TYPE %s gauge\n", name)
+		for _, sample := range s.samples[name] {
+			sb.WriteString(name)
+			if len(sample.labels) > 0 {
+				sb.WriteString("{")
+				for i, label := range sample.labels {
+					if i > 0 {
+						sb.WriteString(",")
+					}
+					fmt.Fprintf(sb, `%s="%s"`, label[0], escapeLabelValue(label[1]))
+				}
+				sb.WriteString("}")
+			}
+			fmt.Fprintf(sb, " %s\n", strconv.FormatFloat(sample.value, 'f', -1, 64))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
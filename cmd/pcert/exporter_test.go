@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_exporter(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	fileCert := newTestServerCert(t, "file", ca)
+	certFile := writeTestCert(t, dir, "server", fileCert)
+
+	server := newTestServerCert(t, "localhost", ca)
+	addr := newTLSServer(t, server.tlsCertificate(ca.cert))
+
+	opts := &exporterOptions{
+		Paths:   []string{dir, filepath.Join(dir, "missing")},
+		Targets: []string{addr, "127.0.0.1:1"},
+		Workers: 2,
+		Connect: newConnectOptions(),
+	}
+	opts.Connect.TLSConfig.RootCAs = x509.NewCertPool()
+	opts.Connect.TLSConfig.RootCAs.AddCert(ca.cert)
+	e := &exporter{opts: opts}
+	e.collect(context.Background())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %s", rec.Header().Get("Content-Type"))
+	}
+
+	for _, expected := range []string{
+		"# TYPE pcert_cert_not_after_seconds gauge\n",
+		fmt.Sprintf(`pcert_cert_not_after_seconds{path="%s",target="",subject="CN=file",issuer="CN=Root",serial="%s"} %d`, certFile, encodeSerial(fileCert.cert.SerialNumber), fileCert.cert.NotAfter.Unix()),
+		fmt.Sprintf(`pcert_cert_verify_ok{path="",target="%s",subject="CN=localhost",issuer="CN=Root",serial="%s"} 1`, addr, encodeSerial(server.cert.SerialNumber)),
+		fmt.Sprintf(`pcert_target_up{target="%s"} 1`, addr),
+		`pcert_target_up{target="127.0.0.1:1"} 0`,
+		fmt.Sprintf(`pcert_file_up{path="%s"} 1`, dir),
+		fmt.Sprintf(`pcert_file_up{path="%s"} 0`, filepath.Join(dir, "missing")),
+		"pcert_scan_timestamp_seconds ",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("'%s' not found in output:\n%s", expected, out)
+		}
+	}
+}
+
+func Test_escapeLabelValue(t *testing.T) {
+	got := escapeLabelValue("CN=a\\b,O=\"c\"\n")
+	want := `CN=a\\b,O=\"c\"\n`
+	if got != want {
+		t.Errorf("got=%s want=%s", got, want)
+	}
+}
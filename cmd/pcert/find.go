@@ -0,0 +1,490 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+// findCacheVersion is increased if the format of the cache changes. Caches
+// with another version are ignored.
+const findCacheVersion = 1
+
+// findEntry is an indexed certificate.
+type findEntry struct {
+	Path  string `json:"path"`
+	Index int    `json:"index"`
+	// Names contains the common name and the SANs of the certificate.
+	Names          []string  `json:"names"`
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	KeyAlgorithm   string    `json:"key_algorithm"`
+	KeySize        int       `json:"key_size,omitempty"`
+	KeyCurve       string    `json:"key_curve,omitempty"`
+	SubjectKeyID   string    `json:"subject_key_id,omitempty"`
+	AuthorityKeyID string    `json:"authority_key_id,omitempty"`
+	SHA1           string    `json:"sha1"`
+	SHA256         string    `json:"sha256"`
+}
+
+func newFindEntry(path string, index int, cert *x509.Certificate) findEntry {
+	c := pcert.NewCertificateJSON(cert)
+	names := []string{}
+	names = append(names, c.SubjectAltNames.DNS...)
+	names = append(names, c.SubjectAltNames.IP...)
+	names = append(names, c.SubjectAltNames.Email...)
+	names = append(names, c.SubjectAltNames.URI...)
+	if cn := cert.Subject.CommonName; cn != "" && !slices.Contains(names, cn) {
+		names = append([]string{cn}, names...)
+	}
+	return findEntry{
+		Path:           path,
+		Index:          index,
+		Names:          names,
+		Subject:        c.Subject.String,
+		Issuer:         c.Issuer.String,
+		Serial:         c.SerialNumber,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		KeyAlgorithm:   c.PublicKey.Algorithm,
+		KeySize:        c.PublicKey.Size,
+		KeyCurve:       c.PublicKey.Curve,
+		SubjectKeyID:   c.SubjectKeyID,
+		AuthorityKeyID: c.AuthorityKeyID,
+		SHA1:           c.Fingerprints.SHA1,
+		SHA256:         c.Fingerprints.SHA256,
+	}
+}
+
+// key returns a short description of the public key (e.g. RSA 2048).
+func (e findEntry) key() string {
+	switch {
+	case e.KeyCurve != "" && e.KeyCurve != e.KeyAlgorithm:
+		return e.KeyAlgorithm + " " + e.KeyCurve
+	case e.KeySize != 0:
+		return e.KeyAlgorithm + " " + strconv.Itoa(e.KeySize)
+	default:
+		return e.KeyAlgorithm
+	}
+}
+
+// findQuery selects certificates. Empty fields match all certificates.
+type findQuery struct {
+	// Name is a case insensitive substring of the common name or a SAN.
+	Name string
+	// Issuer is a case insensitive substring of the issuer.
+	Issuer         string
+	Serial         string
+	SubjectKeyID   string
+	AuthorityKeyID string
+	// Fingerprint is a SHA-1 or SHA-256 fingerprint.
+	Fingerprint  string
+	KeyAlgorithm string
+	// ExpiresWithin selects certificates which expire before Now plus
+	// ExpiresWithin. This includes expired certificates.
+	ExpiresWithin time.Duration
+	Expired       bool
+	Now           time.Time
+}
+
+func (q *findQuery) match(e *findEntry) bool {
+	if q.Name != "" && !containsFold(e.Names, q.Name) {
+		return false
+	}
+	if q.Issuer != "" && !containsFold([]string{e.Issuer}, q.Issuer) {
+		return false
+	}
+	if q.Serial != "" && strings.TrimLeft(normalizeHex(q.Serial), "0") != strings.TrimLeft(e.Serial, "0") {
+		return false
+	}
+	if q.SubjectKeyID != "" && normalizeHex(q.SubjectKeyID) != e.SubjectKeyID {
+		return false
+	}
+	if q.AuthorityKeyID != "" && normalizeHex(q.AuthorityKeyID) != e.AuthorityKeyID {
+		return false
+	}
+	if q.Fingerprint != "" {
+		fp := normalizeHex(q.Fingerprint)
+		if fp != e.SHA1 && fp != e.SHA256 {
+			return false
+		}
+	}
+	if q.KeyAlgorithm != "" && !strings.EqualFold(q.KeyAlgorithm, e.KeyAlgorithm) {
+		return false
+	}
+	if q.ExpiresWithin != 0 && !e.NotAfter.Before(q.Now.Add(q.ExpiresWithin)) {
+		return false
+	}
+	if q.Expired && !e.NotAfter.Before(q.Now) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, substr string) bool {
+	substr = strings.ToLower(substr)
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHex removes colons and spaces from hex strings and converts them
+// to lower case.
+func normalizeHex(s string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(s))
+}
+
+// findCache stores the indexed certificates of files. A file is indexed
+// again if its modification time or size changed.
+type findCache struct {
+	Version int                       `json:"version"`
+	Files   map[string]findCachedFile `json:"files"`
+}
+
+type findCachedFile struct {
+	ModTime time.Time   `json:"mod_time"`
+	Size    int64       `json:"size"`
+	Entries []findEntry `json:"entries"`
+}
+
+func newFindCache() *findCache {
+	return &findCache{
+		Version: findCacheVersion,
+		Files:   map[string]findCachedFile{},
+	}
+}
+
+// loadFindCache reads the cache from file. A missing or outdated cache
+// results in an empty cache.
+func loadFindCache(file string) (*findCache, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newFindCache(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := &findCache{}
+	err = json.Unmarshal(data, cache)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache '%s': %w", file, err)
+	}
+	if cache.Version != findCacheVersion || cache.Files == nil {
+		return newFindCache(), nil
+	}
+	return cache, nil
+}
+
+func (c *findCache) save(file string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o600)
+}
+
+type findIndexer struct {
+	cache    *findCache
+	password string
+	// seen contains all files visited during the indexing
+	seen     map[string]bool
+	warnings io.Writer
+}
+
+// index returns the certificates of all files in paths. Files which cannot
+// be read are reported as warning.
+func (ix *findIndexer) index(paths []string) ([]findEntry, error) {
+	entries := []findEntry{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			entries = append(entries, ix.indexFile(path, info)...)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fmt.Fprintf(ix.warnings, "warning: %s\n", err)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() > maxCheckFileSize {
+				return nil
+			}
+			entries = append(entries, ix.indexFile(file, info)...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (ix *findIndexer) indexFile(file string, info fs.FileInfo) []findEntry {
+	ix.seen[file] = true
+	cached, ok := ix.cache.Files[file]
+	if ok && cached.ModTime.Equal(info.ModTime()) && cached.Size == info.Size() {
+		return cached.Entries
+	}
+
+	entries := []findEntry{}
+	certs, err := readFindCertificates(file, ix.password)
+	if err != nil {
+		fmt.Fprintf(ix.warnings, "warning: %s: %s\n", file, err)
+		return entries
+	}
+	for i, cert := range certs {
+		entries = append(entries, newFindEntry(file, i, cert))
+	}
+	// files without certificates are cached as well so that they are not
+	// read again
+	ix.cache.Files[file] = findCachedFile{
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Entries: entries,
+	}
+	return entries
+}
+
+// prune removes files below paths which no longer exist from the cache.
+func (ix *findIndexer) prune(paths []string) {
+	for file := range ix.cache.Files {
+		if ix.seen[file] {
+			continue
+		}
+		for _, path := range paths {
+			if file == path || strings.HasPrefix(file, strings.TrimSuffix(path, string(filepath.Separator))+string(filepath.Separator)) {
+				delete(ix.cache.Files, file)
+				break
+			}
+		}
+	}
+}
+
+// readFindCertificates reads the certificates of PEM, DER and PKCS#7 files
+// and of PKCS#12 files with the extension .p12 or .pfx. Files of other
+// formats result in no certificates.
+func readFindCertificates(file, password string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == ".p12" || ext == ".pfx" {
+		_, certs, err := pcert.DecodePKCS12(data, password)
+		return certs, err
+	}
+	certs, err := pcert.ParseAll(data)
+	if err != nil {
+		// files in config trees are mostly not certificates
+		return nil, nil
+	}
+	return certs, nil
+}
+
+func newFindCmd() *cobra.Command {
+	var (
+		query     = &findQuery{}
+		format    = "table"
+		cacheFile string
+		password  string
+	)
+	cmd := &cobra.Command{
+		Use:   "find <DIR|FILE>...",
+		Short: "Search certificates in directories",
+		Long: `Search certificates in files and directories. Directories are searched
+recursively. PEM, DER and PKCS#7 files and bundles are read. PKCS#12 files are
+read if they have the extension .p12 or .pfx and can be decrypted with
+--password. Files which do not contain certificates are skipped.
+
+All given conditions must match. --name and --issuer match case insensitive
+substrings. Serials, key identifiers and fingerprints are hex encoded and may
+contain colons.
+
+With --cache the indexed certificates are stored in a file. Files whose
+modification time and size did not change are not read again.`,
+		Example: `  # find all certificates for example.com
+  pcert find --name example.com /etc
+
+  # find certificates which expire within 30 days and write an HTML report
+  pcert find --expires-within 30d --format html /etc/ssl > report.html
+
+  # find all certificates issued by a CA using a cache
+  pcert find --cache ~/.cache/pcert-find.json --aki 3a:4f:... /etc /opt`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var printer func(w io.Writer, entries []findEntry) error
+			switch format {
+			case "table":
+				printer = printFindTable
+			case "csv":
+				printer = printFindCSV
+			case "html":
+				printer = printFindHTML
+			case "json":
+				printer = printFindJSON
+			default:
+				return fmt.Errorf("unknown format '%s'. valid formats are table, csv, html and json", format)
+			}
+			query.Now = time.Now()
+
+			cache := newFindCache()
+			if cacheFile != "" {
+				var err error
+				cache, err = loadFindCache(cacheFile)
+				if err != nil {
+					return err
+				}
+			}
+			ix := &findIndexer{
+				cache:    cache,
+				password: password,
+				seen:     map[string]bool{},
+				warnings: cmd.ErrOrStderr(),
+			}
+			entries, err := ix.index(args)
+			if err != nil {
+				return err
+			}
+			if cacheFile != "" {
+				ix.prune(args)
+				err = cache.save(cacheFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			matches := []findEntry{}
+			for i := range entries {
+				if query.match(&entries[i]) {
+					matches = append(matches, entries[i])
+				}
+			}
+			return printer(cmd.OutOrStdout(), matches)
+		},
+	}
+	cmd.Flags().StringVar(&query.Name, "name", "", "Substring of the common name or a SAN.")
+	cmd.Flags().StringVar(&query.Issuer, "issuer", "", "Substring of the issuer.")
+	cmd.Flags().StringVar(&query.Serial, "serial", "", "Serial number (hex).")
+	cmd.Flags().StringVar(&query.SubjectKeyID, "ski", "", "Subject key identifier (hex).")
+	cmd.Flags().StringVar(&query.AuthorityKeyID, "aki", "", "Authority key identifier (hex).")
+	cmd.Flags().StringVar(&query.Fingerprint, "fingerprint", "", "SHA-1 or SHA-256 fingerprint (hex).")
+	cmd.Flags().StringVar(&query.KeyAlgorithm, "key-algorithm", "", "Public key algorithm (RSA, ECDSA or Ed25519).")
+	cmd.Flags().Var(newDurationValue(&query.ExpiresWithin), "expires-within", "Certificates which expire within this duration (e.g. 30d), including expired ones.")
+	cmd.Flags().BoolVar(&query.Expired, "expired", false, "Only expired certificates.")
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Output format. Valid formats are table, csv, html and json.")
+	cmd.Flags().StringVar(&cacheFile, "cache", "", "File in which the index is cached.")
+	cmd.Flags().StringVar(&password, "password", "", "Password to decrypt PKCS#12 files.")
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "csv", "html", "json"}, cobra.ShellCompDirectiveDefault
+	})
+	_ = cmd.RegisterFlagCompletionFunc("key-algorithm", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"RSA", "ECDSA", "Ed25519"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	return cmd
+}
+
+func printFindTable(w io.Writer, entries []findEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tSUBJECT\tISSUER\tSERIAL\tKEY\tNOT AFTER")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s[%d]\t%s\t%s\t%s\t%s\t%s\n", e.Path, e.Index, e.Subject, e.Issuer, e.Serial, e.key(), e.NotAfter.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func printFindCSV(w io.Writer, entries []findEntry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"path", "index", "subject", "issuer", "names", "serial", "not_before", "not_after", "key", "subject_key_id", "authority_key_id", "sha256"})
+	for _, e := range entries {
+		_ = cw.Write([]string{
+			e.Path,
+			strconv.Itoa(e.Index),
+			e.Subject,
+			e.Issuer,
+			strings.Join(e.Names, " "),
+			e.Serial,
+			e.NotBefore.Format(time.RFC3339),
+			e.NotAfter.Format(time.RFC3339),
+			e.key(),
+			e.SubjectKeyID,
+			e.AuthorityKeyID,
+			e.SHA256,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func printFindJSON(w io.Writer, entries []findEntry) error {
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+var findHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"join": strings.Join,
+	"key":  func(e findEntry) string { return e.key() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Certificates</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.expired { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Certificates</h1>
+<p>{{ len .Entries }} certificates, generated {{ .Now.Format "2006-01-02 15:04:05 MST" }}</p>
+<table>
+<tr><th>Path</th><th>Subject</th><th>Names</th><th>Issuer</th><th>Serial</th><th>Key</th><th>Not before</th><th>Not after</th></tr>
+{{- range .Entries }}
+<tr{{ if .NotAfter.Before $.Now }} class="expired"{{ end }}><td>{{ .Path }}[{{ .Index }}]</td><td>{{ .Subject }}</td><td>{{ join .Names ", " }}</td><td>{{ .Issuer }}</td><td>{{ .Serial }}</td><td>{{ key . }}</td><td>{{ date .NotBefore }}</td><td>{{ date .NotAfter }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+func printFindHTML(w io.Writer, entries []findEntry) error {
+	return findHTMLTemplate.Execute(w, struct {
+		Entries []findEntry
+		Now     time.Time
+	}{entries, time.Now()})
+}
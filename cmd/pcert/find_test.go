@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_find(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	dir := t.TempDir()
+
+	ca := newTestCA(t, "Root", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+	server := newTestServerCert(t, "www.example.com", ca)
+	serverFile := writeTestCert(t, dir, "server", server)
+	expired := newTestCertValidity(t, "expired", now.Add(-10*day), now.Add(-day), false, ca)
+	err := os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = writeTestCert(t, filepath.Join(dir, "sub"), "expired", expired)
+
+	der, _, err := pcert.CreateCertificateWithKeyOptions(pcert.NewCertificate(&pcert.CertificateOptions{
+		Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "ed25519"}},
+	}), pcert.KeyOptions{Algorithm: x509.Ed25519}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := pcert.EncodePKCS12(nil, []*x509.Certificate{mustParseCertificate(t, der)}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12File := filepath.Join(dir, "ed25519.p12")
+	err = os.WriteFile(p12File, p12, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(args ...string) []findEntry {
+		t.Helper()
+		stdout, _, err := runCmd(append([]string{"find", "--format", "json", "--password", "secret"}, args...), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := []findEntry{}
+		err = json.Unmarshal(stdout.Bytes(), &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	subjects := func(entries []findEntry) string {
+		s := []string{}
+		for _, e := range entries {
+			s = append(s, e.Subject)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// the key files are skipped
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{dir}, "CN=Root,CN=ed25519,CN=www.example.com,CN=expired"},
+		{[]string{"--name", "EXAMPLE.com", dir}, "CN=www.example.com"},
+		{[]string{"--name", "127.0.0.1", dir}, "CN=www.example.com"},
+		{[]string{"--issuer", "root", "--expired", dir}, "CN=expired"},
+		{[]string{"--expires-within", "1d", dir}, "CN=expired"},
+		{[]string{"--serial", encodeSerial(server.cert.SerialNumber), dir}, "CN=www.example.com"},
+		{[]string{"--ski", pcert.NewCertificateJSON(ca.cert).SubjectKeyID, dir}, "CN=Root"},
+		{[]string{"--aki", pcert.NewCertificateJSON(ca.cert).SubjectKeyID, "--key-algorithm", "ed25519", dir}, "CN=ed25519"},
+		{[]string{"--fingerprint", pcert.NewCertificateFingerprints(ca.cert).SHA1, caFile, serverFile}, "CN=Root"},
+	}
+	for _, test := range tests {
+		got := subjects(find(test.args...))
+		if got != test.expected {
+			t.Errorf("%v: got=%s want=%s", test.args, got, test.expected)
+		}
+	}
+
+	stdout, _, err := runCmd([]string{"find", "--format", "html", "--name", "www", dir}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "<td>www.example.com, 127.0.0.1</td>") {
+		t.Errorf("unexpected HTML output:\n%s", stdout)
+	}
+
+	stdout, _, err = runCmd([]string{"find", "--format", "csv", "--name", "www", dir}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], serverFile+",0,CN=www.example.com,CN=Root") {
+		t.Errorf("unexpected CSV output:\n%s", stdout)
+	}
+}
+
+func Test_find_cache(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	certFile := writeTestCert(t, dir, "server", newTestServerCert(t, "server", nil))
+
+	find := func() string {
+		t.Helper()
+		stdout, _, err := runCmd([]string{"find", "--cache", cacheFile, dir}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stdout.String()
+	}
+	_ = find()
+
+	// modify the cache to check that it is used
+	cache, err := loadFindCache(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Files) != 2 || len(cache.Files[certFile].Entries) != 1 {
+		t.Fatalf("unexpected cache: %+v", cache)
+	}
+	cache.Files[certFile].Entries[0].Subject = "CN=cached"
+	err = cache.save(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := find(); !strings.Contains(out, "CN=cached") {
+		t.Errorf("cache not used:\n%s", out)
+	}
+
+	// a changed modification time invalidates the entry
+	modTime := time.Now().Add(time.Minute)
+	err = os.Chtimes(certFile, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := find(); !strings.Contains(out, "CN=server") {
+		t.Errorf("cache not updated:\n%s", out)
+	}
+
+	// removed files are pruned
+	err = os.Remove(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = find()
+	cache, err = loadFindCache(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Files[certFile]; ok || len(cache.Files) != 1 {
+		t.Errorf("removed file not pruned: %+v", cache.Files)
+	}
+}
+
+func mustParseCertificate(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
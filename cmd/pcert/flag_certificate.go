@@ -30,6 +30,7 @@ func bindCertFlags(fs *pflag.FlagSet, co *pcert.CertificateOptions) {
 	fs.StringSliceVar(&co.EmailAddresses, "email", []string{}, "Email subject alternative name.")
 	fs.IPSliceVar(&co.IPAddresses, "ip", []net.IP{}, "IP subject alternative name.")
 	fs.Var(newURISliceValue(&co.URIs), "uri", "URI subject alternative name.")
+	fs.Var(newSPIFFEIDValue(co), "spiffe-id", "SPIFFE ID (spiffe://trust-domain/path) of an X509-SVID. Sets it as the only URI SAN and sets the key usages of an X509-SVID. With --ca the URI names are constrained to the trust domain.")
 
 	// signature algorithm
 	fs.Var(newSignAlgValue(&co.SignatureAlgorithm), "sign-alg", "Signature Algorithm. See 'pcert list' for available algorithms.")
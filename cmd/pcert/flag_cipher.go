@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func cipherSuites() []*tls.CipherSuite {
+	return append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+}
+
+type cipherSuitesValue struct {
+	value   *[]uint16
+	changed bool
+}
+
+func newCipherSuitesValue(ids *[]uint16) *cipherSuitesValue {
+	return &cipherSuitesValue{
+		value: ids,
+	}
+}
+
+func (cv *cipherSuitesValue) Type() string {
+	return "ciphers"
+}
+
+func (cv *cipherSuitesValue) String() string {
+	names := []string{}
+	for _, id := range *cv.value {
+		names = append(names, tls.CipherSuiteName(id))
+	}
+	return strings.Join(names, ",")
+}
+
+func (cv *cipherSuitesValue) Set(cipherNames string) error {
+	var ids []uint16
+	for _, name := range strings.Split(cipherNames, ",") {
+		var found bool
+		for _, suite := range cipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown cipher suite: %s", name)
+		}
+	}
+
+	// overwrite the defaults/initial value on first Set
+	if cv.changed {
+		*cv.value = append(*cv.value, ids...)
+	} else {
+		*cv.value = ids
+		cv.changed = true
+	}
+	return nil
+}
+
+func cipherSuiteCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	out := []string{}
+	for _, suite := range cipherSuites() {
+		out = append(out, suite.Name)
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/pflag"
+)
+
+// defaultIssuerCacheDir returns the directory in the users cache directory
+// where fetched issuers are stored. If there is no cache directory issuers
+// are not cached.
+func defaultIssuerCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pcert", "issuers")
+}
+
+func bindIssuerFetcherFlags(fs *pflag.FlagSet, f *pcert.IssuerFetcher) {
+	fs.StringVar(&f.CacheDir, "issuer-cache", f.CacheDir, "Directory to cache fetched issuer certificates. Set to empty string to disable caching.")
+	fs.IntVar(&f.MaxDepth, "issuer-depth", f.MaxDepth, "Maximum number of issuer certificates to fetch.")
+	fs.DurationVar(&f.Timeout, "issuer-timeout", f.Timeout, "Timeout for fetching a single issuer certificate.")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outFormatPEM = "pem"
+	outFormatDER = "der"
+	outFormatP7B = "p7b"
+)
+
+type outFormatValue struct {
+	value *string
+}
+
+func newOutFormatValue(format *string) *outFormatValue {
+	if *format == "" {
+		*format = outFormatPEM
+	}
+	return &outFormatValue{
+		value: format,
+	}
+}
+
+func (of *outFormatValue) Type() string {
+	return "format"
+}
+
+func (of *outFormatValue) String() string {
+	return *of.value
+}
+
+func (of *outFormatValue) Set(format string) error {
+	switch format {
+	case outFormatPEM, outFormatDER, outFormatP7B:
+		*of.value = format
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s. valid formats are pem, der and p7b", format)
+	}
+}
+
+func outFormatCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{outFormatPEM, outFormatDER, outFormatP7B}, cobra.ShellCompDirectiveNoFileComp
+}
+
+// encodeCertificates encodes certs in format. With der the certificates are
+// concatenated and with p7b they are put into a single PKCS#7 structure.
+func encodeCertificates(format string, certs ...*x509.Certificate) ([]byte, error) {
+	switch format {
+	case outFormatDER:
+		var out []byte
+		for _, cert := range certs {
+			out = append(out, cert.Raw...)
+		}
+		return out, nil
+	case outFormatP7B:
+		return pcert.EncodePKCS7Certificates(certs)
+	default:
+		var out []byte
+		for _, cert := range certs {
+			out = append(out, pcert.Encode(cert.Raw)...)
+		}
+		return out, nil
+	}
+}
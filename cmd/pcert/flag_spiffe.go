@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/pflag"
+)
+
+// spiffeIDValue sets a validated SPIFFE ID as the only URI SAN and enables
+// the SPIFFE profile.
+type spiffeIDValue struct {
+	uris    *uriSliceValue
+	profile *bool
+}
+
+func newSPIFFEIDValue(co *pcert.CertificateOptions) *spiffeIDValue {
+	return &spiffeIDValue{
+		uris:    newURISliceValue(&co.URIs),
+		profile: &co.ProfileSPIFFE,
+	}
+}
+
+func (sv *spiffeIDValue) Type() string {
+	return "spiffe-id"
+}
+
+func (sv *spiffeIDValue) String() string {
+	if !sv.uris.changed {
+		return ""
+	}
+	return sv.uris.String()
+}
+
+func (sv *spiffeIDValue) Set(id string) error {
+	if sv.uris.changed {
+		return errors.New("an X509-SVID contains exactly one SPIFFE ID")
+	}
+	_, err := pcert.ParseSPIFFEID(id)
+	if err != nil {
+		return err
+	}
+	*sv.profile = true
+	return sv.uris.Set(id)
+}
+
+// checkSPIFFEFlags rejects flags which add further names to an X509-SVID.
+func checkSPIFFEFlags(fs *pflag.FlagSet) error {
+	if !fs.Changed("spiffe-id") {
+		return nil
+	}
+	if fs.Changed("uri") {
+		return errors.New("--spiffe-id and --uri can not be combined, an X509-SVID contains exactly one URI SAN")
+	}
+	if fs.Changed("server") {
+		return errors.New("--spiffe-id and --server can not be combined, as --server adds the common name as DNS name. --spiffe-id already sets server and client authentication")
+	}
+	return nil
+}
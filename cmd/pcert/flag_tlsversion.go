@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type tlsVersionValue struct {
+	value *uint16
+}
+
+func newTLSVersionValue(version *uint16) *tlsVersionValue {
+	return &tlsVersionValue{
+		value: version,
+	}
+}
+
+func (tv *tlsVersionValue) Type() string {
+	return "version"
+}
+
+func (tv *tlsVersionValue) String() string {
+	for name, version := range tlsVersions {
+		if version == *tv.value {
+			return name
+		}
+	}
+	return ""
+}
+
+func (tv *tlsVersionValue) Set(name string) error {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return fmt.Errorf("unknown TLS version: %s. valid versions are 1.0, 1.1, 1.2 and 1.3", name)
+	}
+	*tv.value = version
+	return nil
+}
+
+func tlsVersionCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"1.0", "1.1", "1.2", "1.3"}, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newJWKSCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "jwks FILE...",
+		Short: "Create a JSON Web Key Set from certificates and keys",
+		Long: `Creates a JSON Web Key Set (JWKS) with the public keys of the certificates or
+keys in FILE. If a file contains certificates, the key of the first
+certificate is used. The key ID (kid) is the subject key identifier of the
+certificate. The certificate and the further certificates of the file are
+added as x5c and the SHA-256 fingerprint of the certificate as x5t#S256.
+The algorithm (alg) is set for ECDSA and Ed25519 keys. RSA keys have no
+algorithm, so tokens signed with RS256 to PS512 can be verified.
+
+If a file contains no certificate but a private key, the public key is added
+with its JWK thumbprint (RFC 7638) as key ID. Private keys are never added
+to the key set.
+
+Tokens signed with 'pcert jwt sign' use the same key IDs.`,
+		Example: `  pcert jwks service1.crt service2.crt > jwks.json`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jwks := &pcert.JWKS{Keys: []*pcert.JWK{}}
+			for _, file := range args {
+				jwk, err := readJWK(file)
+				if err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+				if jwks.Key(jwk.Kid) != nil {
+					return fmt.Errorf("%s: duplicate key ID '%s'", file, jwk.Kid)
+				}
+				jwks.Keys = append(jwks.Keys, jwk)
+			}
+			out, err := json.MarshalIndent(jwks, "", "  ")
+			if err != nil {
+				return err
+			}
+			return writeStdoutOrFile(output, append(out, '\n'), 0o644, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Output file. If not set the output is written to STDOUT.")
+	return cmd
+}
+
+// readJWK returns the public JWK of the first certificate or of the private
+// key of a file.
+func readJWK(file string) (*pcert.JWK, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := pcert.ParseAll(data)
+	if err == nil && len(certs) > 0 {
+		return pcert.NewCertificateJWK(certs[0], certs[1:])
+	}
+	key, err := pcert.ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("no certificate or private key found")
+	}
+	return publicKeyJWK(key)
+}
+
+// publicKeyJWK returns the public JWK of a private key with its thumbprint as
+// key ID.
+func publicKeyJWK(key any) (*pcert.JWK, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	jwk, err := pcert.NewJWK(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	// RSA keys are used with several algorithms (RS256 to PS512)
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		jwk.Alg, err = pcert.JWSAlgorithm(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	jwk.Kid, err = jwk.Thumbprint()
+	return jwk, err
+}
+
+// readJWKS reads a JSON Web Key Set or the certificates of a file as key set.
+func readJWKS(file string) (*pcert.JWKS, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	jwks := &pcert.JWKS{}
+	if json.Valid(data) {
+		err = json.Unmarshal(data, jwks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS '%s': %w", file, err)
+		}
+		return jwks, nil
+	}
+	certs, err := pcert.ParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no JWKS or certificates found in '%s'", file)
+	}
+	for _, cert := range certs {
+		jwk, err := pcert.NewCertificateJWK(cert, []*x509.Certificate{})
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newJWTCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jwt",
+		Short: "Sign and verify JSON Web Tokens",
+	}
+	cmd.AddCommand(
+		newJWTSignCmd(),
+		newJWTVerifyCmd(),
+	)
+	return cmd
+}
+
+type jwtSignOptions struct {
+	Key      string
+	Cert     string
+	Alg      string
+	Kid      string
+	Claims   string
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Duration
+}
+
+func newJWTSignCmd() *cobra.Command {
+	opts := &jwtSignOptions{
+		Expiry: time.Hour,
+	}
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Create a signed JSON Web Token",
+		Long: `Creates a JSON Web Token (JWT) signed with --key. The algorithm defaults to
+RS256 for RSA keys, to ES256, ES384 or ES512 for ECDSA keys depending on the
+curve and to EdDSA for Ed25519 keys. RSA keys can also be used with RS384,
+RS512 and PS256, PS384 or PS512.
+
+The key ID (kid) is the subject key identifier of the certificate set with
+--cert or the JWK thumbprint of the key. This matches the key IDs of
+'pcert jwks'. The claims iat and exp are set from the current time and
+--expiry. Further claims are read from the JSON object in the file set with
+--claims.`,
+		Example: `  pcert jwt sign --key service.key --cert service.crt --subject service --audience api`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Key == "" {
+				return errors.New("--key is required")
+			}
+			var (
+				key any
+				err error
+			)
+			kid := opts.Kid
+			if opts.Cert != "" {
+				signer, err := loadSigningCA(opts.Cert, opts.Key)
+				if err != nil {
+					return err
+				}
+				key = signer.Key
+				if kid == "" {
+					kid, err = pcert.CertificateKeyID(signer.Cert)
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				key, err = pcert.LoadKey(opts.Key)
+				if err != nil {
+					return err
+				}
+				if kid == "" {
+					jwk, err := publicKeyJWK(key)
+					if err != nil {
+						return err
+					}
+					kid = jwk.Kid
+				}
+			}
+
+			claims := map[string]any{}
+			if opts.Claims != "" {
+				data, err := readStdinOrFile(opts.Claims, &stdinKeeper{stdin: cmd.InOrStdin()})
+				if err != nil {
+					return err
+				}
+				err = json.Unmarshal(data, &claims)
+				if err != nil {
+					return fmt.Errorf("invalid claims: %w", err)
+				}
+			}
+			now := time.Now()
+			claims["iat"] = now.Unix()
+			if opts.Expiry != 0 {
+				claims["exp"] = now.Add(opts.Expiry).Unix()
+			}
+			if opts.Issuer != "" {
+				claims["iss"] = opts.Issuer
+			}
+			if opts.Subject != "" {
+				claims["sub"] = opts.Subject
+			}
+			switch len(opts.Audience) {
+			case 0:
+			case 1:
+				claims["aud"] = opts.Audience[0]
+			default:
+				claims["aud"] = opts.Audience
+			}
+
+			token, err := pcert.SignJWT(claims, key, opts.Alg, kid)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), token)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.Key, "key", opts.Key, "Key to sign the token.")
+	cmd.Flags().StringVar(&opts.Cert, "cert", opts.Cert, "Certificate of the key. Its subject key identifier is used as key ID.")
+	cmd.Flags().StringVar(&opts.Alg, "alg", opts.Alg, "Signature algorithm (RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA). Defaults to the algorithm of the key type.")
+	cmd.Flags().StringVar(&opts.Kid, "kid", opts.Kid, "Key ID. Overrides the key ID of --cert or the key.")
+	cmd.Flags().StringVar(&opts.Claims, "claims", opts.Claims, "File with a JSON object with claims. Use '-' for stdin.")
+	cmd.Flags().StringVar(&opts.Issuer, "issuer", opts.Issuer, "Issuer claim (iss).")
+	cmd.Flags().StringVar(&opts.Subject, "subject", opts.Subject, "Subject claim (sub).")
+	cmd.Flags().StringSliceVar(&opts.Audience, "audience", opts.Audience, "Audience claim (aud).")
+	cmd.Flags().DurationVar(&opts.Expiry, "expiry", opts.Expiry, "Validity of the token. Use 0 for a token without expiration time.")
+	return cmd
+}
+
+func newJWTVerifyCmd() *cobra.Command {
+	var jwksFile string
+	cmd := &cobra.Command{
+		Use:   "verify [FILE]",
+		Short: "Verify a JSON Web Token",
+		Long: `Verifies the signature, expiration time (exp) and not before time (nbf) of a
+JSON Web Token read from FILE or stdin. The keys are read from the file set
+with --jwks which contains either a JSON Web Key Set or certificates. The
+header and the claims of the token are printed as JSON.`,
+		Example: `  pcert jwt verify --jwks jwks.json token.jwt`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jwksFile == "" {
+				return errors.New("--jwks is required")
+			}
+			jwks, err := readJWKS(jwksFile)
+			if err != nil {
+				return err
+			}
+			file := ""
+			if len(args) == 1 {
+				file = args[0]
+			}
+			token, err := readStdinOrFile(file, &stdinKeeper{stdin: cmd.InOrStdin()})
+			if err != nil {
+				return err
+			}
+
+			jwt, err := pcert.VerifyJWT(string(token), jwks, time.Now())
+			if err != nil {
+				return err
+			}
+			out, err := json.MarshalIndent(struct {
+				Header pcert.JWTHeader `json:"header"`
+				Claims map[string]any  `json:"claims"`
+			}{jwt.Header, jwt.Claims}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&jwksFile, "jwks", jwksFile, "File with a JSON Web Key Set or certificates.")
+	return cmd
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_jwks_jwt(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	service := newTestCert(t, &pcert.CertificateOptions{}, ca)
+	serviceCert := writeTestCert(t, dir, "service", service)
+
+	plainKey, _, err := pcert.GenerateKey(pcert.KeyOptions{Algorithm: x509.Ed25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainKeyFile := filepath.Join(dir, "plain.key")
+	pemKey, err := pcert.EncodeKey(plainKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(plainKeyFile, pemKey, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	_, _, err = runCmd([]string{"jwks", serviceCert, plainKeyFile, "-o", jwksFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jwksFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := &pcert.JWKS{}
+	err = json.Unmarshal(data, jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(jwks.Keys))
+	}
+	kid, err := pcert.CertificateKeyID(service.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwks.Keys[0].Kid != kid || len(jwks.Keys[0].X5c) != 1 || jwks.Keys[0].X5tS256 == "" {
+		t.Errorf("unexpected certificate key: %+v", jwks.Keys[0])
+	}
+	if jwks.Keys[1].Alg != "EdDSA" || jwks.Keys[1].D != "" {
+		t.Errorf("unexpected plain key: %+v", jwks.Keys[1])
+	}
+
+	_, _, err = runCmd([]string{"jwks", serviceCert, serviceCert}, nil, nil)
+	if err == nil {
+		t.Error("expected error for duplicate key")
+	}
+
+	claims := filepath.Join(dir, "claims.json")
+	err = os.WriteFile(claims, []byte(`{"scope": "read"}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args []string
+		keys []string
+	}{
+		{[]string{"--key", filepath.Join(dir, "service.key"), "--cert", serviceCert, "--claims", claims, "--subject", "service"}, []string{jwksFile, serviceCert}},
+		{[]string{"--key", filepath.Join(dir, "service.key"), "--cert", serviceCert, "--alg", "ES256", "--audience", "a,b"}, []string{jwksFile, serviceCert}},
+		{[]string{"--key", plainKeyFile}, []string{jwksFile}},
+	} {
+		token, _, err := runCmd(append([]string{"jwt", "sign"}, test.args...), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, keys := range test.keys {
+			stdout, _, err := runCmd([]string{"jwt", "verify", "--jwks", keys}, strings.NewReader(token.String()), nil)
+			if err != nil {
+				t.Fatalf("%v: %s", test.args, err)
+			}
+			if !strings.Contains(stdout.String(), `"exp"`) {
+				t.Errorf("missing exp claim: %s", stdout)
+			}
+		}
+	}
+
+	token, _, err := runCmd([]string{"jwt", "sign", "--key", filepath.Join(dir, "service.key"), "--cert", serviceCert, "--claims", claims}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err := runCmd([]string{"jwt", "verify", "--jwks", jwksFile, "-"}, token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `"scope": "read"`) {
+		t.Errorf("missing claim: %s", stdout)
+	}
+
+	_, _, err = runCmd([]string{"jwt", "sign", "--key", plainKeyFile, "--alg", "RS256"}, nil, nil)
+	if err == nil {
+		t.Error("expected error for algorithm which does not match the key")
+	}
+	expired, _, err := runCmd([]string{"jwt", "sign", "--key", plainKeyFile, "--expiry", "-1m"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"jwt", "verify", "--jwks", jwksFile}, expired, nil)
+	if err == nil {
+		t.Error("expected error for expired token")
+	}
+	tampered := strings.Replace(token.String(), ".", ".e", 1)
+	_, _, err = runCmd([]string{"jwt", "verify", "--jwks", jwksFile}, strings.NewReader(tampered), nil)
+	if err == nil {
+		t.Error("expected error for tampered token")
+	}
+}
+
+func Test_jwks_jwt_rsa(t *testing.T) {
+	dir := t.TempDir()
+	caCert := writeTestCert(t, dir, "ca", newTestCA(t, "Root", nil))
+	rsaCert := filepath.Join(dir, "rsa.crt")
+	_, _, err := runCmd([]string{"create", rsaCert, "--key-alg", "RSA", "--sign-cert", caCert}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RSA keys are published without algorithm so that tokens signed with
+	// any RSA algorithm can be verified
+	jwksFile := filepath.Join(dir, "jwks.json")
+	_, _, err = runCmd([]string{"jwks", rsaCert, "-o", jwksFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{"RS256", "RS512", "PS256", "PS384"} {
+		token, _, err := runCmd([]string{"jwt", "sign", "--key", filepath.Join(dir, "rsa.key"), "--cert", rsaCert, "--alg", alg}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = runCmd([]string{"jwt", "verify", "--jwks", jwksFile}, token, nil)
+		if err != nil {
+			t.Errorf("%s: %s", alg, err)
+		}
+	}
+
+	jwksFile = filepath.Join(dir, "plain.json")
+	_, _, err = runCmd([]string{"jwks", filepath.Join(dir, "rsa.key"), "-o", jwksFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := runCmd([]string{"jwt", "sign", "--key", filepath.Join(dir, "rsa.key"), "--alg", "PS256"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"jwt", "verify", "--jwks", jwksFile}, token, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
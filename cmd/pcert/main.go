@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -38,12 +39,31 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer, getEnv func(s
 	err := rootCmd.Execute()
 	if err != nil {
 		fmt.Fprintln(stderr, err)
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			return exitErr.code
+		}
 		return 1
 	}
 
 	return 0
 }
 
+// exitCodeError is returned by commands which use an exit code other than 1
+// to report a certain result.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
 func newRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "pcert",
@@ -63,6 +83,24 @@ prefix (e.g PCERT_CERT instad of --cert).`,
 		newSignCmd(),
 		newShowCmd(),
 		newConnectCmd(),
+		newScanCmd(),
+		newPinCmd(),
+		newDiffCmd(),
+		newFindCmd(),
+		newACMECmd(),
+		newESTCmd(),
+		newSCEPCmd(),
+		newServeCmd(),
+		newTSACmd(),
+		newCMSCmd(),
+		newSMIMECmd(),
+		newJWKSCmd(),
+		newJWTCmd(),
+		newSPIFFECmd(),
+		newCheckCmd(),
+		newExporterCmd(),
+		newConvertCmd(),
+		newASN1ParseCmd(),
 		newListCmd(),
 		newCompletionCmd(),
 		newVersionCmd(),
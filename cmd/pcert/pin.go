@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+type pinResult struct {
+	Subject   string `json:"subject"`
+	Issuer    string `json:"issuer"`
+	PinSHA256 string `json:"pin_sha256"`
+	Match     bool   `json:"match"`
+}
+
+func newPinCmd() *cobra.Command {
+	var (
+		opts   = newConnectOptions()
+		pins   []string
+		format = "text"
+	)
+	cmd := &cobra.Command{
+		Use:   "pin <host:port>",
+		Short: "Print and check the public key pins of the chain of an endpoint",
+		Long: `Connect to an endpoint and print the SPKI pins (pin-sha256) of all
+certificates presented by the server.
+
+With --pin the presented chain is compared against the expected pins. The
+command fails if no certificate of the chain matches one of the expected pins.
+Pins can be specified as base64 value, as sha256/<base64> or as
+pin-sha256="<base64>".`,
+		Example: `  # print the pins of the chain
+  pcert pin example.com:443
+
+  # check that the server presents one of the expected keys
+  pcert pin --pin sha256/QNnz+LBx8iOvhpxs/pMsrxEcm06v2pZZs+9jrk5YZUk= example.com:443`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format '%s'. valid formats are text and json", format)
+			}
+
+			expected := map[string]bool{}
+			for _, pin := range pins {
+				pin, err := parsePin(pin)
+				if err != nil {
+					return err
+				}
+				expected[pin] = true
+			}
+
+			err := opts.loadFiles()
+			if err != nil {
+				return err
+			}
+
+			conn, err := dialTLS(cmd.Context(), args[0], opts)
+			if err != nil {
+				return err
+			}
+			conn.Close()
+
+			results := pinResults(conn.ConnectionState().PeerCertificates, expected)
+			if format == "json" {
+				err = printPinJSON(cmd.OutOrStdout(), results)
+			} else {
+				err = printPinText(cmd.OutOrStdout(), results)
+			}
+			if err != nil {
+				return err
+			}
+
+			if len(expected) == 0 {
+				return nil
+			}
+			for _, result := range results {
+				if result.Match {
+					return nil
+				}
+			}
+			return fmt.Errorf("no certificate presented by %s matches the expected pins", args[0])
+		},
+	}
+	registerConnectFlags(cmd, opts)
+	cmd.Flags().StringSliceVar(&pins, "pin", pins, "Expected pin. Can be specified multiple times. At least one certificate of the chain has to match one of the pins.")
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Output format. Valid formats are text and json.")
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveDefault
+	})
+	return cmd
+}
+
+// parsePin returns the base64 encoded hash of a pin in one of the formats
+// <base64>, sha256/<base64> or pin-sha256="<base64>".
+func parsePin(pin string) (string, error) {
+	value := strings.TrimSpace(pin)
+	value = strings.TrimPrefix(value, "sha256/")
+	if strings.HasPrefix(value, "pin-sha256=") {
+		value = strings.Trim(strings.TrimPrefix(value, "pin-sha256="), `"`)
+	}
+	hash, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(hash) != 32 {
+		return "", fmt.Errorf("invalid pin '%s': expected base64 encoded SHA-256 hash", pin)
+	}
+	return value, nil
+}
+
+func pinResults(certs []*x509.Certificate, expected map[string]bool) []pinResult {
+	results := []pinResult{}
+	for _, cert := range certs {
+		pin := pcert.NewCertificateFingerprints(cert).PinSHA256
+		results = append(results, pinResult{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			PinSHA256: pin,
+			Match:     expected[pin],
+		})
+	}
+	return results
+}
+
+func printPinText(w io.Writer, results []pinResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PIN-SHA256\tMATCH\tSUBJECT")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%t\t%s\n", r.PinSHA256, r.Match, r.Subject)
+	}
+	return tw.Flush()
+}
+
+func printPinJSON(w io.Writer, results []pinResult) error {
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
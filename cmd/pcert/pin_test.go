@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_parsePin(t *testing.T) {
+	const pin = "QNnz+LBx8iOvhpxs/pMsrxEcm06v2pZZs+9jrk5YZUk="
+	for _, input := range []string{pin, "sha256/" + pin, `pin-sha256="` + pin + `"`, " " + pin + "\n"} {
+		got, err := parsePin(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		if got != pin {
+			t.Errorf("%s: got=%s want=%s", input, got, pin)
+		}
+	}
+	for _, invalid := range []string{"", "sha1/" + pin, "Zm9v", "not base64"} {
+		_, err := parsePin(invalid)
+		if err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func Test_pin(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	caFile := writeTestCert(t, t.TempDir(), "ca", ca)
+	addr := newTLSServer(t, server.tlsCertificate(ca.cert))
+
+	caPin, err := pcert.PublicKeyPin(ca.cert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPin, err := pcert.PublicKeyPin(newTestCA(t, "Other", nil).cert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runCmd([]string{"pin", "--ca", caFile, addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), caPin) || !strings.Contains(stdout.String(), "CN=localhost") {
+		t.Errorf("pins missing in output:\n%s", stdout)
+	}
+
+	stdout, _, err = runCmd([]string{"pin", "--ca", caFile, "--format", "json", "--pin", otherPin, "--pin", "sha256/" + caPin, addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []pinResult{}
+	err = json.Unmarshal(stdout.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Match || !results[1].Match {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	_, _, err = runCmd([]string{"pin", "--ca", caFile, "--pin", otherPin, addr}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "no certificate presented") {
+		t.Errorf("expected pin mismatch error, got %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+// tlsReport describes a TLS handshake with a server.
+type tlsReport struct {
+	Address      string `json:"address"`
+	ServerName   string `json:"server_name"`
+	Version      string `json:"version"`
+	CipherSuite  string `json:"cipher_suite"`
+	ALPN         string `json:"alpn"`
+	Resumed      bool   `json:"resumed"`
+	ResumedError string `json:"resumed_error,omitempty"`
+
+	OCSP       *ocspReport       `json:"ocsp,omitempty"`
+	SCTs       []sctReport       `json:"scts"`
+	ClientAuth *clientAuthReport `json:"client_auth,omitempty"`
+
+	Verification verificationReport `json:"verification"`
+
+	Certificates []*x509.Certificate `json:"-"`
+}
+
+// ocspReport describes a stapled OCSP response. The times are nil if the
+// response is invalid or does not contain them.
+type ocspReport struct {
+	Status         string     `json:"status"`
+	SerialNumber   string     `json:"serial_number,omitempty"`
+	ProducedAt     *time.Time `json:"produced_at,omitempty"`
+	ThisUpdate     *time.Time `json:"this_update,omitempty"`
+	NextUpdate     *time.Time `json:"next_update,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	SignatureValid bool       `json:"signature_valid"`
+	Error          string     `json:"error,omitempty"`
+}
+
+type sctReport struct {
+	Source    string    `json:"source"`
+	Version   int       `json:"version"`
+	LogID     string    `json:"log_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type clientAuthReport struct {
+	AcceptableCAs []string `json:"acceptable_cas"`
+}
+
+type verificationReport struct {
+	OK          bool       `json:"ok"`
+	Error       string     `json:"error,omitempty"`
+	Explanation string     `json:"explanation,omitempty"`
+	Chains      [][]string `json:"chains,omitempty"`
+}
+
+// newTLSReport connects to addr and collects information about the
+// handshake. The server certificate is not verified during the handshake but
+// afterwards, so that the report can explain verification failures. A second
+// connection is made to check if session resumption works.
+func newTLSReport(ctx context.Context, addr string, opts *connectOptions) (*tlsReport, error) {
+	report := &tlsReport{
+		Address: addr,
+		SCTs:    []sctReport{},
+	}
+
+	reportOpts := *opts
+	reportOpts.TLSConfig = opts.TLSConfig.Clone()
+	reportOpts.TLSConfig.InsecureSkipVerify = true
+	reportOpts.TLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	reportOpts.TLSConfig.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		report.ClientAuth = &clientAuthReport{
+			AcceptableCAs: []string{},
+		}
+		for _, rawName := range info.AcceptableCAs {
+			report.ClientAuth.AcceptableCAs = append(report.ClientAuth.AcceptableCAs, rawNameToString(rawName))
+		}
+		if len(opts.TLSConfig.Certificates) > 0 {
+			return &opts.TLSConfig.Certificates[0], nil
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	conn, err := dialTLS(ctx, addr, &reportOpts)
+	if err != nil {
+		return nil, err
+	}
+	// In TLS 1.3 session tickets are sent after the handshake. A short
+	// read gives the client the chance to process them.
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, _ = conn.Read(make([]byte, 1))
+	conn.Close()
+
+	state := conn.ConnectionState()
+	report.ServerName = state.ServerName
+	if report.ServerName == "" {
+		report.ServerName = reportOpts.TLSConfig.ServerName
+	}
+	report.Version = tls.VersionName(state.Version)
+	report.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	report.ALPN = state.NegotiatedProtocol
+	report.Certificates = state.PeerCertificates
+
+	resumeConn, err := dialTLS(ctx, addr, &reportOpts)
+	if err != nil {
+		report.ResumedError = err.Error()
+	} else {
+		report.Resumed = resumeConn.ConnectionState().DidResume
+		resumeConn.Close()
+	}
+
+	if len(state.OCSPResponse) > 0 {
+		report.OCSP = newOCSPReport(state.OCSPResponse, state.PeerCertificates)
+	}
+
+	for _, raw := range state.SignedCertificateTimestamps {
+		sct, err := pcert.ParseSCT(raw)
+		if err != nil {
+			continue
+		}
+		report.SCTs = append(report.SCTs, newSCTReport("tls", sct))
+	}
+	if len(state.PeerCertificates) > 0 {
+		scts, _ := pcert.CertificateSCTs(state.PeerCertificates[0])
+		for _, sct := range scts {
+			report.SCTs = append(report.SCTs, newSCTReport("certificate", sct))
+		}
+	}
+
+	report.Verification = verifyPeerCertificates(state.PeerCertificates, report.ServerName, opts.TLSConfig.RootCAs)
+	return report, nil
+}
+
+func newSCTReport(source string, sct *pcert.SignedCertificateTimestamp) sctReport {
+	return sctReport{
+		Source:    source,
+		Version:   sct.Version,
+		LogID:     base64.StdEncoding.EncodeToString(sct.LogID),
+		Timestamp: sct.Timestamp,
+	}
+}
+
+func newOCSPReport(der []byte, certs []*x509.Certificate) *ocspReport {
+	resp, err := pcert.ParseOCSPResponse(der)
+	if err != nil {
+		return &ocspReport{
+			Status: "invalid",
+			Error:  err.Error(),
+		}
+	}
+	report := &ocspReport{
+		Status:       resp.Status.String(),
+		SerialNumber: encodeSerial(resp.SerialNumber),
+		ProducedAt:   optionalTime(resp.ProducedAt),
+		ThisUpdate:   optionalTime(resp.ThisUpdate),
+		NextUpdate:   optionalTime(resp.NextUpdate),
+		RevokedAt:    optionalTime(resp.RevokedAt),
+	}
+
+	switch {
+	case len(certs) > 0 && resp.SerialNumber.Cmp(certs[0].SerialNumber) != 0:
+		report.Error = "response is not for the server certificate"
+	case len(certs) < 2:
+		report.Error = "issuer not presented by the server, signature not verified"
+	default:
+		err = resp.CheckSignatureFrom(certs[1])
+		if err != nil {
+			report.Error = err.Error()
+		} else {
+			report.SignatureValid = true
+		}
+	}
+	return report
+}
+
+// rawNameToString returns the string representation of a DER encoded
+// distinguished name.
+func rawNameToString(raw []byte) string {
+	var rdn pkix.RDNSequence
+	_, err := asn1.Unmarshal(raw, &rdn)
+	if err != nil {
+		return fmt.Sprintf("invalid name: %s", err)
+	}
+	var name pkix.Name
+	name.FillFromRDNSequence(&rdn)
+	return name.String()
+}
+
+// verifyPeerCertificates verifies the certificates presented by a server
+// against roots. If roots is nil the system trust store is used.
+func verifyPeerCertificates(certs []*x509.Certificate, serverName string, roots *x509.CertPool) verificationReport {
+	if len(certs) == 0 {
+		return verificationReport{
+			Error:       "no certificates",
+			Explanation: "The server did not present any certificates.",
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return verificationReport{
+			Error:       err.Error(),
+			Explanation: explainVerifyError(err, certs),
+		}
+	}
+
+	report := verificationReport{
+		OK: true,
+	}
+	for _, chain := range chains {
+		subjects := []string{}
+		for _, cert := range chain {
+			subjects = append(subjects, cert.Subject.String())
+		}
+		report.Chains = append(report.Chains, subjects)
+	}
+	return report
+}
+
+// explainVerifyError returns a human readable explanation and hint for a
+// verification error.
+func explainVerifyError(err error, certs []*x509.Certificate) string {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalidErr       x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		if len(certs) == 1 && pcert.IsSelfSigned(certs[0]) {
+			return "The server certificate is self-signed and not trusted. Use --ca to specify it as trusted certificate."
+		}
+		return "The chain does not end in a trusted root. Either the server does not send all intermediate certificates (try --fetch-issuers) or the root is not trusted (use --ca)."
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("The server certificate is not valid for '%s'. Valid names are: %s. Use --servername to verify another name.", hostnameErr.Host, strings.Join(certificateNames(hostnameErr.Certificate), ", "))
+	case errors.As(err, &invalidErr):
+		switch invalidErr.Reason {
+		case x509.Expired:
+			return fmt.Sprintf("The certificate '%s' is not valid at the current time (valid from %s until %s).", invalidErr.Cert.Subject, invalidErr.Cert.NotBefore.Format(time.RFC3339), invalidErr.Cert.NotAfter.Format(time.RFC3339))
+		case x509.NotAuthorizedToSign:
+			return fmt.Sprintf("The certificate '%s' is used as issuer but is not a CA certificate.", invalidErr.Cert.Subject)
+		case x509.IncompatibleUsage:
+			return "The certificate does not allow server authentication (extended key usage ServerAuth is missing)."
+		case x509.CANotAuthorizedForThisName:
+			return "A CA in the chain has name constraints which do not allow the names of the server certificate."
+		case x509.TooManyIntermediates:
+			return "The chain violates the max path length of a CA certificate."
+		default:
+			return "The chain contains an invalid certificate."
+		}
+	default:
+		return "The certificate chain could not be verified."
+	}
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{}
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+	return names
+}
+
+// optionalTime returns nil for the zero time so that it is omitted in JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func printReportText(w io.Writer, r *tlsReport) {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "address:      %s\n", r.Address)
+	fmt.Fprintf(sb, "server name:  %s\n", r.ServerName)
+	fmt.Fprintf(sb, "version:      %s\n", r.Version)
+	fmt.Fprintf(sb, "cipher suite: %s\n", r.CipherSuite)
+	alpn := r.ALPN
+	if alpn == "" {
+		alpn = "none"
+	}
+	fmt.Fprintf(sb, "alpn:         %s\n", alpn)
+	fmt.Fprintf(sb, "resumption:   %t", r.Resumed)
+	if r.ResumedError != "" {
+		fmt.Fprintf(sb, " (%s)", r.ResumedError)
+	}
+	fmt.Fprintln(sb)
+
+	if r.OCSP == nil {
+		fmt.Fprintf(sb, "ocsp staple:  none\n")
+	} else {
+		fmt.Fprintf(sb, "ocsp staple\n")
+		fmt.Fprintf(sb, "    status:      %s\n", r.OCSP.Status)
+		if r.OCSP.Status != "invalid" {
+			fmt.Fprintf(sb, "    serial:      %s\n", r.OCSP.SerialNumber)
+			fmt.Fprintf(sb, "    produced at: %s\n", formatTime(r.OCSP.ProducedAt))
+			fmt.Fprintf(sb, "    this update: %s\n", formatTime(r.OCSP.ThisUpdate))
+			fmt.Fprintf(sb, "    next update: %s\n", formatTime(r.OCSP.NextUpdate))
+			if r.OCSP.Status == pcert.OCSPRevoked.String() {
+				fmt.Fprintf(sb, "    revoked at:  %s\n", formatTime(r.OCSP.RevokedAt))
+			}
+			fmt.Fprintf(sb, "    signature:   %t\n", r.OCSP.SignatureValid)
+		}
+		if r.OCSP.Error != "" {
+			fmt.Fprintf(sb, "    error:       %s\n", r.OCSP.Error)
+		}
+	}
+
+	if len(r.SCTs) == 0 {
+		fmt.Fprintf(sb, "scts:         none\n")
+	} else {
+		fmt.Fprintf(sb, "scts\n")
+		for _, sct := range r.SCTs {
+			fmt.Fprintf(sb, "    %s %s (%s)\n", sct.LogID, formatTime(&sct.Timestamp), sct.Source)
+		}
+	}
+
+	if r.ClientAuth == nil {
+		fmt.Fprintf(sb, "client auth:  not requested\n")
+	} else {
+		fmt.Fprintf(sb, "client auth:  requested\n")
+		for _, ca := range r.ClientAuth.AcceptableCAs {
+			fmt.Fprintf(sb, "    acceptable ca: %s\n", ca)
+		}
+	}
+
+	if r.Verification.OK {
+		fmt.Fprintf(sb, "verification: ok\n")
+		for _, chain := range r.Verification.Chains {
+			fmt.Fprintf(sb, "    chain: %s\n", strings.Join(chain, " -> "))
+		}
+	} else {
+		fmt.Fprintf(sb, "verification: failed\n")
+		fmt.Fprintf(sb, "    error:       %s\n", r.Verification.Error)
+		fmt.Fprintf(sb, "    explanation: %s\n", r.Verification.Explanation)
+	}
+	fmt.Fprintln(w, sb.String())
+}
+
+func printReportJSON(w io.Writer, r *tlsReport) {
+	certs := []*jsonCertificate{}
+	for _, cert := range r.Certificates {
+		certs = append(certs, &jsonCertificate{cert})
+	}
+	out, err := json.MarshalIndent(struct {
+		*tlsReport
+		Certificates []*jsonCertificate `json:"certificates"`
+	}{r, certs}, "", "  ")
+	if err != nil {
+		// should never fail because all fields are marshalable
+		panic(err)
+	}
+	fmt.Fprintf(w, "%s\n", out)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+// testOCSPStaple returns an OCSP response with the status good for cert
+// signed by the ECDSA key of issuer.
+func testOCSPStaple(t *testing.T, cert *x509.Certificate, issuer *testCert) []byte {
+	t.Helper()
+	type certID struct {
+		HashAlgorithm  pkix.AlgorithmIdentifier
+		IssuerNameHash []byte
+		IssuerKeyHash  []byte
+		SerialNumber   *big.Int
+	}
+	type singleResponse struct {
+		CertID     certID
+		Good       asn1.Flag `asn1:"tag:0"`
+		ThisUpdate time.Time `asn1:"generalized"`
+		NextUpdate time.Time `asn1:"generalized,explicit,tag:0"`
+	}
+	type responseData struct {
+		ResponderID asn1.RawValue
+		ProducedAt  time.Time `asn1:"generalized"`
+		Responses   []singleResponse
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(issuer.cert.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameHash := sha1.Sum(issuer.cert.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	now := time.Now().UTC().Truncate(time.Second)
+	tbs, err := asn1.Marshal(responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: issuer.cert.RawSubject},
+		ProducedAt:  now,
+		Responses: []singleResponse{{
+			CertID: certID{
+				HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, Parameters: asn1.NullRawValue},
+				IssuerNameHash: nameHash[:],
+				IssuerKeyHash:  keyHash[:],
+				SerialNumber:   cert.SerialNumber,
+			},
+			Good:       true,
+			ThisUpdate: now.Add(-time.Hour),
+			NextUpdate: now.Add(time.Hour),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	signature, err := issuer.key.(*ecdsa.PrivateKey).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := asn1.Marshal(struct {
+		TBSResponseData    asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+	}{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staple, err := asn1.Marshal(struct {
+		Status   asn1.Enumerated
+		Response struct {
+			ResponseType asn1.ObjectIdentifier
+			Response     []byte
+		} `asn1:"explicit,tag:0"`
+	}{
+		Response: struct {
+			ResponseType asn1.ObjectIdentifier
+			Response     []byte
+		}{asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}, basic},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return staple
+}
+
+func Test_connect_report(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+
+	tlsCert := server.tlsCertificate(ca.cert)
+	tlsCert.OCSPStaple = testOCSPStaple(t, server.cert, ca)
+	// SCT v1 of the log ID 0x0101... without extensions and with an empty
+	// sha256/ecdsa signature
+	sct, err := hex.DecodeString("00" + strings.Repeat("01", 32) + "0000018cc9a1b2c8" + "0000" + "0403" + "0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert.SignedCertificateTimestamps = [][]byte{sct}
+
+	addr := newTLSServerWithConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		ClientAuth:   tls.RequestClientCert,
+		ClientCAs:    newCertPool(ca.cert),
+		NextProtos:   []string{"h2"},
+	})
+	dir := t.TempDir()
+	caFile := writeTestCert(t, dir, "ca", ca)
+
+	t.Run("text", func(t *testing.T) {
+		stdout, _, err := runCmd([]string{"connect", "--report", "--ca", caFile, "--alpn", "h2", "--servername", "localhost", addr}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{
+			"version:      TLS 1.3",
+			"alpn:         h2",
+			"resumption:   true",
+			"status:      good",
+			"signature:   true",
+			"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+			"acceptable ca: CN=Root",
+			"verification: ok",
+			"chain: CN=localhost -> CN=Root",
+		} {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Errorf("'%s' not found in report:\n%s", expected, stdout)
+			}
+		}
+		certs, err := pcert.ParseAll(stdout.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 1 || !certs[0].Equal(server.cert) {
+			t.Errorf("server certificate not printed after report")
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		stdout, _, err := runCmd([]string{"connect", "--report", "--format", "json", "--insecure", addr}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := struct {
+			Version      string             `json:"version"`
+			OCSP         *ocspReport        `json:"ocsp"`
+			SCTs         []sctReport        `json:"scts"`
+			Verification verificationReport `json:"verification"`
+			Certificates []map[string]any   `json:"certificates"`
+			ClientAuth   *clientAuthReport  `json:"client_auth"`
+		}{}
+		err = json.Unmarshal(stdout.Bytes(), &report)
+		if err != nil {
+			t.Fatalf("invalid json: %s: %s", err, stdout)
+		}
+		if report.OCSP == nil || report.OCSP.Status != "good" {
+			t.Fatalf("ocsp staple missing: %+v", report.OCSP)
+		}
+		if report.OCSP.NextUpdate == nil || report.OCSP.RevokedAt != nil || strings.Contains(stdout.String(), `"revoked_at"`) {
+			t.Errorf("unexpected ocsp times: %+v", report.OCSP)
+		}
+		if len(report.SCTs) != 1 || report.SCTs[0].Source != "tls" {
+			t.Errorf("sct missing: %+v", report.SCTs)
+		}
+		if report.ClientAuth == nil || len(report.ClientAuth.AcceptableCAs) != 1 {
+			t.Errorf("acceptable CAs missing: %+v", report.ClientAuth)
+		}
+		if len(report.Certificates) != 1 {
+			t.Errorf("wrong number of certificates: got=%d want=%d", len(report.Certificates), 1)
+		}
+		// --insecure only skips the verification during the handshake
+		if report.Verification.OK || report.Verification.Explanation == "" {
+			t.Errorf("verification against system roots did not fail: %+v", report.Verification)
+		}
+	})
+}
+
+func Test_explainVerifyError(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	client := newTestCert(t, &pcert.CertificateOptions{
+		ProfileClient: true,
+		Certificate: x509.Certificate{
+			Subject:  pkix.Name{CommonName: "localhost"},
+			DNSNames: []string{"localhost"},
+		},
+	}, ca)
+	roots := newCertPool(ca.cert)
+
+	tests := []struct {
+		name       string
+		certs      []*x509.Certificate
+		serverName string
+		roots      *x509.CertPool
+		expected   string
+	}{
+		{"unknown authority", []*x509.Certificate{server.cert}, "localhost", x509.NewCertPool(), "--fetch-issuers"},
+		{"self-signed", []*x509.Certificate{ca.cert}, "", x509.NewCertPool(), "self-signed"},
+		{"hostname", []*x509.Certificate{server.cert}, "example.com", roots, "Valid names are: localhost, 127.0.0.1"},
+		{"no server auth", []*x509.Certificate{client.cert}, "localhost", roots, "ServerAuth"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := verifyPeerCertificates(test.certs, test.serverName, test.roots)
+			if report.OK {
+				t.Fatal("verification did not fail")
+			}
+			if !strings.Contains(report.Explanation, test.expected) {
+				t.Errorf("explanation does not contain '%s': %s", test.expected, report.Explanation)
+			}
+		})
+	}
+}
+
+func newCertPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
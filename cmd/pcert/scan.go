@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultScanWorkers = 10
+	defaultScanTimeout = time.Second * 10
+
+	// maxScanTargets limits the number of targets a single expression
+	// (e.g. a large CIDR range) expands to.
+	maxScanTargets = 1 << 16
+)
+
+// scanResult is the result of a single target of a scan.
+type scanResult struct {
+	Target     string     `json:"target"`
+	Error      string     `json:"error,omitempty"`
+	Subject    string     `json:"subject,omitempty"`
+	Issuer     string     `json:"issuer,omitempty"`
+	NotAfter   *time.Time `json:"not_after,omitempty"`
+	DaysLeft   int        `json:"days_left"`
+	ServerName string     `json:"server_name,omitempty"`
+	// NameCovered is true if the leaf certificate is valid for ServerName.
+	NameCovered  bool               `json:"name_covered"`
+	Verification verificationReport `json:"verification"`
+	Chain        []string           `json:"chain,omitempty"`
+	// Leaf is the certificate presented by the server.
+	Leaf *x509.Certificate `json:"-"`
+}
+
+func newScanCmd() *cobra.Command {
+	var (
+		opts     = newConnectOptions()
+		workers  = defaultScanWorkers
+		format   = "table"
+		progress = isTerminal(os.Stderr)
+	)
+	opts.Timeout = defaultScanTimeout
+	cmd := &cobra.Command{
+		Use:   "scan <TARGET|FILE>...",
+		Short: "Connect to many endpoints concurrently and report on their certificates",
+		Long: `Connect to many endpoints concurrently and report expiry, issuer, name
+coverage and verification status of their certificates.
+
+A target is host:port. The host can also be a CIDR range and the port a list
+or a range of ports (e.g. 10.0.0.0/24:443,8443 or example.com:8000-8010). If
+an argument is an existing file, targets are read from it line by line. Empty
+lines and lines starting with # are ignored. With - targets are read from
+STDIN.
+
+The server certificates are verified after the handshake, so endpoints with
+invalid certificates are reported as well.`,
+		Example: `  # scan endpoints listed in a file
+  pcert scan targets.txt
+
+  # scan a network on two ports and write CSV
+  pcert scan --format csv 10.0.0.0/24:443,8443 > scan.csv`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var printer func(w io.Writer, results []scanResult) error
+			switch format {
+			case "table":
+				printer = printScanTable
+			case "json":
+				printer = printScanJSON
+			case "csv":
+				printer = printScanCSV
+			default:
+				return fmt.Errorf("unknown format '%s'. valid formats are table, json and csv", format)
+			}
+			if workers < 1 {
+				return fmt.Errorf("workers must be at least 1")
+			}
+
+			err := opts.loadFiles()
+			if err != nil {
+				return err
+			}
+
+			targets, err := readScanTargets(args, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+
+			var progressOut io.Writer
+			if progress {
+				progressOut = cmd.ErrOrStderr()
+			}
+			results := scan(cmd.Context(), targets, opts, workers, progressOut)
+
+			err = printer(cmd.OutOrStdout(), results)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, result := range results {
+				if result.Error != "" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to connect to %d of %d targets", failed, len(results))
+			}
+			return nil
+		},
+	}
+	registerConnectFlags(cmd, opts)
+	cmd.Flags().IntVarP(&workers, "workers", "w", workers, "Number of targets which are scanned concurrently.")
+	cmd.Flags().StringVarP(&format, "format", "f", format, "Output format. Valid formats are table, json and csv.")
+	cmd.Flags().BoolVar(&progress, "progress", progress, "Show a progress bar on STDERR. Defaults to true if STDERR is a terminal.")
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "json", "csv"}, cobra.ShellCompDirectiveDefault
+	})
+	return cmd
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// readScanTargets expands the arguments to a list of host:port targets.
+func readScanTargets(args []string, stdin io.Reader) ([]string, error) {
+	var targets []string
+	for _, arg := range args {
+		var data []byte
+		switch {
+		case arg == "-":
+			var err error
+			data, err = io.ReadAll(stdin)
+			if err != nil {
+				return nil, err
+			}
+		case fileExists(arg):
+			var err error
+			data, err = os.ReadFile(arg)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			expanded, err := expandScanTarget(arg)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, expanded...)
+			continue
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		lineNr := 0
+		for scanner.Scan() {
+			lineNr++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			expanded, err := expandScanTarget(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", arg, lineNr, err)
+			}
+			targets = append(targets, expanded...)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return targets, nil
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && !info.IsDir()
+}
+
+// expandScanTarget expands a target expression of the form host:ports where
+// host is a hostname, an IP address or a CIDR range and ports is a comma
+// separated list of ports and port ranges.
+func expandScanTarget(target string) ([]string, error) {
+	idx := strings.LastIndex(target, ":")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid target '%s': port missing", target)
+	}
+	host, portList := target[:idx], target[idx+1:]
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		return nil, fmt.Errorf("invalid target '%s': host missing", target)
+	}
+
+	ports, err := parsePorts(portList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target '%s': %w", target, err)
+	}
+
+	hosts := []string{host}
+	if strings.Contains(host, "/") {
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target '%s': %w", target, err)
+		}
+		hosts = nil
+		for addr := prefix.Masked().Addr(); prefix.Contains(addr); addr = addr.Next() {
+			if len(hosts)*len(ports) >= maxScanTargets {
+				return nil, fmt.Errorf("invalid target '%s': more than %d targets", target, maxScanTargets)
+			}
+			hosts = append(hosts, addr.String())
+		}
+	}
+
+	targets := []string{}
+	for _, h := range hosts {
+		for _, port := range ports {
+			targets = append(targets, net.JoinHostPort(h, strconv.Itoa(port)))
+		}
+	}
+	return targets, nil
+}
+
+func parsePorts(portList string) ([]int, error) {
+	ports := []int{}
+	for _, part := range strings.Split(portList, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			end, err = parsePort(last)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid port range '%s'", part)
+		}
+		for port := start; port <= end; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port '%s'", s)
+	}
+	return port, nil
+}
+
+// scan connects to all targets using a pool of workers. The results are in
+// the same order as the targets. If progress is not nil a progress bar is
+// written to it.
+func scan(ctx context.Context, targets []string, opts *connectOptions, workers int, progress io.Writer) []scanResult {
+	scanOpts := *opts
+	scanOpts.TLSConfig = opts.TLSConfig.Clone()
+	// we verify the certificates after the handshake to report the
+	// result instead of failing the connection
+	scanOpts.TLSConfig.InsecureSkipVerify = true
+
+	var (
+		results = make([]scanResult, len(targets))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		done    = 0
+	)
+	for i := 0; i < workers && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = scanTarget(ctx, targets[idx], &scanOpts, opts.TLSConfig.RootCAs)
+				if progress != nil {
+					mu.Lock()
+					done++
+					printProgress(progress, done, len(targets))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for idx := range targets {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	if progress != nil {
+		fmt.Fprintln(progress)
+	}
+	return results
+}
+
+func printProgress(w io.Writer, done, total int) {
+	const width = 40
+	filled := done * width / total
+	fmt.Fprintf(w, "\r[%s%s] %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), done, total)
+}
+
+func scanTarget(ctx context.Context, target string, opts *connectOptions, roots *x509.CertPool) scanResult {
+	result := scanResult{
+		Target: target,
+	}
+	conn, err := dialTLS(ctx, target, opts)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	conn.Close()
+
+	state := conn.ConnectionState()
+	certs := state.PeerCertificates
+	leaf := certs[0]
+	result.Leaf = leaf
+	result.Subject = leaf.Subject.String()
+	result.Issuer = leaf.Issuer.String()
+	result.NotAfter = &leaf.NotAfter
+	result.DaysLeft = int(time.Until(leaf.NotAfter).Hours() / 24)
+	result.ServerName = state.ServerName
+	if result.ServerName == "" {
+		result.ServerName, _, _ = net.SplitHostPort(target)
+	}
+	result.NameCovered = leaf.VerifyHostname(result.ServerName) == nil
+	result.Verification = verifyPeerCertificates(certs, result.ServerName, roots)
+	for _, cert := range certs {
+		result.Chain = append(result.Chain, cert.Subject.String())
+	}
+	return result
+}
+
+func (r *scanResult) status() string {
+	switch {
+	case r.Error != "":
+		return "error: " + r.Error
+	case r.Verification.OK:
+		return "ok"
+	default:
+		return "invalid: " + r.Verification.Error
+	}
+}
+
+func printScanTable(w io.Writer, results []scanResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSUBJECT\tISSUER\tNOT AFTER\tDAYS\tNAME\tSTATUS")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t%s\n", r.Target, r.status())
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%t\t%s\n", r.Target, r.Subject, r.Issuer, formatTime(r.NotAfter), r.DaysLeft, r.NameCovered, r.status())
+	}
+	return tw.Flush()
+}
+
+func printScanJSON(w io.Writer, results []scanResult) error {
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func printScanCSV(w io.Writer, results []scanResult) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"target", "subject", "issuer", "not_after", "days_left", "name_covered", "verified", "error"})
+	for _, r := range results {
+		notAfter := ""
+		if r.NotAfter != nil {
+			notAfter = r.NotAfter.Format(time.RFC3339)
+		}
+		errMsg := r.Error
+		if errMsg == "" {
+			errMsg = r.Verification.Error
+		}
+		_ = cw.Write([]string{
+			r.Target,
+			r.Subject,
+			r.Issuer,
+			notAfter,
+			strconv.Itoa(r.DaysLeft),
+			strconv.FormatBool(r.NameCovered),
+			strconv.FormatBool(r.Verification.OK),
+			errMsg,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_expandScanTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected []string
+	}{
+		{"example.com:443", []string{"example.com:443"}},
+		{"example.com:443,8443", []string{"example.com:443", "example.com:8443"}},
+		{"example.com:8000-8002", []string{"example.com:8000", "example.com:8001", "example.com:8002"}},
+		{"10.0.0.0/31:443", []string{"10.0.0.0:443", "10.0.0.1:443"}},
+		{"10.0.0.5/31:443", []string{"10.0.0.4:443", "10.0.0.5:443"}},
+		{"[::1]:443", []string{"[::1]:443"}},
+	}
+	for _, test := range tests {
+		targets, err := expandScanTarget(test.target)
+		if err != nil {
+			t.Errorf("%s: %s", test.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(targets, test.expected) {
+			t.Errorf("%s: got=%v want=%v", test.target, targets, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{"example.com", ":443", "example.com:0", "example.com:9-1", "10.0.0.0/8:443"} {
+		_, err := expandScanTarget(invalid)
+		if err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func Test_scan(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	dir := t.TempDir()
+	caFile := writeTestCert(t, dir, "ca", ca)
+
+	valid := newTLSServer(t, server.tlsCertificate())
+	selfSigned := newTLSServer(t, ca.tlsCertificate())
+
+	// a port which refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	targetFile := filepath.Join(dir, "targets.txt")
+	err = os.WriteFile(targetFile, []byte("# test targets\n"+valid+"\n\n"+selfSigned+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runCmd([]string{"scan", "--ca", caFile, "--format", "json", targetFile, closed}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Fatalf("expected error for closed port: %v", err)
+	}
+
+	results := []scanResult{}
+	err = json.Unmarshal(stdout.Bytes(), &results)
+	if err != nil {
+		t.Fatalf("invalid json: %s: %s", err, stdout)
+	}
+	if len(results) != 3 {
+		t.Fatalf("wrong number of results: got=%d want=%d", len(results), 3)
+	}
+	if results[0].Target != valid || !results[0].Verification.OK || !results[0].NameCovered {
+		t.Errorf("valid target not reported correctly: %+v", results[0])
+	}
+	if results[1].Verification.OK || results[1].Subject != "CN=Root" {
+		t.Errorf("self-signed target not reported correctly: %+v", results[1])
+	}
+	if results[0].NotAfter == nil {
+		t.Errorf("valid target has no not_after: %+v", results[0])
+	}
+	if results[2].Error == "" || results[2].NotAfter != nil {
+		t.Errorf("closed target not reported correctly: %+v", results[2])
+	}
+
+	stdout, _, err = runCmd([]string{"scan", "--ca", caFile, "--format", "csv", valid}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(stdout).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][6] != "true" {
+		t.Errorf("unexpected csv output: %v", records)
+	}
+
+	stdout, _, err = runCmd([]string{"scan", "--ca", caFile, valid}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "TARGET") || !strings.Contains(stdout.String(), "ok") {
+		t.Errorf("unexpected table output: %s", stdout)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newSCEPCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scep",
+		Short: "SCEP (RFC 8894) server",
+	}
+	cmd.AddCommand(
+		newSCEPServeCmd(),
+	)
+	return cmd
+}
+
+type scepServeOptions struct {
+	Listen            string
+	Path              string
+	SignCert          string
+	SignKey           string
+	RACert            string
+	RAKey             string
+	ChallengePassword string
+	Server            pcert.SCEPServer
+}
+
+func newSCEPServeCmd() *cobra.Command {
+	opts := &scepServeOptions{
+		Listen:   ":8080",
+		Path:     "/scep",
+		SignCert: "ca.crt",
+		Server: pcert.SCEPServer{
+			CertificateOptions: pcert.CertificateOptions{
+				ProfileClient: true,
+			},
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a SCEP server which signs CSRs with a local CA",
+		Long: `Run a SCEP (RFC 8894) server which signs CSRs with a CA created with
+'pcert create --ca'. The operations GetCACaps, GetCACert and PKIOperation with
+PKCSReq messages are supported. Renewal and polling requests are not supported.
+
+The requests are encrypted for and the responses are signed by the RA
+certificate. SCEP uses RSA key transport, so the RA key and the keys of the
+clients have to be RSA keys. If no --ra-cert is set, an RA certificate with a
+new RSA key is issued by the CA on every start. Clients which cache the
+GetCACert response should use a server with a fixed --ra-cert.
+
+Every CSR has to contain the challenge password set with
+--challenge-password. The certificates are created like with 'pcert sign'.
+The subject and names are taken from the CSR.`,
+		Example: `  # serve SCEP on http://localhost:8080/scep
+  pcert scep serve --challenge-password secret
+
+  # use an existing RA certificate
+  pcert scep serve --challenge-password secret --ra-cert ra.crt`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := opts.load()
+			if err != nil {
+				return err
+			}
+			mux := http.NewServeMux()
+			mux.Handle(opts.Path, &opts.Server)
+			server := &http.Server{
+				Addr:              opts.Listen,
+				Handler:           mux,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = server.Shutdown(shutdownCtx)
+			}()
+
+			printServerURL(cmd.ErrOrStderr(), "SCEP server", "http", opts.Listen, nil, opts.Path)
+			err = server.ListenAndServe()
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the server listens.")
+	cmd.Flags().StringVar(&opts.Path, "path", opts.Path, "URL path of the SCEP server.")
+	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "CA certificate used to sign the certificates. Further certificates in the file are returned by GetCACert.")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key of the CA. If not set the key file relative to --sign-cert is used.")
+	cmd.Flags().StringVar(&opts.RACert, "ra-cert", opts.RACert, "RA certificate with an RSA key. If not set an RA certificate is issued by the CA.")
+	cmd.Flags().StringVar(&opts.RAKey, "ra-key", opts.RAKey, "Key of the RA certificate. If not set the key file relative to --ra-cert is used.")
+	cmd.Flags().StringVar(&opts.ChallengePassword, "challenge-password", opts.ChallengePassword, "Challenge password which has to be set in the CSRs.")
+	cmd.Flags().BoolVar(&opts.Server.CertificateOptions.ProfileServer, "server", opts.Server.CertificateOptions.ProfileServer, "Issue certificates with settings typical for a server certificate.")
+	cmd.Flags().BoolVar(&opts.Server.CertificateOptions.ProfileClient, "client", opts.Server.CertificateOptions.ProfileClient, "Issue certificates with settings typical for a client certificate.")
+	cmd.Flags().Var(newDurationValue(&opts.Server.CertificateOptions.Expiry), "expiry", "Validity period of the issued certificates.")
+	return cmd
+}
+
+// load reads the CA and the RA certificate. If no RA certificate is set, one
+// is issued by the CA.
+func (o *scepServeOptions) load() error {
+	if o.ChallengePassword == "" {
+		return errors.New("--challenge-password is required")
+	}
+	ca, err := loadSigningCA(o.SignCert, o.SignKey)
+	if err != nil {
+		return err
+	}
+	o.Server.CACert = ca.Cert
+	o.Server.CAKey = ca.Key
+	o.Server.Chain = ca.Chain
+
+	if o.RACert != "" {
+		ra, err := loadSigningCA(o.RACert, o.RAKey)
+		if err != nil {
+			return err
+		}
+		if ra.Cert.PublicKeyAlgorithm != x509.RSA {
+			return fmt.Errorf("RA certificate '%s' has no RSA key", o.RACert)
+		}
+		o.Server.RACert = ra.Cert
+		o.Server.RAKey = ra.Key
+	} else {
+		raOpts := &pcert.CertificateOptions{
+			Certificate: x509.Certificate{
+				Subject:  pkix.Name{CommonName: ca.Cert.Subject.CommonName + " SCEP RA"},
+				KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			},
+		}
+		der, key, err := pcert.CreateCertificateWithKeyOptions(pcert.NewCertificate(raOpts), pcert.KeyOptions{Algorithm: x509.RSA}, ca.Cert, ca.Key)
+		if err != nil {
+			return err
+		}
+		o.Server.RACert, err = x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		o.Server.RAKey = key
+	}
+
+	challengePassword := []byte(o.ChallengePassword)
+	o.Server.VerifyChallenge = func(_ *x509.CertificateRequest, password string) bool {
+		return subtle.ConstantTimeCompare([]byte(password), challengePassword) == 1
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_scep(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "SCEP CA", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+
+	opts := &scepServeOptions{SignCert: caFile}
+	err := opts.load()
+	if err == nil || !strings.Contains(err.Error(), "--challenge-password") {
+		t.Errorf("expected error without challenge password, got %v", err)
+	}
+
+	opts.ChallengePassword = "secret"
+	opts.Server.CertificateOptions.ProfileClient = true
+	err = opts.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.Server.RACert.CheckSignatureFrom(ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&opts.Server)
+	defer server.Close()
+
+	client := &pcert.SCEPClient{URL: server.URL}
+	ctx := context.Background()
+	csrTemplate := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "router-1"}}
+	csrDER, key, err := pcert.CreateRequestWithKeyOptions(csrTemplate, pcert.KeyOptions{Algorithm: x509.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := key.(crypto.Signer)
+	csrDER, err = pcert.AddChallengePassword(csrDER, signer, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := client.Enroll(ctx, csrDER, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(ca.cert); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "router-1" || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("unexpected certificate: %s %v", cert.Subject, cert.ExtKeyUsage)
+	}
+
+	// RA certificate without RSA key
+	raFile := writeTestCert(t, dir, "ra", newTestCA(t, "RA", nil))
+	opts = &scepServeOptions{SignCert: caFile, RACert: raFile, ChallengePassword: "secret"}
+	err = opts.load()
+	if err == nil || !strings.Contains(err.Error(), "no RSA key") {
+		t.Errorf("expected error for non RSA RA, got %v", err)
+	}
+}
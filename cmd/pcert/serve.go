@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+type serveOptions struct {
+	Listen    string
+	SignCert  string
+	SignKey   string
+	TLSCert   string
+	TLSKey    string
+	Hostnames []string
+	ClientCA  string
+	Config    string
+	Server    pcert.CAServer
+}
+
+// serveConfig is the configuration file of the clients of 'pcert serve'.
+type serveConfig struct {
+	Clients []serveClientConfig `json:"clients"`
+}
+
+// serveClientConfig is a client which authenticates with a token, an HMAC
+// secret or a client certificate of the client CA with the common name Name.
+type serveClientConfig struct {
+	Name              string   `json:"name"`
+	Token             string   `json:"token"`
+	HMACSecret        string   `json:"hmac_secret"`
+	ClientCertificate bool     `json:"client_certificate"`
+	AllowedNames      []string `json:"allowed_names"`
+	Profiles          []string `json:"profiles"`
+	MaxValidity       string   `json:"max_validity"`
+	Admin             bool     `json:"admin"`
+}
+
+func newServeCmd() *cobra.Command {
+	opts := &serveOptions{
+		Listen:    ":8443",
+		SignCert:  "ca.crt",
+		Hostnames: []string{"localhost"},
+	}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run an HTTP API which issues certificates with a local CA",
+		Long: `Run a JSON/HTTP API over HTTPS which issues certificates with a CA created
+with 'pcert create --ca'. The API offers the following endpoints:
+
+  GET  /ca            CA certificate and chain in PEM format
+  POST /sign          sign a CSR: {"csr": "PEM", "profile": "server", "validity": "720h"}
+  POST /issue         create a key and certificate: {"common_name": "...", "dns_names": [...], "profile": "client"}
+  POST /revoke        revoke a certificate: {"serial_number": "hex", "reason": 1}
+  GET  /certificates  list the issued certificates
+
+The clients are configured in the JSON file set with --config. Each client
+authenticates with a bearer token (token), with requests signed with an HMAC
+secret (hmac_secret) or with a client certificate whose common name is the
+name of the client (client_certificate). Client certificates are verified
+with the separate CA set with --client-ca. Certificates issued by the API
+itself are never accepted for authentication, as clients could otherwise
+obtain a certificate with the name of another client. A client
+certificate entry with the name '*' applies to all common names. The
+policy of a client restricts the names (allowed_names), the profiles and the
+validity (max_validity) of the certificates. Clients can list and revoke their
+own certificates, admins all certificates:
+
+  {
+    "clients": [
+      {"name": "ci", "token": "secret", "allowed_names": ["*.ci.example.com"], "profiles": ["server"], "max_validity": "30d"},
+      {"name": "deploy", "hmac_secret": "secret", "allowed_names": ["*.example.com"]},
+      {"name": "ops", "client_certificate": true, "allowed_names": ["*"], "admin": true}
+    ]
+  }
+
+The issued certificates are kept in memory and are lost if the server is
+stopped. If no --tls-cert is set a server certificate for --hostname is
+issued by the CA.`,
+		Example: `  pcert serve --config clients.json --client-ca clients-ca.crt
+  curl -H "Authorization: Bearer secret" -d '{"dns_names": ["a.ci.example.com"], "profile": "server"}' --cacert ca.crt https://localhost:8443/issue`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tlsConfig, err := opts.load()
+			if err != nil {
+				return err
+			}
+			server := &http.Server{
+				Addr:              opts.Listen,
+				Handler:           &opts.Server,
+				TLSConfig:         tlsConfig,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = server.Shutdown(shutdownCtx)
+			}()
+
+			printServerURL(cmd.ErrOrStderr(), "CA API", "https", opts.Listen, opts.Hostnames, "")
+			err = server.ListenAndServeTLS("", "")
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the server listens.")
+	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "CA certificate used to sign the certificates. Further certificates in the file are returned as chain.")
+	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key of the CA. If not set the key file relative to --sign-cert is used.")
+	cmd.Flags().StringVar(&opts.TLSCert, "tls-cert", opts.TLSCert, "Server certificate. If not set a certificate for --hostname is issued by the CA.")
+	cmd.Flags().StringVar(&opts.TLSKey, "tls-key", opts.TLSKey, "Key of the server certificate. If not set the key file relative to --tls-cert is used.")
+	cmd.Flags().StringSliceVar(&opts.Hostnames, "hostname", opts.Hostnames, "Names of the server certificate if --tls-cert is not set.")
+	cmd.Flags().StringVar(&opts.Config, "config", opts.Config, "JSON file with the clients and their policies.")
+	cmd.Flags().StringVar(&opts.ClientCA, "client-ca", opts.ClientCA, "CA certificates to verify the client certificates. Must not be the CA of --sign-cert.")
+	cmd.Flags().Var(newDurationValue(&opts.Server.Validity), "validity", "Validity of the certificates if the request sets none. Defaults to 90d.")
+	return cmd
+}
+
+// load reads the CA and the configuration and returns the TLS configuration
+// of the server.
+func (o *serveOptions) load() (*tls.Config, error) {
+	if o.Config == "" {
+		return nil, errors.New("--config is required")
+	}
+	data, err := os.ReadFile(o.Config)
+	if err != nil {
+		return nil, err
+	}
+	config := &serveConfig{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config '%s': %w", o.Config, err)
+	}
+
+	ca, err := loadSigningCA(o.SignCert, o.SignKey)
+	if err != nil {
+		return nil, err
+	}
+	o.Server.CACert = ca.Cert
+	o.Server.CAKey = ca.Key
+	o.Server.Chain = ca.Chain
+
+	roots, err := o.clientCAs(ca)
+	if err != nil {
+		return nil, err
+	}
+	tokens := &pcert.BearerTokenAuthenticator{Tokens: map[string]*pcert.CAIdentity{}}
+	hmacKeys := &pcert.HMACAuthenticator{Keys: map[string]pcert.HMACKey{}}
+	certs := &pcert.ClientCertificateAuthenticator{Roots: roots, Policies: map[string]pcert.CAPolicy{}}
+	for _, client := range config.Clients {
+		identity, err := client.identity()
+		if err != nil {
+			return nil, err
+		}
+		if client.Token == "" && client.HMACSecret == "" && !client.ClientCertificate {
+			return nil, fmt.Errorf("client '%s' has no token, hmac_secret or client_certificate", client.Name)
+		}
+		if client.Token != "" {
+			if _, ok := tokens.Tokens[client.Token]; ok {
+				return nil, fmt.Errorf("client '%s' uses the token of another client", client.Name)
+			}
+			tokens.Tokens[client.Token] = identity
+		}
+		if client.HMACSecret != "" {
+			hmacKeys.Keys[client.Name] = pcert.HMACKey{Secret: []byte(client.HMACSecret), Identity: identity}
+		}
+		if client.ClientCertificate {
+			if roots == nil {
+				return nil, fmt.Errorf("client '%s' uses client_certificate but --client-ca is not set", client.Name)
+			}
+			if client.Name == "*" {
+				certs.DefaultPolicy = &identity.Policy
+			} else {
+				certs.Policies[client.Name] = identity.Policy
+			}
+		}
+	}
+	o.Server.Authenticators = []pcert.CAAuthenticator{tokens, hmacKeys, certs}
+
+	tlsCert, err := ca.serverCertificate(o.TLSCert, o.TLSKey, o.Hostnames)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*tlsCert},
+	}
+	if roots != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = roots
+	}
+	return tlsConfig, nil
+}
+
+// clientCAs returns the pool of --client-ca or nil if it is not set. The
+// signing CA and its chain are rejected, as certificates issued by the
+// server must not authenticate clients.
+func (o *serveOptions) clientCAs(ca *signingCA) (*x509.CertPool, error) {
+	if o.ClientCA == "" {
+		return nil, nil
+	}
+	certs, err := readCertificateFile(o.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in '%s'", o.ClientCA)
+	}
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		for _, signing := range append([]*x509.Certificate{ca.Cert}, ca.Chain...) {
+			if bytes.Equal(cert.RawSubjectPublicKeyInfo, signing.RawSubjectPublicKeyInfo) {
+				return nil, fmt.Errorf("--client-ca must not contain the CA of --sign-cert or its chain ('%s')", cert.Subject)
+			}
+		}
+		roots.AddCert(cert)
+	}
+	return roots, nil
+}
+
+func (c *serveClientConfig) identity() (*pcert.CAIdentity, error) {
+	if c.Name == "" {
+		return nil, errors.New("client without name")
+	}
+	identity := &pcert.CAIdentity{
+		Name: c.Name,
+		Policy: pcert.CAPolicy{
+			AllowedNames: c.AllowedNames,
+			Profiles:     c.Profiles,
+			Admin:        c.Admin,
+		},
+	}
+	for _, profile := range c.Profiles {
+		if !slices.Contains(pcert.CAServerProfiles, profile) {
+			return nil, fmt.Errorf("invalid profile '%s' of client '%s'", profile, c.Name)
+		}
+	}
+	if c.MaxValidity != "" {
+		d, err := time.ParseDuration(c.MaxValidity)
+		if err != nil {
+			d, err = parseDuration(c.MaxValidity)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_validity of client '%s': %w", c.Name, err)
+		}
+		identity.Policy.MaxValidity = d
+	}
+	return identity, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_serve(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "API CA", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+	clientCA := newTestCA(t, "Client CA", nil)
+	clientCAFile := writeTestCert(t, dir, "client-ca", clientCA)
+	configFile := filepath.Join(dir, "clients.json")
+	config := `{"clients": [
+		{"name": "ci", "token": "ci-token", "allowed_names": ["*.ci.example.com"], "profiles": ["server"], "max_validity": "30d"},
+		{"name": "minter", "token": "minter-token", "allowed_names": ["*"], "profiles": ["client"]},
+		{"name": "ops", "client_certificate": true, "allowed_names": ["*"], "admin": true},
+		{"name": "*", "client_certificate": true, "allowed_names": ["*.mtls.example.com"]}
+	]}`
+	err := os.WriteFile(configFile, []byte(config), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &serveOptions{SignCert: caFile, ClientCA: clientCAFile, Config: configFile, Hostnames: []string{"127.0.0.1"}}
+	tlsConfig, err := opts.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(&opts.Server)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	issue := func(t *testing.T, client *http.Client, token, body string) (int, *pcert.CACertificateResponse) {
+		t.Helper()
+		req, err := http.NewRequest("POST", server.URL+"/issue", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		out := &pcert.CACertificateResponse{}
+		_ = json.NewDecoder(resp.Body).Decode(out)
+		return resp.StatusCode, out
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	status, resp := issue(t, client, "ci-token", `{"dns_names": ["a.ci.example.com"], "profile": "server"}`)
+	if status != http.StatusOK {
+		t.Fatalf("issue failed: %d", status)
+	}
+	cert, err := pcert.Parse([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "a.ci.example.com" || cert.NotAfter.Sub(cert.NotBefore) != 30*24*time.Hour {
+		t.Errorf("unexpected certificate: %s %s", cert.Subject, cert.NotAfter.Sub(cert.NotBefore))
+	}
+	status, _ = issue(t, client, "ci-token", `{"dns_names": ["a.example.com"], "profile": "server"}`)
+	if status != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", status)
+	}
+
+	// authenticate with a client certificate of the client CA
+	newMTLSClient := func(cert *x509.Certificate, key any) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: roots,
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{cert.Raw},
+				PrivateKey:  key,
+			}},
+		}}}
+	}
+	clientCert := newTestCert(t, &pcert.CertificateOptions{
+		ProfileClient: true,
+		Certificate:   x509.Certificate{Subject: pkix.Name{CommonName: "host.mtls.example.com"}},
+	}, clientCA)
+	status, _ = issue(t, newMTLSClient(clientCert.cert, clientCert.key), "", `{"common_name": "host.mtls.example.com", "profile": "client"}`)
+	if status != http.StatusOK {
+		t.Errorf("issue with client certificate failed: %d", status)
+	}
+
+	// a client which may issue any name must not be able to authenticate
+	// as the admin ops with a certificate issued by the API
+	status, resp = issue(t, client, "minter-token", `{"common_name": "ops", "profile": "client"}`)
+	if status != http.StatusOK {
+		t.Fatalf("issue failed: %d", status)
+	}
+	opsCert, err := pcert.Parse([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsKey, err := pcert.ParseKey([]byte(resp.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listResp, err := newMTLSClient(opsCert, opsKey).Get(server.URL + "/certificates")
+	if err == nil {
+		listResp.Body.Close()
+		if listResp.StatusCode == http.StatusOK {
+			t.Error("certificate issued by the API authenticated as admin")
+		}
+	}
+
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{`{"clients": [{"name": "a"}]}`, "has no token"},
+		{`{"clients": [{"name": "a", "token": "t"}, {"name": "b", "token": "t"}]}`, "token of another client"},
+		{`{"clients": [{"name": "a", "token": "t", "profiles": ["ca"]}]}`, "invalid profile"},
+		{`{"clients": [{"name": "a", "token": "t", "max_validity": "1w"}]}`, "invalid max_validity"},
+		{`{"clients": [{"name": "a", "client_certificate": true}]}`, "--client-ca is not set"},
+	} {
+		err := os.WriteFile(configFile, []byte(test.config), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = (&serveOptions{SignCert: caFile, Config: configFile, Hostnames: []string{"localhost"}}).load()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error '%s', got %v", test.err, err)
+		}
+	}
+	_, err = (&serveOptions{SignCert: caFile, ClientCA: caFile, Config: configFile, Hostnames: []string{"localhost"}}).load()
+	if err == nil || !strings.Contains(err.Error(), "must not contain the CA") {
+		t.Errorf("expected error for the signing CA as client CA, got %v", err)
+	}
+}
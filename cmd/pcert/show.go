@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,10 +29,14 @@ func newShowCmd() *cobra.Command {
 	var format string
 	cmd := &cobra.Command{
 		Use:   "show [FILE]",
-		Short: "Reads PEM encoded certificates and show information.",
-		Long: `Reads PEM encoded certificates and shows information like issuer, subject,
-validity used algorithms etc. If no file is provided PEM certificate is read
-from STDIN.`,
+		Short: "Reads certificates, CSRs, keys and CRLs and show information.",
+		Long: `Reads certificates, CSRs, private and public keys and CRLs and shows
+information like issuer, subject, validity used algorithms etc. If no file is
+provided the input is read from STDIN. The input can be PEM, DER or base64
+encoded. A PEM file may contain different types of blocks which are shown in
+the order they appear. Certificates can also be in a PKCS#7 bundle (.p7b).
+
+For keys it is shown if they match a certificate of the same input.`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var file string
@@ -38,7 +49,7 @@ from STDIN.`,
 			var input io.Reader
 
 			if file == "-" {
-				input = os.Stdin
+				input = cmd.InOrStdin()
 			} else {
 				file, err := os.Open(file)
 				if err != nil {
@@ -52,45 +63,63 @@ from STDIN.`,
 				return err
 			}
 
-			certs, err := pcert.ParseAll(inputBytes)
+			items, err := readShowItems(inputBytes)
 			if err != nil {
 				return err
 			}
 
-			if len(certs) == 0 {
-				return fmt.Errorf("no PEM encoded certificates found in input")
+			if len(items) == 0 {
+				return fmt.Errorf("no certificates, CSRs, keys or CRLs found in input")
 			}
 
-			var printer func(cert *x509.Certificate)
-			switch format {
-			case "text":
-				printer = printText
-			case "json":
-				printer = printJSON
-			case "pem":
-				printer = printPEM
+			// validate format
+			_, err = certPrinter(format)
+			if err != nil {
+				return err
 			}
 
-			for _, cert := range certs {
-				printer(cert)
+			// certificates in the input to check if keys match
+			var certs []*x509.Certificate
+			for _, item := range items {
+				if item.cert != nil {
+					certs = append(certs, item.cert)
+				}
+			}
+
+			for _, item := range items {
+				printShowItem(cmd.OutOrStdout(), format, item, certs)
 			}
 			return nil
 		},
 	}
-	cmd.Flags().StringVarP(&format, "format", "f", "text", "Format in which to print the certificate information. Valid formats are text, json and pem.")
+	cmd.Flags().StringVarP(&format, "format", "f", "text", "Format in which to print the information. Valid formats are text, json and pem.")
 	return cmd
 }
 
+// certPrinter returns the function to print a certificate in format.
+func certPrinter(format string) (func(w io.Writer, cert *x509.Certificate), error) {
+	switch format {
+	case "text":
+		return printText, nil
+	case "json":
+		return printJSON, nil
+	case "pem":
+		return printPEM, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s'. valid formats are text, json and pem", format)
+	}
+}
+
 func encodeSerial(s *big.Int) string {
 	return hex.EncodeToString(s.Bytes())
 }
 
-func printPEM(c *x509.Certificate) {
+func printPEM(w io.Writer, c *x509.Certificate) {
 	pem := pcert.Encode(c.Raw)
-	fmt.Printf("%s", pem)
+	fmt.Fprintf(w, "%s", pem)
 }
 
-func printText(c *x509.Certificate) {
+func printText(w io.Writer, c *x509.Certificate) {
 	sb := &strings.Builder{}
 	fmt.Fprintf(sb, "subject:    %s\n", c.Subject.String())
 	fmt.Fprintf(sb, "serial:     %s\n", encodeSerial(c.SerialNumber))
@@ -125,6 +154,12 @@ func printText(c *x509.Certificate) {
 	fmt.Fprintf(sb, "subject key identifier: %s\n", hex.EncodeToString(c.SubjectKeyId))
 	fmt.Fprintf(sb, "authority key identifier: %s\n", hex.EncodeToString(c.AuthorityKeyId))
 
+	fingerprints := pcert.NewCertificateFingerprints(c)
+	fmt.Fprintf(sb, "sha1 fingerprint: %s\n", fingerprints.SHA1)
+	fmt.Fprintf(sb, "sha256 fingerprint: %s\n", fingerprints.SHA256)
+	fmt.Fprintf(sb, "pin-sha256: %s\n", fingerprints.PinSHA256)
+	fmt.Fprintf(sb, "subject hash: %s\n", fingerprints.SubjectHash)
+
 	if len(c.OCSPServer) > 0 {
 		fmt.Fprintf(sb, "ocsp servers: %s\n", strings.Join(c.OCSPServer, ", "))
 	}
@@ -161,91 +196,381 @@ func printText(c *x509.Certificate) {
 			fmt.Fprintf(sb, "    uri:%s\n", uri)
 		}
 	}
-	fmt.Println(sb.String())
+	fmt.Fprintln(w, sb.String())
 }
 
-func printJSON(cert *x509.Certificate) {
-	// TODO: implement proper JSON encoding
-	jsonCert := &jsonCertificate{cert}
-	out, err := json.MarshalIndent(jsonCert, "", "  ")
+func printJSON(w io.Writer, cert *x509.Certificate) {
+	out, err := json.MarshalIndent(&jsonCertificate{cert}, "", "  ")
 	if err != nil {
 		// should never fail because all fields are marshalable
 		panic(err)
 	}
-	fmt.Printf("%s\n", out)
+	fmt.Fprintf(w, "%s\n", out)
 }
 
+// jsonCertificate encodes a certificate according to the schema of
+// pcert.CertificateJSON.
 type jsonCertificate struct {
 	*x509.Certificate
 }
 
 func (c *jsonCertificate) MarshalJSON() ([]byte, error) {
-	publicKeyInfo := map[string]any{
-		"algorithm": c.PublicKeyAlgorithm.String(),
+	return json.Marshal(pcert.NewCertificateJSON(c.Certificate))
+}
+
+// showItem is a single object of the input of the show command. Exactly one
+// of the fields besides block is set.
+type showItem struct {
+	// block is the PEM block of the item
+	block *pem.Block
+
+	cert       *x509.Certificate
+	csr        *x509.CertificateRequest
+	crl        *x509.RevocationList
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+	// unsupported is set for PEM blocks of an unknown type
+	unsupported bool
+}
+
+// readShowItems returns all certificates, CSRs, keys and CRLs of data in the
+// order they appear.
+func readShowItems(data []byte) ([]showItem, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		return readShowItemsBinary(data)
+	}
+
+	var items []showItem
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		item := showItem{
+			block: block,
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			item.cert, err = x509.ParseCertificate(block.Bytes)
+		case "PKCS7":
+			var certs []*x509.Certificate
+			certs, err = pcert.ParsePKCS7Certificates(block.Bytes)
+			for _, cert := range certs {
+				items = append(items, showItem{
+					block: &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw},
+					cert:  cert,
+				})
+			}
+			if err == nil {
+				continue
+			}
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			item.csr, err = x509.ParseCertificateRequest(block.Bytes)
+		case "X509 CRL":
+			item.crl, err = x509.ParseRevocationList(block.Bytes)
+		case "PRIVATE KEY", "EC PRIVATE KEY", "RSA PRIVATE KEY":
+			item.privateKey, err = pcert.ParseKey(pem.EncodeToMemory(block))
+		case "PUBLIC KEY":
+			item.publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			item.publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			item.unsupported = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", block.Type, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readShowItemsBinary reads DER or base64 encoded data which contains either
+// certificates, a CSR, a CRL or a key.
+func readShowItemsBinary(data []byte) ([]showItem, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	certs, certErr := pcert.ParseAll(data)
+	if certErr == nil {
+		var items []showItem
+		for _, cert := range certs {
+			items = append(items, showItem{
+				block: &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw},
+				cert:  cert,
+			})
+		}
+		return items, nil
+	}
+
+	if csr, err := pcert.ParseCSR(data); err == nil {
+		return []showItem{{block: &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}, csr: csr}}, nil
+	}
+	if key, err := pcert.ParseKey(data); err == nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return []showItem{{block: &pem.Block{Type: "PRIVATE KEY", Bytes: der}, privateKey: key}}, nil
+	}
+	// CRLs and public keys are only supported as DER
+	if crl, err := x509.ParseRevocationList(data); err == nil {
+		return []showItem{{block: &pem.Block{Type: "X509 CRL", Bytes: crl.Raw}, crl: crl}}, nil
+	}
+	if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+		return []showItem{{block: &pem.Block{Type: "PUBLIC KEY", Bytes: data}, publicKey: pub}}, nil
+	}
+	return nil, certErr
+}
+
+func printShowItem(w io.Writer, format string, item showItem, certs []*x509.Certificate) {
+	if format == "pem" {
+		fmt.Fprintf(w, "%s", pem.EncodeToMemory(item.block))
+		return
+	}
+
+	if item.cert != nil {
+		printer, _ := certPrinter(format)
+		printer(w, item.cert)
+		return
+	}
+
+	var info map[string]any
+	switch {
+	case item.csr != nil:
+		info = csrInfo(item.csr)
+	case item.crl != nil:
+		info = crlInfo(item.crl)
+	case item.privateKey != nil:
+		info = keyInfo(item.privateKey, true, certs)
+	case item.publicKey != nil:
+		info = keyInfo(item.publicKey, false, certs)
+	default:
+		info = map[string]any{
+			"type":       "unsupported",
+			"block_type": item.block.Type,
+		}
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			// should never fail because all fields are marshalable
+			panic(err)
+		}
+		fmt.Fprintf(w, "%s\n", out)
+		return
+	}
+
+	sb := &strings.Builder{}
+	switch {
+	case item.csr != nil:
+		printCSRText(sb, item.csr, info)
+	case item.crl != nil:
+		printCRLText(sb, item.crl)
+	case item.privateKey != nil, item.publicKey != nil:
+		printKeyText(sb, info)
+	default:
+		fmt.Fprintf(sb, "unsupported PEM block: %s\n", item.block.Type)
 	}
-	switch publicKey := c.PublicKey.(type) {
+	fmt.Fprintln(w, sb.String())
+}
+
+func publicKeyInfo(pub crypto.PublicKey) (algorithm string, size int) {
+	switch pk := pub.(type) {
 	case *rsa.PublicKey:
-		publicKeyInfo["size"] = publicKey.N.BitLen()
+		return x509.RSA.String(), pk.N.BitLen()
 	case *ecdsa.PublicKey:
-		publicKeyInfo["size"] = publicKey.Params().BitSize
-	}
-	tmp := map[string]any{
-		// top level
-		"signature_algorithm": c.SignatureAlgorithm.String(),
-		"signature":           c.Signature,
-
-		// tbsCertificate
-		"public_key":         publicKeyInfo,
-		"version":            c.Version,
-		"serial_number":      c.SerialNumber,
-		"issuer":             c.Issuer.String(),
-		"subject":            c.Subject.String(),
-		"not_before":         c.NotBefore,
-		"not_after":          c.NotAfter,
-		"key_usage":          pcert.KeyUsageToStringSlice(c.KeyUsage),
-		"extended_key_usage": c.ExtKeyUsage,
-		"san": map[string]any{
-			"dns":   c.DNSNames,
-			"ip":    c.IPAddresses,
-			"email": c.EmailAddresses,
-			"uri":   c.URIs,
-		},
+		return x509.ECDSA.String(), pk.Params().BitSize
+	case ed25519.PublicKey:
+		return x509.Ed25519.String(), 256
+	default:
+		return fmt.Sprintf("%T", pub), 0
 	}
-	extensions := []string{}
-	for _, extension := range c.Extensions {
-		name := "unknown"
-		for extName, e := range ext {
-			if e.Equal(extension.Id) {
-				name = extName
+}
+
+func keyInfo(key any, private bool, certs []*x509.Certificate) map[string]any {
+	pub := key
+	keyType := "public key"
+	if private {
+		keyType = "private key"
+		if signer, ok := key.(crypto.Signer); ok {
+			pub = signer.Public()
+		}
+	}
+	algorithm, size := publicKeyInfo(pub)
+	info := map[string]any{
+		"type":      keyType,
+		"algorithm": algorithm,
+		"size":      size,
+	}
+	if ecKey, ok := pub.(*ecdsa.PublicKey); ok {
+		info["curve"] = ecKey.Params().Name
+	}
+	if spki, err := x509.MarshalPKIXPublicKey(pub); err == nil {
+		sum := sha256.Sum256(spki)
+		info["fingerprint_sha256"] = hex.EncodeToString(sum[:])
+		info["pin_sha256"] = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	matches := []string{}
+	for _, cert := range certs {
+		if pk, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); ok && pk.Equal(cert.PublicKey) {
+			name := cert.Subject.String()
+			if name == "" {
+				name = "serial:" + encodeSerial(cert.SerialNumber)
 			}
+			matches = append(matches, name)
 		}
-		extensions = append(extensions, extension.Id.String()+" (+"+name+")")
 	}
-	tmp["extensions"] = extensions
-	if c.BasicConstraintsValid {
-		constraints := map[string]any{
-			"is_ca": c.IsCA,
+	info["matching_certificates"] = matches
+	return info
+}
+
+func printKeyText(sb *strings.Builder, info map[string]any) {
+	fmt.Fprintf(sb, "type:        %s\n", info["type"])
+	fmt.Fprintf(sb, "algorithm:   %s", info["algorithm"])
+	if curve, ok := info["curve"]; ok {
+		fmt.Fprintf(sb, " (%s)", curve)
+	}
+	fmt.Fprintln(sb)
+	fmt.Fprintf(sb, "size:        %d bit\n", info["size"])
+	fmt.Fprintf(sb, "fingerprint: %s\n", info["fingerprint_sha256"])
+	fmt.Fprintf(sb, "pin-sha256:  %s\n", info["pin_sha256"])
+	matches := info["matching_certificates"].([]string)
+	if len(matches) == 0 {
+		fmt.Fprintf(sb, "matches:     no certificate in input\n")
+	}
+	for _, subject := range matches {
+		fmt.Fprintf(sb, "matches:     %s\n", subject)
+	}
+}
+
+func extensionName(oid asn1.ObjectIdentifier) string {
+	name := pcert.ExtensionName(oid)
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+func csrInfo(csr *x509.CertificateRequest) map[string]any {
+	algorithm, size := publicKeyInfo(csr.PublicKey)
+	extensions := []string{}
+	for _, extension := range csr.Extensions {
+		extensions = append(extensions, extension.Id.String()+" ("+extensionName(extension.Id)+")")
+	}
+	signatureErr := csr.CheckSignature()
+	info := map[string]any{
+		"type":                "certificate request",
+		"subject":             csr.Subject.String(),
+		"signature_algorithm": csr.SignatureAlgorithm.String(),
+		"signature_valid":     signatureErr == nil,
+		"public_key": map[string]any{
+			"algorithm": algorithm,
+			"size":      size,
+		},
+		"san":        pcert.NewSubjectAltNamesJSON(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs),
+		"extensions": extensions,
+	}
+	if signatureErr != nil {
+		info["signature_error"] = signatureErr.Error()
+	}
+	return info
+}
+
+func printCSRText(sb *strings.Builder, csr *x509.CertificateRequest, info map[string]any) {
+	fmt.Fprintf(sb, "type:       certificate request\n")
+	fmt.Fprintf(sb, "subject:    %s\n", csr.Subject.String())
+	algorithm, size := publicKeyInfo(csr.PublicKey)
+	fmt.Fprintf(sb, "public key: %s (%d bit)\n", algorithm, size)
+	fmt.Fprintf(sb, "signature:  %s (valid: %t)\n", csr.SignatureAlgorithm.String(), info["signature_valid"])
+	if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		fmt.Fprintf(sb, "subject alternative names\n")
+		for _, dns := range csr.DNSNames {
+			fmt.Fprintf(sb, "    dns:%s\n", dns)
 		}
-		if c.MaxPathLen > 0 || (c.MaxPathLen == 0 && c.MaxPathLenZero) {
-			constraints["max_path_len"] = c.MaxPathLen
+		for _, ip := range csr.IPAddresses {
+			fmt.Fprintf(sb, "    ip:%s\n", ip)
+		}
+		for _, email := range csr.EmailAddresses {
+			fmt.Fprintf(sb, "    email:%s\n", email)
+		}
+		for _, uri := range csr.URIs {
+			fmt.Fprintf(sb, "    uri:%s\n", uri)
+		}
+	}
+	if len(csr.Extensions) > 0 {
+		fmt.Fprintf(sb, "requested extensions\n")
+		for _, extension := range info["extensions"].([]string) {
+			fmt.Fprintf(sb, "    %s\n", extension)
 		}
+	}
+}
 
-		tmp["basic_constraints"] = constraints
+var revocationReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+func revocationReason(code int) string {
+	if reason, ok := revocationReasons[code]; ok {
+		return reason
 	}
+	return strconv.Itoa(code)
+}
 
-	return json.Marshal(tmp)
+func crlInfo(crl *x509.RevocationList) map[string]any {
+	revoked := []map[string]any{}
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked = append(revoked, map[string]any{
+			"serial_number":   encodeSerial(entry.SerialNumber),
+			"revocation_time": entry.RevocationTime,
+			"reason":          revocationReason(entry.ReasonCode),
+		})
+	}
+	info := map[string]any{
+		"type":                "crl",
+		"issuer":              crl.Issuer.String(),
+		"this_update":         crl.ThisUpdate,
+		"signature_algorithm": crl.SignatureAlgorithm.String(),
+		"revoked":             revoked,
+	}
+	if !crl.NextUpdate.IsZero() {
+		info["next_update"] = crl.NextUpdate
+	}
+	if crl.Number != nil {
+		info["number"] = crl.Number.String()
+	}
+	return info
 }
 
-var ext = map[string]asn1.ObjectIdentifier{
-	"SubjectKeyId":          {1, 5, 29, 14},
-	"KeyUsage":              {2, 5, 29, 15},
-	"ExtendedKeyUsage":      {2, 5, 29, 37},
-	"AuthorityKeyId":        {2, 5, 29, 35},
-	"BasicConstraints":      {2, 5, 29, 19},
-	"SubjectAltName":        {2, 5, 29, 17},
-	"CertificatePolicies":   {2, 5, 29, 32},
-	"NameConstraints":       {2, 5, 29, 30},
-	"CRLDistributionPoints": {2, 5, 29, 31},
-	"AuthorityInfoAccess":   {1, 3, 6, 1, 5, 5, 7, 1, 1},
-	"CRLNumber":             {2, 5, 29, 20},
+func printCRLText(sb *strings.Builder, crl *x509.RevocationList) {
+	fmt.Fprintf(sb, "type:        crl\n")
+	fmt.Fprintf(sb, "issuer:      %s\n", crl.Issuer.String())
+	if crl.Number != nil {
+		fmt.Fprintf(sb, "number:      %s\n", crl.Number)
+	}
+	fmt.Fprintf(sb, "this update: %s\n", crl.ThisUpdate.Format(time.RFC3339))
+	if crl.NextUpdate.IsZero() {
+		fmt.Fprintf(sb, "next update: -\n")
+	} else {
+		fmt.Fprintf(sb, "next update: %s\n", crl.NextUpdate.Format(time.RFC3339))
+	}
+	fmt.Fprintf(sb, "signature:   %s\n", crl.SignatureAlgorithm.String())
+	fmt.Fprintf(sb, "revoked:     %d\n", len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		fmt.Fprintf(sb, "    serial:%s date:%s reason:%s\n", encodeSerial(entry.SerialNumber), entry.RevocationTime.Format(time.RFC3339), revocationReason(entry.ReasonCode))
+	}
 }
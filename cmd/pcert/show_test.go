@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/pcert"
+)
+
+// newTestMixedPEM returns a PEM file which contains a certificate with its
+// key, a CSR, a CRL and a block of an unsupported type.
+func newTestMixedPEM(t *testing.T) []byte {
+	t.Helper()
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+
+	csrDER, _, err := pcert.CreateRequest(&x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "request"},
+		DNSNames: []string{"request.example.com"},
+		URIs:     []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/request"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(42),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(1234), RevocationTime: time.Now(), ReasonCode: 1},
+		},
+	}, ca.cert, ca.key.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM, err := pcert.EncodeKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(pcert.Encode(server.cert.Raw))
+	buf.Write(keyPEM)
+	buf.Write(pcert.EncodeCSR(csrDER))
+	_ = pem.Encode(buf, &pem.Block{Type: "X509 CRL", Bytes: crlDER})
+	_ = pem.Encode(buf, &pem.Block{Type: "DH PARAMETERS", Bytes: []byte{0x30, 0x00}})
+	return buf.Bytes()
+}
+
+func Test_show_mixed(t *testing.T) {
+	input := newTestMixedPEM(t)
+
+	stdout, _, err := runCmd([]string{"show"}, bytes.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"subject:    CN=localhost",
+		"sha256 fingerprint: ",
+		"pin-sha256: ",
+		"subject hash: ",
+		"pin-sha256:  ",
+		"type:        private key",
+		"matches:     CN=localhost",
+		"type:       certificate request",
+		"dns:request.example.com",
+		"uri:spiffe://example.org/request",
+		"valid: true",
+		"number:      42",
+		"serial:04d2",
+		"reason:keyCompromise",
+		"unsupported PEM block: DH PARAMETERS",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("'%s' not found in output:\n%s", expected, stdout)
+		}
+	}
+
+	stdout, _, err = runCmd([]string{"show", "--format", "json"}, bytes.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	decoder := json.NewDecoder(stdout)
+	for decoder.More() {
+		raw := json.RawMessage{}
+		err = decoder.Decode(&raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := map[string]any{}
+		err = json.Unmarshal(raw, &obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objType, _ := obj["type"].(string)
+		types = append(types, objType)
+
+		switch objType {
+		case "certificate request":
+			csr := struct {
+				SAN pcert.SubjectAltNamesJSON `json:"san"`
+			}{}
+			err = json.Unmarshal(raw, &csr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(csr.SAN.URI) != 1 || csr.SAN.URI[0] != "spiffe://example.org/request" || csr.SAN.IP == nil || csr.SAN.Email == nil {
+				t.Errorf("unexpected subject alternative names: %s", raw)
+			}
+		case "crl":
+			crl := struct {
+				Number  string `json:"number"`
+				Revoked []struct {
+					SerialNumber string `json:"serial_number"`
+				} `json:"revoked"`
+			}{}
+			err = json.Unmarshal(raw, &crl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crl.Number != "42" || len(crl.Revoked) != 1 || crl.Revoked[0].SerialNumber != "04d2" {
+				t.Errorf("unexpected CRL: %s", raw)
+			}
+		}
+	}
+	expectedTypes := "|private key|certificate request|crl|unsupported"
+	if strings.Join(types, "|") != expectedTypes {
+		t.Errorf("wrong types in JSON output: got=%s want=%s", strings.Join(types, "|"), expectedTypes)
+	}
+
+	stdout, _, err = runCmd([]string{"show", "--format", "pem"}, bytes.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stdout.Bytes(), input) {
+		t.Errorf("PEM output differs from input:\n%s", stdout)
+	}
+}
+
+func Test_show_json(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+
+	stdout, _, err := runCmd([]string{"show", "--format", "json"}, bytes.NewReader(pcert.Encode(server.cert.Raw)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &pcert.CertificateJSON{}
+	err = json.Unmarshal(stdout.Bytes(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SchemaVersion != pcert.CertificateJSONVersion {
+		t.Errorf("schema version: got=%d want=%d", c.SchemaVersion, pcert.CertificateJSONVersion)
+	}
+	if c.SerialNumber != encodeSerial(server.cert.SerialNumber) {
+		t.Errorf("serial: got=%s want=%s", c.SerialNumber, encodeSerial(server.cert.SerialNumber))
+	}
+	if strings.Join(c.ExtendedKeyUsage, ",") != "ClientAuth,ServerAuth" {
+		t.Errorf("extended key usage: got=%v want=ClientAuth,ServerAuth", c.ExtendedKeyUsage)
+	}
+
+	// the output can be used as template
+	template, err := pcert.CertificateFromJSON(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Subject.CommonName != "localhost" || len(template.DNSNames) != 1 || template.DNSNames[0] != "localhost" {
+		t.Errorf("unexpected template: subject=%s dns=%v", template.Subject, template.DNSNames)
+	}
+}
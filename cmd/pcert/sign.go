@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/x509"
 	"fmt"
 	"os"
 
@@ -16,6 +17,8 @@ type signOptions struct {
 
 	SignCert string
 	SignKey  string
+
+	OutFormat string
 }
 
 func newSignCmd() *cobra.Command {
@@ -30,6 +33,10 @@ func newSignCmd() *cobra.Command {
 		Use:   "sign [CSR-IN] [CERT-OUT]",
 		Short: "Create a certificate based on a CSR",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			err := checkSPIFFEFlags(cmd.Flags())
+			if err != nil {
+				return err
+			}
 			if len(args) > 0 {
 				opts.CSR = args[0]
 			}
@@ -88,9 +95,22 @@ func newSignCmd() *cobra.Command {
 				return err
 			}
 
-			certPEM := pcert.Encode(certDER)
+			newCert, err := x509.ParseCertificate(certDER)
+			if err != nil {
+				return err
+			}
+			if opts.CertificateOptions.ProfileSPIFFE {
+				_, err = pcert.CheckX509SVID(newCert)
+				if err != nil {
+					return err
+				}
+			}
+			certOut, err := encodeCertificates(opts.OutFormat, newCert)
+			if err != nil {
+				return err
+			}
 
-			err = writeStdoutOrFile(opts.Cert, certPEM, 0o644, cmd.OutOrStdout())
+			err = writeStdoutOrFile(opts.Cert, certOut, 0o644, cmd.OutOrStdout())
 			if err != nil {
 				return err
 			}
@@ -101,6 +121,8 @@ func newSignCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&opts.SignCert, "sign-cert", "s", opts.SignCert, "Certificate used to sign. If not specified a self-signed certificate is created")
 	cmd.Flags().StringVar(&opts.SignKey, "sign-key", opts.SignKey, "Key used to sign. If not specified but --sign-cert is specified we use the key file relative to the certificate specified with --sign-cert.")
+	cmd.Flags().Var(newOutFormatValue(&opts.OutFormat), "out-format", "Format of the certificate output (pem, der or p7b).")
+	_ = cmd.RegisterFlagCompletionFunc("out-format", outFormatCompletionFunc)
 
 	registerCertFlags(cmd, &opts.CertificateOptions)
 
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newSMIMECmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "smime",
+		Short: "Encrypt and decrypt S/MIME messages",
+	}
+	cmd.AddCommand(
+		newSMIMEEncryptCmd(),
+		newSMIMEDecryptCmd(),
+	)
+	return cmd
+}
+
+type smimeEncryptOptions struct {
+	To                []string
+	ContentEncryption string
+	Format            string
+	Output            string
+}
+
+func newSMIMEEncryptCmd() *cobra.Command {
+	opts := &smimeEncryptOptions{
+		ContentEncryption: pcert.DefaultContentEncryption,
+		Format:            "smime",
+	}
+	cmd := &cobra.Command{
+		Use:   "encrypt [FILE]",
+		Short: "Encrypt a message for one or more recipients",
+		Long: `Encrypts FILE or stdin for the recipients set with --to as CMS EnvelopedData
+or, with AES-GCM, as AuthEnvelopedData. The content encryption key is
+encrypted with RSAES-OAEP for RSA recipients and with ECDH for ECDSA
+recipients.
+
+The output is an S/MIME message (smime), DER (der) or PEM (pem). The message
+is encrypted as is. If it should be readable by a mail client, FILE has to be
+a MIME entity (e.g. with a 'Content-Type: text/plain' header).`,
+		Example: `  pcert create alice.crt --sign-cert ca.crt --ext-key-usage EmailProtection --subject /CN=Alice --email alice@example.com
+  pcert smime encrypt --to alice.crt --to bob.crt msg.txt -o msg.p7m`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(opts.To) == 0 {
+				return errors.New("at least one --to is required")
+			}
+			if !slices.Contains([]string{"smime", "der", "pem"}, opts.Format) {
+				return fmt.Errorf("unknown format '%s'. valid formats are smime, der and pem", opts.Format)
+			}
+			recipients := []*x509.Certificate{}
+			for _, file := range opts.To {
+				certs, err := readCertificateFile(file)
+				if err != nil {
+					return err
+				}
+				if len(certs) == 0 {
+					return fmt.Errorf("no certificates found in '%s'", file)
+				}
+				recipients = append(recipients, certs[0])
+			}
+			file := ""
+			if len(args) == 1 {
+				file = args[0]
+			}
+			content, err := readStdinOrFile(file, &stdinKeeper{stdin: cmd.InOrStdin()})
+			if err != nil {
+				return err
+			}
+
+			out, err := pcert.EncryptCMS(content, recipients, opts.ContentEncryption)
+			if err != nil {
+				return err
+			}
+			switch opts.Format {
+			case "smime":
+				out, err = pcert.EncodeSMIME(out)
+				if err != nil {
+					return err
+				}
+			case "pem":
+				out = pcert.EncodePKCS7(out)
+			}
+			return writeStdoutOrFile(opts.Output, out, 0o644, cmd.OutOrStdout())
+		},
+	}
+	algorithms := []string{}
+	for name := range pcert.ContentEncryptionAlgorithms {
+		algorithms = append(algorithms, name)
+	}
+	slices.Sort(algorithms)
+	cmd.Flags().StringSliceVar(&opts.To, "to", opts.To, "Certificate of a recipient.")
+	cmd.Flags().StringVar(&opts.ContentEncryption, "cipher", opts.ContentEncryption, fmt.Sprintf("Content encryption algorithm (%s).", strings.Join(algorithms, ", ")))
+	cmd.Flags().StringVar(&opts.Format, "format", opts.Format, "Output format (smime, der or pem).")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", opts.Output, "Output file. If not set the output is written to STDOUT.")
+	_ = cmd.RegisterFlagCompletionFunc("cipher", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return algorithms, cobra.ShellCompDirectiveDefault
+	})
+	_ = cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"smime", "der", "pem"}, cobra.ShellCompDirectiveDefault
+	})
+	return cmd
+}
+
+type smimeDecryptOptions struct {
+	Cert   string
+	Key    string
+	Output string
+}
+
+func newSMIMEDecryptCmd() *cobra.Command {
+	opts := &smimeDecryptOptions{}
+	cmd := &cobra.Command{
+		Use:   "decrypt [FILE]",
+		Short: "Decrypt a message",
+		Long: `Decrypts an S/MIME message or a CMS EnvelopedData or AuthEnvelopedData
+structure in DER or PEM format read from FILE or stdin with the key of a
+recipient.`,
+		Example: `  pcert smime decrypt --key alice.key msg.p7m`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Key == "" && opts.Cert == "" {
+				return errors.New("--key or --cert is required")
+			}
+			certFile := opts.Cert
+			if certFile == "" {
+				certFile = strings.TrimSuffix(opts.Key, filepath.Ext(opts.Key)) + certFileSuffix
+			}
+			recipient, err := loadSigningCA(certFile, opts.Key)
+			if err != nil {
+				return err
+			}
+			file := ""
+			if len(args) == 1 {
+				file = args[0]
+			}
+			data, err := readStdinOrFile(file, &stdinKeeper{stdin: cmd.InOrStdin()})
+			if err != nil {
+				return err
+			}
+			content, err := pcert.DecryptCMS(data, recipient.Cert, recipient.Key)
+			if err != nil {
+				return err
+			}
+			return writeStdoutOrFile(opts.Output, content, 0o600, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVar(&opts.Key, "key", opts.Key, "Key of the recipient.")
+	cmd.Flags().StringVar(&opts.Cert, "cert", opts.Cert, "Certificate of the recipient. If not set the certificate file relative to --key is used.")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", opts.Output, "Output file. If not set the output is written to STDOUT.")
+	return cmd
+}
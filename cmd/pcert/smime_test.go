@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_smime(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Root", nil)
+	newRecipient := func(name string, alg x509.PublicKeyAlgorithm) string {
+		der, key, err := pcert.CreateCertificateWithKeyOptions(pcert.NewCertificate(&pcert.CertificateOptions{
+			Certificate: x509.Certificate{
+				Subject:        pkix.Name{CommonName: name},
+				EmailAddresses: []string{name + "@example.com"},
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			},
+		}), pcert.KeyOptions{Algorithm: alg}, ca.cert, ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeTestCert(t, dir, name, &testCert{cert: cert, key: key})
+	}
+	alice := newRecipient("alice", x509.RSA)
+	bob := newRecipient("bob", x509.ECDSA)
+	eve := newRecipient("eve", x509.ECDSA)
+
+	msg := "Content-Type: text/plain\r\n\r\nhello\r\n"
+	for _, format := range []string{"smime", "der", "pem"} {
+		encrypted := filepath.Join(dir, "msg."+format)
+		_, _, err := runCmd([]string{"smime", "encrypt", "--to", alice, "--to", bob, "--format", format, "-o", encrypted}, strings.NewReader(msg), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"alice.key", "bob.key"} {
+			stdout, _, err := runCmd([]string{"smime", "decrypt", "--key", filepath.Join(dir, key), encrypted}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != msg {
+				t.Errorf("unexpected message: %s", stdout)
+			}
+		}
+		_, _, err = runCmd([]string{"smime", "decrypt", "--cert", eve, encrypted}, nil, nil)
+		if err == nil {
+			t.Error("expected error for other recipient")
+		}
+	}
+
+	encrypted, _, err := runCmd([]string{"smime", "encrypt", "--to", alice, "--cipher", "aes-128-cbc"}, strings.NewReader(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(encrypted.String(), "smime-type=enveloped-data") {
+		t.Errorf("unexpected S/MIME message: %s", encrypted)
+	}
+	decrypted := filepath.Join(dir, "decrypted.txt")
+	_, _, err = runCmd([]string{"smime", "decrypt", "--key", filepath.Join(dir, "alice.key"), "-o", decrypted}, encrypted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != msg {
+		t.Errorf("unexpected message: %s", data)
+	}
+
+	_, _, err = runCmd([]string{"smime", "encrypt", "--to", alice, "--cipher", "des"}, strings.NewReader(msg), nil)
+	if err == nil {
+		t.Error("expected error for unknown cipher")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newSPIFFECmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spiffe",
+		Short: "SPIFFE trust bundles",
+		Long:  `Commands for SPIFFE. X509-SVIDs are created with 'pcert create --spiffe-id'.`,
+	}
+	cmd.AddCommand(
+		newSPIFFEBundleCmd(),
+	)
+	return cmd
+}
+
+type spiffeBundleOptions struct {
+	TrustDomain string
+	RefreshHint time.Duration
+	Sequence    uint64
+	Output      string
+}
+
+func newSPIFFEBundleCmd() *cobra.Command {
+	opts := &spiffeBundleOptions{}
+	cmd := &cobra.Command{
+		Use:   "bundle CA-FILE...",
+		Short: "Create a SPIFFE trust bundle from CA certificates",
+		Long: `Creates a SPIFFE trust bundle in JWKS format with the CA certificates of
+CA-FILE as X.509 authorities (use x509-svid). If --trust-domain is set, CA
+certificates with a SPIFFE ID of another trust domain are rejected.`,
+		Example: `  pcert create root.crt --ca --spiffe-id spiffe://example.org
+  pcert create intermediate.crt --ca --spiffe-id spiffe://example.org --sign-cert root.crt
+  pcert create workload.crt --spiffe-id spiffe://example.org/ns/default/sa/api --sign-cert intermediate.crt
+  pcert spiffe bundle --trust-domain example.org root.crt -o bundle.json`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bundle, err := newSPIFFEBundle(opts, args)
+			if err != nil {
+				return err
+			}
+			out, err := json.MarshalIndent(bundle, "", "  ")
+			if err != nil {
+				return err
+			}
+			return writeStdoutOrFile(opts.Output, append(out, '\n'), 0o644, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVar(&opts.TrustDomain, "trust-domain", opts.TrustDomain, "Trust domain of the bundle.")
+	cmd.Flags().DurationVar(&opts.RefreshHint, "refresh-hint", opts.RefreshHint, "Refresh hint (spiffe_refresh_hint) of the bundle.")
+	cmd.Flags().Uint64Var(&opts.Sequence, "sequence", opts.Sequence, "Sequence number (spiffe_sequence) of the bundle.")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", opts.Output, "Output file. If not set the output is written to STDOUT.")
+	return cmd
+}
+
+func newSPIFFEBundle(opts *spiffeBundleOptions, files []string) (*pcert.SPIFFEBundle, error) {
+	cas := []*x509.Certificate{}
+	for _, file := range files {
+		certs, err := readCertificateFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificates found in '%s'", file)
+		}
+		cas = append(cas, certs...)
+	}
+	bundle, err := pcert.NewSPIFFEBundle(cas, opts.TrustDomain, opts.RefreshHint)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Sequence = opts.Sequence
+	return bundle, nil
+}
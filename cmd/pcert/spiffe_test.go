@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_spiffe(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root.crt")
+	intermediate := filepath.Join(dir, "intermediate.crt")
+	workload := filepath.Join(dir, "workload.crt")
+	for _, args := range [][]string{
+		{"create", root, "--ca", "--spiffe-id", "spiffe://example.org", "--subject", "/CN=Root"},
+		{"create", intermediate, "--ca", "--spiffe-id", "spiffe://example.org", "--sign-cert", root, "--subject", "/CN=Intermediate"},
+		{"create", workload, "--spiffe-id", "spiffe://example.org/ns/default/sa/api", "--sign-cert", intermediate, "--subject", "/CN=api"},
+	} {
+		_, _, err := runCmd(args, nil, nil)
+		if err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	certs, err := readCertificateFile(workload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := pcert.CheckX509SVID(certs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "spiffe://example.org/ns/default/sa/api" || len(certs[0].DNSNames) != 0 {
+		t.Errorf("unexpected names: %s %v", id, certs[0].DNSNames)
+	}
+
+	csr := filepath.Join(dir, "workload.csr")
+	_, _, err = runCmd([]string{"request", csr, "--subject", "/CN=web"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"sign", csr, filepath.Join(dir, "web.crt"), "--sign-cert", intermediate, "--spiffe-id", "spiffe://example.org/web"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"create", "--spiffe-id", "spiffe://Example.org/api"},
+		{"create", "--spiffe-id", "spiffe://example.org/api/"},
+		{"create", "--spiffe-id", "spiffe://example.org/a", "--spiffe-id", "spiffe://example.org/b"},
+		{"create", "--spiffe-id", "spiffe://example.org/api", "--uri", "https://example.org"},
+		{"create", "--spiffe-id", "spiffe://example.org/api", "--server"},
+		{"create", "--spiffe-id", "spiffe://example.org/api", "--dns", "spiffe://example.org/api"},
+		{"create", "--spiffe-id", "spiffe://example.org/api", "--ca"},
+	} {
+		_, _, err := runCmd(args, nil, nil)
+		if err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+
+	bundleFile := filepath.Join(dir, "bundle.json")
+	_, _, err = runCmd([]string{"spiffe", "bundle", "--trust-domain", "example.org", "--refresh-hint", "5m", "--sequence", "2", root, "-o", bundleFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(bundleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &pcert.SPIFFEBundle{}
+	err = json.Unmarshal(data, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Keys) != 1 || bundle.Keys[0].Use != "x509-svid" || len(bundle.Keys[0].X5c) != 1 || bundle.RefreshHint != 300 || bundle.Sequence != 2 {
+		t.Errorf("unexpected bundle: %s", data)
+	}
+
+	_, _, err = runCmd([]string{"spiffe", "bundle", "--trust-domain", "other.org", root}, nil, nil)
+	if err == nil {
+		t.Error("expected error for other trust domain")
+	}
+	_, _, err = runCmd([]string{"spiffe", "bundle", workload}, nil, nil)
+	if err == nil {
+		t.Error("expected error for non-CA certificate")
+	}
+}
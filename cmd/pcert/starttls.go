@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// startTLSFunc speaks the plain text part of a protocol until the connection
+// can be upgraded to TLS. The serverName is used where a protocol requires
+// the name of the server (e.g. XMPP).
+type startTLSFunc func(conn net.Conn, serverName string) error
+
+const (
+	// startTLSTimeout limits the time for the plain text part of a
+	// protocol.
+	startTLSTimeout = time.Second * 30
+
+	// maxStartTLSResponse limits the size of a response read during the
+	// plain text part of a protocol.
+	maxStartTLSResponse = 1 << 16
+)
+
+var startTLSProtocols = map[string]startTLSFunc{
+	"smtp":     startTLSSMTP,
+	"imap":     startTLSIMAP,
+	"pop3":     startTLSPOP3,
+	"ftp":      startTLSFTP,
+	"ldap":     startTLSLDAP,
+	"xmpp":     startTLSXMPP,
+	"postgres": startTLSPostgres,
+	"mysql":    startTLSMySQL,
+}
+
+func startTLSProtocolNames() []string {
+	names := []string{}
+	for name := range startTLSProtocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func startTLS(conn net.Conn, proto, serverName string) error {
+	fn, ok := startTLSProtocols[proto]
+	if !ok {
+		return fmt.Errorf("unknown starttls protocol '%s'. valid protocols are: %s", proto, strings.Join(startTLSProtocolNames(), ", "))
+	}
+	err := fn(conn, serverName)
+	if err != nil {
+		return fmt.Errorf("starttls %s: %w", proto, err)
+	}
+	return nil
+}
+
+func startTLSCompletionFunc(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return startTLSProtocolNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// readLine reads a line from r. Lines longer than the buffer of r are
+// rejected.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line too long")
+	}
+	return string(line), err
+}
+
+// readReply reads a SMTP or FTP reply which may span multiple lines (e.g.
+// '250-first', '250 last') and returns the code of the reply.
+func readReply(r *bufio.Reader) (string, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return "", err
+		}
+		if len(line) < 4 {
+			return "", fmt.Errorf("invalid reply '%s'", strings.TrimSpace(line))
+		}
+		if line[3] != '-' {
+			return line[:3], nil
+		}
+	}
+}
+
+func expectReply(r *bufio.Reader, code string) error {
+	got, err := readReply(r)
+	if err != nil {
+		return err
+	}
+	if got != code {
+		return fmt.Errorf("unexpected reply code: got=%s want=%s", got, code)
+	}
+	return nil
+}
+
+func startTLSSMTP(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectReply(r, "220")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, "EHLO pcert\r\n")
+	if err != nil {
+		return err
+	}
+	err = expectReply(r, "250")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, "STARTTLS\r\n")
+	if err != nil {
+		return err
+	}
+	return expectReply(r, "220")
+}
+
+func startTLSFTP(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectReply(r, "220")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, "AUTH TLS\r\n")
+	if err != nil {
+		return err
+	}
+	return expectReply(r, "234")
+}
+
+// expectLine reads lines until a line with prefix is found. Lines starting
+// with one of the errPrefixes cause an error.
+func expectLine(r *bufio.Reader, prefix string, errPrefixes ...string) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, prefix) {
+			return nil
+		}
+		for _, errPrefix := range errPrefixes {
+			if strings.HasPrefix(line, errPrefix) {
+				return fmt.Errorf("server responded '%s'", strings.TrimSpace(line))
+			}
+		}
+	}
+}
+
+func startTLSIMAP(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectLine(r, "* OK", "* BYE")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, "a001 STARTTLS\r\n")
+	if err != nil {
+		return err
+	}
+	return expectLine(r, "a001 OK", "a001 NO", "a001 BAD")
+}
+
+func startTLSPOP3(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectLine(r, "+OK", "-ERR")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, "STLS\r\n")
+	if err != nil {
+		return err
+	}
+	return expectLine(r, "+OK", "-ERR")
+}
+
+// readUntil reads from r until the data read contains one of the tokens. The
+// index of the found token is returned. At most maxStartTLSResponse bytes are
+// read.
+func readUntil(r io.Reader, tokens ...string) (int, error) {
+	var (
+		data []byte
+		buf  = make([]byte, 1)
+	)
+	for {
+		if len(data) >= maxStartTLSResponse {
+			return -1, fmt.Errorf("response too large")
+		}
+		_, err := io.ReadFull(r, buf)
+		if err != nil {
+			return -1, err
+		}
+		data = append(data, buf[0])
+		for i, token := range tokens {
+			if bytes.HasSuffix(data, []byte(token)) {
+				return i, nil
+			}
+		}
+	}
+}
+
+func startTLSXMPP(conn net.Conn, serverName string) error {
+	_, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", serverName)
+	if err != nil {
+		return err
+	}
+	i, err := readUntil(conn, "<starttls", "</stream:features>", "</stream:stream>")
+	if err != nil {
+		return err
+	}
+	if i != 0 {
+		return fmt.Errorf("server does not offer starttls")
+	}
+	_, err = io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	if err != nil {
+		return err
+	}
+	i, err = readUntil(conn, "<proceed", "<failure")
+	if err != nil {
+		return err
+	}
+	if i != 0 {
+		return fmt.Errorf("server refused starttls")
+	}
+	// consume the rest of the proceed element
+	_, err = readUntil(conn, ">")
+	return err
+}
+
+// startTLSPostgres sends a SSLRequest message. See
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+func startTLSPostgres(conn net.Conn, _ string) error {
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], 80877103)
+	_, err := conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 1)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[0] != 'S' {
+		return fmt.Errorf("server does not support SSL")
+	}
+	return nil
+}
+
+const (
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+// startTLSMySQL reads the initial handshake and answers with a
+// SSLRequest packet. See
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html
+func startTLSMySQL(conn net.Conn, _ string) error {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) == 0 || payload[0] == 0xff {
+		return fmt.Errorf("server sent error instead of handshake")
+	}
+	if payload[0] != 10 {
+		return fmt.Errorf("unsupported protocol version %d", payload[0])
+	}
+
+	// skip protocol version and null terminated server version
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 0 {
+		return fmt.Errorf("invalid handshake packet")
+	}
+	// connection id (4), auth plugin data part 1 (8), filler (1)
+	pos := 1 + end + 1 + 4 + 8 + 1
+	if len(payload) < pos+2 {
+		return fmt.Errorf("invalid handshake packet")
+	}
+	capabilities := uint32(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	if capabilities&mysqlClientSSL == 0 {
+		return fmt.Errorf("server does not support SSL")
+	}
+
+	req := make([]byte, 4+32)
+	req[0] = 32
+	req[3] = header[3] + 1
+	binary.LittleEndian.PutUint32(req[4:8], mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(req[8:12], 1<<24)
+	req[12] = 33 // utf8_general_ci
+	_, err = conn.Write(req)
+	return err
+}
+
+const oidLDAPStartTLS = "1.3.6.1.4.1.1466.20037"
+
+type ldapMessage struct {
+	MessageID  int
+	ProtocolOp asn1.RawValue
+}
+
+// startTLSLDAP sends a StartTLS extended request. See RFC 4511 section 4.14.
+func startTLSLDAP(conn net.Conn, _ string) error {
+	// ExtendedRequest ::= [APPLICATION 23] SEQUENCE { requestName [0] LDAPOID }
+	requestName, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific,
+		Tag:   0,
+		Bytes: []byte(oidLDAPStartTLS),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := asn1.Marshal(ldapMessage{
+		MessageID: 1,
+		ProtocolOp: asn1.RawValue{
+			Class:      asn1.ClassApplication,
+			Tag:        23,
+			IsCompound: true,
+			Bytes:      requestName,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	data, err := readASN1Element(conn)
+	if err != nil {
+		return err
+	}
+	// The response is parsed by hand since servers are free to use BER
+	// encoding (e.g. Active Directory uses long form lengths) which
+	// encoding/asn1 rejects.
+	//
+	// LDAPMessage ::= SEQUENCE { messageID INTEGER, protocolOp ... }
+	tag, message, _, err := parseBERElement(data)
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return fmt.Errorf("unexpected response")
+	}
+	tag, _, message, err = parseBERElement(message)
+	if err != nil {
+		return err
+	}
+	if tag != 0x02 {
+		return fmt.Errorf("unexpected response: missing message id")
+	}
+	// ExtendedResponse ::= [APPLICATION 24] SEQUENCE { COMPONENTS OF LDAPResult, ... }
+	tag, op, _, err := parseBERElement(message)
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 {
+		return fmt.Errorf("unexpected response")
+	}
+	tag, resultCode, _, err := parseBERElement(op)
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(resultCode) == 0 {
+		return fmt.Errorf("unexpected response: missing result code")
+	}
+	code := 0
+	for _, b := range resultCode {
+		code = code<<8 | int(b)
+	}
+	if code != 0 {
+		return fmt.Errorf("server responded with result code %d", code)
+	}
+	return nil
+}
+
+// parseBERElement splits data into the tag and content of its first element
+// and the remaining data. Only single byte tags and definite lengths are
+// supported.
+func parseBERElement(data []byte) (tag byte, content, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated ASN.1 element")
+	}
+	tag = data[0]
+	length := int(data[1])
+	pos := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < pos+n {
+			return 0, nil, nil, fmt.Errorf("unsupported ASN.1 length")
+		}
+		length = 0
+		for _, b := range data[pos : pos+n] {
+			length = length<<8 | int(b)
+		}
+		pos += n
+	}
+	if length < 0 || len(data)-pos < length {
+		return 0, nil, nil, fmt.Errorf("truncated ASN.1 element")
+	}
+	return tag, data[pos : pos+length], data[pos+length:], nil
+}
+
+// readASN1Element reads a single BER encoded element with a definite length
+// from r.
+func readASN1Element(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported ASN.1 length")
+		}
+		lengthBytes := make([]byte, n)
+		_, err = io.ReadFull(r, lengthBytes)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxStartTLSResponse {
+		return nil, fmt.Errorf("response too large")
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+// newStartTLSServer starts a server which runs the plain text part of a
+// protocol with handshake and then upgrades the connection to TLS.
+func newStartTLSServer(t *testing.T, tlsCert tls.Certificate, handshake func(conn net.Conn) error) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				err := handshake(conn)
+				if err != nil {
+					return
+				}
+				tlsConn := tls.Server(conn, &tls.Config{
+					Certificates: []tls.Certificate{tlsCert},
+				})
+				_ = tlsConn.Handshake()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// lineDialog returns a handshake which sends greeting and then answers
+// each expected line with the given response.
+func lineDialog(greeting string, dialog ...string) func(conn net.Conn) error {
+	return func(conn net.Conn) error {
+		r := bufio.NewReader(conn)
+		_, err := io.WriteString(conn, greeting)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(dialog); i += 2 {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(line, dialog[i]) {
+				return fmt.Errorf("unexpected line '%s'", line)
+			}
+			_, err = io.WriteString(conn, dialog[i+1])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func Test_connect_starttls(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+	tlsCert := server.tlsCertificate()
+
+	tests := map[string]func(conn net.Conn) error{
+		"smtp": lineDialog(
+			"220-mail.example.com ESMTP\r\n220 ready\r\n",
+			"EHLO", "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n",
+			"STARTTLS", "220 go ahead\r\n",
+		),
+		"imap": lineDialog(
+			"* OK [CAPABILITY IMAP4rev1 STARTTLS] ready\r\n",
+			"a001 STARTTLS", "a001 OK Begin TLS negotiation now\r\n",
+		),
+		"pop3": lineDialog(
+			"+OK POP3 ready\r\n",
+			"STLS", "+OK Begin TLS negotiation\r\n",
+		),
+		"ftp": lineDialog(
+			"220 FTP ready\r\n",
+			"AUTH TLS", "234 AUTH TLS successful\r\n",
+		),
+		"xmpp": func(conn net.Conn) error {
+			_, err := readUntil(conn, "version='1.0'>")
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(conn, "<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'><stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+			if err != nil {
+				return err
+			}
+			_, err = readUntil(conn, "/>")
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+			return err
+		},
+		"postgres": func(conn net.Conn) error {
+			req := make([]byte, 8)
+			_, err := io.ReadFull(conn, req)
+			if err != nil {
+				return err
+			}
+			if binary.BigEndian.Uint32(req[4:]) != 80877103 {
+				return fmt.Errorf("not a SSLRequest")
+			}
+			_, err = conn.Write([]byte{'S'})
+			return err
+		},
+		"mysql": func(conn net.Conn) error {
+			payload := []byte{10}
+			payload = append(payload, "8.0.0\x00"...)
+			payload = append(payload, 1, 0, 0, 0)                // connection id
+			payload = append(payload, 1, 2, 3, 4, 5, 6, 7, 8, 0) // auth data and filler
+			payload = append(payload, 0x00, 0x8a)                // capabilities with CLIENT_SSL
+			packet := []byte{byte(len(payload)), 0, 0, 0}
+			_, err := conn.Write(append(packet, payload...))
+			if err != nil {
+				return err
+			}
+			req := make([]byte, 36)
+			_, err = io.ReadFull(conn, req)
+			if err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32(req[4:8])&mysqlClientSSL == 0 {
+				return fmt.Errorf("not a SSLRequest")
+			}
+			return nil
+		},
+		"ldap": func(conn net.Conn) error {
+			req, err := readASN1Element(conn)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(req), oidLDAPStartTLS) {
+				return fmt.Errorf("not a StartTLS request")
+			}
+			// ExtendedResponse with resultCode success
+			_, err = conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00})
+			return err
+		},
+	}
+
+	for proto, handshake := range tests {
+		t.Run(proto, func(t *testing.T) {
+			addr := newStartTLSServer(t, tlsCert, handshake)
+			stdout, _, err := runCmd([]string{"connect", "--insecure", "--starttls", proto, addr}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := pcert.Parse(stdout.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert.Equal(server.cert) {
+				t.Fatalf("wrong certificate: got=%s want=%s", cert.Subject, server.cert.Subject)
+			}
+		})
+	}
+}
+
+func Test_connect_starttls_unknown(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	addr := newStartTLSServer(t, ca.tlsCertificate(), func(conn net.Conn) error { return nil })
+	_, _, err := runCmd([]string{"connect", "--starttls", "gopher", addr}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown starttls protocol") {
+		t.Fatalf("expected unknown protocol error: %v", err)
+	}
+}
+
+func Test_connect_starttls_ldap_long_form(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	server := newTestServerCert(t, "localhost", ca)
+
+	// Active Directory style response which uses long form lengths
+	addr := newStartTLSServer(t, server.tlsCertificate(), func(conn net.Conn) error {
+		_, err := readASN1Element(conn)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write([]byte{
+			0x30, 0x84, 0x00, 0x00, 0x00, 0x10,
+			0x02, 0x01, 0x01,
+			0x78, 0x84, 0x00, 0x00, 0x00, 0x07,
+			0x0a, 0x01, 0x00,
+			0x04, 0x00,
+			0x04, 0x00,
+		})
+		return err
+	})
+
+	_, _, err := runCmd([]string{"connect", "--insecure", "--starttls", "ldap", addr}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_connect_starttls_ldap_error(t *testing.T) {
+	ca := newTestCA(t, "Root", nil)
+	addr := newStartTLSServer(t, ca.tlsCertificate(), func(conn net.Conn) error {
+		_, err := readASN1Element(conn)
+		if err != nil {
+			return err
+		}
+		// resultCode 2 (protocolError)
+		_, err = conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x02, 0x04, 0x00, 0x04, 0x00})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("no starttls")
+	})
+
+	_, _, err := runCmd([]string{"connect", "--insecure", "--starttls", "ldap", addr}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "result code 2") {
+		t.Fatalf("expected result code error: %v", err)
+	}
+}
+
+func Test_readUntil_limit(t *testing.T) {
+	r := strings.NewReader(strings.Repeat("x", maxStartTLSResponse+1) + "<proceed")
+	_, err := readUntil(r, "<proceed")
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected error for too large response: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/dvob/pcert"
+	"github.com/spf13/cobra"
+)
+
+func newTSACmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tsa",
+		Short: "RFC 3161 time-stamp authority and client",
+	}
+	cmd.AddCommand(
+		newTSAServeCmd(),
+		newTSARequestCmd(),
+		newTSAVerifyCmd(),
+	)
+	return cmd
+}
+
+type tsaServeOptions struct {
+	Listen string
+	Cert   string
+	Key    string
+	Policy string
+	Server pcert.TSAServer
+}
+
+func newTSAServeCmd() *cobra.Command {
+	opts := &tsaServeOptions{
+		Listen: ":8318",
+		Cert:   "tsa.crt",
+		Policy: pcert.DefaultTSAPolicy.String(),
+		Server: pcert.TSAServer{
+			Accuracy: time.Second,
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run an RFC 3161 time-stamp authority",
+		Long: `Run an RFC 3161 time-stamp authority which answers time-stamp requests
+(application/timestamp-query) sent with POST requests over HTTP.
+
+The TSA certificate is issued by a CA with TimeStamping as the only extended
+key usage, which pcert marks as critical as required by RFC 3161. Further
+certificates in the --cert file (e.g. the CA certificate) are added to the
+tokens if a request asks for the certificates.`,
+		Example: `  pcert create tsa.crt --sign-cert ca.crt --ext-key-usage TimeStamping --subject "/CN=My TSA"
+  pcert tsa serve --cert tsa.crt`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := opts.load()
+			if err != nil {
+				return err
+			}
+			server := &http.Server{
+				Addr:              opts.Listen,
+				Handler:           &opts.Server,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = server.Shutdown(shutdownCtx)
+			}()
+
+			printServerURL(cmd.ErrOrStderr(), "TSA", "http", opts.Listen, nil, "/")
+			err = server.ListenAndServe()
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "Address on which the server listens.")
+	cmd.Flags().StringVar(&opts.Cert, "cert", opts.Cert, "TSA certificate. Further certificates in the file are added to the tokens.")
+	cmd.Flags().StringVar(&opts.Key, "key", opts.Key, "Key of the TSA certificate. If not set the key file relative to --cert is used.")
+	cmd.Flags().StringVar(&opts.Policy, "policy", opts.Policy, "Object identifier of the TSA policy.")
+	cmd.Flags().DurationVar(&opts.Server.Accuracy, "accuracy", opts.Server.Accuracy, "Accuracy of the time in the tokens.")
+	return cmd
+}
+
+// load reads the TSA certificate and key.
+func (o *tsaServeOptions) load() error {
+	tsa, err := loadSigningCA(o.Cert, o.Key)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(tsa.Cert.ExtKeyUsage, x509.ExtKeyUsageTimeStamping) {
+		return fmt.Errorf("certificate '%s' is not valid for TimeStamping", o.Cert)
+	}
+	o.Server.Cert = tsa.Cert
+	o.Server.Key = tsa.Key
+	o.Server.Chain = tsa.Chain
+	o.Server.Policy, err = pcert.ParseOID(o.Policy)
+	return err
+}
+
+type tsaRequestOptions struct {
+	Server  string
+	Data    string
+	Timeout time.Duration
+}
+
+func newTSARequestCmd() *cobra.Command {
+	opts := &tsaRequestOptions{
+		Timeout: defaultConnectTimeout,
+	}
+	cmd := &cobra.Command{
+		Use:   "request [TOKEN-OUT]",
+		Short: "Obtain a time-stamp token for a file",
+		Long: `Requests an RFC 3161 time-stamp token for the SHA-256 hash of the file set with
+--data. The DER encoded token is written to TOKEN-OUT or to stdout. The
+signature of the token is verified but not the certificate of the TSA (see
+'pcert tsa verify').`,
+		Example: `  pcert tsa request --server http://localhost:8318 --data artifact.tar.gz artifact.tsr`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Server == "" || opts.Data == "" {
+				return errors.New("--server and --data are required")
+			}
+			data, err := os.Open(opts.Data)
+			if err != nil {
+				return err
+			}
+			defer data.Close()
+			h := crypto.SHA256.New()
+			_, err = data.WriteTo(h)
+			if err != nil {
+				return err
+			}
+			client := &pcert.TSAClient{
+				URL:        opts.Server,
+				HTTPClient: &http.Client{Timeout: opts.Timeout},
+			}
+			timestamp, err := client.Timestamp(cmd.Context(), h.Sum(nil), crypto.SHA256)
+			if err != nil {
+				return err
+			}
+			out := ""
+			if len(args) == 1 {
+				out = args[0]
+			}
+			return writeStdoutOrFile(out, timestamp.Raw, 0o644, cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVar(&opts.Server, "server", opts.Server, "URL of the TSA.")
+	cmd.Flags().StringVar(&opts.Data, "data", opts.Data, "File for which the time-stamp is requested.")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", opts.Timeout, "Timeout of the request.")
+	return cmd
+}
+
+type tsaVerifyOptions struct {
+	Data    string
+	CACerts []string
+	TSACert string
+}
+
+func newTSAVerifyCmd() *cobra.Command {
+	opts := &tsaVerifyOptions{}
+	cmd := &cobra.Command{
+		Use:   "verify [TOKEN]",
+		Short: "Verify a time-stamp token",
+		Long: `Verifies an RFC 3161 time-stamp token or response (DER) read from TOKEN or
+stdin. The token has to be created for the file set with --data and the TSA
+certificate has to be issued by one of the CAs set with --ca and valid for
+TimeStamping at the time of the time-stamp. If the token does not contain the
+TSA certificate it has to be set with --tsa-cert.`,
+		Example: `  pcert tsa verify --data artifact.tar.gz --ca ca.crt artifact.tsr`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Data == "" || len(opts.CACerts) == 0 {
+				return errors.New("--data and --ca are required")
+			}
+			file := ""
+			if len(args) == 1 {
+				file = args[0]
+			}
+			stdin := &stdinKeeper{stdin: cmd.InOrStdin()}
+			der, err := readStdinOrFile(file, stdin)
+			if err != nil {
+				return err
+			}
+
+			roots := x509.NewCertPool()
+			for _, file := range opts.CACerts {
+				certs, err := readCertificateFile(file)
+				if err != nil {
+					return err
+				}
+				for _, cert := range certs {
+					roots.AddCert(cert)
+				}
+			}
+			var tsaCerts []*x509.Certificate
+			if opts.TSACert != "" {
+				tsaCerts, err = readCertificateFile(opts.TSACert)
+				if err != nil {
+					return err
+				}
+			}
+
+			timestamp, err := pcert.ParseTimestamp(der, tsaCerts)
+			if err != nil {
+				return err
+			}
+			data, err := os.Open(opts.Data)
+			if err != nil {
+				return err
+			}
+			defer data.Close()
+			err = timestamp.Verify(data, x509.VerifyOptions{Roots: roots})
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "time: %s\n", timestamp.Time.Format(time.RFC3339))
+			fmt.Fprintf(out, "accuracy: %s\n", timestamp.Accuracy)
+			fmt.Fprintf(out, "serial: %x\n", timestamp.SerialNumber)
+			fmt.Fprintf(out, "policy: %s\n", timestamp.Policy)
+			fmt.Fprintf(out, "tsa: %s\n", timestamp.Certificate.Subject)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.Data, "data", opts.Data, "File for which the time-stamp was created.")
+	cmd.Flags().StringSliceVar(&opts.CACerts, "ca", opts.CACerts, "File with CA certificates to verify the TSA certificate.")
+	cmd.Flags().StringVar(&opts.TSACert, "tsa-cert", opts.TSACert, "TSA certificate if it is not contained in the token.")
+	return cmd
+}
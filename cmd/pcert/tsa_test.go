@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dvob/pcert"
+)
+
+func Test_tsa(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "TSA CA", nil)
+	caFile := writeTestCert(t, dir, "ca", ca)
+	serverFile := writeTestCert(t, dir, "server", newTestServerCert(t, "server", ca))
+	tsaFile := writeTestCert(t, dir, "tsa", newTestCert(t, &pcert.CertificateOptions{
+		Certificate: x509.Certificate{
+			Subject:     pkix.Name{CommonName: "My TSA"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		},
+	}, ca))
+
+	opts := &tsaServeOptions{Cert: serverFile, Policy: "1.2.3.4"}
+	err := opts.load()
+	if err == nil || !strings.Contains(err.Error(), "TimeStamping") {
+		t.Fatalf("expected error for certificate without TimeStamping, got %v", err)
+	}
+	opts = &tsaServeOptions{Cert: tsaFile, Policy: "1.2.3.4"}
+	err = opts.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&opts.Server)
+	defer server.Close()
+
+	dataFile := filepath.Join(dir, "data.txt")
+	err = os.WriteFile(dataFile, []byte("hello world\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "data.tsr")
+	_, _, err = runCmd([]string{"tsa", "request", "--server", server.URL, "--data", dataFile, tokenFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runCmd([]string{"tsa", "verify", "--data", dataFile, "--ca", caFile, tokenFile}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"policy: 1.2.3.4", "tsa: CN=My TSA"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("output does not contain '%s': %s", expected, stdout)
+		}
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"tsa", "verify", "--data", dataFile, "--ca", caFile}, strings.NewReader(string(token)), nil)
+	if err != nil {
+		t.Errorf("verify from stdin: %s", err)
+	}
+
+	otherCAFile := writeTestCert(t, dir, "other", newTestCA(t, "Other CA", nil))
+	_, _, err = runCmd([]string{"tsa", "verify", "--data", dataFile, "--ca", otherCAFile, tokenFile}, nil, nil)
+	if err == nil {
+		t.Error("expected error for unknown CA")
+	}
+
+	err = os.WriteFile(dataFile, []byte("hello world!\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCmd([]string{"tsa", "verify", "--data", dataFile, "--ca", caFile, tokenFile}, nil, nil)
+	if err == nil {
+		t.Error("expected error for modified data")
+	}
+}
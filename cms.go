@@ -0,0 +1,1292 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+var (
+	oidCMSEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidCMSAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+
+	oidCMSContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidCMSMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidCMSSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECPublicKey   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	oidAES128GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES256GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	oidAES128Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidAES192Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidAES256Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+
+	oidDHSinglePassSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	oidDHSinglePassSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	oidDHSinglePassSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	oidDHSinglePassSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+)
+
+var cmsDigestAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidSHA1, crypto.SHA1},
+	{oidSHA256, crypto.SHA256},
+	{oidSHA384, crypto.SHA384},
+	{oidSHA512, crypto.SHA512},
+}
+
+func cmsDigestOID(hash crypto.Hash) asn1.ObjectIdentifier {
+	for _, entry := range cmsDigestAlgorithms {
+		if entry.hash == hash {
+			return entry.oid
+		}
+	}
+	return nil
+}
+
+func cmsDigestHash(oid asn1.ObjectIdentifier) crypto.Hash {
+	for _, entry := range cmsDigestAlgorithms {
+		if entry.oid.Equal(oid) {
+			return entry.hash
+		}
+	}
+	return 0
+}
+
+// cmsSignedData is the SignedData content type (RFC 5652 section 5.1).
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"optional,explicit,tag:0"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// cmsAttribute is an attribute with a single value.
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// newCMSAttribute returns an attribute with value. If value is an
+// asn1.RawValue it is used as is, otherwise it is marshalled with params.
+func newCMSAttribute(typ asn1.ObjectIdentifier, value any, params string) (cmsAttribute, error) {
+	var der []byte
+	if raw, ok := value.(asn1.RawValue); ok {
+		der = raw.FullBytes
+	} else {
+		var err error
+		der, err = asn1.MarshalWithParams(value, params)
+		if err != nil {
+			return cmsAttribute{}, err
+		}
+	}
+	return cmsAttribute{
+		Type:   typ,
+		Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der},
+	}, nil
+}
+
+// cmsAttributes is a parsed set of attributes.
+type cmsAttributes []cmsAttribute
+
+// parseCMSAttributes parses the content of a SET OF Attribute.
+func parseCMSAttributes(der []byte) (cmsAttributes, error) {
+	attrs := cmsAttributes{}
+	for len(der) > 0 {
+		var attr cmsAttribute
+		var err error
+		der, err = asn1.Unmarshal(der, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute: %w", err)
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// unmarshal decodes the first value of the attribute typ into out. It
+// returns false if the attribute is not present.
+func (attrs cmsAttributes) unmarshal(typ asn1.ObjectIdentifier, out any) (bool, error) {
+	for _, attr := range attrs {
+		if !attr.Type.Equal(typ) {
+			continue
+		}
+		_, err := asn1.Unmarshal(attr.Values.Bytes, out)
+		if err != nil {
+			return true, fmt.Errorf("invalid attribute %s: %w", typ, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// marshalCMSAttributes returns the DER encoded SET OF attrs. The attributes
+// are sorted as required by DER.
+func marshalCMSAttributes(attrs []cmsAttribute) ([]byte, error) {
+	encoded := [][]byte{}
+	for _, attr := range attrs {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	slices.SortFunc(encoded, bytes.Compare)
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+}
+
+func cmsIssuerAndSerial(cert *x509.Certificate) ([]byte, error) {
+	return asn1.Marshal(cmsIssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+}
+
+// cmsMatchesIdentifier reports whether a signer or recipient identifier
+// (IssuerAndSerialNumber or [0] SubjectKeyIdentifier) identifies cert.
+func cmsMatchesIdentifier(id asn1.RawValue, cert *x509.Certificate) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(id.Bytes, cert.SubjectKeyId)
+	}
+	var ias cmsIssuerAndSerialNumber
+	_, err := asn1.Unmarshal(id.FullBytes, &ias)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// cmsSigner signs a SignedData structure.
+type cmsSigner struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// Attributes are added to the signed attributes. The content type and
+	// message digest are always added.
+	Attributes []cmsAttribute
+	// OmitCertificate does not add Cert to the certificates of the
+	// SignedData.
+	OmitCertificate bool
+}
+
+// cmsSign returns a ContentInfo with a SignedData structure over content.
+// If detached is true, the content is not included. certs are added to the
+// certificates of the SignedData.
+func cmsSign(content []byte, contentType asn1.ObjectIdentifier, signers []cmsSigner, certs []*x509.Certificate, detached bool) ([]byte, error) {
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: contentType},
+		SignerInfos:      []cmsSignerInfo{},
+	}
+	if !detached {
+		sd.EncapContentInfo.EContent = content
+		if content == nil {
+			sd.EncapContentInfo.EContent = []byte{}
+		}
+	}
+	if !contentType.Equal(oidPKCS7Data) {
+		sd.Version = 3
+	}
+
+	var rawCerts []byte
+	for _, signer := range signers {
+		sigAlg, hash, err := signatureAlgorithmForKey(signer.Key.Public())
+		if err != nil {
+			return nil, err
+		}
+		signedHash := hash
+		if hash == 0 {
+			// Ed25519 (RFC 8419)
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(content)
+		digest := h.Sum(nil)
+
+		attrs := slices.Clone(signer.Attributes)
+		for _, attr := range []struct {
+			typ    asn1.ObjectIdentifier
+			value  any
+			params string
+		}{
+			{oidCMSContentType, contentType, ""},
+			{oidCMSSigningTime, time.Now().UTC(), "utc"},
+			{oidCMSMessageDigest, digest, ""},
+		} {
+			a, err := newCMSAttribute(attr.typ, attr.value, attr.params)
+			if err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, a)
+		}
+		signedAttrs, err := marshalCMSAttributes(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		input := signedAttrs
+		if signedHash != 0 {
+			h := signedHash.New()
+			h.Write(signedAttrs)
+			input = h.Sum(nil)
+		}
+		signature, err := signer.Key.Sign(rand.Reader, input, signedHash)
+		if err != nil {
+			return nil, err
+		}
+
+		sid, err := cmsIssuerAndSerial(signer.Cert)
+		if err != nil {
+			return nil, err
+		}
+		var signedAttrsValue asn1.RawValue
+		_, err = asn1.Unmarshal(signedAttrs, &signedAttrsValue)
+		if err != nil {
+			return nil, err
+		}
+		digestAlg := pkix.AlgorithmIdentifier{Algorithm: cmsDigestOID(hash)}
+		if !slices.ContainsFunc(sd.DigestAlgorithms, func(alg pkix.AlgorithmIdentifier) bool { return alg.Algorithm.Equal(digestAlg.Algorithm) }) {
+			sd.DigestAlgorithms = append(sd.DigestAlgorithms, digestAlg)
+		}
+		sd.SignerInfos = append(sd.SignerInfos, cmsSignerInfo{
+			Version:         1,
+			SID:             asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm: digestAlg,
+			SignedAttrs: asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        0,
+				IsCompound: true,
+				Bytes:      signedAttrsValue.Bytes,
+			},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		})
+		if !signer.OmitCertificate {
+			rawCerts = append(rawCerts, signer.Cert.Raw...)
+		}
+	}
+	for _, cert := range certs {
+		rawCerts = append(rawCerts, cert.Raw...)
+	}
+	if len(rawCerts) > 0 {
+		sd.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: rawCerts}
+	}
+	return marshalContentInfo(oidPKCS7SignedData, sd)
+}
+
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content any) ([]byte, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+	})
+}
+
+// parseContentInfo returns the content of a ContentInfo with contentType.
+func parseContentInfo(der []byte, contentType asn1.ObjectIdentifier) ([]byte, error) {
+	ci := &contentInfo{}
+	rest, err := asn1.Unmarshal(der, ci)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS content info: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after CMS content info")
+	}
+	if !ci.ContentType.Equal(contentType) {
+		return nil, fmt.Errorf("unexpected CMS content type %s", ci.ContentType)
+	}
+	return ci.Content.Bytes, nil
+}
+
+// parseCMSSignedData parses a ContentInfo with a SignedData structure.
+func parseCMSSignedData(der []byte) (*cmsSignedData, error) {
+	content, err := parseContentInfo(der, oidPKCS7SignedData)
+	if err != nil {
+		return nil, err
+	}
+	sd := &cmsSignedData{}
+	_, err = asn1.Unmarshal(content, sd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS signed data: %w", err)
+	}
+	return sd, nil
+}
+
+func (sd *cmsSignedData) certificates() ([]*x509.Certificate, error) {
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, nil
+	}
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+// cmsVerifiedSigner is a signer whose signature has been verified.
+type cmsVerifiedSigner struct {
+	Cert       *x509.Certificate
+	Attributes cmsAttributes
+}
+
+// verify verifies the signatures of all signers. content is used if the
+// SignedData does not contain the content (detached signature). If it
+// contains the content and content is not nil, both have to be equal. The
+// signer certificates are searched in the SignedData and in certs. The
+// signer certificates themselves are not verified.
+func (sd *cmsSignedData) verify(content []byte, certs []*x509.Certificate) ([]cmsVerifiedSigner, error) {
+	if sd.EncapContentInfo.EContent != nil {
+		if content != nil && !bytes.Equal(content, sd.EncapContentInfo.EContent) {
+			return nil, errors.New("content does not match the content of the signed data")
+		}
+		content = sd.EncapContentInfo.EContent
+	}
+	if content == nil {
+		return nil, errors.New("signed data contains no content")
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("signed data contains no signers")
+	}
+	included, err := sd.certificates()
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates in signed data: %w", err)
+	}
+	certs = append(included, certs...)
+
+	signers := []cmsVerifiedSigner{}
+	for _, si := range sd.SignerInfos {
+		var cert *x509.Certificate
+		for _, c := range certs {
+			if cmsMatchesIdentifier(si.SID, c) {
+				cert = c
+				break
+			}
+		}
+		if cert == nil {
+			return nil, errors.New("certificate of signer not found")
+		}
+		hash := cmsDigestHash(si.DigestAlgorithm.Algorithm)
+		if hash == 0 || !hash.Available() {
+			return nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+		}
+		h := hash.New()
+		h.Write(content)
+		digest := h.Sum(nil)
+
+		signed := content
+		var attrs cmsAttributes
+		if len(si.SignedAttrs.FullBytes) > 0 {
+			attrs, err = parseCMSAttributes(si.SignedAttrs.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			var messageDigest []byte
+			ok, err := attrs.unmarshal(oidCMSMessageDigest, &messageDigest)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || !bytes.Equal(messageDigest, digest) {
+				return nil, errors.New("message digest does not match content")
+			}
+			var contentType asn1.ObjectIdentifier
+			ok, err = attrs.unmarshal(oidCMSContentType, &contentType)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || !contentType.Equal(sd.EncapContentInfo.EContentType) {
+				return nil, errors.New("content type attribute does not match content")
+			}
+			// the signature is calculated over the DER encoded SET OF
+			// attributes instead of the [0] IMPLICIT tag
+			signed = slices.Clone(si.SignedAttrs.FullBytes)
+			signed[0] = 0x31
+		}
+
+		sigAlg := cmsSignatureAlgorithm(si.DigestAlgorithm.Algorithm, si.SignatureAlgorithm.Algorithm)
+		if sigAlg == x509.UnknownSignatureAlgorithm {
+			return nil, fmt.Errorf("unsupported signature algorithm %s", si.SignatureAlgorithm.Algorithm)
+		}
+		err = cert.CheckSignature(sigAlg, signed, si.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature of '%s': %w", cert.Subject, err)
+		}
+		signers = append(signers, cmsVerifiedSigner{Cert: cert, Attributes: attrs})
+	}
+	return signers, nil
+}
+
+// cmsSignatureAlgorithm returns the x509.SignatureAlgorithm of a signer.
+// Besides the signature algorithms of certificates, CMS allows the public
+// key algorithm together with the digest algorithm.
+func cmsSignatureAlgorithm(digestAlg, sigAlg asn1.ObjectIdentifier) x509.SignatureAlgorithm {
+	if alg := signatureAlgorithmFromOID(sigAlg); alg != x509.UnknownSignatureAlgorithm {
+		return alg
+	}
+	hash := cmsDigestHash(digestAlg)
+	switch {
+	case sigAlg.Equal(oidRSAEncryption):
+		switch hash {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA
+		case crypto.SHA256:
+			return x509.SHA256WithRSA
+		case crypto.SHA384:
+			return x509.SHA384WithRSA
+		case crypto.SHA512:
+			return x509.SHA512WithRSA
+		}
+	case sigAlg.Equal(oidECPublicKey):
+		switch hash {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512
+		}
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// SignCMS returns a DER encoded CMS SignedData structure (e.g. a .p7s file)
+// over content signed with key. The signed attributes contain the signing
+// time. cert and chain are added to the certificates of the SignedData. If
+// detached is true the content is not included in the SignedData.
+func SignCMS(content []byte, cert *x509.Certificate, key crypto.PrivateKey, chain []*x509.Certificate, detached bool) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T can not sign", key)
+	}
+	if !KeyMatchesCertificate(key, cert) {
+		return nil, errors.New("key does not belong to certificate")
+	}
+	return cmsSign(content, oidPKCS7Data, []cmsSigner{{Cert: cert, Key: signer}}, chain, detached)
+}
+
+// CMSSignature is a verified signature of a CMS SignedData structure.
+type CMSSignature struct {
+	// Certificate is the certificate of the signer.
+	Certificate *x509.Certificate
+	// SigningTime is the signing time claimed by the signer. It is zero if
+	// the signature contains no signing time.
+	SigningTime time.Time
+	// Chains are the verified chains of Certificate.
+	Chains [][]*x509.Certificate
+}
+
+// VerifyCMS verifies all signatures of a CMS SignedData structure in DER or
+// PEM format. content is used if the SignedData does not contain the content
+// (detached signature). If the SignedData contains the content and content
+// is not nil, both have to be equal. The certificates of the signers are
+// verified with opts. The certificates contained in the SignedData are used
+// as intermediates. If opts.KeyUsages is empty any extended key usage is
+// accepted.
+func VerifyCMS(der, content []byte, opts x509.VerifyOptions) ([]CMSSignature, error) {
+	sd, err := parseCMSSignedDataPEM(der)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := sd.verify(content, nil)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := sd.certificates()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	} else {
+		opts.Intermediates = opts.Intermediates.Clone()
+	}
+	for _, cert := range certs {
+		opts.Intermediates.AddCert(cert)
+	}
+	if len(opts.KeyUsages) == 0 {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+
+	signatures := []CMSSignature{}
+	for _, signer := range verified {
+		cert := signer.Cert
+		if cert.KeyUsage != 0 && cert.KeyUsage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+			return nil, fmt.Errorf("certificate '%s' is not valid for digital signatures", cert.Subject)
+		}
+		chains, err := cert.Verify(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate of signer '%s': %w", cert.Subject, err)
+		}
+		signature := CMSSignature{
+			Certificate: cert,
+			Chains:      chains,
+		}
+		_, err = signer.Attributes.unmarshal(oidCMSSigningTime, &signature.SigningTime)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// CMSContent returns the content of a CMS SignedData structure in DER or
+// PEM format. It returns nil if the signature is detached. The signatures
+// are not verified.
+func CMSContent(der []byte) ([]byte, error) {
+	sd, err := parseCMSSignedDataPEM(der)
+	if err != nil {
+		return nil, err
+	}
+	return sd.EncapContentInfo.EContent, nil
+}
+
+func parseCMSSignedDataPEM(der []byte) (*cmsSignedData, error) {
+	if isPEM(der) {
+		block, _ := pem.Decode(der)
+		if block == nil {
+			return nil, errors.New("invalid PEM data")
+		}
+		der = block.Bytes
+	}
+	return parseCMSSignedData(der)
+}
+
+// cmsEnvelopedData is the EnvelopedData content type (RFC 5652 section
+// 6.1). The recipient infos are kept raw as there are different types.
+type cmsEnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// cmsAuthEnvelopedData is the AuthEnvelopedData content type (RFC 5083)
+// which is used with AES-GCM.
+type cmsAuthEnvelopedData struct {
+	Version                  int
+	OriginatorInfo           asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo cmsEncryptedContentInfo
+	AuthAttrs                asn1.RawValue `asn1:"optional,tag:1"`
+	MAC                      []byte
+	UnauthAttrs              asn1.RawValue `asn1:"optional,tag:2"`
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"optional,tag:0"`
+}
+
+// cmsGCMParameters are the parameters of AES-GCM (RFC 5084).
+type cmsGCMParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// cmsKeyAgreeRecipientInfo is the [1] IMPLICIT KeyAgreeRecipientInfo of
+// ECDH recipients (RFC 5753). Originator is the [0] EXPLICIT
+// OriginatorIdentifierOrKey.
+type cmsKeyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue
+	UKM                    []byte `asn1:"optional,explicit,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []cmsRecipientEncryptedKey
+}
+
+type cmsOriginatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type cmsRecipientEncryptedKey struct {
+	RID          asn1.RawValue
+	EncryptedKey []byte
+}
+
+// cmsECCSharedInfo is the input of the key derivation of ECDH recipients.
+type cmsECCSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	EntityUInfo []byte `asn1:"optional,explicit,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+type cmsRSAESOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc asn1.RawValue            `asn1:"optional,explicit,tag:2"`
+}
+
+// cmsKeyAgreementSchemes are the ECDH key agreement schemes with the X9.63
+// key derivation (RFC 5753 section 7.1.4). The SHA-1 scheme is the default
+// of OpenSSL and is only used to decrypt.
+var cmsKeyAgreementSchemes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidDHSinglePassSHA1KDF, crypto.SHA1},
+	{oidDHSinglePassSHA256KDF, crypto.SHA256},
+	{oidDHSinglePassSHA384KDF, crypto.SHA384},
+	{oidDHSinglePassSHA512KDF, crypto.SHA512},
+}
+
+// cmsEncryptOptions are the algorithms used by cmsEncrypt.
+type cmsEncryptOptions struct {
+	// ContentEncryption is AES-128 or AES-256 in CBC or GCM mode. With GCM
+	// an AuthEnvelopedData structure is created.
+	ContentEncryption asn1.ObjectIdentifier
+	// OAEP encrypts the content encryption key for RSA recipients with
+	// RSAES-OAEP and SHA-256 instead of PKCS #1 v1.5.
+	OAEP bool
+}
+
+// cmsContentEncryptionKeySize returns the key size of a content encryption
+// algorithm and whether it is an AEAD algorithm.
+func cmsContentEncryptionKeySize(alg asn1.ObjectIdentifier) (int, bool, error) {
+	switch {
+	case alg.Equal(oidAES128CBC):
+		return 16, false, nil
+	case alg.Equal(oidAES256CBC):
+		return 32, false, nil
+	case alg.Equal(oidAES128GCM):
+		return 16, true, nil
+	case alg.Equal(oidAES256GCM):
+		return 32, true, nil
+	default:
+		return 0, false, fmt.Errorf("unsupported content encryption algorithm %s", alg)
+	}
+}
+
+// cmsEncrypt returns a ContentInfo with an EnvelopedData or
+// AuthEnvelopedData structure which contains content encrypted for the
+// recipients. The content encryption key is encrypted with RSA key transport
+// for RSA recipients and with ECDH key agreement for ECDSA recipients.
+func cmsEncrypt(content []byte, recipients []*x509.Certificate, opts cmsEncryptOptions) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	keySize, aead, err := cmsContentEncryptionKeySize(opts.ContentEncryption)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		encrypted []byte
+		mac       []byte
+		params    []byte
+	)
+	if aead {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		sealed := gcm.Seal(nil, nonce, content, nil)
+		encrypted, mac = sealed[:len(content)], sealed[len(content):]
+		params, err = asn1.Marshal(cmsGCMParameters{Nonce: nonce, ICVLen: gcm.Overhead()})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		iv := make([]byte, aes.BlockSize)
+		_, err = rand.Read(iv)
+		if err != nil {
+			return nil, err
+		}
+		padded := pkcs7Pad(content, aes.BlockSize)
+		encrypted = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+		params, err = asn1.Marshal(iv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	eci := cmsEncryptedContentInfo{
+		ContentType: oidPKCS7Data,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  opts.ContentEncryption,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedContent: encrypted,
+	}
+
+	version := 0
+	recipientInfos := []asn1.RawValue{}
+	for _, recipient := range recipients {
+		var ri []byte
+		switch pub := recipient.PublicKey.(type) {
+		case *rsa.PublicKey:
+			ri, err = cmsKeyTransRecipient(recipient, pub, key, opts.OAEP)
+		case *ecdsa.PublicKey:
+			ri, err = cmsKeyAgreeRecipient(recipient, pub, key)
+			// version 2 is required with KeyAgreeRecipientInfo
+			version = 2
+		default:
+			err = fmt.Errorf("unsupported key type %T of recipient '%s'", recipient.PublicKey, recipient.Subject)
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipientInfos = append(recipientInfos, asn1.RawValue{FullBytes: ri})
+	}
+
+	if aead {
+		return marshalContentInfo(oidCMSAuthEnvelopedData, cmsAuthEnvelopedData{
+			RecipientInfos:           recipientInfos,
+			AuthEncryptedContentInfo: eci,
+			MAC:                      mac,
+		})
+	}
+	return marshalContentInfo(oidCMSEnvelopedData, cmsEnvelopedData{
+		Version:              version,
+		RecipientInfos:       recipientInfos,
+		EncryptedContentInfo: eci,
+	})
+}
+
+// cmsKeyTransRecipient returns a KeyTransRecipientInfo with key encrypted
+// for recipient with RSAES-OAEP or PKCS #1 v1.5.
+func cmsKeyTransRecipient(recipient *x509.Certificate, pub *rsa.PublicKey, key []byte, oaep bool) ([]byte, error) {
+	var (
+		encryptedKey []byte
+		keyEncAlg    pkix.AlgorithmIdentifier
+		err          error
+	)
+	if oaep {
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+		mgfParams, err := asn1.Marshal(sha256Alg)
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(cmsRSAESOAEPParams{
+			HashFunc:    sha256Alg,
+			MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		keyEncAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}
+	} else {
+		encryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		keyEncAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	}
+	rid, err := cmsIssuerAndSerial(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(cmsKeyTransRecipientInfo{
+		Version:                0,
+		RID:                    asn1.RawValue{FullBytes: rid},
+		KeyEncryptionAlgorithm: keyEncAlg,
+		EncryptedKey:           encryptedKey,
+	})
+}
+
+// cmsKeyAgreeRecipient returns a KeyAgreeRecipientInfo with key wrapped with
+// a key derived from an ephemeral-static ECDH key agreement with recipient.
+func cmsKeyAgreeRecipient(recipient *x509.Certificate, pub *ecdsa.PublicKey, key []byte) ([]byte, error) {
+	recipientKey, err := pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := recipientKey.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := ephemeralKey.ECDH(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hash of the key derivation matches the curve size
+	scheme := cmsKeyAgreementSchemes[1]
+	switch pub.Curve.Params().BitSize {
+	case 384:
+		scheme = cmsKeyAgreementSchemes[2]
+	case 521:
+		scheme = cmsKeyAgreementSchemes[3]
+	}
+	wrapAlg := pkix.AlgorithmIdentifier{Algorithm: oidAES128Wrap}
+	if len(key) == 32 {
+		wrapAlg = pkix.AlgorithmIdentifier{Algorithm: oidAES256Wrap}
+	}
+	wrapAlgDER, err := asn1.Marshal(wrapAlg)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := cmsDeriveKEK(scheme.hash, sharedSecret, wrapAlg, len(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return nil, err
+	}
+
+	originatorKey, err := asn1.MarshalWithParams(cmsOriginatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey},
+		PublicKey: asn1.BitString{Bytes: ephemeralKey.PublicKey().Bytes(), BitLength: len(ephemeralKey.PublicKey().Bytes()) * 8},
+	}, "tag:1")
+	if err != nil {
+		return nil, err
+	}
+	rid, err := cmsIssuerAndSerial(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.MarshalWithParams(cmsKeyAgreeRecipientInfo{
+		Version:                3,
+		Originator:             asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: originatorKey},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: scheme.oid, Parameters: asn1.RawValue{FullBytes: wrapAlgDER}},
+		RecipientEncryptedKeys: []cmsRecipientEncryptedKey{
+			{RID: asn1.RawValue{FullBytes: rid}, EncryptedKey: wrapped},
+		},
+	}, "tag:1")
+}
+
+// cmsDeriveKEK derives the key encryption key of an ECDH recipient with the
+// ANSI X9.63 key derivation function.
+func cmsDeriveKEK(hash crypto.Hash, sharedSecret []byte, wrapAlg pkix.AlgorithmIdentifier, keySize int, ukm []byte) ([]byte, error) {
+	suppPubInfo := binary.BigEndian.AppendUint32(nil, uint32(keySize*8))
+	sharedInfo, err := asn1.Marshal(cmsECCSharedInfo{
+		KeyInfo:     pkix.AlgorithmIdentifier{Algorithm: wrapAlg.Algorithm},
+		EntityUInfo: ukm,
+		SuppPubInfo: suppPubInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var kek []byte
+	for counter := uint32(1); len(kek) < keySize; counter++ {
+		h := hash.New()
+		h.Write(sharedSecret)
+		h.Write(binary.BigEndian.AppendUint32(nil, counter))
+		h.Write(sharedInfo)
+		kek = h.Sum(kek)
+	}
+	return kek[:keySize], nil
+}
+
+// cmsDecrypt decrypts a ContentInfo with an EnvelopedData or
+// AuthEnvelopedData structure with the key of the recipient cert.
+func cmsDecrypt(der []byte, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	ci := &contentInfo{}
+	rest, err := asn1.Unmarshal(der, ci)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS content info: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after CMS content info")
+	}
+
+	var (
+		recipientInfos []asn1.RawValue
+		eci            cmsEncryptedContentInfo
+		mac            []byte
+		aad            []byte
+	)
+	switch {
+	case ci.ContentType.Equal(oidCMSEnvelopedData):
+		ed := &cmsEnvelopedData{}
+		_, err = asn1.Unmarshal(ci.Content.Bytes, ed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CMS enveloped data: %w", err)
+		}
+		recipientInfos, eci = ed.RecipientInfos, ed.EncryptedContentInfo
+	case ci.ContentType.Equal(oidCMSAuthEnvelopedData):
+		aed := &cmsAuthEnvelopedData{}
+		_, err = asn1.Unmarshal(ci.Content.Bytes, aed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CMS authenticated enveloped data: %w", err)
+		}
+		recipientInfos, eci, mac = aed.RecipientInfos, aed.AuthEncryptedContentInfo, aed.MAC
+		if len(aed.AuthAttrs.FullBytes) > 0 {
+			// the authenticated attributes are authenticated as DER
+			// encoded SET OF instead of the [1] IMPLICIT tag
+			aad = slices.Clone(aed.AuthAttrs.FullBytes)
+			aad[0] = 0x31
+		}
+	default:
+		return nil, fmt.Errorf("unexpected CMS content type %s", ci.ContentType)
+	}
+
+	keySize := 24
+	if !eci.ContentEncryptionAlgorithm.Algorithm.Equal(oidDESEDE3CBC) {
+		keySize, _, err = cmsContentEncryptionKeySize(eci.ContentEncryptionAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var contentKey []byte
+	for _, raw := range recipientInfos {
+		switch {
+		case raw.Class == asn1.ClassUniversal && raw.Tag == asn1.TagSequence:
+			contentKey, err = cmsDecryptKeyTrans(raw.FullBytes, cert, key, keySize)
+		case raw.Class == asn1.ClassContextSpecific && raw.Tag == 1:
+			contentKey, err = cmsDecryptKeyAgree(raw.FullBytes, cert, key)
+		default:
+			// other recipient info types
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if contentKey != nil {
+			break
+		}
+	}
+	if contentKey == nil {
+		return nil, fmt.Errorf("no recipient info for '%s'", cert.Subject)
+	}
+	return eci.decrypt(contentKey, mac, aad)
+}
+
+// cmsDecryptKeyTrans decrypts the content encryption key of a
+// KeyTransRecipientInfo. It returns nil if the recipient info is not for
+// cert. keySize is the size of the content encryption key.
+func cmsDecryptKeyTrans(der []byte, cert *x509.Certificate, key crypto.PrivateKey, keySize int) ([]byte, error) {
+	ri := cmsKeyTransRecipientInfo{}
+	_, err := asn1.Unmarshal(der, &ri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient info: %w", err)
+	}
+	if !cmsMatchesIdentifier(ri.RID, cert) {
+		return nil, nil
+	}
+	decrypter, ok := key.(crypto.Decrypter)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T can not decrypt", key)
+	}
+	var opts crypto.DecrypterOpts
+	alg := ri.KeyEncryptionAlgorithm
+	switch {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+		// on invalid padding a random key is returned instead of an error
+		// so that the padding check is no oracle (Bleichenbacher)
+		opts = &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keySize}
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		opts, err = parseRSAESOAEPParams(alg.Parameters.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key encryption algorithm %s", alg.Algorithm)
+	}
+	contentKey, err := decrypter.Decrypt(rand.Reader, ri.EncryptedKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content encryption key: %w", err)
+	}
+	return contentKey, nil
+}
+
+// parseRSAESOAEPParams returns the decryption options of RSAES-OAEP
+// parameters. Without parameters SHA-1 is used.
+func parseRSAESOAEPParams(der []byte) (*rsa.OAEPOptions, error) {
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	if len(der) == 0 {
+		return opts, nil
+	}
+	params := cmsRSAESOAEPParams{}
+	_, err := asn1.Unmarshal(der, &params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSAES-OAEP parameters: %w", err)
+	}
+	if len(params.PSourceFunc.FullBytes) > 0 {
+		return nil, errors.New("RSAES-OAEP labels are not supported")
+	}
+	if len(params.HashFunc.Algorithm) > 0 {
+		opts.Hash = cmsDigestHash(params.HashFunc.Algorithm)
+	}
+	if len(params.MaskGenFunc.Algorithm) > 0 {
+		var mgfHash pkix.AlgorithmIdentifier
+		_, err = asn1.Unmarshal(params.MaskGenFunc.Parameters.FullBytes, &mgfHash)
+		if !params.MaskGenFunc.Algorithm.Equal(oidMGF1) || err != nil {
+			return nil, errors.New("unsupported RSAES-OAEP mask generation function")
+		}
+		opts.MGFHash = cmsDigestHash(mgfHash.Algorithm)
+	} else {
+		opts.MGFHash = crypto.SHA1
+	}
+	if opts.Hash == 0 || opts.MGFHash == 0 {
+		return nil, errors.New("unsupported RSAES-OAEP hash algorithm")
+	}
+	return opts, nil
+}
+
+// cmsDecryptKeyAgree unwraps the content encryption key of a
+// KeyAgreeRecipientInfo. It returns nil if the recipient info contains no
+// key for cert.
+func cmsDecryptKeyAgree(der []byte, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	ri := cmsKeyAgreeRecipientInfo{}
+	_, err := asn1.UnmarshalWithParams(der, &ri, "tag:1")
+	if err != nil {
+		return nil, fmt.Errorf("invalid key agreement recipient info: %w", err)
+	}
+	var encryptedKey []byte
+	for _, rek := range ri.RecipientEncryptedKeys {
+		if cmsMatchesIdentifier(rek.RID, cert) {
+			encryptedKey = rek.EncryptedKey
+			break
+		}
+	}
+	if encryptedKey == nil {
+		return nil, nil
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T can not be used for ECDH", key)
+	}
+	privateKey, err := ecKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	originatorKey := cmsOriginatorPublicKey{}
+	_, err = asn1.UnmarshalWithParams(ri.Originator.Bytes, &originatorKey, "tag:1")
+	if err != nil {
+		return nil, errors.New("unsupported originator of key agreement recipient info")
+	}
+	publicKey, err := privateKey.Curve().NewPublicKey(originatorKey.PublicKey.RightAlign())
+	if err != nil {
+		return nil, fmt.Errorf("invalid originator key: %w", err)
+	}
+	sharedSecret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	for _, scheme := range cmsKeyAgreementSchemes {
+		if scheme.oid.Equal(ri.KeyEncryptionAlgorithm.Algorithm) {
+			hash = scheme.hash
+		}
+	}
+	if hash == 0 {
+		return nil, fmt.Errorf("unsupported key agreement algorithm %s", ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+	var wrapAlg pkix.AlgorithmIdentifier
+	_, err = asn1.Unmarshal(ri.KeyEncryptionAlgorithm.Parameters.FullBytes, &wrapAlg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key wrap algorithm: %w", err)
+	}
+	var kekSize int
+	switch {
+	case wrapAlg.Algorithm.Equal(oidAES128Wrap):
+		kekSize = 16
+	case wrapAlg.Algorithm.Equal(oidAES192Wrap):
+		kekSize = 24
+	case wrapAlg.Algorithm.Equal(oidAES256Wrap):
+		kekSize = 32
+	default:
+		return nil, fmt.Errorf("unsupported key wrap algorithm %s", wrapAlg.Algorithm)
+	}
+	kek, err := cmsDeriveKEK(hash, sharedSecret, wrapAlg, kekSize, ri.UKM)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := aesKeyUnwrap(kek, encryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap content encryption key: %w", err)
+	}
+	return contentKey, nil
+}
+
+// decrypt decrypts the content with key. mac and aad are the authentication
+// tag and the additional authenticated data of AES-GCM.
+func (eci *cmsEncryptedContentInfo) decrypt(key, mac, aad []byte) ([]byte, error) {
+	alg := eci.ContentEncryptionAlgorithm.Algorithm
+	if alg.Equal(oidAES128GCM) || alg.Equal(oidAES256GCM) {
+		var params cmsGCMParameters
+		_, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GCM parameters: %w", err)
+		}
+		if params.ICVLen != len(mac) {
+			return nil, errors.New("invalid length of message authentication code")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(params.Nonce))
+		if err != nil {
+			return nil, err
+		}
+		if len(mac) != gcm.Overhead() {
+			gcm, err = cipher.NewGCMWithTagSize(block, len(mac))
+			if err != nil || len(params.Nonce) != gcm.NonceSize() {
+				return nil, errors.New("unsupported GCM parameters")
+			}
+		}
+		decrypted, err := gcm.Open(nil, params.Nonce, append(slices.Clone(eci.EncryptedContent), mac...), aad)
+		if err != nil {
+			return nil, errors.New("decryption failed: message authentication failed")
+		}
+		return decrypted, nil
+	}
+
+	var (
+		block cipher.Block
+		err   error
+	)
+	switch {
+	case alg.Equal(oidAES128CBC), alg.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(key)
+	case alg.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(key)
+	default:
+		return nil, fmt.Errorf("unsupported content encryption algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	_, err = asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != block.BlockSize() {
+		return nil, errors.New("invalid initialization vector")
+	}
+	data := eci.EncryptedContent
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid length of encrypted content")
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+	return pkcs7Unpad(decrypted, block.BlockSize())
+}
+
+// aesKeyWrapIV is the default initial value of the AES key wrap.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap wraps key with kek (RFC 3394).
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("invalid key length for AES key wrap")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	a := slices.Clone(aesKeyWrapIV)
+	r := slices.Clone(key)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b, a)
+			copy(b[8:], r[i*8:i*8+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i*8:], b[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// aesKeyUnwrap unwraps a key wrapped with kek (RFC 3394).
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("invalid length of wrapped key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := slices.Clone(wrapped[:8])
+	r := slices.Clone(wrapped[8:])
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[i*8:i*8+8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[i*8:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, aesKeyWrapIV) != 1 {
+		return nil, errors.New("integrity check failed")
+	}
+	return r, nil
+}
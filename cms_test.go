@@ -0,0 +1,287 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func newCMSTestCert(t *testing.T, name string, keyOpts KeyOptions) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	der, key, err := CreateCertificateWithKeyOptions(NewClientCertificate(name), keyOpts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key.(crypto.Signer)
+}
+
+func TestCMSSignVerify(t *testing.T) {
+	content := []byte("signed content")
+	for _, keyOpts := range []KeyOptions{
+		{Algorithm: x509.RSA},
+		{Algorithm: x509.ECDSA},
+		{Algorithm: x509.Ed25519},
+	} {
+		t.Run(keyOpts.Algorithm.String(), func(t *testing.T) {
+			cert, key := newCMSTestCert(t, "signer", keyOpts)
+			attr, err := newCMSAttribute(asn1.ObjectIdentifier{1, 2, 3}, "value", "printable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			signers := []cmsSigner{{Cert: cert, Key: key, Attributes: []cmsAttribute{attr}}}
+
+			der, err := cmsSign(content, oidPKCS7Data, signers, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sd, err := parseCMSSignedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := sd.verify(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verified[0].Cert.Equal(cert) {
+				t.Error("unexpected signer certificate")
+			}
+			var value string
+			ok, err := verified[0].Attributes.unmarshal(asn1.ObjectIdentifier{1, 2, 3}, &value)
+			if !ok || err != nil || value != "value" {
+				t.Errorf("attribute not found: %v %v %s", ok, err, value)
+			}
+
+			// detached
+			der, err = cmsSign(content, oidPKCS7Data, signers, nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sd, err = parseCMSSignedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = sd.verify(nil, nil)
+			if err == nil {
+				t.Error("detached signature verified without content")
+			}
+			_, err = sd.verify(content, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = sd.verify([]byte("other content"), nil)
+			if err == nil {
+				t.Error("other content verified")
+			}
+		})
+	}
+}
+
+func TestSignVerifyCMS(t *testing.T) {
+	newCert := func(opts *CertificateOptions, keyOpts KeyOptions, signCert *x509.Certificate, signKey crypto.PrivateKey) (*x509.Certificate, crypto.PrivateKey) {
+		t.Helper()
+		der, key, err := CreateCertificateWithKeyOptions(NewCertificate(opts), keyOpts, signCert, signKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	root, rootKey := newCert(&CertificateOptions{ProfileCA: true, Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "Root"}}}, KeyOptions{}, nil, nil)
+	intermediate, intermediateKey := newCert(&CertificateOptions{ProfileCA: true, Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "Intermediate"}}}, KeyOptions{}, root, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	content := []byte("signed file")
+
+	for _, keyOpts := range []KeyOptions{
+		{Algorithm: x509.RSA},
+		{Algorithm: x509.ECDSA},
+		{Algorithm: x509.Ed25519},
+	} {
+		t.Run(keyOpts.Algorithm.String(), func(t *testing.T) {
+			signer, key := newCert(&CertificateOptions{
+				Certificate: x509.Certificate{
+					Subject:     pkix.Name{CommonName: "Signer"},
+					KeyUsage:    x509.KeyUsageDigitalSignature,
+					ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+				},
+			}, keyOpts, intermediate, intermediateKey)
+
+			der, err := SignCMS(content, signer, key, []*x509.Certificate{intermediate}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signatures, err := VerifyCMS(der, content, x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(signatures) != 1 || !signatures[0].Certificate.Equal(signer) || len(signatures[0].Chains[0]) != 3 {
+				t.Fatalf("unexpected signatures: %v", signatures)
+			}
+			if time.Since(signatures[0].SigningTime) > time.Minute {
+				t.Errorf("unexpected signing time %s", signatures[0].SigningTime)
+			}
+
+			_, err = VerifyCMS(EncodePKCS7(der), content, x509.VerifyOptions{Roots: roots})
+			if err != nil {
+				t.Errorf("PEM: %s", err)
+			}
+			_, err = VerifyCMS(der, []byte("other file"), x509.VerifyOptions{Roots: roots})
+			if err == nil {
+				t.Error("other content verified")
+			}
+			_, err = VerifyCMS(der, content, x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}})
+			if err == nil {
+				t.Error("verified with wrong extended key usage")
+			}
+			_, err = VerifyCMS(der, content, x509.VerifyOptions{Roots: x509.NewCertPool()})
+			if err == nil {
+				t.Error("verified without root")
+			}
+		})
+	}
+
+	_, otherKey := newCert(&CertificateOptions{}, KeyOptions{}, nil, nil)
+	_, err := SignCMS(content, intermediate, otherKey, nil, true)
+	if err == nil {
+		t.Error("signed with key of other certificate")
+	}
+
+	// the content of an attached signature must not replace other content
+	signer, key := newCert(&CertificateOptions{Certificate: x509.Certificate{Subject: pkix.Name{CommonName: "Signer"}}}, KeyOptions{}, intermediate, intermediateKey)
+	attached, err := SignCMS([]byte("harmless"), signer, key, []*x509.Certificate{intermediate}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyCMS(attached, []byte("malware"), x509.VerifyOptions{Roots: roots})
+	if err == nil {
+		t.Error("attached signature verified other content")
+	}
+	_, err = VerifyCMS(attached, []byte("harmless"), x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Errorf("attached signature with equal content: %s", err)
+	}
+	_, err = VerifyCMS(attached, nil, x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Errorf("attached signature without content: %s", err)
+	}
+	embedded, err := CMSContent(attached)
+	if err != nil || string(embedded) != "harmless" {
+		t.Errorf("unexpected content: %s %v", embedded, err)
+	}
+}
+
+func TestCMSEncryptDecrypt(t *testing.T) {
+	rsaCert, rsaKey := newCMSTestCert(t, "rsa", KeyOptions{Algorithm: x509.RSA})
+	p256Cert, p256Key := newCMSTestCert(t, "p256", KeyOptions{Algorithm: x509.ECDSA})
+	p384Cert, p384Key := newCMSTestCert(t, "p384", KeyOptions{Algorithm: x509.ECDSA, Size: 384})
+	other, otherKey := newCMSTestCert(t, "other", KeyOptions{Algorithm: x509.RSA})
+	content := bytes.Repeat([]byte("secret"), 10)
+
+	for _, opts := range []cmsEncryptOptions{
+		{ContentEncryption: oidAES128CBC},
+		{ContentEncryption: oidAES256CBC, OAEP: true},
+		{ContentEncryption: oidAES128GCM, OAEP: true},
+		{ContentEncryption: oidAES256GCM},
+	} {
+		der, err := cmsEncrypt(content, []*x509.Certificate{rsaCert, p256Cert, p384Cert}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []struct {
+			cert *x509.Certificate
+			key  crypto.Signer
+		}{{rsaCert, rsaKey}, {p256Cert, p256Key}, {p384Cert, p384Key}} {
+			decrypted, err := cmsDecrypt(der, r.cert, r.key)
+			if err != nil {
+				t.Fatalf("%s %s: %s", opts.ContentEncryption, r.cert.Subject, err)
+			}
+			if !bytes.Equal(decrypted, content) {
+				t.Errorf("unexpected content: %s", decrypted)
+			}
+		}
+		_, err = cmsDecrypt(der, other, otherKey)
+		if err == nil {
+			t.Error("decrypted without being a recipient")
+		}
+	}
+
+	der, err := cmsEncrypt(content, []*x509.Certificate{p256Cert}, cmsEncryptOptions{ContentEncryption: oidAES128GCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// modify the last byte of the message authentication code
+	der[len(der)-1] ^= 1
+	_, err = cmsDecrypt(der, p256Cert, p256Key)
+	if err == nil {
+		t.Error("decrypted modified content")
+	}
+
+	// an invalid PKCS #1 v1.5 padding of the content encryption key must
+	// not be distinguishable from a wrong key
+	der, err = cmsEncrypt(content, []*x509.Certificate{rsaCert}, cmsEncryptOptions{ContentEncryption: oidAES128GCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := &contentInfo{}
+	aed := &cmsAuthEnvelopedData{}
+	ri := &cmsKeyTransRecipientInfo{}
+	_, err = asn1.Unmarshal(der, ci)
+	if err == nil {
+		_, err = asn1.Unmarshal(ci.Content.Bytes, aed)
+	}
+	if err == nil {
+		_, err = asn1.Unmarshal(aed.RecipientInfos[0].FullBytes, ri)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der[bytes.Index(der, ri.EncryptedKey)] ^= 1
+	_, err = cmsDecrypt(der, rsaCert, rsaKey)
+	if err == nil || err.Error() != "decryption failed: message authentication failed" {
+		t.Errorf("unexpected error for invalid padding: %v", err)
+	}
+
+	edCert, _ := newCMSTestCert(t, "ed25519", KeyOptions{Algorithm: x509.Ed25519})
+	_, err = cmsEncrypt(content, []*x509.Certificate{edCert}, cmsEncryptOptions{ContentEncryption: oidAES128CBC})
+	if err == nil {
+		t.Error("expected error for Ed25519 recipient")
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// RFC 3394 section 4.1
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	expected, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wrapped, expected) {
+		t.Fatalf("unexpected wrapped key %x", wrapped)
+	}
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("unexpected unwrapped key %x", unwrapped)
+	}
+	wrapped[0] ^= 1
+	_, err = aesKeyUnwrap(kek, wrapped)
+	if err == nil {
+		t.Error("unwrapped modified key")
+	}
+}
@@ -0,0 +1,308 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// ESTPathPrefix is the path under which the EST operations are
+	// served.
+	ESTPathPrefix = "/.well-known/est"
+
+	estMaxRequestSize = 1 << 20
+)
+
+// ESTServer is an EST (RFC 7030) server which signs CSRs with a CA. It
+// supports the operations cacerts, simpleenroll, simplereenroll and
+// csrattrs. Server-side key generation, full CMC and labels are not
+// supported.
+//
+// Clients are authenticated with a client certificate verified by the TLS
+// server (tls.VerifyClientCertIfGiven with ClientCAs) or with HTTP basic
+// authentication. Re-enrollment requires a client certificate whose subject
+// and subject alternative names match the CSR.
+type ESTServer struct {
+	// CACert and CAKey are used to sign the certificates.
+	CACert *x509.Certificate
+	CAKey  any
+	// Chain contains further certificates which are returned by cacerts
+	// (e.g. the root of an intermediate CACert).
+	Chain []*x509.Certificate
+
+	// CertificateOptions are used for every issued certificate. The
+	// subject and the subject alternative names are taken from the CSR if
+	// they are not set.
+	CertificateOptions CertificateOptions
+
+	// CSRAttributes are the object identifiers returned by csrattrs. If
+	// empty, csrattrs responds with 204 No Content.
+	CSRAttributes []asn1.ObjectIdentifier
+
+	// BasicAuth verifies username and password of HTTP basic
+	// authentication. If nil, only clients with a verified client
+	// certificate can enroll.
+	BasicAuth func(username, password string) bool
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+func (s *ESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("GET "+ESTPathPrefix+"/cacerts", s.handleCACerts)
+		s.mux.HandleFunc("GET "+ESTPathPrefix+"/csrattrs", s.handleCSRAttrs)
+		s.mux.HandleFunc("POST "+ESTPathPrefix+"/simpleenroll", s.handleEnroll)
+		s.mux.HandleFunc("POST "+ESTPathPrefix+"/simplereenroll", s.handleEnroll)
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *ESTServer) handleCACerts(w http.ResponseWriter, r *http.Request) {
+	p7, err := EncodePKCS7Certificates(append([]*x509.Certificate{s.CACert}, s.Chain...))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeESTResponse(w, "application/pkcs7-mime", p7)
+}
+
+func (s *ESTServer) handleCSRAttrs(w http.ResponseWriter, r *http.Request) {
+	if len(s.CSRAttributes) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	der, err := asn1.Marshal(s.CSRAttributes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeESTResponse(w, "application/csrattrs", der)
+}
+
+func (s *ESTServer) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	reenroll := strings.HasSuffix(r.URL.Path, "/simplereenroll")
+	var clientCert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		clientCert = r.TLS.VerifiedChains[0][0]
+	}
+	if clientCert == nil {
+		username, password, ok := r.BasicAuth()
+		if reenroll || !ok || s.BasicAuth == nil || !s.BasicAuth(username, password) {
+			if !reenroll && s.BasicAuth != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+			}
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, estMaxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	der, err := decodeESTBody(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, "invalid CSR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		http.Error(w, "invalid CSR signature: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reenroll && !estSameNames(csr, clientCert) {
+		http.Error(w, "subject and subject alternative names of the CSR do not match the client certificate", http.StatusForbidden)
+		return
+	}
+
+	cert, err := issueCertificateForCSR(csr, s.CertificateOptions, s.CACert, s.CAKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p7, err := EncodePKCS7Certificates([]*x509.Certificate{cert})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeESTResponse(w, "application/pkcs7-mime; smime-type=certs-only", p7)
+}
+
+// estSameNames reports whether the CSR has the same subject and subject
+// alternative names as cert.
+func estSameNames(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	if !bytes.Equal(csr.RawSubject, cert.RawSubject) {
+		return false
+	}
+	return slices.Equal(csr.DNSNames, cert.DNSNames) &&
+		slices.Equal(csr.EmailAddresses, cert.EmailAddresses) &&
+		slices.EqualFunc(csr.IPAddresses, cert.IPAddresses, func(a, b net.IP) bool { return a.Equal(b) }) &&
+		slices.EqualFunc(csr.URIs, cert.URIs, func(a, b *url.URL) bool { return a.String() == b.String() })
+}
+
+func writeESTResponse(w http.ResponseWriter, contentType string, der []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = io.WriteString(w, base64.StdEncoding.EncodeToString(der))
+}
+
+// decodeESTBody decodes the base64 encoded body of EST messages. Line
+// breaks and other white space are ignored.
+func decodeESTBody(body []byte) ([]byte, error) {
+	body = bytes.Join(bytes.Fields(body), nil)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+	return der, nil
+}
+
+// ESTClient is an EST (RFC 7030) client. Client certificates for the
+// authentication are configured in the TLS configuration of the
+// HTTPClient.
+type ESTClient struct {
+	// URL is the URL of the EST server (e.g. https://est.example.com). If
+	// it has no path, ESTPathPrefix is used.
+	URL string
+	// HTTPClient is used for all requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// Username and Password are used for HTTP basic authentication if
+	// Username is set.
+	Username string
+	Password string
+}
+
+// CACerts returns the CA certificates of the server.
+func (c *ESTClient) CACerts(ctx context.Context) ([]*x509.Certificate, error) {
+	der, err := c.do(ctx, http.MethodGet, "cacerts", nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePKCS7Certificates(der)
+}
+
+// CSRAttributes returns the object identifiers of the attributes the server
+// wants to be present in CSRs. For attributes with values only the type is
+// returned.
+func (c *ESTClient) CSRAttributes(ctx context.Context) ([]asn1.ObjectIdentifier, error) {
+	der, err := c.do(ctx, http.MethodGet, "csrattrs", nil)
+	if err != nil || len(der) == 0 {
+		return nil, err
+	}
+	var attrs []asn1.RawValue
+	_, err = asn1.Unmarshal(der, &attrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR attributes: %w", err)
+	}
+	oids := []asn1.ObjectIdentifier{}
+	for _, attr := range attrs {
+		var oid asn1.ObjectIdentifier
+		if attr.Tag == asn1.TagSequence {
+			_, err = asn1.Unmarshal(attr.Bytes, &oid)
+		} else {
+			_, err = asn1.Unmarshal(attr.FullBytes, &oid)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSR attribute: %w", err)
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+// Enroll sends a DER encoded CSR to the server and returns the issued
+// certificate.
+func (c *ESTClient) Enroll(ctx context.Context, csr []byte) (*x509.Certificate, error) {
+	return c.enroll(ctx, "simpleenroll", csr)
+}
+
+// Reenroll renews a certificate. The client has to authenticate with the
+// certificate which is renewed and the CSR has to contain the same subject
+// and subject alternative names.
+func (c *ESTClient) Reenroll(ctx context.Context, csr []byte) (*x509.Certificate, error) {
+	return c.enroll(ctx, "simplereenroll", csr)
+}
+
+func (c *ESTClient) enroll(ctx context.Context, operation string, csr []byte) (*x509.Certificate, error) {
+	body := []byte(base64.StdEncoding.EncodeToString(csr))
+	der, err := c.do(ctx, http.MethodPost, operation, body)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParsePKCS7Certificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("server returned no certificate")
+	}
+	return certs[0], nil
+}
+
+// do performs an EST operation and returns the decoded body of the
+// response.
+func (c *ESTClient) do(ctx context.Context, method, operation string, body []byte) ([]byte, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = ESTPathPrefix
+	}
+	u = u.JoinPath(operation)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/pkcs10")
+		req.Header.Set("Content-Transfer-Encoding", "base64")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, estMaxRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return nil, nil
+	case resp.StatusCode == http.StatusAccepted:
+		return nil, fmt.Errorf("%s: request is pending (retry after %s)", operation, resp.Header.Get("Retry-After"))
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s failed: %s: %s", operation, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return decodeESTBody(respBody)
+}
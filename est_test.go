@@ -0,0 +1,148 @@
+package pcert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEST(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCACertificate("EST CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ESTServer{
+		CACert: caCert,
+		CAKey:  caKey,
+		CertificateOptions: CertificateOptions{
+			ProfileClient: true,
+			Expiry:        time.Hour,
+		},
+		BasicAuth: func(username, password string) bool {
+			return username == "device" && password == "secret"
+		},
+	}
+	ts := httptest.NewUnstartedServer(server)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  clientCAs,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := &ESTClient{
+		URL:        ts.URL,
+		HTTPClient: ts.Client(),
+	}
+	cacerts, err := client.CACerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cacerts) != 1 || !cacerts[0].Equal(caCert) {
+		t.Fatalf("unexpected CA certificates: %d", len(cacerts))
+	}
+
+	attrs, err := client.CSRAttributes(ctx)
+	if err != nil || len(attrs) != 0 {
+		t.Errorf("expected no CSR attributes, got %v %v", attrs, err)
+	}
+	challengePassword := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+	extensionRequest := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	server.CSRAttributes = []asn1.ObjectIdentifier{challengePassword, extensionRequest}
+	attrs, err = client.CSRAttributes(ctx)
+	if err != nil || len(attrs) != 2 || !attrs[0].Equal(challengePassword) {
+		t.Errorf("unexpected CSR attributes: %v %v", attrs, err)
+	}
+
+	csrDER, key, err := CreateRequestWithKeyOptions(&x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.example.com"},
+	}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Enroll(ctx, csrDER)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 without authentication, got %v", err)
+	}
+	client.Username = "device"
+	client.Password = "wrong"
+	_, err = client.Enroll(ctx, csrDER)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 with wrong password, got %v", err)
+	}
+	client.Password = "secret"
+	cert, err := client.Enroll(ctx, csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "device-1" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "device-1.example.com" {
+		t.Errorf("names not taken from CSR: %s %v", cert.Subject, cert.DNSNames)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("unexpected extended key usage: %v", cert.ExtKeyUsage)
+	}
+
+	// re-enrollment requires the certificate
+	_, err = client.Reenroll(ctx, csrDER)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 for re-enrollment with basic auth, got %v", err)
+	}
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+	}}
+	certClient := &ESTClient{
+		URL:        ts.URL + ESTPathPrefix,
+		HTTPClient: &http.Client{Transport: transport},
+	}
+	newCSR, _, err := CreateRequestWithKeyOptions(&x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.example.com"},
+	}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, err := certClient.Reenroll(ctx, newCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCert.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Error("no new certificate issued")
+	}
+
+	otherCSR, _, err := CreateRequestWithKeyOptions(&x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device-2"},
+	}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = certClient.Reenroll(ctx, otherCSR)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected 403 for other names, got %v", err)
+	}
+	// the client certificate authenticates enrollments as well
+	_, err = certClient.Enroll(ctx, otherCSR)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,172 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PublicKeyPin returns the base64 encoded SHA-256 hash of the DER encoded
+// SubjectPublicKeyInfo of pub as used for public key pinning (pin-sha256, RFC
+// 7469).
+func PublicKeyPin(pub crypto.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return spkiPin(spki), nil
+}
+
+func spkiPin(spki []byte) string {
+	sum := sha256.Sum256(spki)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// SubjectHash returns the hash of the subject of cert as OpenSSL computes it
+// (openssl x509 -subject_hash). It is used as file name for certificates in
+// CA directories (c_rehash).
+func SubjectHash(cert *x509.Certificate) (string, error) {
+	return NameHash(cert.RawSubject)
+}
+
+// NameHash returns the OpenSSL hash of a DER encoded distinguished name. The
+// hash is computed over the canonical encoding of the name in which all
+// strings are UTF-8 encoded, lower case and without superfluous white space.
+func NameHash(rawName []byte) (string, error) {
+	canon, err := canonicalName(rawName)
+	if err != nil {
+		return "", fmt.Errorf("invalid name: %w", err)
+	}
+	sum := sha1.Sum(canon)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum[:4])), nil
+}
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// canonicalName returns the canonical encoding of a name like OpenSSL's
+// x509_name_canon. It is the concatenation of the encoded RDNs without the
+// outer sequence.
+func canonicalName(rawName []byte) ([]byte, error) {
+	var rdns []asn1.RawValue
+	rest, err := asn1.Unmarshal(rawName, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data")
+	}
+
+	canon := []byte{}
+	for _, rdn := range rdns {
+		if rdn.Tag != asn1.TagSet {
+			return nil, errors.New("relative distinguished name is not a set")
+		}
+		attributes := [][]byte{}
+		for rest := rdn.Bytes; len(rest) > 0; {
+			var atv attributeTypeAndValue
+			rest, err = asn1.Unmarshal(rest, &atv)
+			if err != nil {
+				return nil, err
+			}
+			if atv.Value.Class == asn1.ClassUniversal {
+				if s, ok := decodeNameString(atv.Value.Tag, atv.Value.Bytes); ok {
+					atv.Value = asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(canonicalString(s))}
+				}
+			}
+			attribute, err := asn1.Marshal(atv)
+			if err != nil {
+				return nil, err
+			}
+			attributes = append(attributes, attribute)
+		}
+		// DER requires the elements of a set to be sorted
+		sort.Slice(attributes, func(i, j int) bool {
+			return bytes.Compare(attributes[i], attributes[j]) < 0
+		})
+		set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attributes, nil)})
+		if err != nil {
+			return nil, err
+		}
+		canon = append(canon, set...)
+	}
+	return canon, nil
+}
+
+// decodeNameString decodes the string types which OpenSSL canonicalizes.
+func decodeNameString(tag int, data []byte) (string, bool) {
+	switch tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, 26: // VisibleString
+		return string(data), true
+	case asn1.TagT61String:
+		// like OpenSSL we treat T61String as Latin-1
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), true
+	case asn1.TagBMPString:
+		if len(data)%2 != 0 {
+			return "", false
+		}
+		chars := make([]uint16, len(data)/2)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(data[2*i:])
+		}
+		return string(utf16.Decode(chars)), true
+	case 28: // UniversalString
+		if len(data)%4 != 0 {
+			return "", false
+		}
+		sb := &strings.Builder{}
+		for i := 0; i < len(data); i += 4 {
+			r := rune(binary.BigEndian.Uint32(data[i:]))
+			if !utf8.ValidRune(r) {
+				return "", false
+			}
+			sb.WriteRune(r)
+		}
+		return sb.String(), true
+	default:
+		return "", false
+	}
+}
+
+// canonicalString removes leading and trailing white space, collapses
+// white space to a single space and converts ASCII characters to lower case.
+func canonicalString(s string) string {
+	isSpace := func(b byte) bool {
+		return b == ' ' || (b >= '\t' && b <= '\r')
+	}
+	s = strings.TrimFunc(s, func(r rune) bool {
+		return r < utf8.RuneSelf && isSpace(byte(r))
+	})
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case isSpace(b):
+			out = append(out, ' ')
+			for i+1 < len(s) && isSpace(s[i+1]) {
+				i++
+			}
+		case b >= 'A' && b <= 'Z':
+			out = append(out, b+'a'-'A')
+		default:
+			out = append(out, b)
+		}
+	}
+	return string(out)
+}
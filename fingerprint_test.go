@@ -0,0 +1,88 @@
+package pcert
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestNameHash(t *testing.T) {
+	tests := []struct {
+		name     pkix.Name
+		expected string
+	}{
+		{
+			// openssl req -subj "/CN=localhost"
+			name:     pkix.Name{CommonName: "localhost"},
+			expected: "ce275665",
+		},
+		{
+			// openssl req -subj "/C=CH/O=  Example   Org /CN=My  Test CA/emailAddress=CA@Example.com"
+			name: pkix.Name{
+				Country:      []string{"CH"},
+				Organization: []string{"  Example   Org "},
+				CommonName:   "My  Test CA",
+				ExtraNames: []pkix.AttributeTypeAndValue{
+					{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, Value: "CA@Example.com"},
+				},
+			},
+			expected: "e92f9213",
+		},
+	}
+	for _, test := range tests {
+		rawName, err := asn1.Marshal(test.name.ToRDNSequence())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := NameHash(rawName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != test.expected {
+			t.Errorf("%s: got=%s want=%s", test.name, hash, test.expected)
+		}
+	}
+}
+
+// testOpenSSLCert is a self-signed certificate created with:
+//
+//	openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -subj "/C=CH/O=  Example   Org /CN=My  Test CA/emailAddress=CA@Example.com"
+const testOpenSSLCert = `-----BEGIN CERTIFICATE-----
+MIICDjCCAbWgAwIBAgIUc4yRaWbLVVuoTwfhZOSNhX3ODj4wCgYIKoZIzj0EAwIw
+XTELMAkGA1UEBhMCQ0gxGTAXBgNVBAoMECAgRXhhbXBsZSAgIE9yZyAxFDASBgNV
+BAMMC015ICBUZXN0IENBMR0wGwYJKoZIhvcNAQkBFg5DQUBFeGFtcGxlLmNvbTAe
+Fw0yNjEwMTgyMDA4NDJaFw0yNjEwMTkyMDA4NDJaMF0xCzAJBgNVBAYTAkNIMRkw
+FwYDVQQKDBAgIEV4YW1wbGUgICBPcmcgMRQwEgYDVQQDDAtNeSAgVGVzdCBDQTEd
+MBsGCSqGSIb3DQEJARYOQ0FARXhhbXBsZS5jb20wWTATBgcqhkjOPQIBBggqhkjO
+PQMBBwNCAATgxvYUfH1/3mPUul5rpS/z1jqvxh7se2wQdEFK7w+bGcpQo3vKK3nU
+rp+0U3sFLMv5+psM/l0gpsCvBZX4V3ajo1MwUTAdBgNVHQ4EFgQUq+I3N3EJzBAQ
+Qzw3G7WsiU7NaOMwHwYDVR0jBBgwFoAUq+I3N3EJzBAQQzw3G7WsiU7NaOMwDwYD
+VR0TAQH/BAUwAwEB/zAKBggqhkjOPQQDAgNHADBEAiBjAciu9YJMWX3pgxHFR5Gy
+ZcjYka81NtrdfZrWEUNorAIgJbtYzzDDpVN6RHTBRKhPKrzVaIZqIPoFsi0weqn0
+0KE=
+-----END CERTIFICATE-----`
+
+func TestPublicKeyPin(t *testing.T) {
+	cert, err := Parse([]byte(testOpenSSLCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// openssl x509 -pubkey | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+	expected := "QNnz+LBx8iOvhpxs/pMsrxEcm06v2pZZs+9jrk5YZUk="
+	pin, err := PublicKeyPin(cert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin != expected {
+		t.Errorf("got=%s want=%s", pin, expected)
+	}
+
+	// openssl x509 -subject_hash
+	hash, err := SubjectHash(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "e92f9213" {
+		t.Errorf("subject hash: got=%s want=e92f9213", hash)
+	}
+}
@@ -0,0 +1,361 @@
+package pcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key (RFC 7517) of type RSA, EC or OKP (Ed25519).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+
+	// private part of all key types
+	D string `json:"d,omitempty"`
+
+	// X5c is the certificate chain (base64 DER, not URL encoded).
+	X5c []string `json:"x5c,omitempty"`
+	// X5tS256 is the base64url encoded SHA-256 fingerprint of the
+	// certificate.
+	X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517 section 5).
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// Key returns the key with the key ID kid or nil if the set contains no such
+// key.
+func (s *JWKS) Key(kid string) *JWK {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+var jwkEncoding = base64.RawURLEncoding
+
+// NewJWK returns the JWK of a public or private key.
+func NewJWK(key any) (*JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			N:   jwkEncoding.EncodeToString(k.N.Bytes()),
+			E:   jwkEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("RSA keys with more than two primes are not supported")
+		}
+		// the CRT values are calculated instead of calling Precompute
+		// which would modify the key of the caller
+		p, q := k.Primes[0], k.Primes[1]
+		one := big.NewInt(1)
+		dp := new(big.Int).Mod(k.D, new(big.Int).Sub(p, one))
+		dq := new(big.Int).Mod(k.D, new(big.Int).Sub(q, one))
+		qi := new(big.Int).ModInverse(q, p)
+		if qi == nil {
+			return nil, errors.New("invalid RSA key: q is not invertible modulo p")
+		}
+		jwk, _ := NewJWK(&k.PublicKey)
+		jwk.D = jwkEncoding.EncodeToString(k.D.Bytes())
+		jwk.P = jwkEncoding.EncodeToString(p.Bytes())
+		jwk.Q = jwkEncoding.EncodeToString(q.Bytes())
+		jwk.DP = jwkEncoding.EncodeToString(dp.Bytes())
+		jwk.DQ = jwkEncoding.EncodeToString(dq.Bytes())
+		jwk.QI = jwkEncoding.EncodeToString(qi.Bytes())
+		return jwk, nil
+	case *ecdsa.PublicKey:
+		crv, size, err := jwkCurve(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &JWK{
+			Kty: "EC",
+			Crv: crv,
+			X:   jwkEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   jwkEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case *ecdsa.PrivateKey:
+		jwk, err := NewJWK(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		_, size, _ := jwkCurve(k.Curve)
+		jwk.D = jwkEncoding.EncodeToString(k.D.FillBytes(make([]byte, size)))
+		return jwk, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   jwkEncoding.EncodeToString(k),
+		}, nil
+	case ed25519.PrivateKey:
+		jwk, _ := NewJWK(k.Public())
+		jwk.D = jwkEncoding.EncodeToString(k.Seed())
+		return jwk, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// NewCertificateJWK returns the public JWK of a certificate for signatures.
+// The key ID is the hex encoded subject key identifier of the certificate.
+// The certificate and chain are added as x5c and the SHA-256 fingerprint of
+// the certificate as x5t#S256. The algorithm is only set for ECDSA and
+// Ed25519 keys since RSA keys are used with several algorithms (RS256 to
+// PS512).
+func NewCertificateJWK(cert *x509.Certificate, chain []*x509.Certificate) (*JWK, error) {
+	jwk, err := NewJWK(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Alg, err = jwkAlgorithm(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	jwk.Kid, err = CertificateKeyID(cert)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	jwk.X5tS256 = jwkEncoding.EncodeToString(fingerprint[:])
+	return jwk, nil
+}
+
+// jwkAlgorithm returns the algorithm of a public JWK. It is empty for RSA keys
+// because the key type does not determine the algorithm.
+func jwkAlgorithm(key any) (string, error) {
+	if _, ok := publicKey(key).(*rsa.PublicKey); ok {
+		return "", nil
+	}
+	return JWSAlgorithm(key)
+}
+
+// CertificateKeyID returns the hex encoded subject key identifier of a
+// certificate. If the certificate has no subject key identifier it is
+// calculated as SHA-1 hash of the public key (RFC 5280 section 4.2.1.2).
+func CertificateKeyID(cert *x509.Certificate) (string, error) {
+	if len(cert.SubjectKeyId) > 0 {
+		return hex.EncodeToString(cert.SubjectKeyId), nil
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(spki.PublicKey.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func jwkCurve(curve elliptic.Curve) (string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	case elliptic.P521():
+		return "P-521", 66, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+// ParseJWK parses a JSON encoded JWK.
+func ParseJWK(data []byte) (*JWK, error) {
+	jwk := &JWK{}
+	err := json.Unmarshal(data, jwk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	if jwk.Kty == "" {
+		return nil, errors.New("invalid JWK: kty missing")
+	}
+	return jwk, nil
+}
+
+// IsPrivate reports whether the JWK contains a private key.
+func (j *JWK) IsPrivate() bool {
+	return j.D != ""
+}
+
+// Key returns the key of the JWK. If the JWK contains a private key a
+// *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey is returned,
+// otherwise the corresponding public key.
+func (j *JWK) Key() (any, error) {
+	var errs []error
+	decode := func(name, value string) *big.Int {
+		data, err := jwkEncoding.DecodeString(value)
+		if err != nil || len(data) == 0 {
+			errs = append(errs, fmt.Errorf("invalid JWK parameter %s", name))
+		}
+		return new(big.Int).SetBytes(data)
+	}
+
+	switch j.Kty {
+	case "RSA":
+		pub := &rsa.PublicKey{
+			N: decode("n", j.N),
+			E: int(decode("e", j.E).Int64()),
+		}
+		if !j.IsPrivate() {
+			return pub, errors.Join(errs...)
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: *pub,
+			D:         decode("d", j.D),
+			Primes:    []*big.Int{decode("p", j.P), decode("q", j.Q)},
+		}
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		err := priv.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA JWK: %w", err)
+		}
+		priv.Precompute()
+		return priv, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported JWK curve '%s'", j.Crv)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     decode("x", j.X),
+			Y:     decode("y", j.Y),
+		}
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid EC JWK: point is not on curve")
+		}
+		if !j.IsPrivate() {
+			return pub, nil
+		}
+		priv := &ecdsa.PrivateKey{
+			PublicKey: *pub,
+			D:         decode("d", j.D),
+		}
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		x, y := curve.ScalarBaseMult(priv.D.Bytes())
+		if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+			return nil, errors.New("invalid EC JWK: private key does not match public key")
+		}
+		return priv, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported JWK curve '%s'", j.Crv)
+		}
+		x, err := jwkEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid JWK parameter x")
+		}
+		if !j.IsPrivate() {
+			return ed25519.PublicKey(x), nil
+		}
+		seed, err := jwkEncoding.DecodeString(j.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("invalid JWK parameter d")
+		}
+		priv := ed25519.NewKeyFromSeed(seed)
+		if !priv.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+			return nil, errors.New("invalid OKP JWK: private key does not match public key")
+		}
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type '%s'", j.Kty)
+	}
+}
+
+// Public returns the JWK without the private parts.
+func (j *JWK) Public() *JWK {
+	pub := *j
+	pub.D, pub.P, pub.Q, pub.DP, pub.DQ, pub.QI = "", "", "", "", "", ""
+	return &pub
+}
+
+// Thumbprint returns the base64url encoded SHA-256 JWK thumbprint (RFC
+// 7638).
+func (j *JWK) Thumbprint() (string, error) {
+	var members any
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return "", fmt.Errorf("unsupported JWK key type '%s'", j.Kty)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return jwkEncoding.EncodeToString(sum[:]), nil
+}
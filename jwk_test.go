@@ -0,0 +1,138 @@
+package pcert
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+)
+
+func TestJWK(t *testing.T) {
+	for _, alg := range PublicKeyAlgorithms {
+		t.Run(alg.String(), func(t *testing.T) {
+			priv, pub, err := GenerateKey(KeyOptions{Algorithm: alg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwk, err := NewJWK(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jwk.IsPrivate() || jwk.Public().IsPrivate() {
+				t.Fatal("private part not set or not removed")
+			}
+
+			key, err := jwk.Key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			privDER, _ := x509.MarshalPKCS8PrivateKey(priv)
+			keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(privDER) != string(keyDER) {
+				t.Fatal("private key not restored from JWK")
+			}
+
+			pubJWK, err := NewJWK(pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tp1, err := pubJWK.Thumbprint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tp2, _ := jwk.Thumbprint()
+			if tp1 != tp2 {
+				t.Fatal("thumbprint of public and private JWK differ")
+			}
+		})
+	}
+}
+
+func TestNewJWK_rsaNotModified(t *testing.T) {
+	priv, _, err := GenerateKey(KeyOptions{Algorithm: x509.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	precomputed := priv.(*rsa.PrivateKey)
+	key := &rsa.PrivateKey{
+		PublicKey: precomputed.PublicKey,
+		D:         precomputed.D,
+		Primes:    precomputed.Primes,
+	}
+	jwk, err := NewJWK(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Precomputed.Dp != nil {
+		t.Error("key of the caller was modified")
+	}
+	expected, err := NewJWK(precomputed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.DP != expected.DP || jwk.DQ != expected.DQ || jwk.QI != expected.QI {
+		t.Errorf("wrong CRT values: %+v", jwk)
+	}
+	if jwk.DP != jwkEncoding.EncodeToString(precomputed.Precomputed.Dp.Bytes()) || jwk.QI != jwkEncoding.EncodeToString(precomputed.Precomputed.Qinv.Bytes()) {
+		t.Errorf("CRT values differ from the precomputed values")
+	}
+}
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// example from RFC 7638 section 3.1
+	jwk := &JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	tp, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("wrong thumbprint: %s", tp)
+	}
+}
+
+func TestNewCertificateJWK(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCertificate(&CertificateOptions{ProfileCA: true}), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	der, _, err := CreateCertificateWithKeyOptions(NewClientCertificate("client"), KeyOptions{Algorithm: x509.RSA}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	jwk, err := NewCertificateJWK(cert, []*x509.Certificate{ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.Kty != "RSA" || jwk.Alg != "" || jwk.Use != "sig" || len(jwk.X5c) != 2 || jwk.IsPrivate() {
+		t.Errorf("unexpected JWK: %+v", jwk)
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	if jwk.X5tS256 != jwkEncoding.EncodeToString(fingerprint[:]) {
+		t.Errorf("unexpected x5t#S256 %s", jwk.X5tS256)
+	}
+
+	// the key ID of a certificate without subject key identifier is
+	// calculated like the subject key identifier of CA certificates
+	kid, err := CertificateKeyID(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.SubjectKeyId = nil
+	calculated, err := CertificateKeyID(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid == "" || kid != calculated {
+		t.Errorf("calculated key ID %s does not match subject key identifier %s", calculated, kid)
+	}
+}
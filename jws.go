@@ -0,0 +1,153 @@
+package pcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWSAlgorithm returns the default JWS algorithm (RFC 7518) for a public or
+// private key: RS256 for RSA, ES256, ES384 or ES512 for ECDSA depending on
+// the curve and EdDSA for Ed25519.
+func JWSAlgorithm(key any) (string, error) {
+	switch k := publicKey(key).(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func publicKey(key any) any {
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return key
+}
+
+// jwsHash returns the hash of a JWS algorithm and whether the key type
+// matches the algorithm.
+func jwsHash(alg string, pub any) (crypto.Hash, error) {
+	var (
+		hash    crypto.Hash
+		keyType bool
+	)
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		_, keyType = pub.(*rsa.PublicKey)
+		hash = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	case "ES256", "ES384", "ES512":
+		var k *ecdsa.PublicKey
+		k, keyType = pub.(*ecdsa.PublicKey)
+		hash = map[string]crypto.Hash{"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512}[alg]
+		// the curve has to match the algorithm (RFC 7518 section 3.4)
+		if keyType {
+			expected, _ := JWSAlgorithm(k)
+			keyType = expected == alg
+		}
+	case "EdDSA":
+		_, keyType = pub.(ed25519.PublicKey)
+	default:
+		return 0, fmt.Errorf("unsupported JWS algorithm '%s'", alg)
+	}
+	if !keyType {
+		return 0, fmt.Errorf("JWS algorithm '%s' does not match key type %T", alg, pub)
+	}
+	return hash, nil
+}
+
+// jwsSign signs the JWS signing input with key. ECDSA signatures are
+// returned in the fixed size R || S format required by JWS.
+func jwsSign(key crypto.Signer, alg string, signingInput []byte) ([]byte, error) {
+	hash, err := jwsHash(alg, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if hash == 0 {
+		return key.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	var opts crypto.SignerOpts = hash
+	if alg[0] == 'P' {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	sig, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	var ecSig struct {
+		R, S *big.Int
+	}
+	_, err = asn1.Unmarshal(sig, &ecSig)
+	if err != nil {
+		return nil, err
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	ecSig.R.FillBytes(out[:size])
+	ecSig.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// jwsVerify verifies a JWS signature of signingInput.
+func jwsVerify(pub any, alg string, signingInput, sig []byte) error {
+	hash, err := jwsHash(alg, pub)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signingInput)
+		digest = h.Sum(nil)
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, signingInput, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", pub)
+}
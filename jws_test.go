@@ -0,0 +1,79 @@
+package pcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestJWSSignVerify(t *testing.T) {
+	rsaKey, _, err := GenerateKey(KeyOptions{Algorithm: x509.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, _, err := GenerateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key, _, err := GenerateKey(KeyOptions{Algorithm: x509.Ed25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key      any
+		alg      string
+		sigLen   int
+		defaults bool
+	}{
+		{rsaKey, "RS256", 256, true},
+		{rsaKey, "PS384", 256, false},
+		{p256Key, "ES256", 64, true},
+		{p384Key, "ES384", 96, true},
+		{ed25519Key, "EdDSA", 64, true},
+	}
+	input := []byte("header.payload")
+	for _, test := range tests {
+		t.Run(test.alg, func(t *testing.T) {
+			if test.defaults {
+				alg, err := JWSAlgorithm(test.key)
+				if err != nil || alg != test.alg {
+					t.Errorf("default algorithm: got=%s want=%s (%v)", alg, test.alg, err)
+				}
+			}
+			signer := test.key.(crypto.Signer)
+			sig, err := jwsSign(signer, test.alg, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sig) != test.sigLen {
+				t.Errorf("signature length: got=%d want=%d", len(sig), test.sigLen)
+			}
+			err = jwsVerify(signer.Public(), test.alg, input, sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = jwsVerify(signer.Public(), test.alg, []byte("header.other"), sig)
+			if err == nil {
+				t.Error("modified input verified")
+			}
+		})
+	}
+
+	// the curve has to match the algorithm
+	_, err = jwsSign(p384Key, "ES256", input)
+	if err == nil {
+		t.Error("expected error for ES256 with P-384 key")
+	}
+	_, err = jwsSign(rsaKey.(crypto.Signer), "EdDSA", input)
+	if err == nil {
+		t.Error("expected error for EdDSA with RSA key")
+	}
+}
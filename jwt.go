@@ -0,0 +1,160 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JWTHeader is the header of a JSON Web Token (RFC 7519).
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// JWT is a verified JSON Web Token.
+type JWT struct {
+	Header JWTHeader
+	// Claims are the claims of the token. Numbers are decoded as
+	// json.Number.
+	Claims map[string]any
+	// Key is the key which verified the signature.
+	Key *JWK
+}
+
+// SignJWT returns a JWT in compact serialization with claims signed with
+// key. If alg is empty the default algorithm of the key is used (see
+// JWSAlgorithm). kid is set as key ID in the header if it is not empty.
+func SignJWT(claims map[string]any, key crypto.PrivateKey, alg, kid string) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", fmt.Errorf("key of type %T can not sign", key)
+	}
+	if alg == "" {
+		var err error
+		alg, err = JWSAlgorithm(key)
+		if err != nil {
+			return "", err
+		}
+	}
+	header, err := json.Marshal(JWTHeader{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwkEncoding.EncodeToString(header) + "." + jwkEncoding.EncodeToString(payload)
+	sig, err := jwsSign(signer, alg, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwkEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJWT verifies the signature of a JWT in compact serialization with
+// the key of keys whose key ID matches the kid of the header. If the header
+// contains no kid, all keys are tried. If a key has an algorithm, it has to
+// match the algorithm of the token. The expiration time (exp) and not before
+// time (nbf) claims are checked against now.
+func VerifyJWT(token string, keys *JWKS, now time.Time) (*JWT, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid JWT: compact serialization with three parts expected")
+	}
+	jwt := &JWT{}
+	err := decodeJWTPart(parts[0], &jwt.Header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	err = decodeJWTPart(parts[1], &jwt.Claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	sig, err := jwkEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature: %w", err)
+	}
+
+	candidates := keys.Keys
+	if jwt.Header.Kid != "" {
+		key := keys.Key(jwt.Header.Kid)
+		if key == nil {
+			return nil, fmt.Errorf("no key with kid '%s'", jwt.Header.Kid)
+		}
+		candidates = []*JWK{key}
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	for _, candidate := range candidates {
+		if candidate.Alg != "" && candidate.Alg != jwt.Header.Alg {
+			err = fmt.Errorf("algorithm '%s' of token does not match algorithm '%s' of key", jwt.Header.Alg, candidate.Alg)
+			continue
+		}
+		var key any
+		key, err = candidate.Public().Key()
+		if err != nil {
+			continue
+		}
+		err = jwsVerify(key, jwt.Header.Alg, signingInput, sig)
+		if err == nil {
+			jwt.Key = candidate
+			break
+		}
+	}
+	if jwt.Key == nil {
+		if err == nil {
+			err = errors.New("no keys")
+		}
+		return nil, fmt.Errorf("invalid JWT signature: %w", err)
+	}
+
+	exp, ok, err := jwt.numericDate("exp")
+	if err != nil {
+		return nil, err
+	}
+	if ok && !now.Before(exp) {
+		return nil, fmt.Errorf("JWT expired at %s", exp.Format(time.RFC3339))
+	}
+	nbf, ok, err := jwt.numericDate("nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Before(nbf) {
+		return nil, fmt.Errorf("JWT is not valid before %s", nbf.Format(time.RFC3339))
+	}
+	return jwt, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := jwkEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numericDate returns the time of a NumericDate claim and whether the claim
+// is present.
+func (t *JWT) numericDate(name string) (time.Time, bool, error) {
+	value, ok := t.Claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, true, fmt.Errorf("invalid JWT claim %s: number expected", name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("invalid JWT claim %s: %w", name, err)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
@@ -0,0 +1,99 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWT(t *testing.T) {
+	now := time.Now()
+	claims := map[string]any{
+		"sub": "service",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for _, test := range []struct {
+		alg    x509.PublicKeyAlgorithm
+		jwtAlg string
+	}{
+		{x509.RSA, ""},
+		{x509.RSA, "PS256"},
+		{x509.ECDSA, ""},
+		{x509.Ed25519, ""},
+	} {
+		t.Run(test.alg.String()+test.jwtAlg, func(t *testing.T) {
+			key, pub, err := GenerateKey(KeyOptions{Algorithm: test.alg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwk, err := NewJWK(pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwk.Kid = "key-1"
+			jwk.Alg = test.jwtAlg
+			_, otherPub, _ := GenerateKey(KeyOptions{Algorithm: test.alg})
+			other, _ := NewJWK(otherPub)
+			other.Kid = "key-2"
+			keys := &JWKS{Keys: []*JWK{other, jwk}}
+
+			token, err := SignJWT(claims, key, test.jwtAlg, "key-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwt, err := VerifyJWT(token, keys, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jwt.Key != jwk || jwt.Claims["sub"] != "service" || jwt.Claims["exp"] != json.Number(strconv.FormatInt(claims["exp"].(int64), 10)) {
+				t.Errorf("unexpected JWT: %+v", jwt)
+			}
+
+			// without kid all keys are tried
+			token, err = SignJWT(claims, key, test.jwtAlg, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = VerifyJWT(token, keys, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = VerifyJWT(token, keys, now.Add(2*time.Hour))
+			if err == nil || !strings.Contains(err.Error(), "expired") {
+				t.Errorf("expected expired error, got %v", err)
+			}
+			_, err = VerifyJWT(token, &JWKS{Keys: []*JWK{other}}, now)
+			if err == nil {
+				t.Error("verified with other key")
+			}
+			parts := strings.Split(token, ".")
+			modified := parts[0] + "." + jwkEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+			_, err = VerifyJWT(modified, keys, now)
+			if err == nil {
+				t.Error("modified token verified")
+			}
+		})
+	}
+
+	key, pub, _ := GenerateKey(KeyOptions{Algorithm: x509.RSA})
+	jwk, _ := NewJWK(pub)
+	jwk.Alg = "RS256"
+	token, err := SignJWT(claims, key, "PS256", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyJWT(token, &JWKS{Keys: []*JWK{jwk}}, now)
+	if err == nil {
+		t.Error("verified token with algorithm which does not match key algorithm")
+	}
+	none := jwkEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + jwkEncoding.EncodeToString([]byte(`{}`)) + "."
+	_, err = VerifyJWT(none, &JWKS{Keys: []*JWK{jwk}}, now)
+	if err == nil {
+		t.Error("verified unsigned token")
+	}
+}
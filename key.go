@@ -91,3 +91,17 @@ func GenerateKey(opts KeyOptions) (crypto.PrivateKey, crypto.PublicKey, error) {
 
 	}
 }
+
+// KeyMatchesCertificate reports whether key is the private key which belongs
+// to the public key of cert.
+func KeyMatchesCertificate(key crypto.PrivateKey, cert *x509.Certificate) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false
+	}
+	return pub.Equal(cert.PublicKey)
+}
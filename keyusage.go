@@ -27,6 +27,11 @@ func KeyUsageToStringSlice(bitmask x509.KeyUsage) []string {
 
 // ExtKeyUsageToString returns a string representation of a []x509.ExtKeyUsage slice
 func ExtKeyUsageToString(ku []x509.ExtKeyUsage) string {
+	return strings.Join(ExtKeyUsageToStringSlice(ku), ",")
+}
+
+// ExtKeyUsageToStringSlice returns a sorted slice with string representations of the []x509.ExtKeyUsage slice
+func ExtKeyUsageToStringSlice(ku []x509.ExtKeyUsage) []string {
 	usages := []string{}
 	for str, usage := range ExtKeyUsages {
 		for _, existingUsage := range ku {
@@ -37,5 +42,5 @@ func ExtKeyUsageToString(ku []x509.ExtKeyUsage) string {
 		}
 	}
 	sort.Strings(usages)
-	return strings.Join(usages, ",")
+	return usages
 }
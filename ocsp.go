@@ -0,0 +1,188 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// OCSPStatus is the status of a certificate in an OCSP response.
+type OCSPStatus int
+
+const (
+	OCSPGood OCSPStatus = iota
+	OCSPRevoked
+	OCSPUnknown
+)
+
+func (s OCSPStatus) String() string {
+	switch s {
+	case OCSPGood:
+		return "good"
+	case OCSPRevoked:
+		return "revoked"
+	case OCSPUnknown:
+		return "unknown"
+	default:
+		return fmt.Sprintf("invalid (%d)", int(s))
+	}
+}
+
+// OCSPResponse is a decoded OCSP response (RFC 6960) for a single
+// certificate.
+type OCSPResponse struct {
+	Status       OCSPStatus
+	SerialNumber *big.Int
+	ProducedAt   time.Time
+	ThisUpdate   time.Time
+	NextUpdate   time.Time
+	RevokedAt    time.Time
+
+	// Certificate is the delegated responder certificate if it is part
+	// of the response.
+	Certificate *x509.Certificate
+
+	SignatureAlgorithm x509.SignatureAlgorithm
+	Signature          []byte
+	// TBSResponseData is the raw signed part of the response.
+	TBSResponseData []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw         asn1.RawContent
+	Version     int           `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue // CHOICE byName [1] or byKey [2]
+	ProducedAt  time.Time     `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// ParseOCSPResponse decodes a DER encoded OCSP response as it is stapled to
+// a TLS handshake. Only the first single response is returned. The signature
+// is not verified, use CheckSignatureFrom for that.
+func ParseOCSPResponse(der []byte) (*OCSPResponse, error) {
+	resp := &ocspResponse{}
+	_, err := asn1.Unmarshal(der, resp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("OCSP response status is not successful: %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type %s", resp.Response.ResponseType)
+	}
+
+	basic := &ocspBasicResponse{}
+	_, err = asn1.Unmarshal(resp.Response.Response, basic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP basic response: %w", err)
+	}
+
+	data := &ocspResponseData{}
+	_, err = asn1.Unmarshal(basic.TBSResponseData.FullBytes, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response data: %w", err)
+	}
+	if len(data.Responses) == 0 {
+		return nil, fmt.Errorf("OCSP response contains no responses")
+	}
+
+	single := data.Responses[0]
+	r := &OCSPResponse{
+		SerialNumber:       single.CertID.SerialNumber,
+		ProducedAt:         data.ProducedAt,
+		ThisUpdate:         single.ThisUpdate,
+		NextUpdate:         single.NextUpdate,
+		SignatureAlgorithm: signatureAlgorithmFromOID(basic.SignatureAlgorithm.Algorithm),
+		Signature:          basic.Signature.RightAlign(),
+		TBSResponseData:    basic.TBSResponseData.FullBytes,
+	}
+	switch {
+	case bool(single.Good):
+		r.Status = OCSPGood
+	case bool(single.Unknown):
+		r.Status = OCSPUnknown
+	case !single.Revoked.RevocationTime.IsZero():
+		r.Status = OCSPRevoked
+		r.RevokedAt = single.Revoked.RevocationTime
+	default:
+		return nil, fmt.Errorf("OCSP response has invalid certificate status")
+	}
+
+	if len(basic.Certificates) > 0 {
+		r.Certificate, err = x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCSP responder certificate: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// CheckSignatureFrom verifies that the response is signed by issuer or by a
+// delegated responder certificate issued by issuer.
+func (r *OCSPResponse) CheckSignatureFrom(issuer *x509.Certificate) error {
+	signer := issuer
+	if r.Certificate != nil && !r.Certificate.Equal(issuer) {
+		err := r.Certificate.CheckSignatureFrom(issuer)
+		if err != nil {
+			return fmt.Errorf("responder certificate not issued by issuer: %w", err)
+		}
+		hasOCSPSigning := false
+		for _, eku := range r.Certificate.ExtKeyUsage {
+			if eku == x509.ExtKeyUsageOCSPSigning {
+				hasOCSPSigning = true
+			}
+		}
+		if !hasOCSPSigning {
+			return errors.New("responder certificate has no OCSPSigning extended key usage")
+		}
+		signer = r.Certificate
+	}
+	return signer.CheckSignature(r.SignatureAlgorithm, r.TBSResponseData, r.Signature)
+}
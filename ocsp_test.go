@@ -0,0 +1,231 @@
+package pcert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOCSPResponse(t *testing.T) {
+	for _, alg := range PublicKeyAlgorithms {
+		t.Run(alg.String(), func(t *testing.T) {
+			caTmpl := NewCACertificate("CA")
+			caDER, caKey, err := CreateCertificateWithKeyOptions(caTmpl, KeyOptions{Algorithm: alg}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, err := x509.ParseCertificate(caDER)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leafDER, _, err := CreateCertificate(NewServerCertificate("leaf"), ca, caKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(leafDER)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			thisUpdate := time.Now().UTC().Truncate(time.Second)
+			der, err := createOCSPResponse(&OCSPResponse{
+				Status:     OCSPRevoked,
+				ThisUpdate: thisUpdate,
+				NextUpdate: thisUpdate.Add(time.Hour),
+				RevokedAt:  thisUpdate.Add(-time.Hour),
+			}, leaf, ca, nil, caKey.(crypto.Signer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := ParseOCSPResponse(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != OCSPRevoked {
+				t.Errorf("wrong status: got=%s want=%s", resp.Status, OCSPRevoked)
+			}
+			if resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+				t.Errorf("wrong serial number: got=%s want=%s", resp.SerialNumber, leaf.SerialNumber)
+			}
+			if !resp.ThisUpdate.Equal(thisUpdate) || !resp.NextUpdate.Equal(thisUpdate.Add(time.Hour)) {
+				t.Errorf("wrong update times: this=%s next=%s", resp.ThisUpdate, resp.NextUpdate)
+			}
+			if !resp.RevokedAt.Equal(thisUpdate.Add(-time.Hour)) {
+				t.Errorf("wrong revocation time: %s", resp.RevokedAt)
+			}
+			err = resp.CheckSignatureFrom(ca)
+			if err != nil {
+				t.Errorf("signature invalid: %s", err)
+			}
+		})
+	}
+}
+
+func TestOCSPResponse_delegated(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCACertificate("CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _, err := createAndParse("leaf", ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderTmpl := NewCertificate(&CertificateOptions{
+		Certificate: x509.Certificate{
+			Subject:     pkix.Name{CommonName: "responder"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		},
+	})
+	responderDER, responderKey, err := CreateCertificate(responderTmpl, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := x509.ParseCertificate(responderDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := createOCSPResponse(&OCSPResponse{
+		Status:     OCSPGood,
+		ThisUpdate: time.Now(),
+	}, leaf, ca, responder, responderKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ParseOCSPResponse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != OCSPGood {
+		t.Errorf("wrong status: got=%s want=%s", resp.Status, OCSPGood)
+	}
+	if resp.Certificate == nil || !resp.Certificate.Equal(responder) {
+		t.Fatal("responder certificate missing")
+	}
+	err = resp.CheckSignatureFrom(ca)
+	if err != nil {
+		t.Errorf("signature invalid: %s", err)
+	}
+
+	other, _, err := createAndParse("other", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resp.CheckSignatureFrom(other)
+	if err == nil {
+		t.Error("signature check with wrong issuer did not fail")
+	}
+}
+
+// createOCSPResponse creates a DER encoded OCSP response for cert based on
+// the status, times and the serial number in template. The response is signed
+// with signKey which belongs either to issuer or to responder. responder is a
+// delegated OCSP signing certificate and can be nil.
+func createOCSPResponse(template *OCSPResponse, cert, issuer, responder *x509.Certificate, signKey crypto.Signer) ([]byte, error) {
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{}
+	_, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+
+	serial := template.SerialNumber
+	if serial == nil {
+		serial = cert.SerialNumber
+	}
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+			IssuerNameHash: nameHash[:],
+			IssuerKeyHash:  keyHash[:],
+			SerialNumber:   serial,
+		},
+		ThisUpdate: template.ThisUpdate.UTC(),
+		NextUpdate: template.NextUpdate.UTC(),
+	}
+	switch template.Status {
+	case OCSPGood:
+		single.Good = true
+	case OCSPUnknown:
+		single.Unknown = true
+	case OCSPRevoked:
+		single.Revoked.RevocationTime = template.RevokedAt.UTC()
+	default:
+		return nil, fmt.Errorf("invalid OCSP status %d", template.Status)
+	}
+
+	signer := issuer
+	if responder != nil {
+		signer = responder
+	}
+	producedAt := template.ProducedAt
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        1,
+			IsCompound: true,
+			Bytes:      signer.RawSubject,
+		},
+		ProducedAt: producedAt.UTC().Truncate(time.Second),
+		Responses:  []ocspSingleResponse{single},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sigAlg, hash, err := signatureAlgorithmForKey(signKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	digest := tbs
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
+	}
+	signature, err := signKey.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: sigAlg,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	}
+	if responder != nil {
+		basic.Certificates = []asn1.RawValue{{FullBytes: responder.Raw}}
+	}
+	basicDER, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspResponse{
+		Status: 0,
+		Response: ocspResponseBytes{
+			ResponseType: oidOCSPBasic,
+			Response:     basicDER,
+		},
+	})
+}
@@ -0,0 +1,171 @@
+package pcert
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Extensions maps the names of well-known certificate extensions to their
+// object identifier.
+var Extensions = map[string]asn1.ObjectIdentifier{
+	"SubjectKeyId":          {2, 5, 29, 14},
+	"KeyUsage":              {2, 5, 29, 15},
+	"SubjectAltName":        {2, 5, 29, 17},
+	"BasicConstraints":      {2, 5, 29, 19},
+	"CRLNumber":             {2, 5, 29, 20},
+	"CRLReason":             {2, 5, 29, 21},
+	"NameConstraints":       {2, 5, 29, 30},
+	"CRLDistributionPoints": {2, 5, 29, 31},
+	"CertificatePolicies":   {2, 5, 29, 32},
+	"AuthorityKeyId":        {2, 5, 29, 35},
+	"ExtendedKeyUsage":      {2, 5, 29, 37},
+	"AuthorityInfoAccess":   {1, 3, 6, 1, 5, 5, 7, 1, 1},
+	"OCSPNoCheck":           {1, 3, 6, 1, 5, 5, 7, 48, 1, 5},
+	"SCTList":               {1, 3, 6, 1, 4, 1, 11129, 2, 4, 2},
+	"CTPoison":              {1, 3, 6, 1, 4, 1, 11129, 2, 4, 3},
+	"ACMEIdentifier":        {1, 3, 6, 1, 5, 5, 7, 1, 31},
+}
+
+// ExtensionName returns the name of a well-known extension or an empty
+// string if the extension is unknown.
+func ExtensionName(oid asn1.ObjectIdentifier) string {
+	for name, extOID := range Extensions {
+		if extOID.Equal(oid) {
+			return name
+		}
+	}
+	return ""
+}
+
+// extKeyUsageOIDs maps the names of ExtKeyUsages to their object identifier.
+var extKeyUsageOIDs = map[string]asn1.ObjectIdentifier{
+	"Any":                            {2, 5, 29, 37, 0},
+	"ServerAuth":                     {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	"ClientAuth":                     {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	"CodeSigning":                    {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	"EmailProtection":                {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	"IPSECEndSystem":                 {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	"IPSECTunnel":                    {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	"IPSECUser":                      {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	"TimeStamping":                   {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	"OCSPSigning":                    {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	"MicrosoftServerGatedCrypto":     {1, 3, 6, 1, 4, 1, 311, 10, 3, 3},
+	"NetscapeServerGatedCrypto":      {2, 16, 840, 1, 113730, 4, 1},
+	"MicrosoftCommercialCodeSigning": {1, 3, 6, 1, 4, 1, 311, 2, 1, 22},
+	"MicrosoftKernelCodeSigning":     {1, 3, 6, 1, 4, 1, 311, 61, 1, 1},
+}
+
+// oidNames contains the names of other object identifiers which commonly
+// appear in certificates, CSRs, CRLs, keys and PKCS#7 and PKCS#12 files.
+var oidNames = map[string]string{
+	// attribute types of distinguished names
+	"2.5.4.3":                    "commonName",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "countryName",
+	"2.5.4.7":                    "localityName",
+	"2.5.4.8":                    "stateOrProvinceName",
+	"2.5.4.9":                    "streetAddress",
+	"2.5.4.10":                   "organizationName",
+	"2.5.4.11":                   "organizationalUnitName",
+	"2.5.4.17":                   "postalCode",
+	"0.9.2342.19200300.100.1.25": "domainComponent",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+
+	// public key algorithms and curves
+	"1.2.840.113549.1.1.1":  "rsaEncryption",
+	"1.2.840.113549.1.1.10": "rsassaPss",
+	"1.2.840.10045.2.1":     "id-ecPublicKey",
+	"1.2.840.10045.3.1.7":   "prime256v1",
+	"1.3.132.0.34":          "secp384r1",
+	"1.3.132.0.35":          "secp521r1",
+	"1.3.101.110":           "X25519",
+	"1.3.101.112":           "Ed25519",
+
+	// signature and hash algorithms
+	"1.2.840.113549.1.1.5":   "sha1WithRSAEncryption",
+	"1.2.840.113549.1.1.11":  "sha256WithRSAEncryption",
+	"1.2.840.113549.1.1.12":  "sha384WithRSAEncryption",
+	"1.2.840.113549.1.1.13":  "sha512WithRSAEncryption",
+	"1.2.840.113549.1.1.8":   "mgf1",
+	"1.2.840.10045.4.1":      "ecdsa-with-SHA1",
+	"1.2.840.10045.4.3.2":    "ecdsa-with-SHA256",
+	"1.2.840.10045.4.3.3":    "ecdsa-with-SHA384",
+	"1.2.840.10045.4.3.4":    "ecdsa-with-SHA512",
+	"1.3.14.3.2.26":          "sha1",
+	"2.16.840.1.101.3.4.2.1": "sha256",
+	"2.16.840.1.101.3.4.2.2": "sha384",
+	"2.16.840.1.101.3.4.2.3": "sha512",
+
+	// authority information access and policies
+	"1.3.6.1.5.5.7.48.1":   "ocsp",
+	"1.3.6.1.5.5.7.48.1.1": "basicOCSPResponse",
+	"1.3.6.1.5.5.7.48.2":   "caIssuers",
+	"1.3.6.1.5.5.7.2.1":    "cps",
+	"1.3.6.1.5.5.7.2.2":    "unotice",
+	"2.5.29.32.0":          "anyPolicy",
+	"2.23.140.1.1":         "ev-guidelines",
+	"2.23.140.1.2.1":       "domain-validated",
+	"2.23.140.1.2.2":       "organization-validated",
+	"2.23.140.1.2.3":       "individual-validated",
+
+	// PKCS#7, PKCS#9 and PKCS#12
+	"1.2.840.113549.1.7.1":       "data",
+	"1.2.840.113549.1.7.2":       "signedData",
+	"1.2.840.113549.1.7.3":       "envelopedData",
+	"1.2.840.113549.1.7.6":       "encryptedData",
+	"1.2.840.113549.1.9.3":       "contentType",
+	"1.2.840.113549.1.9.4":       "messageDigest",
+	"1.2.840.113549.1.9.5":       "signingTime",
+	"1.2.840.113549.1.9.7":       "challengePassword",
+	"1.2.840.113549.1.9.14":      "extensionRequest",
+	"1.2.840.113549.1.9.20":      "friendlyName",
+	"1.2.840.113549.1.9.21":      "localKeyID",
+	"1.2.840.113549.1.9.22.1":    "x509Certificate",
+	"1.2.840.113549.1.12.1.3":    "pbeWithSHA1And3-KeyTripleDES-CBC",
+	"1.2.840.113549.1.12.1.6":    "pbeWithSHA1And40BitRC2-CBC",
+	"1.2.840.113549.1.12.10.1.1": "keyBag",
+	"1.2.840.113549.1.12.10.1.2": "pkcs8ShroudedKeyBag",
+	"1.2.840.113549.1.12.10.1.3": "certBag",
+	"1.2.840.113549.1.5.12":      "PBKDF2",
+	"1.2.840.113549.1.5.13":      "PBES2",
+	"1.2.840.113549.2.7":         "hmacWithSHA1",
+	"1.2.840.113549.2.9":         "hmacWithSHA256",
+	"1.2.840.113549.3.7":         "des-ede3-cbc",
+	"2.16.840.1.101.3.4.1.2":     "aes-128-cbc",
+	"2.16.840.1.101.3.4.1.22":    "aes-192-cbc",
+	"2.16.840.1.101.3.4.1.42":    "aes-256-cbc",
+}
+
+// OIDName returns the name of an object identifier or an empty string if
+// it is unknown. Extensions and extended key usages have the names used in
+// Extensions and ExtKeyUsages.
+func OIDName(oid asn1.ObjectIdentifier) string {
+	if name := ExtensionName(oid); name != "" {
+		return name
+	}
+	for name, ekuOID := range extKeyUsageOIDs {
+		if ekuOID.Equal(oid) {
+			return name
+		}
+	}
+	return oidNames[oid.String()]
+}
+
+// ParseOID parses an object identifier in dotted notation (e.g. 2.5.29.15).
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", s)
+	}
+	oid := asn1.ObjectIdentifier{}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID '%s'", s)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
@@ -0,0 +1,76 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestOIDName(t *testing.T) {
+	tests := []struct {
+		oid      asn1.ObjectIdentifier
+		expected string
+	}{
+		{asn1.ObjectIdentifier{2, 5, 29, 14}, "SubjectKeyId"},
+		{asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}, "ServerAuth"},
+		{asn1.ObjectIdentifier{2, 5, 4, 3}, "commonName"},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, "ecdsa-with-SHA256"},
+		{asn1.ObjectIdentifier{1, 2, 3, 4}, ""},
+	}
+	for _, test := range tests {
+		got := OIDName(test.oid)
+		if got != test.expected {
+			t.Errorf("%s: got=%s want=%s", test.oid, got, test.expected)
+		}
+	}
+}
+
+func TestExtKeyUsageOIDs(t *testing.T) {
+	// create a certificate with every extended key usage known to
+	// crypto/x509 and compare the encoded object identifiers
+	names := []string{}
+	template := NewCertificate(nil)
+	for name, usage := range ExtKeyUsages {
+		names = append(names, name)
+		template.ExtKeyUsage = append(template.ExtKeyUsage, usage)
+	}
+	certDER, _, err := CreateCertificate(template, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var oids []asn1.ObjectIdentifier
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(Extensions["ExtendedKeyUsage"]) {
+			_, err = asn1.Unmarshal(ext.Value, &oids)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(oids) != len(names) {
+		t.Fatalf("got %d extended key usages, want %d", len(oids), len(names))
+	}
+	for i, name := range names {
+		if !extKeyUsageOIDs[name].Equal(oids[i]) {
+			t.Errorf("%s: got=%s want=%s", name, extKeyUsageOIDs[name], oids[i])
+		}
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	oid, err := ParseOID("2.5.29.32.0")
+	if err != nil || !oid.Equal(asn1.ObjectIdentifier{2, 5, 29, 32, 0}) {
+		t.Errorf("unexpected result: %s %v", oid, err)
+	}
+	for _, invalid := range []string{"", "1", "1.x", "1.-2"} {
+		_, err := ParseOID(invalid)
+		if err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
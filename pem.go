@@ -1,22 +1,31 @@
 package pcert
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"os"
+	"unicode"
 )
 
 const (
 	certificateBlock        = "CERTIFICATE"
 	certificateRequestBlock = "CERTIFICATE REQUEST"
+	// newCertificateRequestBlock is used by some older tools (e.g. Windows).
+	newCertificateRequestBlock = "NEW CERTIFICATE REQUEST"
+	pkcs7Block                 = "PKCS7"
 
 	privateKeyBlock    = "PRIVATE KEY"
 	ecPrivateKeyBlock  = "EC PRIVATE KEY"
 	rsaPrivateKeyBlock = "RSA PRIVATE KEY"
 )
 
-// Load reads a *x509.Certificate from a PEM encoded file.
+// Load reads a *x509.Certificate from a file. See ParseAll for the
+// supported formats.
 func Load(f string) (*x509.Certificate, error) {
 	pem, err := os.ReadFile(f)
 	if err != nil {
@@ -26,7 +35,7 @@ func Load(f string) (*x509.Certificate, error) {
 	return Parse(pem)
 }
 
-// LoadKey reads a *crypto.PrivateKey from a PEM encoded file.
+// LoadKey reads a *crypto.PrivateKey from a PEM, DER or base64 encoded file.
 func LoadKey(f string) (any, error) {
 	pem, err := os.ReadFile(f)
 	if err != nil {
@@ -36,7 +45,8 @@ func LoadKey(f string) (any, error) {
 	return ParseKey(pem)
 }
 
-// LoadCSR reads a *x509.CertificateRequest from a PEM encoded file.
+// LoadCSR reads a *x509.CertificateRequest from a PEM, DER or base64 encoded
+// file.
 func LoadCSR(f string) (*x509.CertificateRequest, error) {
 	pem, err := os.ReadFile(f)
 	if err != nil {
@@ -46,27 +56,35 @@ func LoadCSR(f string) (*x509.CertificateRequest, error) {
 	return ParseCSR(pem)
 }
 
-// Parse returns a *x509.Certificate from PEM encoded data.
-func Parse(pemData []byte) (*x509.Certificate, error) {
-	var block *pem.Block
-	for {
-		if len(pemData) == 0 {
-			return nil, fmt.Errorf("no CERTIFICATE found in PEM data")
-		}
-		block, pemData = pem.Decode(pemData)
-		if block == nil {
-			return nil, fmt.Errorf("invalid data is not PEM encoded")
-		}
-
-		if block.Type == "CERTIFICATE" {
-			return x509.ParseCertificate(block.Bytes)
-		}
+// Parse returns the first *x509.Certificate from data. The format of data is
+// detected automatically, see ParseAll.
+func Parse(data []byte) (*x509.Certificate, error) {
+	certs, err := ParseAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no CERTIFICATE found in data")
 	}
+	return certs[0], nil
 }
 
-// ParseAll returns a list of x509.Certificates from a list of concatenated PEM
-// encoded certificates.
+// ParseAll returns a list of x509.Certificates from data. The format of data
+// is detected automatically. Supported are concatenated PEM encoded
+// certificates (CERTIFICATE and PKCS7 blocks), DER encoded certificates,
+// PKCS#7 SignedData bundles (.p7b) and base64 encoded DER without PEM armor.
 func ParseAll(data []byte) ([]*x509.Certificate, error) {
+	if !isPEM(data) {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil, nil
+		}
+		der, err := decodeBinary(data)
+		if err != nil {
+			return nil, err
+		}
+		return parseCertificatesDER(der)
+	}
+
 	var (
 		certs []*x509.Certificate
 		block *pem.Block
@@ -76,29 +94,57 @@ func ParseAll(data []byte) ([]*x509.Certificate, error) {
 		if block == nil {
 			break
 		}
-		if block.Type != "CERTIFICATE" {
-			continue
+		switch block.Type {
+		case certificateBlock:
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		case pkcs7Block:
+			pkcs7Certs, err := ParsePKCS7Certificates(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, pkcs7Certs...)
 		}
+	}
+	return certs, nil
+}
+
+// parseCertificatesDER parses one or more concatenated DER encoded
+// certificates or a PKCS#7 SignedData structure.
+func parseCertificatesDER(der []byte) ([]*x509.Certificate, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err == nil {
+		return certs, nil
+	}
+	pkcs7Certs, pkcs7Err := ParsePKCS7Certificates(der)
+	if pkcs7Err == nil {
+		return pkcs7Certs, nil
+	}
+	return nil, err
+}
 
-		cert, err := x509.ParseCertificate(block.Bytes)
+// ParseKey returns a *crypto.PrivateKey from PEM, DER or base64 encoded data.
+// PKCS#8, PKCS#1 (RSA) and SEC 1 (EC) keys are supported.
+func ParseKey(data []byte) (key any, err error) {
+	if !isPEM(data) {
+		der, err := decodeBinary(data)
 		if err != nil {
 			return nil, err
 		}
-		certs = append(certs, cert)
+		return parseKeyDER(der)
 	}
-	return certs, nil
-}
 
-// ParseKey returns a *crypto.PrivateKey from PEM encoded data.
-func ParseKey(pemData []byte) (key any, err error) {
 	var block *pem.Block
 	for {
-		if len(pemData) == 0 {
+		if len(data) == 0 {
 			return nil, fmt.Errorf("no private key found in data")
 		}
-		block, pemData = pem.Decode(pemData)
+		block, data = pem.Decode(data)
 		if block == nil {
-			return nil, fmt.Errorf("data is not PEM encoded")
+			return nil, fmt.Errorf("no private key found in data")
 		}
 		switch block.Type {
 		case privateKeyBlock:
@@ -112,24 +158,66 @@ func ParseKey(pemData []byte) (key any, err error) {
 	}
 }
 
-// ParseCSR returns a *x509.CertificateRequest from PEM encoded data.
-func ParseCSR(pem []byte) (*x509.CertificateRequest, error) {
-	block, err := parsePEM(pem)
-	if err != nil {
-		return nil, err
+func parseKeyDER(der []byte) (any, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("data is not a PKCS#8, PKCS#1 or SEC 1 private key")
+}
+
+// ParseCSR returns a *x509.CertificateRequest from PEM, DER or base64 encoded
+// data.
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if !isPEM(data) {
+		der, err := decodeBinary(data)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificateRequest(der)
 	}
 
-	return x509.ParseCertificateRequest(block.Bytes)
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no CERTIFICATE REQUEST found in PEM data")
+		}
+		if block.Type == certificateRequestBlock || block.Type == newCertificateRequestBlock {
+			return x509.ParseCertificateRequest(block.Bytes)
+		}
+	}
 }
 
-func parsePEM(bytes []byte) (*pem.Block, error) {
-	block, _ := pem.Decode(bytes)
+// isPEM reports whether data contains a PEM block.
+func isPEM(data []byte) bool {
+	return bytes.Contains(data, []byte("-----BEGIN "))
+}
 
-	if block == nil {
-		return nil, fmt.Errorf("no pem data found")
+// decodeBinary returns the DER data of binary input. data is either DER
+// itself or base64 encoded DER without PEM armor.
+func decodeBinary(data []byte) ([]byte, error) {
+	if len(data) > 0 && data[0] == 0x30 {
+		return data, nil
 	}
 
-	return block, nil
+	stripped := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, data)
+	der := make([]byte, base64.StdEncoding.DecodedLen(len(stripped)))
+	n, err := base64.StdEncoding.Decode(der, stripped)
+	if err != nil || n == 0 || der[0] != 0x30 {
+		return nil, fmt.Errorf("unknown format: data is neither PEM, DER nor base64 encoded DER")
+	}
+	return der[:n], nil
 }
 
 // Encode encodes DER encoded certificate into PEM encoding
@@ -146,11 +234,31 @@ func EncodeKey(priv any) ([]byte, error) {
 	return encode(privateKeyBlock, pkcs8der), nil
 }
 
+// EncodeKeyPKCS1 encodes a RSA key into PKCS#1 PEM encoding (RSA PRIVATE KEY).
+func EncodeKeyPKCS1(priv *rsa.PrivateKey) []byte {
+	return encode(rsaPrivateKeyBlock, x509.MarshalPKCS1PrivateKey(priv))
+}
+
+// EncodeKeySEC1 encodes an ECDSA key into SEC 1 PEM encoding (EC PRIVATE
+// KEY).
+func EncodeKeySEC1(priv *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return encode(ecPrivateKeyBlock, der), nil
+}
+
 // EncodeCSR encodes DER encoded CSR into PEM encoding
 func EncodeCSR(derBytes []byte) []byte {
 	return encode(certificateRequestBlock, derBytes)
 }
 
+// EncodePKCS7 encodes a DER encoded PKCS#7 / CMS structure into PEM encoding
+func EncodePKCS7(derBytes []byte) []byte {
+	return encode(pkcs7Block, derBytes)
+}
+
 func encode(blockType string, bytes []byte) []byte {
 	block := &pem.Block{
 		Type:  blockType,
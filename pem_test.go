@@ -0,0 +1,78 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+func TestParseAll_formats(t *testing.T) {
+	root, rootKey, err := createAndParse("Root", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _, err := createAndParse("Leaf", root, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := EncodePKCS7Certificates([]*x509.Certificate{leaf, root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der := append(append([]byte{}, leaf.Raw...), root.Raw...)
+	b64 := base64.StdEncoding.EncodeToString(der)
+	tests := map[string][]byte{
+		"pem":            append(Encode(leaf.Raw), Encode(root.Raw)...),
+		"der":            der,
+		"base64":         []byte(b64 + "\n"),
+		"base64 wrapped": []byte(b64[:64] + "\r\n" + b64[64:]),
+		"pkcs7 der":      p7,
+		"pkcs7 pem":      pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7}),
+		"pkcs7 base64":   []byte(base64.StdEncoding.EncodeToString(p7)),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			certs, err := ParseAll(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) == 0 || !certs[0].Equal(leaf) {
+				t.Fatalf("leaf not parsed: %d certificates", len(certs))
+			}
+			cert, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert.Equal(leaf) {
+				t.Fatal("Parse did not return first certificate")
+			}
+		})
+	}
+
+	_, err = ParseAll([]byte("not a certificate"))
+	if err == nil {
+		t.Fatal("invalid data did not fail")
+	}
+}
+
+func TestParseKey_formats(t *testing.T) {
+	_, key, err := createAndParse("Key", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"der":    der,
+		"base64": []byte(base64.StdEncoding.EncodeToString(der)),
+	} {
+		_, err := ParseKey(data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,673 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+var (
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPKCS7EncryptedData  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+	oidPBES2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC       = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidPBEWithSHA3DES   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHARC240  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBEWithSHARC2128 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+)
+
+// pkcs12Iterations is the iteration count used for the key derivation when
+// creating PKCS#12 files. It is the same as the default of OpenSSL.
+const pkcs12Iterations = 2048
+
+// pkcs12MaxIterations is the maximum iteration count of the key derivation
+// which is accepted when reading PKCS#12 files. Larger counts are rejected
+// since a file could otherwise block the key derivation for a long time.
+const pkcs12MaxIterations = 10_000_000
+
+func checkPKCS12Iterations(iterations int) error {
+	if iterations > pkcs12MaxIterations {
+		return fmt.Errorf("PKCS#12 iteration count %d exceeds the maximum of %d", iterations, pkcs12MaxIterations)
+	}
+	return nil
+}
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// DecodePKCS12 returns the private key and the certificates of a DER encoded
+// PKCS#12 file (.p12 or .pfx). The certificate which belongs to the key is
+// returned first. Encryption with PBES2 (AES or 3DES) and the legacy
+// pbeWithSHAAnd3-KeyTripleDES-CBC is supported. RC2 encryption is not
+// supported.
+func DecodePKCS12(data []byte, password string) (key any, certs []*x509.Certificate, err error) {
+	pfx := &pfxPDU{}
+	rest, err := asn1.Unmarshal(data, pfx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PKCS#12 data: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("trailing data after PKCS#12 data")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 content type %s", pfx.AuthSafe.ContentType)
+	}
+
+	var authSafe []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PKCS#12 authenticated safe: %w", err)
+	}
+
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		err = verifyPKCS12MAC(&pfx.MacData, authSafe, password)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var contents []contentInfo
+	_, err = asn1.Unmarshal(authSafe, &contents)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PKCS#12 authenticated safe: %w", err)
+	}
+
+	for _, ci := range contents {
+		var safeContents []byte
+		switch {
+		case ci.ContentType.Equal(oidPKCS7Data):
+			_, err = asn1.Unmarshal(ci.Content.Bytes, &safeContents)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid PKCS#12 safe contents: %w", err)
+			}
+		case ci.ContentType.Equal(oidPKCS7EncryptedData):
+			ed := &encryptedData{}
+			_, err = asn1.Unmarshal(ci.Content.Bytes, ed)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid PKCS#12 encrypted data: %w", err)
+			}
+			safeContents, err = pbeDecrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, ed.EncryptedContentInfo.EncryptedContent, password)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported PKCS#12 content type %s", ci.ContentType)
+		}
+
+		var bags []safeBag
+		_, err = asn1.Unmarshal(safeContents, &bags)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid PKCS#12 safe contents: %w", err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				cb := &certBag{}
+				_, err = asn1.Unmarshal(bag.Value.Bytes, cb)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid PKCS#12 certificate bag: %w", err)
+				}
+				if !cb.ID.Equal(oidCertTypeX509) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, err
+				}
+				certs = append(certs, cert)
+			case bag.ID.Equal(oidKeyBag):
+				key, err = x509.ParsePKCS8PrivateKey(bag.Value.Bytes)
+				if err != nil {
+					return nil, nil, err
+				}
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				epki := &encryptedPrivateKeyInfo{}
+				_, err = asn1.Unmarshal(bag.Value.Bytes, epki)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid PKCS#12 key bag: %w", err)
+				}
+				keyDER, err := pbeDecrypt(epki.Algorithm, epki.EncryptedData, password)
+				if err != nil {
+					return nil, nil, err
+				}
+				key, err = x509.ParsePKCS8PrivateKey(keyDER)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	// move the certificate of the key to the front
+	if key != nil {
+		for i, cert := range certs {
+			if KeyMatchesCertificate(key, cert) {
+				certs[0], certs[i] = certs[i], certs[0]
+				break
+			}
+		}
+	}
+	return key, certs, nil
+}
+
+// EncodePKCS12 returns a DER encoded PKCS#12 file which contains key and
+// certs. The first certificate should be the one which belongs to the key. The
+// key and the certificates are encrypted with AES-256-CBC (PBES2) and the
+// integrity is protected by a HMAC-SHA256. This corresponds to the defaults of
+// OpenSSL 3. key can be nil.
+func EncodePKCS12(key any, certs []*x509.Certificate, password string) ([]byte, error) {
+	var localKeyID []byte
+	if key != nil && len(certs) > 0 {
+		sum := sha1.Sum(certs[0].Raw)
+		localKeyID = sum[:]
+	}
+
+	var certBags []safeBag
+	for i, cert := range certs {
+		cb, err := asn1.Marshal(certBag{
+			ID:   oidCertTypeX509,
+			Data: cert.Raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{
+			ID:    oidCertBag,
+			Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cb},
+		}
+		if i == 0 && localKeyID != nil {
+			bag.Attributes, err = localKeyIDAttributes(localKeyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		certBags = append(certBags, bag)
+	}
+
+	var contents []contentInfo
+	if len(certBags) > 0 {
+		safeContents, err := asn1.Marshal(certBags)
+		if err != nil {
+			return nil, err
+		}
+		alg, encrypted, err := pbes2Encrypt(safeContents, password)
+		if err != nil {
+			return nil, err
+		}
+		ed, err := asn1.Marshal(encryptedData{
+			Version: 0,
+			EncryptedContentInfo: encryptedContentInfo{
+				ContentType:                oidPKCS7Data,
+				ContentEncryptionAlgorithm: alg,
+				EncryptedContent:           encrypted,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, contentInfo{
+			ContentType: oidPKCS7EncryptedData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ed},
+		})
+	}
+
+	if key != nil {
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		alg, encrypted, err := pbes2Encrypt(keyDER, password)
+		if err != nil {
+			return nil, err
+		}
+		epki, err := asn1.Marshal(encryptedPrivateKeyInfo{
+			Algorithm:     alg,
+			EncryptedData: encrypted,
+		})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{
+			ID:    oidPKCS8ShroudedKeyBag,
+			Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: epki},
+		}
+		if localKeyID != nil {
+			bag.Attributes, err = localKeyIDAttributes(localKeyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		data, err := marshalDataContent([]safeBag{bag})
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, data)
+	}
+
+	authSafe, err := asn1.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 8)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(sha256.New, salt, bmpString(password), pkcs12Iterations, 3, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+
+	return asn1.Marshal(pfxPDU{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidPKCS7Data,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: authSafeContent},
+		},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+func marshalDataContent(bags []safeBag) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	octets, err := asn1.Marshal(safeContents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{
+		ContentType: oidPKCS7Data,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+	}, nil
+}
+
+func localKeyIDAttributes(id []byte) ([]pkcs12Attribute, error) {
+	value, err := asn1.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	return []pkcs12Attribute{
+		{
+			ID:    oidLocalKeyID,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		},
+	}, nil
+}
+
+func verifyPKCS12MAC(md *macData, content []byte, password string) error {
+	var (
+		h    func() hash.Hash
+		size int
+	)
+	switch {
+	case md.Mac.Algorithm.Algorithm.Equal(oidSHA1):
+		h, size = sha1.New, sha1.Size
+	case md.Mac.Algorithm.Algorithm.Equal(oidSHA256):
+		h, size = sha256.New, sha256.Size
+	default:
+		return fmt.Errorf("unsupported PKCS#12 MAC algorithm %s", md.Mac.Algorithm.Algorithm)
+	}
+	err := checkPKCS12Iterations(md.Iterations)
+	if err != nil {
+		return err
+	}
+	key := pkcs12KDF(h, md.MacSalt, bmpString(password), md.Iterations, 3, size)
+	mac := hmac.New(h, key)
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), md.Mac.Digest) {
+		return errors.New("PKCS#12 MAC verification failed: wrong password")
+	}
+	return nil
+}
+
+func pbes2Encrypt(data []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 8)
+	iv := make([]byte, aes.BlockSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	_, err = rand.Read(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	key := pbkdf2Key(sha256.New, []byte(password), salt, pkcs12Iterations, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	encrypted := pkcs7Pad(data, block.BlockSize())
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs12Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, encrypted, nil
+}
+
+func pbeDecrypt(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var (
+		block cipher.Block
+		iv    []byte
+		err   error
+	)
+	switch {
+	case alg.Algorithm.Equal(oidPBES2):
+		block, iv, err = pbes2Cipher(alg.Parameters.FullBytes, password)
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+		params := &pbeParams{}
+		_, err = asn1.Unmarshal(alg.Parameters.FullBytes, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PBE parameters: %w", err)
+		}
+		err = checkPKCS12Iterations(params.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		pw := bmpString(password)
+		key := pkcs12KDF(sha1.New, params.Salt, pw, params.Iterations, 1, 24)
+		iv = pkcs12KDF(sha1.New, params.Salt, pw, params.Iterations, 2, des.BlockSize)
+		block, err = des.NewTripleDESCipher(key)
+	case alg.Algorithm.Equal(oidPBEWithSHARC240), alg.Algorithm.Equal(oidPBEWithSHARC2128):
+		return nil, fmt.Errorf("unsupported PKCS#12 encryption RC2. re-encrypt the file with AES")
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#12 encryption algorithm %s", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid length of encrypted data")
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+	return pkcs7Unpad(decrypted, block.BlockSize())
+}
+
+func pbes2Cipher(rawParams []byte, password string) (cipher.Block, []byte, error) {
+	params := &pbes2Params{}
+	_, err := asn1.Unmarshal(rawParams, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+	kdfParams := &pbkdf2Params{}
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, kdfParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+	}
+	err = checkPKCS12Iterations(kdfParams.IterationCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBKDF2 PRF %s", kdfParams.PRF.Algorithm)
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PBES2 IV: %w", err)
+	}
+
+	var (
+		keyLen    int
+		newCipher func(key []byte) (cipher.Block, error)
+	)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBES2 encryption scheme %s", scheme)
+	}
+
+	key := pbkdf2Key(prf, []byte(password), kdfParams.Salt, kdfParams.IterationCount, keyLen)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, nil, errors.New("invalid PBES2 IV length")
+	}
+	return block, iv, nil
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, errors.New("decryption failed: wrong password")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("decryption failed: wrong password")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+// bmpString returns the password as null terminated UTF-16 big endian string
+// as it is used by the PKCS#12 key derivation.
+func bmpString(s string) []byte {
+	var out []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		out = binary.BigEndian.AppendUint16(out, c)
+	}
+	return append(out, 0, 0)
+}
+
+// pbkdf2Key derives a key from password according to PBKDF2 (RFC 8018
+// section 5.2).
+func pbkdf2Key(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	var (
+		key []byte
+		u   []byte
+	)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// pkcs12KDF derives key material according to RFC 7292 appendix B.2. id is
+// 1 for keys, 2 for IVs and 3 for MAC keys.
+func pkcs12KDF(h func() hash.Hash, salt, password []byte, iterations int, id byte, size int) []byte {
+	const v = 64 // block size of SHA-1 and SHA-256
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(data)+v-1)/v))
+		for i := range out {
+			out[i] = data[i%len(data)]
+		}
+		return out
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		hash := h()
+		hash.Write(d)
+		hash.Write(i)
+		a := hash.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			hash.Reset()
+			hash.Write(a)
+			a = hash.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8)
+		b := fill(a)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
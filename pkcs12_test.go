@@ -0,0 +1,165 @@
+package pcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// created with:
+//
+//	openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout k.pem -out c.pem -subj /CN=p12test
+//	openssl pkcs12 -export -in c.pem -inkey k.pem -passout pass:test -certpbe PBE-SHA1-3DES -keypbe PBE-SHA1-3DES -macalg sha1
+//	openssl pkcs12 -export -in c.pem -inkey k.pem -passout pass:test
+var testPKCS12Files = map[string]string{
+	"openssl legacy": `
+MIIDegIBAzCCA0AGCSqGSIb3DQEHAaCCAzEEggMtMIIDKTCCAh8GCSqGSIb3DQEHBqCCAhAwggIM
+AgEAMIICBQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQI3bZeI+y1OjICAggAgIIB2BkyTpki
+qGJkDCdrKT7a0nbzz7kSHHUKbDFLpOL7FsryaLBdyG+IBE7D2xygx11/TJu/F7xSUiPMPRVXR92Y
+NLv75eSCIcmOHYEUMPTnDd/Y6khf++8Vs3gsk8Bx2qOJyIyw+DTCKzn05NbA3XpgEIhMyG/zriDa
+m3O4xK97LeC21OPIJmsQ0sv+tN+ApaNAw/UeyDkkeKxmm6Q1ACW82H6uLx/1aia6ODoG/3lRrlVP
+skl8PVRj5Bz9haI+eQCSxzRZ/8spLkYxBTxPrwmuZ/buhRZGE1+CrzUgrj90ub/dtifJPJ4yCd4/
+HRX2zfSuLYlI77LRYgNIuEwoSHBJ6/j0HR+d4wAfAKQ8/s3kQuzalianBqY968SuzfED+lmZz145
+/m13RFL1tbeMcFBdfz6hh50WydJxlBfn5CJDPxcKoFIFnOjT9Ygf1aRboiKMdCQSsbHzQYRDLmSp
+nuw7m+1229Sl06P7ukWg2sqNJQlVnSIlt9e+w29tvGBWAlE5PxNkBJFfOic0dFa70pdIYo0akuqP
+Xg0ODkpRZxE8TGe6IfW7ZFI/wXTcGBRxVLWMHEQMndPUucFde5fk/m1Cvj3Gi5+76tysfv54t5ul
+sXFHBQFEj7xbR1YwggECBgkqhkiG9w0BBwGggfQEgfEwge4wgesGCyqGSIb3DQEMCgECoIG0MIGx
+MBwGCiqGSIb3DQEMAQMwDgQIw7myl3ZWuxICAggABIGQB86IDoY9TSlE+jncgYg8Xlwl/YVW/dSy
+om7TIw+vrDH6BgY0Z3ui/OayV0SOjDH1Em2kXakB9hnerxN0vky80/JqwqeTkv0PT5f+2fg1wYEJ
+KC6+crajl07sLivbNp48yFmGHp+PDNcMinn0wMPsDbngpzhLsdDLPLYrT9cmEKGpowlBPJHZKQZB
+SZiez1CgMSUwIwYJKoZIhvcNAQkVMRYEFHn7QmnKJnnYujV9+Z8Z8FpuOieMMDEwITAJBgUrDgMC
+GgUABBST7vYzeHuRswZ6hxQf2WzcK8kU9AQIn+RB2+SBtrQCAggA`,
+	"openssl aes": `
+MIIEDAIBAzCCA8IGCSqGSIb3DQEHAaCCA7MEggOvMIIDqzCCAmIGCSqGSIb3DQEHBqCCAlMwggJP
+AgEAMIICSAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAhzde18PgkQ
+JQICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEG0dBR8yDTnSpSqwVY8UFm+AggHgKLR5
+U0uvEhJRtj+l78uoAFkN2jmbaPDw0OwLIBPJtnq11zZWRWiq+y2QpT/d1EL/4qgpQ22eEWIGsgUn
+M97b0nS1QIGP+cr0ULIpOX3qYmx7GLhNgRCJa4TtDYM/B1GRvtuodbrIpPTpgZgDxqTxUBrgAJ5C
+dJ8u4Qz6RgwRap9W9blNKon6/WMl1/45iOSxQWsKnzK8Fri+E23l04zvA4jeYQenf0JOUr6NQ47S
+hrnJqNhZ39XyzJvPg1nQKR6+F1qnFVWrr4OJO0UHdEAaPv95DCPApYsnIqJfIUo5B7RwP2PYtD4t
+pyMBECNq5421rGs+WwPiptn9ak6MjUKHAjvdJ6/uqrKaMcIgJnpFRf8ibXoiwY5I8Cw4+Owoc73f
+MJPIxv1F8UYnfhXhbS3+FvwOHgM3+27D18dSDoSUxdZcPKxRR8wx122gF1d6umXqB2FQOPWKevbs
+PM4ecGLarFDAt6gxLz6xCbgwVJQqKn8S46nW3Cxn4psztUWc9EjTLKWdSQADNu8VifN48Y6QjW4g
+SWatf1GDIzSrE3aAe1bBJpqIXGzCJtxPerzqMnSI7kLejQt/gqoPlihr3ZyjNv7roZnLYlOK2/E7
+pBhWLppha+h1lwCmctcV85POjQOXMIIBQQYJKoZIhvcNAQcBoIIBMgSCAS4wggEqMIIBJgYLKoZI
+hvcNAQwKAQKgge8wgewwVwYJKoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECOpfitpE+XhmAgII
+ADAMBggqhkiG9w0CCQUAMB0GCWCGSAFlAwQBKgQQy2D02W7MSQHvdc6ZmmIsDQSBkET5b7kagkST
+3JVqMNlHTDmQveLulh7AxwqsQOdhq4nmL0ZMLm+Wf7xwPuayBQLGcIrcLniPn58s2evWFD/BSKOq
+W1Z1QEUu7QfigDBs06AwhMwoW6ZTu3nEHKRo2nogEVp5tTDWxFxG1tPDNj76diE2PhwfdXlukcoR
+NCzi/t06Gtqo/CAKmIa4OhqkFDUOSTElMCMGCSqGSIb3DQEJFTEWBBR5+0JpyiZ52Lo1ffmfGfBa
+bjonjDBBMDEwDQYJYIZIAWUDBAIBBQAEII7mriaVvg0Vv3kA8kQKsP4yI3yEShxrieEfmaw9yEY7
+BAidqkiqogrhUAICCAA=`,
+}
+
+func TestDecodePKCS12_openssl(t *testing.T) {
+	for name, b64 := range testPKCS12Files {
+		t.Run(name, func(t *testing.T) {
+			data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(b64, "\n", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, certs, err := DecodePKCS12(data, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := key.(*ecdsa.PrivateKey); !ok {
+				t.Fatalf("wrong key type %T", key)
+			}
+			if len(certs) != 1 || certs[0].Subject.CommonName != "p12test" {
+				t.Fatalf("certificate not decoded: %v", certs)
+			}
+			if !KeyMatchesCertificate(key, certs[0]) {
+				t.Fatal("key does not match certificate")
+			}
+
+			_, _, err = DecodePKCS12(data, "wrong")
+			if err == nil {
+				t.Fatal("wrong password did not fail")
+			}
+		})
+	}
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	root, rootKey, err := createAndParse("Root", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, leafKey, err := createAndParse("Leaf", root, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := EncodePKCS12(leafKey, []*x509.Certificate{root, leaf}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := DecodePKCS12(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(leaf) || !certs[1].Equal(root) {
+		t.Fatal("certificates not decoded correctly")
+	}
+	if !KeyMatchesCertificate(key, leaf) {
+		t.Fatal("key not decoded correctly")
+	}
+}
+
+func TestDecodePKCS12_maxIterations(t *testing.T) {
+	leaf, leafKey, err := createAndParse("Leaf", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodePKCS12(leafKey, []*x509.Certificate{leaf}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx := &pfxPDU{}
+	_, err = asn1.Unmarshal(data, pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx.MacData.Iterations = pkcs12MaxIterations + 1
+	data, err = asn1.Marshal(*pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DecodePKCS12(data, "secret")
+	if err == nil || !strings.Contains(err.Error(), "iteration count") {
+		t.Errorf("expected error for MAC iteration count, got %v", err)
+	}
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{Salt: []byte("salt"), IterationCount: pkcs12MaxIterations + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := asn1.Marshal(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbes2, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: iv}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbe, err := asn1.Marshal(pbeParams{Salt: []byte("salt"), Iterations: pkcs12MaxIterations + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []pkix.AlgorithmIdentifier{
+		{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: pbes2}},
+		{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: pbe}},
+	} {
+		_, err = pbeDecrypt(alg, make([]byte, 16), "secret")
+		if err == nil || !strings.Contains(err.Error(), "iteration count") {
+			t.Errorf("%s: expected error for iteration count, got %v", alg.Algorithm, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// contentInfo is the outer structure of PKCS#7 / CMS messages (RFC 5652 section 3).
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is the SignedData content type (RFC 5652 section 5.1). The
+// certificates and signer infos are kept raw, as they are only partially
+// needed by the functions in this package.
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// ParsePKCS7Certificates returns the certificates of a DER encoded PKCS#7
+// SignedData structure (e.g. a .p7b or .p7c file).
+func ParsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, nil
+	}
+
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+func parseSignedData(der []byte) (*signedData, error) {
+	ci := &contentInfo{}
+	rest, err := asn1.Unmarshal(der, ci)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 content info: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after PKCS#7 content info")
+	}
+
+	if !ci.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, fmt.Errorf("unsupported PKCS#7 content type %s", ci.ContentType)
+	}
+
+	sd := &signedData{}
+	_, err = asn1.Unmarshal(ci.Content.Bytes, sd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 signed data: %w", err)
+	}
+	return sd, nil
+}
+
+// EncodePKCS7Certificates returns a DER encoded PKCS#7 SignedData structure
+// which contains only certs and no signers (a degenerate "certs-only"
+// message as it is used for .p7b and .p7c files).
+func EncodePKCS7Certificates(certs []*x509.Certificate) ([]byte, error) {
+	var rawCerts []byte
+	for _, cert := range certs {
+		rawCerts = append(rawCerts, cert.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo: contentInfo{
+			ContentType: oidPKCS7Data,
+		},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      rawCerts,
+		},
+		SignerInfos: emptySet,
+	}
+	sdDER, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      sdDER,
+		},
+	})
+}
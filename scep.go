@@ -0,0 +1,579 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SCEP message types, PKI status and failure information (RFC 8894 section
+// 3.2.1).
+const (
+	SCEPMessageTypeCertRep = "3"
+	SCEPMessageTypePKCSReq = "19"
+
+	SCEPStatusSuccess = "0"
+	SCEPStatusFailure = "2"
+	SCEPStatusPending = "3"
+
+	SCEPFailBadAlg          = "0"
+	SCEPFailBadMessageCheck = "1"
+	SCEPFailBadRequest      = "2"
+	SCEPFailBadTime         = "3"
+	SCEPFailBadCertID       = "4"
+
+	scepMaxRequestSize = 1 << 20
+)
+
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+var scepFailInfoNames = map[string]string{
+	SCEPFailBadAlg:          "badAlg",
+	SCEPFailBadMessageCheck: "badMessageCheck",
+	SCEPFailBadRequest:      "badRequest",
+	SCEPFailBadTime:         "badTime",
+	SCEPFailBadCertID:       "badCertID",
+}
+
+// SCEPCapabilities are the capabilities returned by GetCACaps.
+var SCEPCapabilities = []string{"AES", "POSTPKIOperation", "SCEPStandard", "SHA-256", "SHA-512"}
+
+// SCEPServer is a SCEP (RFC 8894) server which signs CSRs with a CA. It
+// supports the operations GetCACert, GetCACaps and PKIOperation with
+// PKCSReq messages. Renewal and polling (GetCertInitial) are not supported.
+//
+// The requests are encrypted for and the responses are signed by the RA
+// certificate. As SCEP uses RSA key transport, the RA certificate and the
+// keys of the clients have to be RSA keys.
+type SCEPServer struct {
+	// CACert and CAKey are used to sign the certificates.
+	CACert *x509.Certificate
+	CAKey  any
+	// Chain contains further certificates which are returned by
+	// GetCACert.
+	Chain []*x509.Certificate
+
+	// RACert and RAKey decrypt the requests and sign the responses. If
+	// RACert is nil, the CA is used instead.
+	RACert *x509.Certificate
+	RAKey  any
+
+	// CertificateOptions are used for every issued certificate. The
+	// subject and the subject alternative names are taken from the CSR if
+	// they are not set.
+	CertificateOptions CertificateOptions
+
+	// VerifyChallenge verifies the challenge password of a CSR. If nil,
+	// all requests are rejected.
+	VerifyChallenge func(csr *x509.CertificateRequest, challengePassword string) bool
+}
+
+func (s *SCEPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("operation") {
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Join(SCEPCapabilities, "\n")+"\n")
+	case "GetCACert":
+		s.handleGetCACert(w)
+	case "PKIOperation":
+		var (
+			msg []byte
+			err error
+		)
+		if r.Method == http.MethodPost {
+			msg, err = io.ReadAll(io.LimitReader(r.Body, scepMaxRequestSize))
+		} else {
+			msg, err = base64.StdEncoding.DecodeString(r.URL.Query().Get("message"))
+		}
+		if err != nil {
+			http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := s.pkiOperation(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pki-message")
+		_, _ = w.Write(resp)
+	default:
+		http.Error(w, "unsupported operation", http.StatusBadRequest)
+	}
+}
+
+func (s *SCEPServer) handleGetCACert(w http.ResponseWriter) {
+	if s.RACert == nil && len(s.Chain) == 0 {
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		_, _ = w.Write(s.CACert.Raw)
+		return
+	}
+	certs := []*x509.Certificate{s.CACert}
+	if s.RACert != nil {
+		certs = append(certs, s.RACert)
+	}
+	certs = append(certs, s.Chain...)
+	p7, err := EncodePKCS7Certificates(certs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+	_, _ = w.Write(p7)
+}
+
+func (s *SCEPServer) ra() (*x509.Certificate, crypto.Signer, error) {
+	cert, key := s.RACert, s.RAKey
+	if cert == nil {
+		cert, key = s.CACert, s.CAKey
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported RA key type %T", key)
+	}
+	return cert, signer, nil
+}
+
+// pkiOperation processes a PKCSReq message and returns the CertRep message.
+func (s *SCEPServer) pkiOperation(msg []byte) ([]byte, error) {
+	raCert, raKey, err := s.ra()
+	if err != nil {
+		return nil, err
+	}
+	sd, err := parseCMSSignedData(msg)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := sd.verify(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req := signers[0]
+	var messageType, transactionID string
+	var senderNonce []byte
+	for _, attr := range []struct {
+		oid asn1.ObjectIdentifier
+		out any
+	}{
+		{oidSCEPMessageType, &messageType},
+		{oidSCEPTransactionID, &transactionID},
+		{oidSCEPSenderNonce, &senderNonce},
+	} {
+		ok, err := req.Attributes.unmarshal(attr.oid, attr.out)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("attribute %s is missing", attr.oid)
+		}
+	}
+
+	rep := &scepCertRep{
+		signerCert:     raCert,
+		signerKey:      raKey,
+		transactionID:  transactionID,
+		recipientNonce: senderNonce,
+	}
+	if messageType != SCEPMessageTypePKCSReq {
+		return rep.failure(SCEPFailBadRequest)
+	}
+	content, err := cmsDecrypt(sd.EncapContentInfo.EContent, raCert, raKey)
+	if err != nil {
+		return rep.failure(SCEPFailBadMessageCheck)
+	}
+	csr, err := x509.ParseCertificateRequest(content)
+	if err != nil || csr.CheckSignature() != nil {
+		return rep.failure(SCEPFailBadRequest)
+	}
+	// the request has to be signed with the key of the CSR since the
+	// certificate is encrypted for the signer
+	if !bytes.Equal(req.Cert.RawSubjectPublicKeyInfo, csr.RawSubjectPublicKeyInfo) {
+		return rep.failure(SCEPFailBadMessageCheck)
+	}
+	challenge, err := csrChallengePassword(csr)
+	if err != nil || s.VerifyChallenge == nil || !s.VerifyChallenge(csr, challenge) {
+		return rep.failure(SCEPFailBadRequest)
+	}
+
+	cert, err := issueCertificateForCSR(csr, s.CertificateOptions, s.CACert, s.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	degenerate, err := EncodePKCS7Certificates([]*x509.Certificate{cert})
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := cmsEncrypt(degenerate, []*x509.Certificate{req.Cert}, cmsEncryptOptions{ContentEncryption: oidAES128CBC})
+	if err != nil {
+		return rep.failure(SCEPFailBadAlg)
+	}
+	return rep.sign(SCEPStatusSuccess, "", envelope)
+}
+
+// scepCertRep creates CertRep messages.
+type scepCertRep struct {
+	signerCert     *x509.Certificate
+	signerKey      crypto.Signer
+	transactionID  string
+	recipientNonce []byte
+}
+
+func (rep *scepCertRep) failure(failInfo string) ([]byte, error) {
+	return rep.sign(SCEPStatusFailure, failInfo, nil)
+}
+
+func (rep *scepCertRep) sign(status, failInfo string, envelope []byte) ([]byte, error) {
+	senderNonce := make([]byte, 16)
+	_, err := rand.Read(senderNonce)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []struct {
+		oid    asn1.ObjectIdentifier
+		value  any
+		params string
+	}{
+		{oidSCEPMessageType, SCEPMessageTypeCertRep, "printable"},
+		{oidSCEPPKIStatus, status, "printable"},
+		{oidSCEPTransactionID, rep.transactionID, "printable"},
+		{oidSCEPSenderNonce, senderNonce, ""},
+		{oidSCEPRecipientNonce, rep.recipientNonce, ""},
+	}
+	if failInfo != "" {
+		attrs = append(attrs, struct {
+			oid    asn1.ObjectIdentifier
+			value  any
+			params string
+		}{oidSCEPFailInfo, failInfo, "printable"})
+	}
+	signer := cmsSigner{Cert: rep.signerCert, Key: rep.signerKey}
+	for _, attr := range attrs {
+		a, err := newCMSAttribute(attr.oid, attr.value, attr.params)
+		if err != nil {
+			return nil, err
+		}
+		signer.Attributes = append(signer.Attributes, a)
+	}
+	// failures contain no pkcsPKIEnvelope
+	return cmsSign(envelope, oidPKCS7Data, []cmsSigner{signer}, nil, envelope == nil)
+}
+
+// csrTBS is the CertificationRequestInfo of a CSR (RFC 2986).
+type csrTBS struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes asn1.RawValue `asn1:"tag:0"`
+}
+
+type csrOuter struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// csrChallengePassword returns the challenge password attribute of a CSR.
+func csrChallengePassword(csr *x509.CertificateRequest) (string, error) {
+	tbs := csrTBS{}
+	_, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := parseCMSAttributes(tbs.Attributes.Bytes)
+	if err != nil {
+		return "", err
+	}
+	var password string
+	ok, err := attrs.unmarshal(oidChallengePassword, &password)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("CSR contains no challenge password")
+	}
+	return password, nil
+}
+
+// AddChallengePassword adds the challenge password attribute to a DER
+// encoded CSR and signs it again with key.
+func AddChallengePassword(csrDER []byte, key crypto.Signer, password string) ([]byte, error) {
+	outer := csrOuter{}
+	_, err := asn1.Unmarshal(csrDER, &outer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	tbs := csrTBS{}
+	_, err = asn1.Unmarshal(outer.TBS.FullBytes, &tbs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	attrs, err := parseCMSAttributes(tbs.Attributes.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	attrs = slices.DeleteFunc(attrs, func(attr cmsAttribute) bool { return attr.Type.Equal(oidChallengePassword) })
+	attr, err := newCMSAttribute(oidChallengePassword, password, "")
+	if err != nil {
+		return nil, err
+	}
+	attrSet, err := marshalCMSAttributes(append(attrs, attr))
+	if err != nil {
+		return nil, err
+	}
+	var attrValue asn1.RawValue
+	_, err = asn1.Unmarshal(attrSet, &attrValue)
+	if err != nil {
+		return nil, err
+	}
+	tbs.Attributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrValue.Bytes}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	sigAlg, hash, err := signatureAlgorithmForKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	signed := tbsDER
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbsDER)
+		signed = h.Sum(nil)
+	}
+	signature, err := key.Sign(rand.Reader, signed, hash)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(csrOuter{
+		TBS:                asn1.RawValue{FullBytes: tbsDER},
+		SignatureAlgorithm: sigAlg,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+}
+
+// SCEPClient is a SCEP (RFC 8894) client.
+type SCEPClient struct {
+	// URL of the SCEP server (e.g. http://scep.example.com/scep).
+	URL string
+	// HTTPClient is used for all requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// GetCACaps returns the capabilities of the server.
+func (c *SCEPClient) GetCACaps(ctx context.Context) ([]string, error) {
+	body, _, err := c.do(ctx, "GetCACaps", nil)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(body)), nil
+}
+
+// GetCACert returns the CA certificate followed by the RA certificate and
+// further certificates if the server uses an RA.
+func (c *SCEPClient) GetCACert(ctx context.Context) ([]*x509.Certificate, error) {
+	body, contentType, err := c.do(ctx, "GetCACert", nil)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "application/x-x509-ca-cert" {
+		cert, err := x509.ParseCertificate(body)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+	return ParsePKCS7Certificates(body)
+}
+
+// Enroll requests a certificate for a DER encoded CSR which contains a
+// challenge password (see AddChallengePassword). key is the RSA key of the
+// CSR.
+func (c *SCEPClient) Enroll(ctx context.Context, csrDER []byte, key crypto.Signer) (*x509.Certificate, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := c.GetCACert(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(caCerts) == 0 {
+		return nil, errors.New("server returned no CA certificates")
+	}
+	recipient := caCerts[0]
+	for _, cert := range caCerts[1:] {
+		if !cert.IsCA {
+			recipient = cert
+			break
+		}
+	}
+
+	// the request is signed with a self-signed certificate of the key
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signerDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	signerCert, err := x509.ParseCertificate(signerDER)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := cmsEncrypt(csrDER, []*x509.Certificate{recipient}, cmsEncryptOptions{ContentEncryption: oidAES128CBC})
+	if err != nil {
+		return nil, err
+	}
+	keyHash := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+	transactionID := hex.EncodeToString(keyHash[:])
+	senderNonce := make([]byte, 16)
+	_, err = rand.Read(senderNonce)
+	if err != nil {
+		return nil, err
+	}
+	signer := cmsSigner{Cert: signerCert, Key: key}
+	for _, attr := range []struct {
+		oid    asn1.ObjectIdentifier
+		value  any
+		params string
+	}{
+		{oidSCEPMessageType, SCEPMessageTypePKCSReq, "printable"},
+		{oidSCEPTransactionID, transactionID, "printable"},
+		{oidSCEPSenderNonce, senderNonce, ""},
+	} {
+		a, err := newCMSAttribute(attr.oid, attr.value, attr.params)
+		if err != nil {
+			return nil, err
+		}
+		signer.Attributes = append(signer.Attributes, a)
+	}
+	msg, err := cmsSign(envelope, oidPKCS7Data, []cmsSigner{signer}, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := c.do(ctx, "PKIOperation", msg)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := parseCMSSignedData(body)
+	if err != nil {
+		return nil, err
+	}
+	// responses without a certificate (e.g. failures) have no content
+	var content []byte
+	if sd.EncapContentInfo.EContent == nil {
+		content = []byte{}
+	}
+	signers, err := sd.verify(content, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if !slices.ContainsFunc(caCerts, signers[0].Cert.Equal) {
+		return nil, errors.New("response is not signed by the CA or RA")
+	}
+	attrs := signers[0].Attributes
+	var status, failInfo, respTransactionID string
+	var recipientNonce []byte
+	_, _ = attrs.unmarshal(oidSCEPFailInfo, &failInfo)
+	_, _ = attrs.unmarshal(oidSCEPTransactionID, &respTransactionID)
+	_, _ = attrs.unmarshal(oidSCEPRecipientNonce, &recipientNonce)
+	_, err = attrs.unmarshal(oidSCEPPKIStatus, &status)
+	if err != nil {
+		return nil, err
+	}
+	if respTransactionID != transactionID || !bytes.Equal(recipientNonce, senderNonce) {
+		return nil, errors.New("response does not match the request")
+	}
+	switch status {
+	case SCEPStatusSuccess:
+	case SCEPStatusPending:
+		return nil, errors.New("request is pending")
+	case SCEPStatusFailure:
+		name, ok := scepFailInfoNames[failInfo]
+		if !ok {
+			name = failInfo
+		}
+		return nil, fmt.Errorf("request failed: %s", name)
+	default:
+		return nil, fmt.Errorf("invalid status '%s'", status)
+	}
+
+	degenerate, err := cmsDecrypt(sd.EncapContentInfo.EContent, signerCert, key)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParsePKCS7Certificates(degenerate)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("response contains no certificate")
+	}
+	return certs[0], nil
+}
+
+func (c *SCEPClient) do(ctx context.Context, operation string, msg []byte) ([]byte, string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	q := u.Query()
+	q.Set("operation", operation)
+	u.RawQuery = q.Encode()
+	method := http.MethodGet
+	if msg != nil {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(msg))
+	if err != nil {
+		return nil, "", err
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, scepMaxRequestSize))
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s failed: %s: %s", operation, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
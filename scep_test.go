@@ -0,0 +1,138 @@
+package pcert
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSCEP(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCACertificate("SCEP CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raCert, raKey := newCMSTestCert(t, "SCEP RA", KeyOptions{Algorithm: x509.RSA})
+
+	server := &SCEPServer{
+		CACert: caCert,
+		CAKey:  caKey,
+		RACert: raCert,
+		RAKey:  raKey,
+		VerifyChallenge: func(_ *x509.CertificateRequest, password string) bool {
+			return password == "secret"
+		},
+	}
+	server.CertificateOptions.ProfileClient = true
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := &SCEPClient{URL: ts.URL + "/scep"}
+	ctx := context.Background()
+
+	caps, err := client.GetCACaps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caps, " ") != strings.Join(SCEPCapabilities, " ") {
+		t.Errorf("unexpected capabilities: %v", caps)
+	}
+	caCerts, err := client.GetCACert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caCerts) != 2 || !caCerts[0].Equal(caCert) || !caCerts[1].Equal(raCert) {
+		t.Fatalf("unexpected CA certificates: %d", len(caCerts))
+	}
+
+	csrTemplate := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.example.com"},
+	}
+	csrDER, key, err := CreateRequestWithKeyOptions(csrTemplate, KeyOptions{Algorithm: x509.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := key.(crypto.Signer)
+
+	_, err = client.Enroll(ctx, csrDER, signer)
+	if err == nil || !strings.Contains(err.Error(), "badRequest") {
+		t.Errorf("expected badRequest without challenge password, got %v", err)
+	}
+
+	wrongDER, err := AddChallengePassword(csrDER, signer, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Enroll(ctx, wrongDER, signer)
+	if err == nil || !strings.Contains(err.Error(), "badRequest") {
+		t.Errorf("expected badRequest for wrong challenge password, got %v", err)
+	}
+
+	csrDER, err = AddChallengePassword(csrDER, signer, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Fatal(err)
+	}
+	// the request has to be signed with the key of the CSR
+	_, otherKey, err := CreateRequestWithKeyOptions(csrTemplate, KeyOptions{Algorithm: x509.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Enroll(ctx, csrDER, otherKey.(crypto.Signer))
+	if err == nil || !strings.Contains(err.Error(), "badMessageCheck") {
+		t.Errorf("expected badMessageCheck for other signer key, got %v", err)
+	}
+
+	cert, err := client.Enroll(ctx, csrDER, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "device-1" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "device-1.example.com" {
+		t.Errorf("unexpected names: %s %v", cert.Subject, cert.DNSNames)
+	}
+	if !KeyMatchesCertificate(signer, cert) {
+		t.Error("certificate does not match key")
+	}
+
+	// without RA the CA is used which requires an RSA CA
+	rsaCADER, rsaCAKey, err := CreateCertificateWithKeyOptions(NewCACertificate("SCEP RSA CA"), KeyOptions{Algorithm: x509.RSA}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCACert, err := x509.ParseCertificate(rsaCADER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CACert, server.CAKey = rsaCACert, rsaCAKey
+	server.RACert, server.RAKey = nil, nil
+	caCerts, err = client.GetCACert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caCerts) != 1 || !caCerts[0].Equal(rsaCACert) {
+		t.Fatalf("unexpected CA certificates: %d", len(caCerts))
+	}
+	cert, err = client.Enroll(ctx, csrDER, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(rsaCACert); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,80 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// SignedCertificateTimestamp is a Certificate Transparency SCT (RFC 6962).
+type SignedCertificateTimestamp struct {
+	Version   int
+	LogID     []byte
+	Timestamp time.Time
+}
+
+// ParseSCT decodes a single TLS encoded SCT as it is sent in the TLS
+// handshake (see tls.ConnectionState.SignedCertificateTimestamps).
+func ParseSCT(data []byte) (*SignedCertificateTimestamp, error) {
+	// version (1), log id (32), timestamp (8)
+	if len(data) < 41 {
+		return nil, fmt.Errorf("SCT too short")
+	}
+	return &SignedCertificateTimestamp{
+		Version:   int(data[0]),
+		LogID:     data[1:33],
+		Timestamp: time.UnixMilli(int64(binary.BigEndian.Uint64(data[33:41]))).UTC(),
+	}, nil
+}
+
+// ParseSCTList decodes a TLS encoded SignedCertificateTimestampList.
+func ParseSCTList(data []byte) ([]*SignedCertificateTimestamp, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("SCT list too short")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) != length {
+		return nil, fmt.Errorf("invalid SCT list length")
+	}
+
+	var scts []*SignedCertificateTimestamp
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated SCT list")
+		}
+		sctLen := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if len(data) < sctLen {
+			return nil, fmt.Errorf("truncated SCT list")
+		}
+		sct, err := ParseSCT(data[:sctLen])
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+		data = data[sctLen:]
+	}
+	return scts, nil
+}
+
+// CertificateSCTs returns the SCTs embedded in the certificate. If the
+// certificate has no SCT list extension nil is returned.
+func CertificateSCTs(cert *x509.Certificate) ([]*SignedCertificateTimestamp, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		var list []byte
+		_, err := asn1.Unmarshal(ext.Value, &list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCT list extension: %w", err)
+		}
+		return ParseSCTList(list)
+	}
+	return nil, nil
+}
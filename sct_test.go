@@ -0,0 +1,44 @@
+package pcert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func testSCT(logID byte, timestamp time.Time) []byte {
+	sct := []byte{0}
+	sct = append(sct, bytes.Repeat([]byte{logID}, 32)...)
+	sct = binary.BigEndian.AppendUint64(sct, uint64(timestamp.UnixMilli()))
+	// no extensions, sha256/ecdsa signature of length 0
+	sct = append(sct, 0, 0, 4, 3, 0, 0)
+	return sct
+}
+
+func TestParseSCTList(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var list []byte
+	for _, id := range []byte{1, 2} {
+		sct := testSCT(id, ts)
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+
+	scts, err := ParseSCTList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 2 {
+		t.Fatalf("wrong number of SCTs: got=%d want=%d", len(scts), 2)
+	}
+	if scts[1].LogID[0] != 2 || !scts[1].Timestamp.Equal(ts) {
+		t.Errorf("SCT not parsed correctly: %+v", scts[1])
+	}
+
+	_, err = ParseSCTList(list[:len(list)-1])
+	if err == nil {
+		t.Error("truncated list did not fail")
+	}
+}
@@ -0,0 +1,74 @@
+package pcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+var oidSignatureAlgList = []struct {
+	oid asn1.ObjectIdentifier
+	alg x509.SignatureAlgorithm
+}{
+	{oidSHA1WithRSA, x509.SHA1WithRSA},
+	{oidSHA256WithRSA, x509.SHA256WithRSA},
+	{oidSHA384WithRSA, x509.SHA384WithRSA},
+	{oidSHA512WithRSA, x509.SHA512WithRSA},
+	{oidECDSAWithSHA1, x509.ECDSAWithSHA1},
+	{oidECDSAWithSHA256, x509.ECDSAWithSHA256},
+	{oidECDSAWithSHA384, x509.ECDSAWithSHA384},
+	{oidECDSAWithSHA512, x509.ECDSAWithSHA512},
+	{oidEd25519, x509.PureEd25519},
+}
+
+// signatureAlgorithmFromOID returns the x509.SignatureAlgorithm of a
+// signature algorithm identifier.
+func signatureAlgorithmFromOID(oid asn1.ObjectIdentifier) x509.SignatureAlgorithm {
+	for _, entry := range oidSignatureAlgList {
+		if entry.oid.Equal(oid) {
+			return entry.alg
+		}
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// signatureAlgorithmForKey returns the algorithm identifier and hash used to
+// sign with a key. For RSA SHA-256 is used, for ECDSA the hash matches the
+// curve size and Ed25519 signs the message without hashing (hash is zero).
+func signatureAlgorithmForKey(pub crypto.PublicKey) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}, crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P384():
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384}, crypto.SHA384, nil
+		case elliptic.P521():
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA512}, crypto.SHA512, nil
+		default:
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, crypto.SHA256, nil
+		}
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, 0, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, 0, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
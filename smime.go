@@ -0,0 +1,135 @@
+package pcert
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// ContentEncryptionAlgorithms are the content encryption algorithms of
+// EncryptCMS.
+var ContentEncryptionAlgorithms = map[string]asn1.ObjectIdentifier{
+	"aes-128-cbc": oidAES128CBC,
+	"aes-256-cbc": oidAES256CBC,
+	"aes-128-gcm": oidAES128GCM,
+	"aes-256-gcm": oidAES256GCM,
+}
+
+// DefaultContentEncryption is the default content encryption algorithm of
+// EncryptCMS.
+const DefaultContentEncryption = "aes-256-gcm"
+
+// EncryptCMS returns content encrypted for the recipients as DER encoded CMS
+// EnvelopedData structure or as AuthEnvelopedData structure if the content
+// encryption algorithm is AES-GCM. contentEncryption is one of
+// ContentEncryptionAlgorithms. If it is empty DefaultContentEncryption is
+// used. The content encryption key is encrypted with RSAES-OAEP (SHA-256)
+// for RSA recipients and with ephemeral-static ECDH for ECDSA recipients.
+func EncryptCMS(content []byte, recipients []*x509.Certificate, contentEncryption string) ([]byte, error) {
+	if contentEncryption == "" {
+		contentEncryption = DefaultContentEncryption
+	}
+	alg, ok := ContentEncryptionAlgorithms[strings.ToLower(contentEncryption)]
+	if !ok {
+		return nil, fmt.Errorf("unknown content encryption algorithm '%s'", contentEncryption)
+	}
+	return cmsEncrypt(content, recipients, cmsEncryptOptions{ContentEncryption: alg, OAEP: true})
+}
+
+// DecryptCMS decrypts a CMS EnvelopedData or AuthEnvelopedData structure for
+// the recipient cert with its key. data is either DER, PEM or an S/MIME
+// message (application/pkcs7-mime).
+func DecryptCMS(data []byte, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	if !KeyMatchesCertificate(key, cert) {
+		return nil, errors.New("key does not belong to certificate")
+	}
+	var der []byte
+	switch {
+	case len(data) > 0 && data[0] == 0x30:
+		der = data
+	case isPEM(data):
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("invalid PEM data")
+		}
+		der = block.Bytes
+	default:
+		var err error
+		der, err = ParseSMIME(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cmsDecrypt(der, cert, key)
+}
+
+// EncodeSMIME returns a DER encoded CMS EnvelopedData or AuthEnvelopedData
+// structure as S/MIME message (RFC 8551) which can be sent as email.
+func EncodeSMIME(der []byte) ([]byte, error) {
+	ci := &contentInfo{}
+	_, err := asn1.Unmarshal(der, ci)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS content info: %w", err)
+	}
+	var smimeType string
+	switch {
+	case ci.ContentType.Equal(oidCMSEnvelopedData):
+		smimeType = "enveloped-data"
+	case ci.ContentType.Equal(oidCMSAuthEnvelopedData):
+		smimeType = "authEnveloped-data"
+	default:
+		return nil, fmt.Errorf("unexpected CMS content type %s", ci.ContentType)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Disposition: attachment; filename=\"smime.p7m\"\r\n")
+	fmt.Fprintf(buf, "Content-Type: application/pkcs7-mime; smime-type=%s; name=\"smime.p7m\"\r\n", smimeType)
+	fmt.Fprintf(buf, "Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(der)
+	for len(encoded) > 76 {
+		fmt.Fprintf(buf, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(buf, "%s\r\n", encoded)
+	return buf.Bytes(), nil
+}
+
+// ParseSMIME returns the DER encoded CMS structure of an S/MIME message with
+// the content type application/pkcs7-mime.
+func ParseSMIME(data []byte) ([]byte, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME message: %w", err)
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME content type: %w", err)
+	}
+	if mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime" {
+		return nil, fmt.Errorf("unexpected S/MIME content type '%s'", mediaType)
+	}
+	body, err := io.ReadAll(reader.R)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		return decodeBinary(body)
+	case "binary", "":
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported content transfer encoding '%s'", header.Get("Content-Transfer-Encoding"))
+	}
+}
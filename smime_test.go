@@ -0,0 +1,68 @@
+package pcert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecryptCMS(t *testing.T) {
+	rsaCert, rsaKey := newCMSTestCert(t, "rsa", KeyOptions{Algorithm: x509.RSA})
+	ecCert, ecKey := newCMSTestCert(t, "ec", KeyOptions{Algorithm: x509.ECDSA})
+	content := []byte("Subject: test\r\n\r\nsecret message\r\n")
+
+	for _, alg := range []string{"", "aes-128-cbc", "AES-256-CBC", "aes-128-gcm"} {
+		der, err := EncryptCMS(content, []*x509.Certificate{rsaCert, ecCert}, alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		smime, err := EncodeSMIME(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		smimeType := "smime-type=enveloped-data"
+		if alg == "" || strings.HasSuffix(alg, "gcm") {
+			smimeType = "smime-type=authEnveloped-data"
+		}
+		if !bytes.Contains(smime, []byte(smimeType)) {
+			t.Errorf("S/MIME message does not contain %s:\n%s", smimeType, smime)
+		}
+		for _, data := range [][]byte{der, EncodePKCS7(der), smime} {
+			for _, decrypted := range [][]byte{
+				mustDecryptCMS(t, data, rsaCert, rsaKey),
+				mustDecryptCMS(t, data, ecCert, ecKey),
+			} {
+				if !bytes.Equal(decrypted, content) {
+					t.Errorf("unexpected content: %s", decrypted)
+				}
+			}
+		}
+	}
+
+	_, err := EncryptCMS(content, []*x509.Certificate{rsaCert}, "des")
+	if err == nil {
+		t.Error("expected error for unknown content encryption algorithm")
+	}
+	der, err := EncryptCMS(content, []*x509.Certificate{rsaCert}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptCMS(der, rsaCert, ecKey)
+	if err == nil {
+		t.Error("expected error for key of other certificate")
+	}
+	_, err = DecryptCMS([]byte("Content-Type: text/plain\r\n\r\nhello"), rsaCert, rsaKey)
+	if err == nil {
+		t.Error("expected error for text message")
+	}
+}
+
+func mustDecryptCMS(t *testing.T, data []byte, cert *x509.Certificate, key any) []byte {
+	t.Helper()
+	decrypted, err := DecryptCMS(data, cert, key)
+	if err != nil {
+		t.Fatalf("%s: %s", cert.Subject, err)
+	}
+	return decrypted
+}
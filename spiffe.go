@@ -0,0 +1,202 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const spiffeScheme = "spiffe"
+
+// ParseSPIFFEID parses and validates a SPIFFE ID of the form
+// spiffe://trust-domain/path according to the SPIFFE ID specification. The
+// trust domain may only contain lowercase letters, digits, dots, dashes and
+// underscores. The path segments may only contain letters, digits, dots,
+// dashes and underscores and must not be empty, '.' or '..'.
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	if len(id) > 2048 {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': longer than 2048 bytes", id)
+	}
+	rest, ok := strings.CutPrefix(id, spiffeScheme+"://")
+	if !ok {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': scheme spiffe:// expected", id)
+	}
+	trustDomain, path, _ := strings.Cut(rest, "/")
+	err := validateSPIFFETrustDomain(trustDomain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': %w", id, err)
+	}
+	if strings.Contains(rest, "/") {
+		for _, segment := range strings.Split(path, "/") {
+			switch segment {
+			case "":
+				return nil, fmt.Errorf("invalid SPIFFE ID '%s': empty path segment or trailing slash", id)
+			case ".", "..":
+				return nil, fmt.Errorf("invalid SPIFFE ID '%s': relative path segment '%s'", id, segment)
+			}
+			for _, c := range segment {
+				if !isSPIFFEPathChar(c) {
+					return nil, fmt.Errorf("invalid SPIFFE ID '%s': invalid character '%c' in path", id, c)
+				}
+			}
+		}
+	}
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': %w", id, err)
+	}
+	return u, nil
+}
+
+func validateSPIFFETrustDomain(trustDomain string) error {
+	if trustDomain == "" {
+		return errors.New("trust domain missing")
+	}
+	for _, c := range trustDomain {
+		if !isSPIFFETrustDomainChar(c) {
+			return fmt.Errorf("invalid character '%c' in trust domain (only lowercase letters, digits, '.', '-' and '_' are allowed)", c)
+		}
+	}
+	return nil
+}
+
+func isSPIFFETrustDomainChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_'
+}
+
+func isSPIFFEPathChar(c rune) bool {
+	return isSPIFFETrustDomainChar(c) || c >= 'A' && c <= 'Z'
+}
+
+// SetSPIFFEProfile sets the characteristics of an X509-SVID (SPIFFE X.509
+// SVID specification) based on the SPIFFE ID in the URIs of cert. A leaf
+// certificate is marked as non-CA with the key usages digital signature and
+// key encipherment and the extended key usages server and client
+// authentication. Unlike SetServerProfile no DNS names are added. If cert
+// is a CA (see SetCAProfile) the URI names are constrained to the trust
+// domain. Use CheckX509SVID to validate the result.
+func SetSPIFFEProfile(cert *x509.Certificate) {
+	if cert.IsCA {
+		cert.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		if len(cert.URIs) == 1 && cert.URIs[0].Host != "" {
+			cert.PermittedURIDomains = []string{cert.URIs[0].Host}
+			// RFC 5280 requires name constraints to be critical
+			cert.PermittedDNSDomainsCritical = true
+		}
+		return
+	}
+	addExtKeyUsage(cert, x509.ExtKeyUsageServerAuth)
+	addExtKeyUsage(cert, x509.ExtKeyUsageClientAuth)
+	cert.BasicConstraintsValid = true
+	cert.KeyUsage |= defaultKeyUsage
+}
+
+// CheckX509SVID checks if cert conforms to the SPIFFE X509-SVID
+// specification and returns its SPIFFE ID. The certificate must contain
+// exactly one URI SAN with a valid SPIFFE ID. Leaf certificates must not be
+// a CA, must have the digital signature key usage and must not have the key
+// usages certificate sign and CRL sign. CA certificates must have the
+// certificate sign key usage and a SPIFFE ID without path. The common name
+// and the DNS names must not contain a SPIFFE ID.
+func CheckX509SVID(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("X509-SVID must contain exactly one URI SAN, found %d", len(cert.URIs))
+	}
+	id, err := ParseSPIFFEID(cert.URIs[0].String())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if strings.Contains(strings.ToLower(name), spiffeScheme+":") {
+			return nil, fmt.Errorf("SPIFFE ID '%s' must only be set as URI SAN", name)
+		}
+	}
+	if cert.IsCA {
+		if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return nil, errors.New("X509-SVID signing certificate must have the key usage CertSign")
+		}
+		if id.Path != "" {
+			return nil, fmt.Errorf("SPIFFE ID '%s' of signing certificate must not have a path", id)
+		}
+		return id, nil
+	}
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, errors.New("X509-SVID leaf certificate must have the key usage DigitalSignature")
+	}
+	if cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return nil, errors.New("X509-SVID leaf certificate must not have the key usages CertSign or CRLSign")
+	}
+	return id, nil
+}
+
+// SPIFFEBundle is a SPIFFE trust bundle in JWKS format (SPIFFE Trust Domain
+// and Bundle specification).
+type SPIFFEBundle struct {
+	Keys []*JWK `json:"keys"`
+	// Sequence is the spiffe_sequence number of the bundle.
+	Sequence uint64 `json:"spiffe_sequence,omitempty"`
+	// RefreshHint is the spiffe_refresh_hint in seconds.
+	RefreshHint int64 `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// NewSPIFFEBundle returns a trust bundle with the X.509 authorities cas. If
+// trustDomain is not empty, CA certificates with a SPIFFE ID of another trust
+// domain are rejected.
+func NewSPIFFEBundle(cas []*x509.Certificate, trustDomain string, refreshHint time.Duration) (*SPIFFEBundle, error) {
+	if trustDomain != "" {
+		err := validateSPIFFETrustDomain(trustDomain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust domain '%s': %w", trustDomain, err)
+		}
+	}
+	bundle := &SPIFFEBundle{
+		Keys:        []*JWK{},
+		RefreshHint: int64(refreshHint / time.Second),
+	}
+	for _, ca := range cas {
+		if !ca.IsCA {
+			return nil, fmt.Errorf("certificate '%s' is not a CA", ca.Subject)
+		}
+		for _, uri := range ca.URIs {
+			if trustDomain != "" && uri.Scheme == spiffeScheme && uri.Host != trustDomain {
+				return nil, fmt.Errorf("SPIFFE ID '%s' of certificate '%s' is not in trust domain '%s'", uri, ca.Subject, trustDomain)
+			}
+		}
+		jwk, err := NewJWK(ca.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk.Use = "x509-svid"
+		jwk.X5c = []string{base64.StdEncoding.EncodeToString(ca.Raw)}
+		bundle.Keys = append(bundle.Keys, jwk)
+	}
+	return bundle, nil
+}
+
+// X509Authorities returns the certificates of the x509-svid keys of the
+// bundle.
+func (b *SPIFFEBundle) X509Authorities() ([]*x509.Certificate, error) {
+	cas := []*x509.Certificate{}
+	for _, key := range b.Keys {
+		if key.Use != "x509-svid" {
+			continue
+		}
+		if len(key.X5c) != 1 {
+			return nil, fmt.Errorf("x509-svid key must contain exactly one certificate, found %d", len(key.X5c))
+		}
+		der, err := base64.StdEncoding.DecodeString(key.X5c[0])
+		if err != nil {
+			return nil, err
+		}
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+	return cas, nil
+}
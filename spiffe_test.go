@@ -0,0 +1,157 @@
+package pcert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestParseSPIFFEID(t *testing.T) {
+	for _, id := range []string{
+		"spiffe://example.org",
+		"spiffe://example.org/ns/default/sa/api",
+		"spiffe://my_domain-1.example.org/A.b-c_D",
+	} {
+		u, err := ParseSPIFFEID(id)
+		if err != nil {
+			t.Errorf("%s: %s", id, err)
+			continue
+		}
+		if u.String() != id {
+			t.Errorf("unexpected ID: got %s, want %s", u, id)
+		}
+	}
+	for _, id := range []string{
+		"",
+		"https://example.org/a",
+		"SPIFFE://example.org/a",
+		"spiffe://",
+		"spiffe:///a",
+		"spiffe://Example.org/a",
+		"spiffe://example.org:8080/a",
+		"spiffe://user@example.org/a",
+		"spiffe://example.org/",
+		"spiffe://example.org/a/",
+		"spiffe://example.org/a//b",
+		"spiffe://example.org/a/../b",
+		"spiffe://example.org/a/./b",
+		"spiffe://example.org/a?b=c",
+		"spiffe://example.org/a#b",
+		"spiffe://example.org/a%20b",
+	} {
+		_, err := ParseSPIFFEID(id)
+		if err == nil {
+			t.Errorf("%s: expected error", id)
+		}
+	}
+}
+
+func newTestSVID(t *testing.T, id string, opts CertificateOptions, signCert *x509.Certificate, signKey any) (*x509.Certificate, any) {
+	t.Helper()
+	u, err := ParseSPIFFEID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.URIs = []*url.URL{u}
+	opts.ProfileSPIFFE = true
+	der, key, err := CreateCertificateWithKeyOptions(NewCertificate(&opts), KeyOptions{}, signCert, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestSPIFFE(t *testing.T) {
+	caOpts := CertificateOptions{ProfileCA: true}
+	caOpts.Subject = pkix.Name{CommonName: "Root"}
+	root, rootKey := newTestSVID(t, "spiffe://example.org", caOpts, nil, nil)
+	caOpts.Subject = pkix.Name{CommonName: "Intermediate"}
+	intermediate, intermediateKey := newTestSVID(t, "spiffe://example.org", caOpts, root, rootKey)
+	if len(intermediate.PermittedURIDomains) != 1 || intermediate.PermittedURIDomains[0] != "example.org" {
+		t.Errorf("unexpected name constraints: %v", intermediate.PermittedURIDomains)
+	}
+	for _, cert := range []*x509.Certificate{root, intermediate} {
+		_, err := CheckX509SVID(cert)
+		if err != nil {
+			t.Errorf("%s: %s", cert.Subject, err)
+		}
+	}
+
+	opts := CertificateOptions{}
+	opts.Subject = pkix.Name{CommonName: "api"}
+	leaf, _ := newTestSVID(t, "spiffe://example.org/ns/default/sa/api", opts, intermediate, intermediateKey)
+	id, err := CheckX509SVID(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "spiffe://example.org/ns/default/sa/api" {
+		t.Errorf("unexpected SPIFFE ID: %s", id)
+	}
+	if len(leaf.DNSNames) != 0 {
+		t.Errorf("unexpected DNS names: %v", leaf.DNSNames)
+	}
+	if leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 || len(leaf.ExtKeyUsage) != 2 {
+		t.Errorf("unexpected key usage %v %v", leaf.KeyUsage, leaf.ExtKeyUsage)
+	}
+
+	bundle, err := NewSPIFFEBundle([]*x509.Certificate{root}, "example.org", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorities, err := bundle.X509Authorities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyOpts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, ca := range authorities {
+		verifyOpts.Roots.AddCert(ca)
+	}
+	verifyOpts.Intermediates.AddCert(intermediate)
+	_, err = leaf.Verify(verifyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := newTestSVID(t, "spiffe://other.org/api", opts, intermediate, intermediateKey)
+	_, err = other.Verify(verifyOpts)
+	if err == nil {
+		t.Error("expected name constraint violation for other trust domain")
+	}
+
+	_, err = NewSPIFFEBundle([]*x509.Certificate{root}, "other.org", 0)
+	if err == nil {
+		t.Error("expected error for CA of other trust domain")
+	}
+	_, err = NewSPIFFEBundle([]*x509.Certificate{leaf}, "", 0)
+	if err == nil {
+		t.Error("expected error for non-CA certificate")
+	}
+
+	invalid := *leaf
+	invalid.URIs = append(invalid.URIs, leaf.URIs[0])
+	_, err = CheckX509SVID(&invalid)
+	if err == nil {
+		t.Error("expected error for two URI SANs")
+	}
+	invalid = *leaf
+	invalid.DNSNames = []string{"spiffe://example.org/other"}
+	_, err = CheckX509SVID(&invalid)
+	if err == nil {
+		t.Error("expected error for SPIFFE ID as DNS name")
+	}
+	invalid = *leaf
+	invalid.KeyUsage |= x509.KeyUsageCertSign
+	_, err = CheckX509SVID(&invalid)
+	if err == nil {
+		t.Error("expected error for leaf with key usage CertSign")
+	}
+}
@@ -0,0 +1,509 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PKIStatus and PKIFailureInfo values of RFC 3161 section 2.4.2.
+const (
+	tsaStatusGranted         = 0
+	tsaStatusGrantedWithMods = 1
+	tsaStatusRejection       = 2
+
+	tsaFailBadAlg              = 0
+	tsaFailBadRequest          = 2
+	tsaFailBadDataFormat       = 5
+	tsaFailUnacceptedPolicy    = 15
+	tsaFailUnacceptedExtension = 16
+	tsaFailSystemFailure       = 25
+
+	tsaMaxRequestSize = 1 << 16
+)
+
+var (
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSigningCertificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+
+	// DefaultTSAPolicy is the policy of the TSAServer if no policy is set.
+	// It is the anyPolicy identifier of RFC 5280.
+	DefaultTSAPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+)
+
+var tsaFailInfoNames = map[int]string{
+	tsaFailBadAlg:              "badAlg",
+	tsaFailBadRequest:          "badRequest",
+	tsaFailBadDataFormat:       "badDataFormat",
+	tsaFailUnacceptedPolicy:    "unacceptedPolicy",
+	tsaFailUnacceptedExtension: "unacceptedExtension",
+	tsaFailSystemFailure:       "systemFailure",
+}
+
+type tsaMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaMessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type tsaStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type tsaResponse struct {
+	Status         tsaStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tsaAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsaMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       tsaAccuracy      `asn1:"optional"`
+	Ordering       bool             `asn1:"optional"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// essCertID is the ESSCertID of RFC 2634 and essCertIDv2 the one of RFC
+// 5035. Only the certificate hash is used.
+type essCertID struct {
+	CertHash     []byte
+	IssuerSerial asn1.RawValue `asn1:"optional"`
+}
+
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  asn1.RawValue `asn1:"optional"`
+}
+
+type signingCertificate struct {
+	Certs    []essCertID
+	Policies asn1.RawValue `asn1:"optional"`
+}
+
+type signingCertificateV2 struct {
+	Certs    []essCertIDv2
+	Policies asn1.RawValue `asn1:"optional"`
+}
+
+// TSAServer is an RFC 3161 time-stamp authority. It answers TimeStampReq
+// messages in POST requests with TimeStampResp messages. The messages
+// imprints have to be SHA-256, SHA-384 or SHA-512 hashes.
+//
+// According to RFC 3161 the certificate of the TSA has to contain a
+// critical extended key usage extension with TimeStamping as only usage.
+// Certificates created with NewCertificate fulfill this if TimeStamping is
+// the only ExtKeyUsage.
+type TSAServer struct {
+	// Cert and Key are used to sign the time-stamp tokens.
+	Cert *x509.Certificate
+	Key  any
+	// Chain contains certificates which are added to the tokens after
+	// Cert if the request asks for certificates.
+	Chain []*x509.Certificate
+	// Policy of the TSA. Requests with a different policy are rejected.
+	// Defaults to DefaultTSAPolicy.
+	Policy asn1.ObjectIdentifier
+	// Accuracy of the time. Defaults to one second.
+	Accuracy time.Duration
+}
+
+// ServeHTTP implements http.Handler.
+func (s *TSAServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := io.ReadAll(http.MaxBytesReader(w, r.Body, tsaMaxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.respond(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	_, _ = w.Write(resp)
+}
+
+// respond returns the TimeStampResp for a TimeStampReq.
+func (s *TSAServer) respond(der []byte) ([]byte, error) {
+	req := &tsaRequest{}
+	rest, err := asn1.Unmarshal(der, req)
+	if err != nil || len(rest) != 0 {
+		return tsaRejection(tsaFailBadDataFormat, "invalid request")
+	}
+	if req.Version != 1 {
+		return tsaRejection(tsaFailBadRequest, fmt.Sprintf("unsupported version %d", req.Version))
+	}
+	hash := cmsDigestHash(req.MessageImprint.HashAlgorithm.Algorithm)
+	if hash == 0 || hash == crypto.SHA1 {
+		return tsaRejection(tsaFailBadAlg, fmt.Sprintf("unsupported hash algorithm %s", req.MessageImprint.HashAlgorithm.Algorithm))
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return tsaRejection(tsaFailBadDataFormat, "invalid length of hashed message")
+	}
+	policy := s.Policy
+	if policy == nil {
+		policy = DefaultTSAPolicy
+	}
+	if req.ReqPolicy != nil && !req.ReqPolicy.Equal(policy) {
+		return tsaRejection(tsaFailUnacceptedPolicy, fmt.Sprintf("unaccepted policy %s", req.ReqPolicy))
+	}
+	if len(req.Extensions) > 0 {
+		return tsaRejection(tsaFailUnacceptedExtension, "extensions are not supported")
+	}
+
+	key, ok := s.Key.(crypto.Signer)
+	if !ok {
+		return tsaRejection(tsaFailSystemFailure, "unsupported key")
+	}
+	serial, err := generateSerial()
+	if err != nil {
+		return tsaRejection(tsaFailSystemFailure, "failed to create serial number")
+	}
+	accuracy := s.Accuracy
+	if accuracy == 0 {
+		accuracy = time.Second
+	}
+	info := tstInfo{
+		Version:        1,
+		Policy:         policy,
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   serial,
+		GenTime:        time.Now().UTC().Truncate(time.Second),
+		Accuracy: tsaAccuracy{
+			Seconds: int(accuracy / time.Second),
+			Millis:  int(accuracy % time.Second / time.Millisecond),
+			Micros:  int(accuracy % time.Millisecond / time.Microsecond),
+		},
+		Nonce: req.Nonce,
+	}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	certHash := sha256.Sum256(s.Cert.Raw)
+	attr, err := newCMSAttribute(oidSigningCertificateV2, signingCertificateV2{
+		Certs: []essCertIDv2{{CertHash: certHash[:]}},
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+	signer := cmsSigner{
+		Cert:            s.Cert,
+		Key:             key,
+		Attributes:      []cmsAttribute{attr},
+		OmitCertificate: !req.CertReq,
+	}
+	var chain []*x509.Certificate
+	if req.CertReq {
+		chain = s.Chain
+	}
+	token, err := cmsSign(infoDER, oidTSTInfo, []cmsSigner{signer}, chain, false)
+	if err != nil {
+		return tsaRejection(tsaFailSystemFailure, "failed to sign time-stamp token")
+	}
+	return asn1.Marshal(tsaResponse{
+		Status:         tsaStatusInfo{Status: tsaStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+}
+
+func tsaRejection(failInfo int, status string) ([]byte, error) {
+	bits := make([]byte, failInfo/8+1)
+	bits[failInfo/8] = 0x80 >> (failInfo % 8)
+	return asn1.Marshal(tsaResponse{
+		Status: tsaStatusInfo{
+			Status:       tsaStatusRejection,
+			StatusString: []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(status)}},
+			FailInfo:     asn1.BitString{Bytes: bits, BitLength: failInfo + 1},
+		},
+	})
+}
+
+// CreateTimestampRequest returns a DER encoded TimeStampReq for a digest
+// created with hash. If nonce is not nil it is added to the request. If
+// certReq is true the TSA adds its certificates to the token.
+func CreateTimestampRequest(digest []byte, hash crypto.Hash, nonce *big.Int, certReq bool) ([]byte, error) {
+	oid := cmsDigestOID(hash)
+	if oid == nil {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", hash)
+	}
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf("invalid length of %s digest", hash)
+	}
+	return asn1.Marshal(tsaRequest{
+		Version: 1,
+		MessageImprint: tsaMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: certReq,
+	})
+}
+
+// Timestamp is a time-stamp token of RFC 3161.
+type Timestamp struct {
+	Time         time.Time
+	Accuracy     time.Duration
+	Policy       asn1.ObjectIdentifier
+	SerialNumber *big.Int
+	Nonce        *big.Int
+	// HashAlgorithm and HashedMessage are the message imprint.
+	HashAlgorithm crypto.Hash
+	HashedMessage []byte
+	// Certificate is the certificate of the TSA.
+	Certificate *x509.Certificate
+	// Certificates are the certificates contained in the token.
+	Certificates []*x509.Certificate
+	// Raw is the DER encoded TimeStampToken.
+	Raw []byte
+}
+
+// ParseTimestamp parses a DER encoded TimeStampToken or TimeStampResp and
+// verifies the signature of the token. The certificate of the TSA is
+// searched in the token and in certs. The certificate itself is not
+// verified (see Timestamp.Verify).
+func ParseTimestamp(der []byte, certs []*x509.Certificate) (*Timestamp, error) {
+	token := der
+	if _, err := parseContentInfo(der, oidPKCS7SignedData); err != nil {
+		resp := &tsaResponse{}
+		rest, respErr := asn1.Unmarshal(der, resp)
+		if respErr != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("neither a time-stamp token nor a response: %w", err)
+		}
+		if resp.Status.Status != tsaStatusGranted && resp.Status.Status != tsaStatusGrantedWithMods {
+			return nil, tsaStatusError(resp.Status)
+		}
+		token = resp.TimeStampToken.FullBytes
+	}
+
+	sd, err := parseCMSSignedData(token)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("unexpected content type %s", sd.EncapContentInfo.EContentType)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("time-stamp token has %d signers", len(sd.SignerInfos))
+	}
+	signers, err := sd.verify(nil, certs)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSigningCertificate(signers[0])
+	if err != nil {
+		return nil, err
+	}
+	included, err := sd.certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &tstInfo{}
+	rest, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, info)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid TSTInfo: %v", err)
+	}
+	hash := cmsDigestHash(info.MessageImprint.HashAlgorithm.Algorithm)
+	if hash == 0 {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	return &Timestamp{
+		Time:          info.GenTime,
+		Accuracy:      time.Duration(info.Accuracy.Seconds)*time.Second + time.Duration(info.Accuracy.Millis)*time.Millisecond + time.Duration(info.Accuracy.Micros)*time.Microsecond,
+		Policy:        info.Policy,
+		SerialNumber:  info.SerialNumber,
+		Nonce:         info.Nonce,
+		HashAlgorithm: hash,
+		HashedMessage: info.MessageImprint.HashedMessage,
+		Certificate:   signers[0].Cert,
+		Certificates:  included,
+		Raw:           token,
+	}, nil
+}
+
+// checkSigningCertificate checks that the signing certificate attribute,
+// which RFC 3161 requires, references the certificate of the signer.
+func checkSigningCertificate(signer cmsVerifiedSigner) error {
+	v2 := signingCertificateV2{}
+	ok, err := signer.Attributes.unmarshal(oidSigningCertificateV2, &v2)
+	if err != nil {
+		return fmt.Errorf("invalid signing certificate attribute: %w", err)
+	}
+	if ok && len(v2.Certs) > 0 {
+		hash := crypto.SHA256
+		if len(v2.Certs[0].HashAlgorithm.Algorithm) > 0 {
+			hash = cmsDigestHash(v2.Certs[0].HashAlgorithm.Algorithm)
+		}
+		if hash == 0 {
+			return fmt.Errorf("unsupported hash algorithm %s in signing certificate attribute", v2.Certs[0].HashAlgorithm.Algorithm)
+		}
+		h := hash.New()
+		h.Write(signer.Cert.Raw)
+		if !bytes.Equal(h.Sum(nil), v2.Certs[0].CertHash) {
+			return errors.New("signing certificate attribute does not match the signer")
+		}
+		return nil
+	}
+	v1 := signingCertificate{}
+	ok, err = signer.Attributes.unmarshal(oidSigningCertificate, &v1)
+	if err != nil {
+		return fmt.Errorf("invalid signing certificate attribute: %w", err)
+	}
+	if ok && len(v1.Certs) > 0 {
+		certHash := sha1.Sum(signer.Cert.Raw)
+		if !bytes.Equal(certHash[:], v1.Certs[0].CertHash) {
+			return errors.New("signing certificate attribute does not match the signer")
+		}
+		return nil
+	}
+	return errors.New("signing certificate attribute is missing")
+}
+
+// Verify checks that the time-stamp was created for data and verifies the
+// certificate of the TSA with opts at the time of the time-stamp. The
+// certificates of the token are added to the intermediates and the
+// certificate has to be valid for TimeStamping.
+func (t *Timestamp) Verify(data io.Reader, opts x509.VerifyOptions) error {
+	h := t.HashAlgorithm.New()
+	_, err := io.Copy(h, data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), t.HashedMessage) {
+		return errors.New("time-stamp was not created for the data")
+	}
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	}
+	for _, cert := range t.Certificates {
+		opts.Intermediates.AddCert(cert)
+	}
+	opts.CurrentTime = t.Time
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+	_, err = t.Certificate.Verify(opts)
+	return err
+}
+
+func tsaStatusError(status tsaStatusInfo) error {
+	failInfos := []string{}
+	for bit := 0; bit < status.FailInfo.BitLength; bit++ {
+		if status.FailInfo.At(bit) == 1 {
+			name, ok := tsaFailInfoNames[bit]
+			if !ok {
+				name = fmt.Sprintf("failInfo %d", bit)
+			}
+			failInfos = append(failInfos, name)
+		}
+	}
+	msg := fmt.Sprintf("time-stamp request rejected with status %d", status.Status)
+	if len(failInfos) > 0 {
+		msg += " (" + strings.Join(failInfos, ", ") + ")"
+	}
+	for i, s := range status.StatusString {
+		if i == 0 {
+			msg += ":"
+		}
+		msg += " " + string(s.Bytes)
+	}
+	return errors.New(msg)
+}
+
+// TSAClient requests time-stamps from an RFC 3161 time-stamp authority
+// over HTTP.
+type TSAClient struct {
+	// URL of the TSA.
+	URL string
+	// HTTPClient is used for the requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Timestamp requests a time-stamp for a digest created with hash. The
+// request asks for the certificates of the TSA. The signature of the
+// returned token is verified but not the certificate of the TSA.
+func (c *TSAClient) Timestamp(ctx context.Context, digest []byte, hash crypto.Hash) (*Timestamp, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := CreateTimestampRequest(digest, hash, nonce, true)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/timestamp-query")
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("time-stamp request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	ts, err := ParseTimestamp(body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("time-stamp token does not contain the nonce of the request")
+	}
+	if ts.HashAlgorithm != hash || !bytes.Equal(ts.HashedMessage, digest) {
+		return nil, errors.New("time-stamp token does not match the request")
+	}
+	return ts, nil
+}
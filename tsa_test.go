@@ -0,0 +1,152 @@
+package pcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTSA(t *testing.T) {
+	caDER, caKey, err := CreateCertificate(NewCACertificate("TSA CA"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsaTemplate := NewCertificate(&CertificateOptions{
+		Certificate: x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}},
+	})
+	tsaTemplate.Subject.CommonName = "TSA"
+	tsaDER, tsaKey, err := CreateCertificate(tsaTemplate, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsaCert, err := x509.ParseCertificate(tsaDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	critical := false
+	for _, ext := range tsaCert.Extensions {
+		if ext.Id.Equal(Extensions["ExtendedKeyUsage"]) {
+			critical = ext.Critical
+		}
+	}
+	if !critical || len(tsaCert.ExtKeyUsage) != 1 {
+		t.Fatal("extended key usage of TSA certificate is not critical")
+	}
+
+	server := &TSAServer{Cert: tsaCert, Key: tsaKey, Chain: []*x509.Certificate{caCert}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := &TSAClient{URL: ts.URL}
+
+	data := []byte("build artifact")
+	digest := sha256.Sum256(data)
+	timestamp, err := client.Timestamp(context.Background(), digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(timestamp.Time) > time.Minute || timestamp.Accuracy != time.Second || !timestamp.Policy.Equal(DefaultTSAPolicy) {
+		t.Errorf("unexpected time-stamp: %s %s %s", timestamp.Time, timestamp.Accuracy, timestamp.Policy)
+	}
+	if !timestamp.Certificate.Equal(tsaCert) || len(timestamp.Certificates) != 2 {
+		t.Errorf("unexpected certificates: %d", len(timestamp.Certificates))
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	err = timestamp.Verify(bytes.NewReader(data), x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = timestamp.Verify(strings.NewReader("other data"), x509.VerifyOptions{Roots: roots})
+	if err == nil || !strings.Contains(err.Error(), "not created for the data") {
+		t.Errorf("expected error for other data, got %v", err)
+	}
+	err = timestamp.Verify(bytes.NewReader(data), x509.VerifyOptions{Roots: x509.NewCertPool()})
+	if err == nil {
+		t.Error("expected error for unknown CA")
+	}
+
+	// the bare token can be parsed as well
+	parsed, err := ParseTimestamp(timestamp.Raw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.SerialNumber.Cmp(timestamp.SerialNumber) != 0 {
+		t.Error("unexpected serial number")
+	}
+
+	// without certReq the token contains no certificates
+	digest512 := sha512.Sum512(data)
+	req, err := CreateTimestampRequest(digest512[:], crypto.SHA512, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.respond(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseTimestamp(resp, nil)
+	if err == nil || !strings.Contains(err.Error(), "certificate of signer not found") {
+		t.Errorf("expected error without certificate, got %v", err)
+	}
+	timestamp, err = ParseTimestamp(resp, []*x509.Certificate{tsaCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamp.Certificates) != 0 || timestamp.Nonce != nil || timestamp.HashAlgorithm != crypto.SHA512 {
+		t.Errorf("unexpected time-stamp: %d %s %s", len(timestamp.Certificates), timestamp.Nonce, timestamp.HashAlgorithm)
+	}
+	err = timestamp.Verify(bytes.NewReader(data), x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rejections
+	sha256Imprint := func(digest []byte) tsaMessageImprint {
+		return tsaMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: digest,
+		}
+	}
+	for _, test := range []struct {
+		name string
+		req  any
+		err  string
+	}{
+		{"invalid request", asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte("invalid")}, "badDataFormat"},
+		{"version", tsaRequest{Version: 2, MessageImprint: sha256Imprint(digest[:])}, "badRequest"},
+		{"policy", tsaRequest{Version: 1, MessageImprint: sha256Imprint(digest[:]), ReqPolicy: asn1.ObjectIdentifier{1, 2, 3}}, "unacceptedPolicy"},
+		{"digest length", tsaRequest{Version: 1, MessageImprint: sha256Imprint(digest[:16])}, "badDataFormat"},
+		{"SHA-1", tsaRequest{Version: 1, MessageImprint: tsaMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1},
+			HashedMessage: digest[:20],
+		}}, "badAlg"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := asn1.Marshal(test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := server.respond(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ParseTimestamp(resp, nil)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %s, got %v", test.err, err)
+			}
+		})
+	}
+}